@@ -0,0 +1,481 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+	"github.com/Sabir222/torrent-at-home/data/hasher"
+	"github.com/Sabir222/torrent-at-home/engine"
+	"github.com/Sabir222/torrent-at-home/logging"
+	"github.com/Sabir222/torrent-at-home/network/connector"
+	"github.com/Sabir222/torrent-at-home/network/lsd"
+	"github.com/Sabir222/torrent-at-home/network/throttle"
+	"github.com/Sabir222/torrent-at-home/network/utp"
+	"github.com/Sabir222/torrent-at-home/protocol/mse"
+)
+
+var (
+	ErrNotFound = errors.New("torrent not found")
+	ErrExists   = errors.New("torrent already added")
+	ErrRemoved  = errors.New("torrent removed")
+	ErrClosed   = errors.New("client closed")
+)
+
+// State is where a torrent is in its life.
+type State int
+
+const (
+	StateQueued State = iota
+	StateDownloading
+	StatePaused
+	StateDone
+	StateFailed
+)
+
+func (st State) String() string {
+	switch st {
+	case StateDownloading:
+		return "downloading"
+	case StatePaused:
+		return "paused"
+	case StateDone:
+		return "done"
+	case StateFailed:
+		return "failed"
+	}
+	return "queued"
+}
+
+// Config sets up what a Client shares between its torrents.
+type Config struct {
+	// Port accepts incoming peers over TCP and, with UTP, over uTP on
+	// the same port. Zero disables listening.
+	Port uint16
+	// UTP dials peers over uTP before TCP.
+	UTP bool
+	// LocalDiscovery finds peers on the LAN; it needs a listening Port.
+	LocalDiscovery bool
+	LocalUnlimited bool
+
+	Encryption    mse.Mode
+	FallbackPlain bool
+	// Proxy, when set, carries every outgoing peer connection instead
+	// of TCP and uTP.
+	Proxy connector.Transport
+	// HTTP makes the HTTP tracker announces, web seed requests and
+	// .torrent downloads; http.DefaultClient's transport when nil.
+	HTTP *http.Client
+
+	// Down and Up are the rates in bytes per second for all torrents
+	// together, zero for unlimited.
+	Down int
+	Up   int
+	// MaxActive is how many torrents download at once, the others wait
+	// in the queue. MaxConns caps the peer connections of all torrents.
+	// Zero means no limit for either.
+	MaxActive int
+	MaxConns  int
+	// HashWorkers is how many pieces are verified at once across all
+	// torrents. Zero shares the process wide pool, one worker per CPU.
+	HashWorkers int
+}
+
+// Client runs many torrents in one process over a shared listener, UDP
+// socket, bandwidth and connection limits. Torrents are keyed by infohash.
+type Client struct {
+	cfg       Config
+	global    *throttle.Set
+	conns     *engine.ConnLimit
+	events    engine.Feed
+	announces engine.Announces
+	hasher    *hasher.Pool
+
+	listener *connector.Listener
+	socket   *utp.Socket
+	local    *lsd.Service
+	trackers *descriptor.UDPTrackers
+
+	mu       sync.Mutex
+	torrents map[[20]byte]*Torrent
+	order    []*Torrent
+	changed  chan struct{}
+	closed   bool
+}
+
+// New opens the shared sockets. Failing to listen is logged and leaves
+// the client working with outgoing connections only.
+func New(cfg Config) (*Client, error) {
+	c := &Client{
+		cfg:      cfg,
+		global:   throttle.NewSet(cfg.Down, cfg.Up),
+		conns:    engine.NewConnLimit(cfg.MaxConns),
+		torrents: make(map[[20]byte]*Torrent),
+		changed:  make(chan struct{}),
+	}
+	if cfg.HashWorkers > 0 {
+		c.hasher = hasher.New(cfg.HashWorkers, 0)
+	}
+
+	if cfg.Port != 0 {
+		listener, err := connector.Listen(fmt.Sprintf(":%d", cfg.Port), cfg.Encryption)
+		if err != nil {
+			logging.Errorf("[listen] not accepting incoming peers: %v", err)
+		} else {
+			c.listener = listener
+		}
+	}
+	if cfg.UTP {
+		// The UDP socket carries uTP and the UDP tracker announces; with
+		// listening disabled it takes any free port.
+		addr := ":0"
+		if c.listener != nil {
+			addr = fmt.Sprintf(":%d", cfg.Port)
+		}
+		socket, err := utp.Listen(addr)
+		if err != nil {
+			logging.Errorf("[utp] disabled: %v", err)
+		} else {
+			c.socket = socket
+			c.trackers = descriptor.NewUDPTrackers(socket)
+			socket.Unhandled(func(b []byte, addr net.Addr) {
+				c.trackers.Handle(b, addr)
+			})
+			if c.listener != nil {
+				c.listener.Serve(socket)
+			}
+		}
+	}
+	if c.trackers == nil {
+		trackers, err := descriptor.ListenUDPTrackers(":0")
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.trackers = trackers
+	}
+	if cfg.LocalDiscovery && c.listener != nil {
+		service, err := lsd.Listen(cfg.Port)
+		if err != nil {
+			logging.Errorf("[lsd] disabled: %v", err)
+		} else {
+			c.local = service
+		}
+	}
+	return c, nil
+}
+
+// options fills in the parts of opts the client shares.
+func (c *Client) options(opts descriptor.Options) descriptor.Options {
+	opts.Global = c.global
+	opts.Conns = c.conns
+	opts.Events = &c.events
+	opts.Announces = &c.announces
+	opts.Hasher = c.hasher
+	opts.Encryption = c.cfg.Encryption
+	opts.FallbackPlain = c.cfg.FallbackPlain
+	opts.Port = c.cfg.Port
+	opts.Listener = c.listener
+	opts.LocalDiscovery = c.local
+	opts.LocalUnlimited = c.cfg.LocalUnlimited
+	opts.UDPTrackers = c.trackers
+	opts.HTTP = c.cfg.HTTP
+	opts.Transports = nil
+	if c.cfg.Proxy != nil {
+		opts.Transports = []connector.Transport{c.cfg.Proxy}
+	} else if c.socket != nil {
+		opts.Transports = []connector.Transport{connector.UTP{Socket: c.socket}, connector.TCP}
+	}
+	return opts
+}
+
+// Add queues a torrent to be downloaded to path, as laid out by
+// descriptor.OpenStorage. Only the per torrent fields of opts are used:
+// Limits, Sequential and Select.
+func (c *Client) Add(meta descriptor.TorrentFile, path string, opts descriptor.Options) (*Torrent, error) {
+	return c.Restore(meta, path, opts, ResumeData{})
+}
+
+// AddMagnet queues a magnet link. Its metadata is fetched when it first
+// starts, and it is then stored under dir by its name.
+func (c *Client) AddMagnet(m descriptor.Magnet, dir string, opts descriptor.Options) (*Torrent, error) {
+	return c.RestoreMagnet(m, dir, opts, ResumeData{})
+}
+
+// RestoreMagnet adds a magnet link saved by an earlier run before its
+// metadata arrived.
+func (c *Client) RestoreMagnet(m descriptor.Magnet, dir string, opts descriptor.Options, r ResumeData) (*Torrent, error) {
+	t := c.newTorrent(m.InfoHash, opts, r)
+	t.magnet = &m
+	t.dir = dir
+	t.meta = descriptor.TorrentFile{InfoHash: m.InfoHash, Name: m.Name}
+	if err := c.add(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Restore adds a torrent saved by an earlier run, carrying on from its
+// resume data.
+func (c *Client) Restore(meta descriptor.TorrentFile, path string, opts descriptor.Options, r ResumeData) (*Torrent, error) {
+	t := c.newTorrent(meta.InfoHash, opts, r)
+	t.meta = meta
+	t.path = path
+	if err := c.add(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (c *Client) newTorrent(infoHash [20]byte, opts descriptor.Options, r ResumeData) *Torrent {
+	opts = c.options(opts)
+	if opts.Limits == nil {
+		opts.Limits = throttle.NewSet(r.Limits.Down, r.Limits.Up)
+	}
+	if r.Sequential {
+		opts.Sequential = true
+	}
+	if r.Key == 0 {
+		r.Key = descriptor.NewKey()
+	}
+	opts.Key = r.Key
+	t := &Torrent{
+		client:   c,
+		infoHash: infoHash,
+		opts:     opts,
+		resume:   r,
+		stopped:  make(chan struct{}),
+	}
+	if r.Paused {
+		t.state = StatePaused
+	}
+	close(t.stopped)
+	return t
+}
+
+func (c *Client) add(t *Torrent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if _, ok := c.torrents[t.infoHash]; ok {
+		return ErrExists
+	}
+	c.torrents[t.infoHash] = t
+	c.order = append(c.order, t)
+	c.notify()
+	c.events.Publish(engine.Event{Kind: engine.EventStateChanged, InfoHash: t.infoHash, State: t.state.String()})
+	c.schedule()
+	return nil
+}
+
+// Get finds a torrent by infohash.
+func (c *Client) Get(infoHash [20]byte) (*Torrent, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.torrents[infoHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return t, nil
+}
+
+// Torrents lists the torrents in the order they were added, which is
+// also the order the queue starts them in.
+func (c *Client) Torrents() []*Torrent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Torrent(nil), c.order...)
+}
+
+// Pause stops a queued or running torrent, keeping what it downloaded.
+func (c *Client) Pause(infoHash [20]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.torrents[infoHash]
+	if !ok {
+		return ErrNotFound
+	}
+	if t.state != StateQueued && t.state != StateDownloading {
+		return nil
+	}
+	t.stop()
+	t.setState(StatePaused, nil)
+	c.schedule()
+	return nil
+}
+
+// Resume queues a paused or failed torrent again.
+func (c *Client) Resume(infoHash [20]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.torrents[infoHash]
+	if !ok {
+		return ErrNotFound
+	}
+	if t.state != StatePaused && t.state != StateFailed {
+		return nil
+	}
+	t.setState(StateQueued, nil)
+	c.schedule()
+	return nil
+}
+
+// Remove stops a torrent and forgets it, deleting its files too when
+// deleteData is set.
+func (c *Client) Remove(infoHash [20]byte, deleteData bool) error {
+	c.mu.Lock()
+	t, ok := c.torrents[infoHash]
+	if !ok {
+		c.mu.Unlock()
+		return ErrNotFound
+	}
+	delete(c.torrents, infoHash)
+	for i, other := range c.order {
+		if other == t {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	t.stop()
+	t.removed = true
+	c.notify()
+	c.events.Publish(engine.Event{Kind: engine.EventStateChanged, InfoHash: infoHash, State: "removed"})
+	c.schedule()
+	stopped := t.stopped
+	c.mu.Unlock()
+
+	<-stopped
+	return t.release(deleteData)
+}
+
+// SetLimits changes the rates shared by every torrent.
+func (c *Client) SetLimits(l throttle.Limits) {
+	c.global.SetLimits(l)
+}
+
+func (c *Client) Limits() throttle.Limits {
+	return c.global.Limits()
+}
+
+// Subscribe receives the events of every torrent: state changes, including
+// being added and "removed", tracker announces and the sessions' events.
+// See engine.Feed.
+func (c *Client) Subscribe(buffer int) (<-chan engine.Event, func()) {
+	return c.events.Subscribe(buffer)
+}
+
+// Announces counts every tracker announce the client's torrents have
+// made, by tracker URL.
+func (c *Client) Announces() map[string]engine.AnnounceCounts {
+	return c.announces.Counts()
+}
+
+// Connections is the number of peer connections across all torrents.
+func (c *Client) Connections() int {
+	return c.conns.Active()
+}
+
+// Close stops every torrent and the shared sockets.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	torrents := c.order
+	c.torrents = map[[20]byte]*Torrent{}
+	c.order = nil
+	for _, t := range torrents {
+		t.stop()
+		t.removed = true
+	}
+	c.notify()
+	c.mu.Unlock()
+
+	for _, t := range torrents {
+		<-t.stopped
+		t.release(false)
+	}
+
+	if c.hasher != nil {
+		c.hasher.Close()
+	}
+	if c.local != nil {
+		c.local.Close()
+	}
+	if c.trackers != nil {
+		c.trackers.Close()
+	}
+	if c.socket != nil {
+		c.socket.Close()
+	}
+	if c.listener != nil {
+		return c.listener.Close()
+	}
+	return nil
+}
+
+// schedule starts queued torrents while there are free slots. c.mu must
+// be held.
+func (c *Client) schedule() {
+	if c.closed {
+		return
+	}
+	active := 0
+	for _, t := range c.order {
+		if t.state == StateDownloading {
+			active++
+		}
+	}
+	for _, t := range c.order {
+		if c.cfg.MaxActive > 0 && active >= c.cfg.MaxActive {
+			return
+		}
+		if t.state == StateQueued {
+			c.start(t)
+			active++
+		}
+	}
+}
+
+// start runs a torrent once its previous run, if any, has returned.
+// c.mu must be held.
+func (c *Client) start(t *Torrent) {
+	ctx, cancel := context.WithCancel(context.Background())
+	previous := t.stopped
+	stopped := make(chan struct{})
+	t.cancel = cancel
+	t.stopped = stopped
+	t.setState(StateDownloading, nil)
+
+	go func() {
+		defer close(stopped)
+		defer cancel()
+		<-previous
+
+		err := t.run(ctx)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if ctx.Err() != nil {
+			// Paused or removed; the state is already set.
+			return
+		}
+		if err != nil {
+			logging.Errorf("[client] %s failed: %v", t.meta.Name, err)
+			t.setState(StateFailed, err)
+		} else {
+			t.setState(StateDone, nil)
+		}
+		c.schedule()
+	}()
+}
+
+// notify wakes everyone waiting on a change. c.mu must be held.
+func (c *Client) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
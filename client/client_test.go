@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"crypto/sha1"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+	"github.com/Sabir222/torrent-at-home/network/connector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedSeed serves content as a web seed once open is closed.
+func gatedSeed(t *testing.T, content []byte, open chan struct{}) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-open:
+			w.Write(content)
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func webSeeded(name string, content []byte, seed string) descriptor.TorrentFile {
+	return descriptor.TorrentFile{
+		InfoHash:    sha1.Sum([]byte(name)),
+		PieceHashes: [][20]byte{sha1.Sum(content)},
+		PieceLength: len(content),
+		Length:      len(content),
+		Name:        name,
+		Files:       []descriptor.File{{Path: []string{name}, Length: len(content)}},
+		URLList:     []string{seed},
+	}
+}
+
+func newClient(t *testing.T, cfg Config) *Client {
+	c, err := New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func wait(t *testing.T, tor *Torrent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, tor.Wait(ctx))
+}
+
+func TestClientQueue(t *testing.T) {
+	c := newClient(t, Config{MaxActive: 1})
+	dir := t.TempDir()
+
+	open := make(chan struct{})
+	first := webSeeded("first", []byte("first"), gatedSeed(t, []byte("first"), open))
+	second := webSeeded("second", []byte("second"), gatedSeed(t, []byte("second"), open))
+
+	a, err := c.Add(first, filepath.Join(dir, "first"), descriptor.Options{})
+	require.NoError(t, err)
+	b, err := c.Add(second, filepath.Join(dir, "second"), descriptor.Options{})
+	require.NoError(t, err)
+	_, err = c.Add(first, filepath.Join(dir, "again"), descriptor.Options{})
+	assert.ErrorIs(t, err, ErrExists)
+
+	assert.Equal(t, StateDownloading, a.State())
+	assert.Equal(t, StateQueued, b.State())
+	assert.Equal(t, []*Torrent{a, b}, c.Torrents())
+
+	close(open)
+	wait(t, a)
+	wait(t, b)
+	got, err := os.ReadFile(filepath.Join(dir, "second"))
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(got))
+}
+
+func TestClientPauseResume(t *testing.T) {
+	c := newClient(t, Config{MaxActive: 1})
+	dir := t.TempDir()
+
+	open := make(chan struct{})
+	slow := webSeeded("slow", []byte("slow"), gatedSeed(t, []byte("slow"), open))
+	quick := webSeeded("quick", []byte("quick"), gatedSeed(t, []byte("quick"), closed()))
+
+	a, err := c.Add(slow, filepath.Join(dir, "slow"), descriptor.Options{})
+	require.NoError(t, err)
+	b, err := c.Add(quick, filepath.Join(dir, "quick"), descriptor.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, StateQueued, b.State())
+
+	// Pausing frees the slot for the next torrent in the queue.
+	require.NoError(t, c.Pause(a.InfoHash()))
+	assert.Equal(t, StatePaused, a.State())
+	wait(t, b)
+
+	close(open)
+	require.NoError(t, c.Resume(a.InfoHash()))
+	wait(t, a)
+	assert.ErrorIs(t, c.Pause([20]byte{1}), ErrNotFound)
+}
+
+func TestClientRemove(t *testing.T) {
+	c := newClient(t, Config{})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gone")
+
+	meta := webSeeded("gone", []byte("gone"), gatedSeed(t, []byte("gone"), closed()))
+	tor, err := c.Add(meta, path, descriptor.Options{})
+	require.NoError(t, err)
+	wait(t, tor)
+	require.FileExists(t, path)
+
+	require.NoError(t, c.Remove(meta.InfoHash, true))
+	assert.NoFileExists(t, path)
+	_, err = c.Get(meta.InfoHash)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, tor.Wait(context.Background()), ErrRemoved)
+	assert.ErrorIs(t, c.Remove(meta.InfoHash, false), ErrNotFound)
+}
+
+func TestClientFailure(t *testing.T) {
+	c := newClient(t, Config{})
+	meta := webSeeded("nowhere", []byte("x"), "")
+	meta.URLList = nil
+
+	tor, err := c.Add(meta, filepath.Join(t.TempDir(), "x"), descriptor.Options{})
+	require.NoError(t, err)
+	assert.Error(t, tor.Wait(context.Background()))
+	assert.Equal(t, StateFailed, tor.State())
+	assert.Error(t, tor.Err())
+}
+
+func TestClientRestore(t *testing.T) {
+	c := newClient(t, Config{})
+	path := filepath.Join(t.TempDir(), "kept")
+	meta := webSeeded("kept", []byte("kept"), gatedSeed(t, []byte("kept"), closed()))
+
+	tor, err := c.Add(meta, path, descriptor.Options{})
+	require.NoError(t, err)
+	wait(t, tor)
+	r := tor.ResumeData()
+	assert.True(t, r.Have.Check(0))
+	assert.False(t, r.Paused)
+	assert.NotZero(t, r.Key)
+	require.NoError(t, c.Remove(meta.InfoHash, false))
+
+	// With every piece in the resume data, nothing is fetched again.
+	restored, err := c.Restore(meta, path, descriptor.Options{}, r)
+	require.NoError(t, err)
+	wait(t, restored)
+	assert.Equal(t, int64(0), restored.Stats().Downloaded)
+	assert.Equal(t, r.Key, restored.ResumeData().Key, "the tracker key is kept")
+	require.NoError(t, c.Remove(meta.InfoHash, false))
+
+	// Data changed on disk is fetched again despite the resume data.
+	require.NoError(t, os.WriteFile(path, []byte("lost"), 0o644))
+	rechecked, err := c.Restore(meta, path, descriptor.Options{}, r)
+	require.NoError(t, err)
+	wait(t, rechecked)
+	assert.Equal(t, int64(4), rechecked.Stats().Downloaded)
+
+	paused, err := c.Restore(webSeeded("later", []byte("x"), ""), path+"2", descriptor.Options{}, ResumeData{Paused: true})
+	require.NoError(t, err)
+	assert.Equal(t, StatePaused, paused.State())
+	require.NoError(t, paused.SetFilePriority(0, 0))
+	assert.Equal(t, []int{0}, paused.ResumeData().Priorities)
+	assert.True(t, paused.ResumeData().Paused)
+}
+
+func closed() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+func TestClientProxy(t *testing.T) {
+	proxy := connector.SOCKS5{Addr: "127.0.0.1:1080"}
+	c := newClient(t, Config{UTP: true, Proxy: proxy})
+	assert.Equal(t, []connector.Transport{proxy}, c.options(descriptor.Options{}).Transports)
+}
+
+func TestClientHashWorkers(t *testing.T) {
+	c := newClient(t, Config{})
+	assert.Nil(t, c.options(descriptor.Options{}).Hasher)
+
+	c = newClient(t, Config{HashWorkers: 3})
+	pool := c.options(descriptor.Options{}).Hasher
+	require.NotNil(t, pool)
+	assert.Equal(t, 3, pool.Workers())
+}
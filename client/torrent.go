@@ -0,0 +1,319 @@
+package client
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+	"github.com/Sabir222/torrent-at-home/data/mask"
+	"github.com/Sabir222/torrent-at-home/data/storage"
+	"github.com/Sabir222/torrent-at-home/engine"
+	"github.com/Sabir222/torrent-at-home/network/throttle"
+)
+
+// Torrent is one download managed by a Client.
+type Torrent struct {
+	client   *Client
+	infoHash [20]byte
+	opts     descriptor.Options
+	// magnet is set for torrents added by magnet link, whose metadata is
+	// fetched into dir on the first run.
+	magnet *descriptor.Magnet
+	dir    string
+
+	// Guarded by client.mu.
+	meta     descriptor.TorrentFile
+	metainfo []byte
+	path     string
+	resume   ResumeData
+	state    State
+	err      error
+	removed  bool
+	session  *engine.Session
+	store    *storage.Files
+	cancel   context.CancelFunc
+	// stopped is closed when the last run of the torrent has returned.
+	stopped chan struct{}
+}
+
+// ResumeData is what a torrent needs to carry on where it left off after
+// a restart.
+type ResumeData struct {
+	// Have are the pieces already verified on disk.
+	Have       mask.Mask
+	Priorities []int
+	Sequential bool
+	Limits     throttle.Limits
+	Paused     bool
+	// Key is the tracker key, kept so the trackers know this client
+	// across restarts and address changes; a new one is picked if zero.
+	Key uint32
+}
+
+// run prepares the session on the first run and downloads.
+func (t *Torrent) run(ctx context.Context) error {
+	c := t.client
+	c.mu.Lock()
+	session := t.session
+	c.mu.Unlock()
+
+	if session == nil {
+		if err := t.fetchMetadata(ctx); err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		meta, path, resume := t.meta, t.path, t.resume
+		c.mu.Unlock()
+
+		var err error
+		session, err = meta.NewSession(t.opts)
+		if err != nil {
+			return err
+		}
+		for i, priority := range resume.Priorities {
+			if err := session.SetFilePriority(i, priority); err != nil {
+				return err
+			}
+		}
+		if resume.Sequential {
+			session.SetSequential(true)
+		}
+		store, err := meta.OpenStorage(path, session.FilePriorities())
+		if err != nil {
+			return err
+		}
+		session.Storage = store
+		// The data may have changed on disk since the resume data was
+		// saved, so the pieces it lists are read again before they count.
+		if resume.Have != nil {
+			have, err := session.Check(ctx, resume.Have)
+			if err != nil {
+				store.Close()
+				return err
+			}
+			session.Restore(have)
+		}
+
+		c.mu.Lock()
+		t.session = session
+		t.store = store
+		c.notify()
+		c.mu.Unlock()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return session.Run(ctx)
+}
+
+// fetchMetadata resolves a magnet link the first time it runs.
+func (t *Torrent) fetchMetadata(ctx context.Context) error {
+	c := t.client
+	c.mu.Lock()
+	done := t.magnet == nil || t.metainfo != nil
+	c.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	data, err := t.magnet.FetchMetadata(ctx, t.opts)
+	if err != nil {
+		return err
+	}
+	meta, err := descriptor.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.meta = meta
+	t.metainfo = data
+	t.path = filepath.Join(t.dir, meta.Name)
+	c.notify()
+	return nil
+}
+
+// stop cancels the running download, if any. c.mu must be held.
+func (t *Torrent) stop() {
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+}
+
+// release closes the storage of a stopped torrent.
+func (t *Torrent) release(deleteData bool) error {
+	if t.store != nil {
+		t.store.Close()
+	}
+	if deleteData && t.path != "" {
+		return t.meta.RemoveData(t.path)
+	}
+	return nil
+}
+
+// setState records and publishes a transition. client.mu must be held.
+func (t *Torrent) setState(st State, err error) {
+	t.state = st
+	t.err = err
+	t.client.notify()
+	t.client.events.Publish(engine.Event{Kind: engine.EventStateChanged, InfoHash: t.infoHash, State: st.String(), Err: err})
+}
+
+func (t *Torrent) InfoHash() [20]byte {
+	return t.infoHash
+}
+
+// Meta is the torrent's metainfo; for a magnet link it only holds the
+// infohash and name until HasMetadata.
+func (t *Torrent) Meta() descriptor.TorrentFile {
+	t.client.mu.Lock()
+	defer t.client.mu.Unlock()
+	return t.meta
+}
+
+// Path is where the torrent is stored, empty for a magnet link until its
+// metadata arrives.
+func (t *Torrent) Path() string {
+	t.client.mu.Lock()
+	defer t.client.mu.Unlock()
+	return t.path
+}
+
+// Dir is the directory a magnet link is stored under, empty for torrent
+// files.
+func (t *Torrent) Dir() string {
+	return t.dir
+}
+
+func (t *Torrent) HasMetadata() bool {
+	t.client.mu.Lock()
+	defer t.client.mu.Unlock()
+	return t.magnet == nil || t.metainfo != nil
+}
+
+// Magnet is the link the torrent was added from, nil for torrent files.
+func (t *Torrent) Magnet() *descriptor.Magnet {
+	return t.magnet
+}
+
+// Metainfo is the .torrent file fetched for a magnet link, nil until it
+// arrives and for torrents added from a TorrentFile.
+func (t *Torrent) Metainfo() []byte {
+	t.client.mu.Lock()
+	defer t.client.mu.Unlock()
+	return t.metainfo
+}
+
+func (t *Torrent) State() State {
+	t.client.mu.Lock()
+	defer t.client.mu.Unlock()
+	return t.state
+}
+
+// Err is why the torrent failed.
+func (t *Torrent) Err() error {
+	t.client.mu.Lock()
+	defer t.client.mu.Unlock()
+	return t.err
+}
+
+// Session is the running download, nil until the torrent first starts.
+func (t *Torrent) Session() *engine.Session {
+	t.client.mu.Lock()
+	defer t.client.mu.Unlock()
+	return t.session
+}
+
+// Stats is the session's progress, zero with an unknown ETA until the
+// torrent first starts.
+func (t *Torrent) Stats() engine.Stats {
+	if session := t.Session(); session != nil {
+		return session.Stats()
+	}
+	return engine.Stats{ETA: -1}
+}
+
+// ResumeData snapshots the torrent for Client.Restore.
+func (t *Torrent) ResumeData() ResumeData {
+	c := t.client
+	c.mu.Lock()
+	r := t.resume
+	r.Paused = t.state == StatePaused
+	session := t.session
+	c.mu.Unlock()
+
+	r.Limits = t.opts.Limits.Limits()
+	if session != nil {
+		r.Have = session.Bitfield()
+		r.Priorities = session.FilePriorities()
+		r.Sequential = session.Sequential()
+	}
+	return r
+}
+
+// SetFilePriority changes a file's priority, now if the torrent is running
+// or else when it starts.
+func (t *Torrent) SetFilePriority(index, priority int) error {
+	if err := engine.CheckFilePriority(priority); err != nil {
+		return err
+	}
+	c := t.client
+	c.mu.Lock()
+	session := t.session
+	if session == nil {
+		if t.resume.Priorities == nil {
+			t.resume.Priorities = make([]int, len(t.meta.Files))
+			for i := range t.resume.Priorities {
+				t.resume.Priorities[i] = engine.PriorityNormal
+			}
+		}
+		if index >= 0 && index < len(t.resume.Priorities) {
+			t.resume.Priorities[index] = priority
+			c.mu.Unlock()
+			return nil
+		}
+	}
+	c.mu.Unlock()
+
+	if session == nil {
+		return engine.ErrNoFile
+	}
+	return session.SetFilePriority(index, priority)
+}
+
+// Limits are the torrent's own rates.
+func (t *Torrent) Limits() throttle.Limits {
+	return t.opts.Limits.Limits()
+}
+
+// SetLimits changes the torrent's own rates.
+func (t *Torrent) SetLimits(l throttle.Limits) {
+	t.opts.Limits.SetLimits(l)
+}
+
+// Wait blocks until the torrent is done, failed or removed.
+func (t *Torrent) Wait(ctx context.Context) error {
+	c := t.client
+	for {
+		c.mu.Lock()
+		state, err, removed, changed := t.state, t.err, t.removed, c.changed
+		c.mu.Unlock()
+
+		switch {
+		case removed:
+			return ErrRemoved
+		case state == StateDone:
+			return nil
+		case state == StateFailed:
+			return err
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix names the environment variables that set flags, such as
+// TORRENT_AT_HOME_MAX_CONNS for -max-conns.
+const envPrefix = "TORRENT_AT_HOME_"
+
+// config holds flag values from a config file by command. The section
+// named "" is the top level, shared by every command.
+type config map[string]map[string]string
+
+// configPath is where the config file is read from: the -config flag,
+// else $TORRENT_AT_HOME_CONFIG, else the first config.toml, config.yaml,
+// config.yml or config.json in the user's config directory. Empty means
+// there is none.
+func configPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if path := os.Getenv(envPrefix + "CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	for _, ext := range []string{"toml", "yaml", "yml", "json"} {
+		path := filepath.Join(dir, name, "config."+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadConfig reads a TOML, YAML or JSON config file, told apart by its
+// extension. Keys are flag names; a table, mapping or object holds the
+// flags of the command it is named after.
+func loadConfig(path string) (config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		c, err = parseTOML(data)
+	case ".yaml", ".yml":
+		var raw map[string]interface{}
+		if err = yaml.Unmarshal(data, &raw); err == nil {
+			c, err = fromMap(raw)
+		}
+	case ".json":
+		var raw map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err = dec.Decode(&raw); err == nil {
+			c, err = fromMap(raw)
+		}
+	default:
+		return nil, fmt.Errorf("config %s: unknown format %q, want .toml, .yaml or .json", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// apply sets the flags left out on the command line from the
+// environment, then the command's section, then the top level. Keys in
+// the command's section must be its flags; the top level is shared, so
+// it may hold flags of other commands.
+func (c config) apply(fs *flag.FlagSet) error {
+	for key := range c[fs.Name()] {
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("config: %s has no flag -%s", fs.Name(), key)
+		}
+	}
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || err != nil {
+			return
+		}
+		value, from, ok := c.lookup(fs.Name(), f.Name)
+		if !ok {
+			return
+		}
+		if e := fs.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("%s: invalid value %q for -%s: %v", from, value, f.Name, e)
+		}
+	})
+	return err
+}
+
+// lookup finds a flag's value and says where it came from.
+func (c config) lookup(command, flagName string) (value, from string, ok bool) {
+	env := envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+	if value, ok := os.LookupEnv(env); ok {
+		return value, env, true
+	}
+	if value, ok := c[command][flagName]; ok {
+		return value, "config [" + command + "]", true
+	}
+	if value, ok := c[""][flagName]; ok {
+		return value, "config", true
+	}
+	return "", "", false
+}
+
+// fromMap flattens a decoded YAML or JSON document.
+func fromMap(raw map[string]interface{}) (config, error) {
+	c := config{"": {}}
+	for key, v := range raw {
+		section, ok := v.(map[string]interface{})
+		if !ok {
+			value, err := scalar(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			c[""][key] = value
+			continue
+		}
+		c[key] = make(map[string]string)
+		for k, v := range section {
+			value, err := scalar(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", key, k, err)
+			}
+			c[key][k] = value
+		}
+	}
+	return c, nil
+}
+
+// scalar renders a value as it would be given on the command line. A
+// list becomes a comma separated one, as taken by -files and -tracker.
+func scalar(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			if _, ok := item.([]interface{}); ok {
+				return "", fmt.Errorf("nested lists are not supported")
+			}
+			s, err := scalar(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// parseTOML reads the part of TOML a config file needs: [command]
+// tables, key = value pairs of strings, numbers, booleans and one line
+// arrays of them, and comments.
+func parseTOML(data []byte) (config, error) {
+	c := config{"": {}}
+	section := ""
+	for n, line := range strings.Split(string(data), "\n") {
+		if i := indexOutside(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.TrimSpace(line[1:len(line)-1]) == "" {
+				return nil, fmt.Errorf("line %d: bad table header %s", n+1, line)
+			}
+			section = strings.Trim(strings.TrimSpace(line[1:len(line)-1]), `"`)
+			if c[section] == nil {
+				c[section] = make(map[string]string)
+			}
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want key = value", n+1)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value, err := tomlValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", n+1, key, err)
+		}
+		c[section][key] = value
+	}
+	return c, nil
+}
+
+func tomlValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case raw[0] == '"':
+		return strconv.Unquote(raw)
+	case raw[0] == '\'':
+		if len(raw) < 2 || raw[len(raw)-1] != '\'' {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case raw[0] == '[':
+		if raw[len(raw)-1] != ']' {
+			return "", fmt.Errorf("arrays must be on one line")
+		}
+		var items []string
+		rest := strings.TrimSpace(raw[1 : len(raw)-1])
+		for rest != "" {
+			item := rest
+			if i := indexOutside(rest, ','); i >= 0 {
+				item, rest = rest[:i], strings.TrimSpace(rest[i+1:])
+			} else {
+				rest = ""
+			}
+			value, err := tomlValue(strings.TrimSpace(item))
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return strings.Join(items, ","), nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	}
+	number := strings.ReplaceAll(raw, "_", "")
+	if _, err := strconv.ParseFloat(number, 64); err != nil {
+		return "", fmt.Errorf("unsupported value %s", raw)
+	}
+	return number, nil
+}
+
+// indexOutside finds the first sep that is not inside a quoted string.
+func indexOutside(s string, sep byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case quote == '"' && ch == '\\':
+			i++
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == sep:
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	want := config{
+		"":         {"port": "6882", "encryption": "require", "utp": "false"},
+		"download": {"max-conns": "80", "files": "0,*.mkv"},
+		"daemon":   {"listen": "0.0.0.0:8181", "down": "1.5"},
+	}
+	files := map[string]string{
+		"config.toml": `
+# shared by every command
+port = 6_882
+encryption = "require" # trailing comment
+utp = false
+
+[download]
+max-conns = 80
+files = ["0", '*.mkv']
+
+[daemon]
+listen = "0.0.0.0:8181"
+down = 1.5
+`,
+		"config.yaml": `
+port: 6882
+encryption: require
+utp: false
+download:
+  max-conns: 80
+  files: ["0", "*.mkv"]
+daemon:
+  listen: 0.0.0.0:8181
+  down: 1.5
+`,
+		"config.json": `{
+	"port": 6882, "encryption": "require", "utp": false,
+	"download": {"max-conns": 80, "files": ["0", "*.mkv"]},
+	"daemon": {"listen": "0.0.0.0:8181", "down": 1.5}
+}`,
+	}
+	dir := t.TempDir()
+	for file, data := range files {
+		path := filepath.Join(dir, file)
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+		c, err := loadConfig(path)
+		require.NoError(t, err, file)
+		assert.Equal(t, want, c, file)
+	}
+
+	bad := map[string]string{
+		"a.toml": "port 6882",
+		"b.toml": "[download",
+		"c.toml": "files = [\"a\",\n\"b\"]",
+		"d.toml": "name = bare",
+		"e.ini":  "port=6882",
+	}
+	for file, data := range bad {
+		path := filepath.Join(dir, file)
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+		_, err := loadConfig(path)
+		assert.Error(t, err, file)
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	newFlags := func() (*flag.FlagSet, *int, *int, *int, *string) {
+		fs := flag.NewFlagSet("download", flag.ContinueOnError)
+		return fs, fs.Int("port", 1, ""), fs.Int("max-conns", 1, ""), fs.Int("down", 1, ""), fs.String("dir", ".", "")
+	}
+	c := config{
+		"":         {"port": "2", "max-conns": "2", "down": "2", "listen": "x"},
+		"download": {"max-conns": "3", "down": "3"},
+	}
+	t.Setenv(envPrefix+"DOWN", "4")
+
+	fs, port, maxConns, down, dir := newFlags()
+	require.NoError(t, fs.Parse([]string{"-dir", "/tmp"}))
+	require.NoError(t, c.apply(fs))
+	// Flags win over the environment, then the command's section, then
+	// the top level, which may hold flags of other commands.
+	assert.Equal(t, "/tmp", *dir)
+	assert.Equal(t, 4, *down)
+	assert.Equal(t, 3, *maxConns)
+	assert.Equal(t, 2, *port)
+
+	fs, _, _, down, _ = newFlags()
+	require.NoError(t, fs.Parse([]string{"-down", "5"}))
+	require.NoError(t, c.apply(fs))
+	assert.Equal(t, 5, *down)
+
+	fs, _, _, _, _ = newFlags()
+	c["download"]["listen"] = "x"
+	assert.ErrorContains(t, c.apply(fs), "download has no flag -listen")
+
+	fs, _, _, _, _ = newFlags()
+	assert.ErrorContains(t, config{"": {"port": "many"}}.apply(fs), `config: invalid value "many" for -port`)
+}
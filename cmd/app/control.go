@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Sabir222/torrent-at-home/engine"
+)
+
+var errStopped = errors.New("stopped")
+
+// control runs a session until it completes, letting it be paused,
+// resumed and stopped from another goroutine.
+type control struct {
+	session *engine.Session
+
+	mu      sync.Mutex
+	paused  bool
+	stopped bool
+	cancel  context.CancelFunc
+	// resumed is closed when the session is resumed or stopped.
+	resumed chan struct{}
+}
+
+// run returns nil once the download is complete and errStopped after Stop.
+func (c *control) run() error {
+	for {
+		c.mu.Lock()
+		if c.stopped {
+			c.mu.Unlock()
+			return errStopped
+		}
+		if c.paused {
+			resumed := c.resumed
+			c.mu.Unlock()
+			<-resumed
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		c.cancel = cancel
+		c.mu.Unlock()
+
+		err := c.session.Run(ctx)
+		cancel()
+		if err == nil || !errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+}
+
+func (c *control) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+func (c *control) TogglePause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		c.paused = false
+		close(c.resumed)
+		return
+	}
+	c.paused = true
+	c.resumed = make(chan struct{})
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+func (c *control) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return
+	}
+	c.stopped = true
+	if c.paused {
+		c.paused = false
+		close(c.resumed)
+	}
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
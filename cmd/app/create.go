@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+)
+
+type createView struct {
+	Path     string `json:"path"`
+	InfoHash string `json:"info_hash"`
+	Magnet   string `json:"magnet"`
+}
+
+// createCmd hashes a file or directory into a torrent file.
+func createCmd(args []string) error {
+	var c common
+	var h hashFlags
+	fs := newFlagSet("create", "<file-or-directory>")
+	c.register(fs)
+	h.register(fs)
+	out := fs.String("o", "", "torrent file to write (default <name>.torrent)")
+	torrentName := fs.String("name", "", "torrent name (default the base name of the path)")
+	trackers := fs.String("tracker", "", "comma separated tracker URLs, each in its own tier")
+	webSeeds := fs.String("web-seed", "", "comma separated web seed URLs")
+	pieceLength := fs.Int("piece-length", 0, "piece length in KiB (0 picks one from the size)")
+	private := fs.Bool("private", false, "only get peers from the trackers")
+	comment := fs.String("comment", "", "free form comment")
+	noDate := fs.Bool("no-date", false, "leave out the creation date, so the same data gives the same file")
+	jsonOut := fs.Bool("json", false, "print the result as JSON")
+	if err := c.parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	opts := descriptor.CreateOptions{
+		Name:        *torrentName,
+		PieceLength: *pieceLength * 1024,
+		Trackers:    splitList(*trackers),
+		WebSeeds:    splitList(*webSeeds),
+		Private:     *private,
+		Comment:     *comment,
+		CreatedBy:   name + "/" + version,
+		Hasher:      h.pool(),
+	}
+	if !*noDate {
+		opts.CreationDate = time.Now()
+	}
+	data, err := descriptor.Create(fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	meta, err := descriptor.Parse(data)
+	if err != nil {
+		return err
+	}
+	path := *out
+	if path == "" {
+		path = filepath.Base(meta.Name) + ".torrent"
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		return json.NewEncoder(os.Stdout).Encode(createView{
+			Path:     path,
+			InfoHash: hex.EncodeToString(meta.InfoHash[:]),
+			Magnet:   meta.Magnet().String(),
+		})
+	}
+	fmt.Printf("[create] %s: %d piece(s) of %d KiB\n", path, len(meta.PieceHashes), meta.PieceLength/1024)
+	fmt.Printf("[create] infohash %x\n", meta.InfoHash)
+	return nil
+}
+
+// splitList splits a comma separated flag, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
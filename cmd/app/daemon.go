@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/Sabir222/torrent-at-home/client"
+	"github.com/Sabir222/torrent-at-home/daemon"
+	"github.com/Sabir222/torrent-at-home/logging"
+)
+
+// daemonCmd runs the multi-torrent client until interrupted, controlled
+// over the JSON API.
+func daemonCmd(args []string) error {
+	var c common
+	var p peerFlags
+	var h hashFlags
+	fs := newFlagSet("daemon", "")
+	c.register(fs)
+	p.register(fs, 200)
+	h.register(fs)
+	listen := fs.String("listen", "127.0.0.1:8181", "address of the JSON control API")
+	stateDir := fs.String("state", defaultStateDir(), "directory keeping the torrent list and resume data")
+	dir := fs.String("dir", ".", "directory torrents are downloaded to")
+	maxActive := fs.Int("max-active", 3, "torrents downloading at once, the others are queued (0 = no limit)")
+	username := fs.String("username", "admin", "user name for the qBittorrent API and Transmission RPC")
+	password := fs.String("password", "", "password for every daemon route, the APIs and metrics (empty leaves them open)")
+	hosts := fs.String("hosts", "", "comma-separated host names the API may be reached under besides localhost and IP addresses")
+	if err := c.parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	proxy, httpClient, err := p.transport()
+	if err != nil {
+		return err
+	}
+	mode, err := p.mode()
+	if err != nil {
+		return err
+	}
+
+	d, err := daemon.New(daemon.Config{
+		Client: client.Config{
+			Port:           uint16(p.port),
+			UTP:            p.utp,
+			LocalDiscovery: p.lsd,
+			LocalUnlimited: p.localUnlimited,
+			Encryption:     mode,
+			FallbackPlain:  p.fallback,
+			Proxy:          proxy,
+			HTTP:           httpClient,
+			Down:           p.down * 1024,
+			Up:             p.up * 1024,
+			MaxActive:      *maxActive,
+			MaxConns:       p.maxConns,
+			HashWorkers:    h.workers,
+		},
+		StateDir:    *stateDir,
+		DownloadDir: *dir,
+		Username:    *username,
+		Password:    *password,
+		Hosts:       apiHosts(*listen, *hosts),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start: %w", err)
+	}
+	// Limits changed over the API are saved; flags given now win over them.
+	limits := d.Client.Limits()
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "down":
+			limits.Down = p.down * 1024
+		case "up":
+			limits.Up = p.up * 1024
+		}
+	})
+	d.Client.SetLimits(limits)
+
+	srv := &http.Server{Addr: *listen, Handler: d.Handler()}
+	go func() {
+		logging.Infof("[daemon] API on http://%s/api/, qBittorrent API on /api/v2/, Transmission RPC on /transmission/rpc, metrics on /metrics\n", *listen)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Errorf("[daemon] API stopped: %v", err)
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	logging.Infof("[daemon] shutting down\n")
+	srv.Close()
+	if err := d.Close(); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
+
+func defaultStateDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "." + name
+	}
+	return filepath.Join(dir, name)
+}
+
+// apiHosts is the host names given with -hosts and the one -listen binds
+// to, if it is a name rather than an address.
+func apiHosts(listen, extra string) []string {
+	var hosts []string
+	if host, _, err := net.SplitHostPort(listen); err == nil && host != "" {
+		hosts = append(hosts, host)
+	}
+	for _, h := range strings.Split(extra, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
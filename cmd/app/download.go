@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sabir222/torrent-at-home/daemon"
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+	"github.com/Sabir222/torrent-at-home/engine"
+	"github.com/Sabir222/torrent-at-home/logging"
+	"github.com/Sabir222/torrent-at-home/network/connector"
+	"github.com/Sabir222/torrent-at-home/network/lsd"
+	"github.com/Sabir222/torrent-at-home/network/stream"
+	"github.com/Sabir222/torrent-at-home/network/throttle"
+	"github.com/Sabir222/torrent-at-home/network/utp"
+	"github.com/Sabir222/torrent-at-home/tui"
+)
+
+// jsonBuffer is how far the -json output may fall behind the download
+// before events are dropped.
+const jsonBuffer = 1024
+
+// downloadCmd downloads one torrent to the output path, or under -dir by
+// its name.
+func downloadCmd(args []string) error {
+	var c common
+	var p peerFlags
+	var h hashFlags
+	fs := newFlagSet("download", "<torrent-file|magnet> [output-path]")
+	c.register(fs)
+	p.register(fs, 0)
+	h.register(fs)
+	dir := fs.String("dir", ".", "directory to download into when no output path is given")
+	schedule := fs.String("schedule", "", "alternative limits by time of day, e.g. 08:00-18:00=512/64")
+	sequential := fs.Bool("sequential", false, "download pieces in order around the read head")
+	serve := fs.String("stream", "", "serve the files over HTTP while downloading, e.g. 127.0.0.1:8080")
+	files := fs.String("files", "", "comma separated file indexes or globs to download, others are skipped")
+	useTUI := fs.Bool("tui", true, "show a full screen view when run in a terminal, logs otherwise")
+	jsonOut := fs.Bool("json", false, "print events as JSON lines instead of progress")
+	if err := c.parse(fs, args, 1, 2); err != nil {
+		return err
+	}
+
+	proxy, httpClient, err := p.transport()
+	if err != nil {
+		return err
+	}
+	mode, err := p.mode()
+	if err != nil {
+		return err
+	}
+	global := throttle.NewSet(p.down*1024, p.up*1024)
+	if *schedule != "" {
+		rules, err := throttle.ParseSchedule(*schedule)
+		if err != nil {
+			return fmt.Errorf("bad schedule: %w", err)
+		}
+		plan := throttle.Schedule{
+			Normal: global.Limits(),
+			Rules:  rules,
+		}
+		stop := make(chan struct{})
+		defer close(stop)
+		go plan.Run(global, stop)
+	}
+
+	var events engine.Feed
+	opts := descriptor.Options{
+		Global:        global,
+		Sequential:    *sequential || *serve != "",
+		Encryption:    mode,
+		FallbackPlain: p.fallback,
+		Port:          uint16(p.port),
+		Conns:         engine.NewConnLimit(p.maxConns),
+		Events:        &events,
+		Hasher:        h.pool(),
+		HTTP:          httpClient,
+
+		LocalUnlimited: p.localUnlimited,
+	}
+	if p.port != 0 {
+		listener, err := connector.Listen(fmt.Sprintf(":%d", p.port), mode)
+		if err != nil {
+			logging.Errorf("[listen] not accepting incoming peers: %v", err)
+		} else {
+			defer listener.Close()
+			opts.Listener = listener
+		}
+	}
+	switch {
+	case proxy != nil:
+		opts.Transports = []connector.Transport{proxy}
+	case p.utp:
+		// The UDP socket is shared by outgoing and incoming uTP; with
+		// listening disabled it takes any free port.
+		addr := ":0"
+		if opts.Listener != nil {
+			addr = fmt.Sprintf(":%d", p.port)
+		}
+		socket, err := utp.Listen(addr)
+		if err != nil {
+			logging.Errorf("[utp] disabled: %v", err)
+		} else {
+			defer socket.Close()
+			if opts.Listener != nil {
+				opts.Listener.Serve(socket)
+			}
+			opts.Transports = []connector.Transport{connector.UTP{Socket: socket}, connector.TCP}
+		}
+	}
+	if p.lsd && opts.Listener != nil {
+		// Local peers connect back to the advertised port, so discovery
+		// is only useful while listening.
+		service, err := lsd.Listen(uint16(p.port))
+		if err != nil {
+			logging.Errorf("[lsd] disabled: %v", err)
+		} else {
+			defer service.Close()
+			opts.LocalDiscovery = service
+		}
+	}
+	if *files != "" {
+		opts.Select = strings.Split(*files, ",")
+	}
+
+	if *jsonOut {
+		sub, unsubscribe := events.Subscribe(jsonBuffer)
+		done := make(chan struct{})
+		defer func() {
+			unsubscribe()
+			<-done
+		}()
+		go func() {
+			defer close(done)
+			enc := json.NewEncoder(os.Stdout)
+			for e := range sub {
+				enc.Encode(daemon.NewEventView(e))
+			}
+		}()
+	} else {
+		banner()
+	}
+
+	meta, err := openSource(fs.Arg(0), opts)
+	if err != nil {
+		return fmt.Errorf("failed to load torrent: %w", err)
+	}
+	dst := fs.Arg(1)
+	if dst == "" {
+		dst = filepath.Join(*dir, meta.Name)
+	}
+	if !*jsonOut {
+		printSummary(meta)
+	}
+
+	session, err := meta.NewSession(opts)
+	if err != nil {
+		return fmt.Errorf("transfer failed: %w", err)
+	}
+
+	out, err := meta.OpenStorage(dst, session.FilePriorities())
+	if err != nil {
+		return fmt.Errorf("failed to create output: %w", err)
+	}
+	defer out.Close()
+	session.Storage = out
+
+	if *serve != "" {
+		go func() {
+			if err := stream.ListenAndServe(*serve, session); err != nil {
+				logging.Errorf("[stream] server stopped: %v", err)
+			}
+		}()
+	}
+
+	ctl := &control{session: session}
+	stop := make(chan struct{})
+	shown := make(chan struct{})
+	switch {
+	case *jsonOut:
+		close(shown)
+	case *useTUI && tui.IsTerminal(os.Stdin) && tui.IsTerminal(os.Stdout):
+		screen := &tui.Screen{
+			Session:     session,
+			Name:        meta.Name,
+			Paused:      ctl.Paused,
+			TogglePause: ctl.TogglePause,
+			Quit:        ctl.Stop,
+		}
+		log.SetOutput(screen)
+		go func() {
+			defer close(shown)
+			if err := screen.Run(stop); err != nil {
+				log.SetOutput(os.Stderr)
+				logging.Errorf("[tui] falling back to logs: %v", err)
+				tui.Report(session, stop)
+			}
+		}()
+	default:
+		go func() {
+			defer close(shown)
+			tui.Report(session, stop)
+		}()
+	}
+	err = ctl.run()
+	close(stop)
+	// The screen is restored before anything else is printed.
+	<-shown
+	log.SetOutput(os.Stderr)
+	if errors.Is(err, errStopped) {
+		return errors.New("transfer stopped")
+	}
+	if err != nil {
+		return fmt.Errorf("transfer failed: %w", err)
+	}
+
+	if !*jsonOut {
+		fmt.Println("\n" + strings.Repeat("─", 50))
+		fmt.Println("[success] download complete!")
+		fmt.Printf("[output] %s\n", dst)
+		fmt.Println(strings.Repeat("─", 50))
+	}
+	return nil
+}
+
+// openSource loads a torrent file, or fetches a magnet link's metadata
+// over opts.
+func openSource(src string, opts descriptor.Options) (descriptor.TorrentFile, error) {
+	if !strings.HasPrefix(src, "magnet:") {
+		return descriptor.Open(src)
+	}
+	m, err := descriptor.ParseMagnet(src)
+	if err != nil {
+		return descriptor.TorrentFile{}, err
+	}
+	data, err := m.FetchMetadata(context.Background(), opts)
+	if err != nil {
+		return descriptor.TorrentFile{}, err
+	}
+	return descriptor.Parse(data)
+}
+
+func printSummary(meta descriptor.TorrentFile) {
+	fmt.Printf("\n[info] torrent: %s\n", meta.Name)
+	fmt.Printf("[info] size: %.2f MB\n", float64(meta.Length)/1024/1024)
+	fmt.Printf("[info] pieces: %d\n", len(meta.PieceHashes))
+	if meta.MultiFile {
+		for i, f := range meta.Files {
+			if f.Padding {
+				continue
+			}
+			fmt.Printf("[info] file %d: %s (%.2f MB)\n", i, f.DisplayPath(), float64(f.Length)/1024/1024)
+		}
+	}
+	fmt.Println()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyTransport(t *testing.T) {
+	proxyOf := func(t *http.Transport) uintptr { return reflect.ValueOf(t.Proxy).Pointer() }
+	before := proxyOf(http.DefaultTransport.(*http.Transport))
+
+	p := peerFlags{proxy: "socks5://127.0.0.1:1080", utp: true, lsd: true}
+	dialer, client, err := p.transport()
+	require.NoError(t, err)
+	require.NotNil(t, dialer)
+	require.NotNil(t, client)
+	assert.False(t, p.utp || p.lsd)
+
+	req, err := http.NewRequest("GET", "http://tracker.example/announce", nil)
+	require.NoError(t, err)
+	proxy, err := client.Transport.(*http.Transport).Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, "socks5://127.0.0.1:1080", proxy.String())
+
+	// The rest of the process keeps its own HTTP settings.
+	assert.Equal(t, before, proxyOf(http.DefaultTransport.(*http.Transport)))
+
+	p = peerFlags{}
+	dialer, client, err = p.transport()
+	require.NoError(t, err)
+	assert.Nil(t, dialer)
+	assert.Nil(t, client)
+}
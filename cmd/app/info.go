@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+	"github.com/Sabir222/torrent-at-home/tui"
+)
+
+type infoView struct {
+	Name         string     `json:"name"`
+	InfoHash     string     `json:"info_hash"`
+	InfoHashV2   string     `json:"info_hash_v2,omitempty"`
+	Version      string     `json:"version"`
+	Length       int        `json:"length"`
+	PieceLength  int        `json:"piece_length"`
+	Pieces       int        `json:"pieces"`
+	Private      bool       `json:"private"`
+	CreationDate *time.Time `json:"creation_date,omitempty"`
+	CreatedBy    string     `json:"created_by,omitempty"`
+	Comment      string     `json:"comment,omitempty"`
+	Trackers     [][]string `json:"trackers"`
+	WebSeeds     []string   `json:"web_seeds"`
+	Magnet       string     `json:"magnet"`
+	Files        []fileView `json:"files"`
+}
+
+type fileView struct {
+	Path       string `json:"path"`
+	Length     int    `json:"length"`
+	Offset     int    `json:"offset"`
+	PiecesRoot string `json:"pieces_root,omitempty"`
+	Executable bool   `json:"executable,omitempty"`
+	Hidden     bool   `json:"hidden,omitempty"`
+	Symlink    string `json:"symlink,omitempty"`
+}
+
+// infoCmd prints what a torrent file holds.
+func infoCmd(args []string) error {
+	var c common
+	fs := newFlagSet("info", "<torrent-file>")
+	c.register(fs)
+	jsonOut := fs.Bool("json", false, "print the metainfo as JSON")
+	if err := c.parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	meta, err := descriptor.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	v := newInfoView(meta)
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	printInfo(os.Stdout, v)
+	return nil
+}
+
+func newInfoView(meta descriptor.TorrentFile) infoView {
+	v := infoView{
+		Name:        meta.Name,
+		InfoHash:    hex.EncodeToString(meta.InfoHash[:]),
+		Version:     "v1",
+		Length:      meta.Length,
+		PieceLength: meta.PieceLength,
+		Pieces:      len(meta.PieceHashes),
+		Private:     meta.Private,
+		CreatedBy:   meta.CreatedBy,
+		Comment:     meta.Comment,
+		Trackers:    tiers(meta),
+		WebSeeds:    append([]string{}, meta.URLList...),
+		Magnet:      meta.Magnet().String(),
+		Files:       []fileView{},
+	}
+	if meta.IsV2() {
+		v.InfoHashV2 = hex.EncodeToString(meta.InfoHashV2[:])
+		v.Version = "v2"
+		if meta.IsHybrid() {
+			v.Version = "hybrid"
+		}
+	}
+	if !meta.CreationDate.IsZero() {
+		v.CreationDate = &meta.CreationDate
+	}
+	for _, f := range meta.Files {
+		if f.Padding {
+			continue
+		}
+		fv := fileView{
+			Path:       f.DisplayPath(),
+			Length:     f.Length,
+			Offset:     f.Offset,
+			Executable: f.Executable,
+			Hidden:     f.Hidden,
+			Symlink:    strings.Join(f.Symlink, "/"),
+		}
+		if f.PiecesRoot != ([32]byte{}) {
+			fv.PiecesRoot = hex.EncodeToString(f.PiecesRoot[:])
+		}
+		v.Files = append(v.Files, fv)
+	}
+	return v
+}
+
+// tiers lists the trackers by tier, with the announce URL as a tier of
+// its own when no tier has it.
+func tiers(meta descriptor.TorrentFile) [][]string {
+	out := [][]string{}
+	found := meta.Announce == ""
+	for _, tier := range meta.AnnounceList {
+		for _, tr := range tier {
+			found = found || tr == meta.Announce
+		}
+		out = append(out, tier)
+	}
+	if !found {
+		out = append(out, []string{meta.Announce})
+	}
+	return out
+}
+
+func printInfo(w io.Writer, v infoView) {
+	row := func(label, format string, args ...any) {
+		fmt.Fprintf(w, "%-14s %s\n", label+":", fmt.Sprintf(format, args...))
+	}
+	row("Name", "%s", v.Name)
+	row("Version", "%s", v.Version)
+	row("Info hash", "%s", v.InfoHash)
+	if v.InfoHashV2 != "" {
+		row("Info hash v2", "%s", v.InfoHashV2)
+	}
+	row("Size", "%s (%d bytes)", tui.FormatBytes(int64(v.Length)), v.Length)
+	row("Pieces", "%d × %s", v.Pieces, tui.FormatBytes(int64(v.PieceLength)))
+	row("Private", "%t", v.Private)
+	if v.CreationDate != nil {
+		row("Created", "%s", v.CreationDate.Format(time.RFC3339))
+	}
+	if v.CreatedBy != "" {
+		row("Created by", "%s", v.CreatedBy)
+	}
+	if v.Comment != "" {
+		row("Comment", "%s", v.Comment)
+	}
+	row("Magnet", "%s", v.Magnet)
+
+	if len(v.Trackers) > 0 {
+		fmt.Fprintln(w, "\nTrackers:")
+		for i, tier := range v.Trackers {
+			for j, tr := range tier {
+				label := ""
+				if j == 0 {
+					label = fmt.Sprintf("tier %d", i+1)
+				}
+				fmt.Fprintf(w, "  %-8s %s\n", label, tr)
+			}
+		}
+	}
+	if len(v.WebSeeds) > 0 {
+		fmt.Fprintln(w, "\nWeb seeds:")
+		for _, ws := range v.WebSeeds {
+			fmt.Fprintf(w, "  %s\n", ws)
+		}
+	}
+
+	fmt.Fprintf(w, "\nFiles (%d):\n", len(v.Files))
+	for _, line := range fileTree(v.Files) {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+}
+
+// treeNode is a directory, or a file when file is set.
+type treeNode struct {
+	name     string
+	size     int
+	file     *fileView
+	children []*treeNode
+}
+
+// fileTree draws the files as an indented tree, directories with the
+// total size of what they hold.
+func fileTree(files []fileView) []string {
+	root := &treeNode{}
+	for i := range files {
+		f := &files[i]
+		node := root
+		parts := strings.Split(f.Path, "/")
+		for j, part := range parts {
+			node.size += f.Length
+			var next *treeNode
+			for _, child := range node.children {
+				if child.name == part && child.file == nil {
+					next = child
+				}
+			}
+			if next == nil || j == len(parts)-1 {
+				next = &treeNode{name: part}
+				node.children = append(node.children, next)
+			}
+			node = next
+		}
+		node.size, node.file = f.Length, f
+	}
+
+	var lines []string
+	var walk func(n *treeNode, depth int)
+	walk = func(n *treeNode, depth int) {
+		for _, child := range n.children {
+			indent := strings.Repeat("  ", depth)
+			if child.file == nil {
+				lines = append(lines, fmt.Sprintf("%-50s %10s", indent+child.name+"/", tui.FormatBytes(int64(child.size))))
+				walk(child, depth+1)
+				continue
+			}
+			line := fmt.Sprintf("%-50s %10s", indent+child.name, tui.FormatBytes(int64(child.size)))
+			var attrs []string
+			if child.file.Executable {
+				attrs = append(attrs, "executable")
+			}
+			if child.file.Hidden {
+				attrs = append(attrs, "hidden")
+			}
+			if child.file.Symlink != "" {
+				attrs = append(attrs, "→ "+child.file.Symlink)
+			}
+			if len(attrs) > 0 {
+				line += "  " + strings.Join(attrs, ", ")
+			}
+			lines = append(lines, line)
+		}
+	}
+	walk(root, 0)
+	return lines
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTiers(t *testing.T) {
+	cases := []struct {
+		meta descriptor.TorrentFile
+		want [][]string
+	}{
+		{descriptor.TorrentFile{}, [][]string{}},
+		{descriptor.TorrentFile{Announce: "a"}, [][]string{{"a"}}},
+		{descriptor.TorrentFile{Announce: "b", AnnounceList: [][]string{{"a", "b"}, {"c"}}}, [][]string{{"a", "b"}, {"c"}}},
+		{descriptor.TorrentFile{Announce: "d", AnnounceList: [][]string{{"a"}}}, [][]string{{"a"}, {"d"}}},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, tiers(tc.meta))
+	}
+}
+
+func TestFileTree(t *testing.T) {
+	lines := fileTree([]fileView{
+		{Path: "cd1/01.flac", Length: 1024},
+		{Path: "cd1/02.flac", Length: 2048, Hidden: true},
+		{Path: "cover.jpg", Length: 10},
+		{Path: "cd1/bonus/run.sh", Length: 5, Executable: true},
+		{Path: "latest", Symlink: "cd1/02.flac"},
+	})
+	for i := range lines {
+		lines[i] = strings.Join(strings.Fields(lines[i]), " ")
+	}
+	assert.Equal(t, []string{
+		"cd1/ 3.0 KiB",
+		"01.flac 1.0 KiB",
+		"02.flac 2.0 KiB hidden",
+		"bonus/ 5 B",
+		"run.sh 5 B executable",
+		"cover.jpg 10 B",
+		"latest 0 B → cd1/02.flac",
+	}, lines)
+}
+
+func TestPrintInfo(t *testing.T) {
+	meta := descriptor.TorrentFile{
+		Name:         "album",
+		InfoHash:     [20]byte{0xab},
+		Announce:     "udp://a.example:1337",
+		AnnounceList: [][]string{{"udp://a.example:1337", "http://b.example/announce"}},
+		URLList:      []string{"http://mirror.example/"},
+		PieceLength:  16 << 10,
+		PieceHashes:  make([][20]byte, 3),
+		Length:       40000,
+		MultiFile:    true,
+		Private:      true,
+		CreationDate: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		CreatedBy:    "mktorrent 1.1",
+		Comment:      "ripped by me",
+		Files: []descriptor.File{
+			{Path: []string{"a.flac"}, Length: 30000},
+			{Path: []string{".pad", "2768"}, Length: 2768, Offset: 30000, Padding: true},
+			{Path: []string{"b.txt"}, Length: 7232, Offset: 32768},
+		},
+	}
+	v := newInfoView(meta)
+	require.Len(t, v.Files, 2)
+	assert.Equal(t, 32768, v.Files[1].Offset)
+	assert.Equal(t, "v1", v.Version)
+
+	var out bytes.Buffer
+	printInfo(&out, v)
+	s := out.String()
+	for _, want := range []string{
+		"Info hash:     ab00000000000000000000000000000000000000\n",
+		"Pieces:        3 × 16.0 KiB\n",
+		"Private:       true\n",
+		"Created:       2024-01-02T03:04:05Z\n",
+		"Created by:    mktorrent 1.1\n",
+		"Comment:       ripped by me\n",
+		"Magnet:        magnet:?xt=urn:btih:ab00000000000000000000000000000000000000&dn=album&tr=",
+		"  tier 1   udp://a.example:1337\n           http://b.example/announce\n",
+		"Web seeds:\n  http://mirror.example/\n",
+		"Files (2):\n",
+	} {
+		assert.Contains(t, s, want)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+)
+
+// magnetCmd prints the magnet link of a torrent file.
+func magnetCmd(args []string) error {
+	var c common
+	fs := newFlagSet("magnet", "<torrent-file>")
+	c.register(fs)
+	if err := c.parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	meta, err := descriptor.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Println(meta.Magnet())
+	return nil
+}
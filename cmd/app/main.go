@@ -1,12 +1,12 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"strings"
-
-	"github.com/Sabir222/torrent-at-home/data/descriptor"
 )
 
 const (
@@ -14,44 +14,91 @@ const (
 	name    = "torrent-at-home"
 )
 
+// command is a subcommand, run with the arguments after its name.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"download", "download a torrent file or magnet link", downloadCmd},
+	{"info", "show what a torrent file holds", infoCmd},
+	{"create", "make a torrent file from a file or directory", createCmd},
+	{"verify", "check downloaded data against a torrent file", verifyCmd},
+	{"scrape", "ask the trackers how many peers a torrent has", scrapeCmd},
+	{"magnet", "print the magnet link of a torrent file", magnetCmd},
+	{"seed", "share a torrent's data with peers (not supported yet)", seedCmd},
+	{"daemon", "run many torrents, controlled over HTTP", daemonCmd},
+}
+
+// errUsage is returned once the usage has been printed for bad arguments.
+var errUsage = errors.New("usage")
+
 func banner() {
 	fmt.Println(strings.Repeat("─", 50))
 	fmt.Printf("  %s v%s\n", name, version)
 	fmt.Println(strings.Repeat("─", 50))
 }
 
-func entry() {
-	if len(os.Args) < 3 {
-		fmt.Fprintf(os.Stderr, "usage: %s <torrent-file> <output-path>\n", os.Args[0])
-		os.Exit(1)
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags] [args]\n\ncommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for its flags. Flags can also be set in a config\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "file or with %s<FLAG> environment variables.\n", envPrefix)
+}
 
-	src := os.Args[1]
-	dst := os.Args[2]
-
-	banner()
-
+func main() {
 	log.SetFlags(log.Ldate | log.Ltime)
 
-	meta, err := descriptor.Open(src)
-	if err != nil {
-		log.Fatalf("failed to load torrent: %v", err)
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
 	}
-
-	fmt.Printf("\n[info] torrent: %s\n", meta.Name)
-	fmt.Printf("[info] size: %.2f MB\n", float64(meta.Length)/1024/1024)
-	fmt.Printf("[info] pieces: %d\n\n", len(meta.PieceHashes))
-
-	if err := meta.DownloadToFile(dst); err != nil {
-		log.Fatalf("transfer failed: %v", err)
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
+	case "version", "-version", "--version":
+		fmt.Printf("%s %s\n", name, version)
+		return
 	}
 
-	fmt.Println("\n" + strings.Repeat("─", 50))
-	fmt.Println("[success] download complete!")
-	fmt.Printf("[output] %s\n", dst)
-	fmt.Println(strings.Repeat("─", 50))
+	run, args, err := lookup(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		usage()
+		os.Exit(2)
+	}
+	err = run(args)
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		log.SetOutput(os.Stderr)
+		log.Fatalf("%v", err)
+	}
 }
 
-func main() {
-	entry()
+// lookup finds the command args name and the arguments it takes. Without
+// a command, an existing file or a magnet link is downloaded, as before
+// there were commands.
+func lookup(args []string) (func([]string) error, []string, error) {
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run, args[1:], nil
+		}
+	}
+	if strings.HasPrefix(args[0], "magnet:") {
+		return downloadCmd, args, nil
+	}
+	if info, err := os.Stat(args[0]); err == nil && info.Mode().IsRegular() {
+		return downloadCmd, args, nil
+	}
+	return nil, nil, fmt.Errorf("unknown command %q", args[0])
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	same := func(a, b func([]string) error) bool {
+		return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+	}
+
+	run, args, err := lookup([]string{"seed", "x.torrent", "data"})
+	require.NoError(t, err)
+	assert.True(t, same(seedCmd, run))
+	assert.Equal(t, []string{"x.torrent", "data"}, args)
+	assert.ErrorContains(t, run(args), "not supported yet")
+
+	file := filepath.Join(t.TempDir(), "x.torrent")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+	for _, first := range []string{file, "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567"} {
+		run, args, err = lookup([]string{first, "out"})
+		require.NoError(t, err)
+		assert.True(t, same(downloadCmd, run))
+		assert.Equal(t, []string{first, "out"}, args)
+	}
+
+	// A mistyped command is not taken for a torrent file.
+	_, _, err = lookup([]string{"sede", file})
+	assert.EqualError(t, err, `unknown command "sede"`)
+}
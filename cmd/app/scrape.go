@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+)
+
+type scrapeView struct {
+	Tracker   string `json:"tracker"`
+	Seeders   int    `json:"seeders"`
+	Leechers  int    `json:"leechers"`
+	Completed int    `json:"completed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// scrapeCmd asks every tracker of a torrent or magnet link about its
+// swarm.
+func scrapeCmd(args []string) error {
+	var c common
+	fs := newFlagSet("scrape", "<torrent-file|magnet>")
+	c.register(fs)
+	timeout := fs.Duration("timeout", 10*time.Second, "how long to wait for each tracker")
+	jsonOut := fs.Bool("json", false, "print the results as JSON")
+	if err := c.parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	var meta descriptor.TorrentFile
+	if src := fs.Arg(0); strings.HasPrefix(src, "magnet:") {
+		m, err := descriptor.ParseMagnet(src)
+		if err != nil {
+			return err
+		}
+		meta = descriptor.TorrentFile{InfoHash: m.InfoHash, AnnounceList: [][]string{m.Trackers}}
+	} else {
+		var err error
+		if meta, err = descriptor.Open(src); err != nil {
+			return err
+		}
+	}
+
+	results := meta.Scrape(*timeout)
+	if *jsonOut {
+		views := make([]scrapeView, len(results))
+		for i, r := range results {
+			views[i] = scrapeView{Tracker: r.Tracker, Seeders: r.Seeders, Leechers: r.Leechers, Completed: r.Completed}
+			if r.Err != nil {
+				views[i].Error = r.Err.Error()
+			}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(views)
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Printf("%-50s error: %v\n", r.Tracker, r.Err)
+			continue
+		}
+		fmt.Printf("%-50s %d seeder(s), %d leecher(s), %d completed\n", r.Tracker, r.Seeders, r.Leechers, r.Completed)
+	}
+	return nil
+}
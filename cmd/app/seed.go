@@ -0,0 +1,15 @@
+package main
+
+import "errors"
+
+// seedCmd would share finished data with peers. The engine answers every
+// request with a reject, so there is nothing to run yet.
+func seedCmd(args []string) error {
+	var c common
+	fs := newFlagSet("seed", "<torrent-file> <data-path>")
+	c.register(fs)
+	if err := c.parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	return errors.New("seeding is not supported yet: peers' piece requests are still refused")
+}
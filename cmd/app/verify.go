@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+)
+
+type verifyView struct {
+	Pieces  int              `json:"pieces"`
+	Damaged []int            `json:"damaged"`
+	Missing []int            `json:"missing"`
+	Files   []verifyFileView `json:"files"`
+}
+
+type verifyFileView struct {
+	Path    string `json:"path"`
+	Length  int    `json:"length"`
+	Missing bool   `json:"missing"`
+	Pieces  int    `json:"pieces"`
+	Bad     int    `json:"bad"`
+}
+
+// verifyCmd hashes downloaded data against a torrent file on every core,
+// failing when any piece is damaged or missing.
+func verifyCmd(args []string) error {
+	var c common
+	var h hashFlags
+	fs := newFlagSet("verify", "<torrent-file> [data-path]")
+	c.register(fs)
+	h.register(fs)
+	dir := fs.String("dir", ".", "directory the torrent was downloaded into when no data path is given")
+	jsonOut := fs.Bool("json", false, "print the result as JSON")
+	if err := c.parse(fs, args, 1, 2); err != nil {
+		return err
+	}
+	meta, err := descriptor.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	path := fs.Arg(1)
+	if path == "" {
+		path = filepath.Join(*dir, meta.Name)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := meta.Verify(ctx, path, h.pool())
+	if errors.Is(err, context.Canceled) {
+		return errors.New("verify stopped")
+	}
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(newVerifyView(report)); err != nil {
+			return err
+		}
+	} else {
+		printVerify(os.Stdout, report)
+	}
+	if !report.OK() {
+		return fmt.Errorf("%d piece(s) damaged, %d missing", len(report.Damaged), len(report.Missing))
+	}
+	return nil
+}
+
+func newVerifyView(r descriptor.VerifyReport) verifyView {
+	v := verifyView{
+		Pieces:  r.Pieces,
+		Damaged: append([]int{}, r.Damaged...),
+		Missing: append([]int{}, r.Missing...),
+		Files:   []verifyFileView{},
+	}
+	for _, f := range r.Files {
+		v.Files = append(v.Files, verifyFileView(f))
+	}
+	return v
+}
+
+func printVerify(w io.Writer, r descriptor.VerifyReport) {
+	bad := len(r.Damaged) + len(r.Missing)
+	fmt.Fprintf(w, "[verify] %d/%d piece(s) ok\n", r.Pieces-bad, r.Pieces)
+	if len(r.Damaged) > 0 {
+		fmt.Fprintf(w, "[verify] damaged pieces: %s\n", ranges(r.Damaged))
+	}
+	if len(r.Missing) > 0 {
+		fmt.Fprintf(w, "[verify] missing pieces: %s\n", ranges(r.Missing))
+	}
+	for _, f := range r.Files {
+		switch {
+		case f.Missing:
+			fmt.Fprintf(w, "[verify] missing  %s\n", f.Path)
+		case f.Bad > 0:
+			fmt.Fprintf(w, "[verify] damaged  %s (%d/%d pieces)\n", f.Path, f.Bad, f.Pieces)
+		}
+	}
+}
+
+// ranges writes sorted piece indexes compactly, as in 0-3,7,9-10.
+func ranges(pieces []int) string {
+	var parts []string
+	for i := 0; i < len(pieces); {
+		j := i
+		for j+1 < len(pieces) && pieces[j+1] == pieces[j]+1 {
+			j++
+		}
+		part := strconv.Itoa(pieces[i])
+		if j > i {
+			part += "-" + strconv.Itoa(pieces[j])
+		}
+		parts = append(parts, part)
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRanges(t *testing.T) {
+	cases := map[string][]int{
+		"":           nil,
+		"4":          {4},
+		"0-3,7,9-10": {0, 1, 2, 3, 7, 9, 10},
+		"1,3,5":      {1, 3, 5},
+	}
+	for want, pieces := range cases {
+		assert.Equal(t, want, ranges(pieces))
+	}
+}
+
+func TestPrintVerify(t *testing.T) {
+	var out bytes.Buffer
+	printVerify(&out, descriptor.VerifyReport{
+		Pieces:  6,
+		Damaged: []int{0},
+		Missing: []int{4, 5},
+		Files: []descriptor.FileReport{
+			{Path: "a", Length: 10, Pieces: 3, Bad: 1},
+			{Path: "b", Length: 4, Pieces: 1},
+			{Path: "c", Length: 8, Missing: true, Pieces: 2, Bad: 2},
+		},
+	})
+	assert.Equal(t, `[verify] 3/6 piece(s) ok
+[verify] damaged pieces: 0
+[verify] missing pieces: 4-5
+[verify] damaged  a (1/3 pieces)
+[verify] missing  c
+`, out.String())
+}
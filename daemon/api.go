@@ -0,0 +1,555 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/client"
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+	"github.com/Sabir222/torrent-at-home/engine"
+	"github.com/Sabir222/torrent-at-home/logging"
+	"github.com/Sabir222/torrent-at-home/network/throttle"
+)
+
+// The JSON control API, all under /api/:
+//
+//	GET    /api/torrents                     list torrents
+//	POST   /api/torrents                     add a .torrent upload, magnet or URL
+//	GET    /api/torrents/{hash}              one torrent with its files
+//	DELETE /api/torrents/{hash}?data=true    remove, optionally with its data
+//	POST   /api/torrents/{hash}/pause
+//	POST   /api/torrents/{hash}/resume
+//	PUT    /api/torrents/{hash}/files/{index} {"priority": 4}
+//	GET    /api/torrents/{hash}/limits
+//	PUT    /api/torrents/{hash}/limits       {"down": 0, "up": 0} in bytes/s
+//	GET    /api/torrents/{hash}/peers
+//	GET    /api/torrents/{hash}/trackers
+//	GET    /api/limits
+//	PUT    /api/limits
+//	GET    /api/events?hash={hash}           server-sent events, of one torrent or all
+//
+// With a password set, every route takes HTTP basic auth or a qBittorrent
+// login cookie.
+
+type torrentView struct {
+	InfoHash    string     `json:"info_hash"`
+	Name        string     `json:"name"`
+	State       string     `json:"state"`
+	Error       string     `json:"error,omitempty"`
+	Path        string     `json:"path,omitempty"`
+	Magnet      string     `json:"magnet,omitempty"`
+	HasMetadata bool       `json:"has_metadata"`
+	Category    string     `json:"category,omitempty"`
+	Size        int64      `json:"size"`
+	Progress    float64    `json:"progress"`
+	Downloaded  int64      `json:"downloaded"`
+	DownRate    int64      `json:"down_rate"`
+	ETA         int64      `json:"eta"` // seconds, -1 when unknown
+	Peers       int        `json:"peers"`
+	Unchoked    int        `json:"unchoked"`
+	Seeds       int        `json:"seeds"`
+	Leechers    int        `json:"leechers"`
+	Wasted      int64      `json:"wasted"`
+	Limits      limitsView `json:"limits"`
+	Files       []fileView `json:"files,omitempty"`
+}
+
+type fileView struct {
+	Index    int    `json:"index"`
+	Path     string `json:"path"`
+	Length   int64  `json:"length"`
+	Priority int    `json:"priority"`
+}
+
+type limitsView struct {
+	Down int `json:"down"`
+	Up   int `json:"up"`
+}
+
+type peerView struct {
+	Addr       string `json:"addr"`
+	Network    string `json:"network"`
+	Client     string `json:"client"`
+	Choked     bool   `json:"choked"`
+	Seed       bool   `json:"seed"`
+	Downloaded int64  `json:"downloaded"`
+	DownRate   int64  `json:"down_rate"`
+}
+
+type trackerView struct {
+	URL       string `json:"url"`
+	Peers     int    `json:"peers"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+type addRequest struct {
+	// URL is a magnet link or an http(s) link to a .torrent file.
+	URL      string `json:"url"`
+	Dir      string `json:"dir"`
+	Paused   bool   `json:"paused"`
+	Category string `json:"category"`
+}
+
+type priorityRequest struct {
+	Priority *int `json:"priority"`
+}
+
+// Handler serves the JSON control API, the qBittorrent API, the
+// Transmission RPC and Prometheus metrics.
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", d.serveAPI)
+	mux.HandleFunc("/api/v2/", d.serveQBittorrent)
+	mux.HandleFunc("/transmission/rpc", d.serveTransmission)
+	mux.HandleFunc("/metrics", d.serveMetrics)
+	return d.guard(mux)
+}
+
+// guard refuses requests under an unknown host name or from other sites'
+// pages and, with a password set, requests without credentials, before
+// they reach any route. Only the qBittorrent login and logout are open.
+func (d *Daemon) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.knownHost(r) {
+			http.Error(w, "unknown host "+r.Host, http.StatusForbidden)
+			return
+		}
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/auth/login", "/api/v2/auth/logout":
+			next.ServeHTTP(w, r)
+			return
+		}
+		if d.authorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/v2/"):
+			// qBittorrent clients expect a plain 403 and log in again.
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case r.URL.Path == "/transmission/rpc":
+			w.Header().Set("WWW-Authenticate", `Basic realm="Transmission"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		default:
+			w.Header().Set("WWW-Authenticate", `Basic realm="torrent-at-home"`)
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		}
+	})
+}
+
+// authorized accepts anything without a password, otherwise basic auth
+// with the username and password or a live qBittorrent login.
+func (d *Daemon) authorized(r *http.Request) bool {
+	if d.password == "" {
+		return true
+	}
+	if user, pass, ok := r.BasicAuth(); ok {
+		return d.credentials(user, pass)
+	}
+	return d.qbtAuthorized(r)
+}
+
+// credentials compares in constant time, so timing does not leak how
+// much of a guess was right.
+func (d *Daemon) credentials(user, pass string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(d.username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(d.password)) == 1
+	return userOK && passOK
+}
+
+// knownHost tells whether the request is addressed to the daemon by an
+// IP address, localhost or one of the configured Hosts. A page using DNS
+// rebinding reaches the daemon under its own name, which also passes
+// sameOrigin, so the name itself has to be one the daemon expects.
+func (d *Daemon) knownHost(r *http.Request) bool {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if net.ParseIP(host) != nil || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	for _, h := range d.hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin tells whether a request came from a page served by the
+// daemon itself, or from no page at all. Browsers send Origin on
+// cross-site POSTs and on scripted requests, so a page on another site
+// cannot drive the API even when it is open.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func (d *Daemon) serveAPI(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "limits":
+		d.serveLimits(w, r, d.Client)
+	case len(parts) == 1 && parts[0] == "events":
+		d.serveEvents(w, r)
+	case len(parts) == 1 && parts[0] == "torrents":
+		switch r.Method {
+		case http.MethodGet:
+			views := []torrentView{}
+			for _, t := range d.Client.Torrents() {
+				views = append(views, d.view(t, false))
+			}
+			writeJSON(w, http.StatusOK, views)
+		case http.MethodPost:
+			d.add(w, r)
+		default:
+			methodNotAllowed(w)
+		}
+	case len(parts) >= 2 && parts[0] == "torrents":
+		infoHash, err := ParseInfoHash(parts[1])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		t, err := d.Client.Get(infoHash)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		d.serveTorrent(w, r, t, parts[2:])
+	default:
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint"))
+	}
+}
+
+func (d *Daemon) serveTorrent(w http.ResponseWriter, r *http.Request, t *client.Torrent, rest []string) {
+	action := ""
+	if len(rest) > 0 {
+		action = rest[0]
+	}
+	method := r.Method
+
+	switch {
+	case action == "" && len(rest) == 0 && method == http.MethodGet:
+		writeJSON(w, http.StatusOK, d.view(t, true))
+	case action == "" && len(rest) == 0 && method == http.MethodDelete:
+		deleteData, _ := strconv.ParseBool(r.URL.Query().Get("data"))
+		d.reply(w, d.Remove(t.InfoHash(), deleteData))
+	case action == "pause" && method == http.MethodPost:
+		d.reply(w, d.Client.Pause(t.InfoHash()))
+	case action == "resume" && method == http.MethodPost:
+		d.reply(w, d.Client.Resume(t.InfoHash()))
+	case action == "files" && len(rest) == 2 && method == http.MethodPut:
+		index, err := strconv.Atoi(rest[1])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		var req priorityRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Priority == nil {
+			writeError(w, http.StatusBadRequest, errors.New(`expected {"priority": n}`))
+			return
+		}
+		err = t.SetFilePriority(index, *req.Priority)
+		if errors.Is(err, engine.ErrNoFile) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if errors.Is(err, engine.ErrBadPriority) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		d.reply(w, err)
+	case action == "limits" && len(rest) == 1:
+		d.serveLimits(w, r, t)
+	case action == "peers" && method == http.MethodGet:
+		peers := []peerView{}
+		if session := t.Session(); session != nil {
+			for _, p := range session.ConnectedPeers() {
+				peers = append(peers, peerView{
+					Addr:       p.Addr,
+					Network:    p.Network,
+					Client:     p.Client,
+					Choked:     p.Choked,
+					Seed:       p.Seed,
+					Downloaded: p.Downloaded,
+					DownRate:   p.DownRate,
+				})
+			}
+		}
+		writeJSON(w, http.StatusOK, peers)
+	case action == "trackers" && method == http.MethodGet:
+		trackers := []trackerView{}
+		if session := t.Session(); session != nil {
+			for _, tr := range session.Trackers {
+				trackers = append(trackers, trackerView{
+					URL:       tr.URL,
+					Peers:     tr.Peers,
+					Error:     tr.Err,
+					LatencyMS: tr.Latency.Milliseconds(),
+				})
+			}
+		}
+		writeJSON(w, http.StatusOK, trackers)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint"))
+	}
+}
+
+// limiter is a client or a torrent.
+type limiter interface {
+	Limits() throttle.Limits
+	SetLimits(throttle.Limits)
+}
+
+func (d *Daemon) serveLimits(w http.ResponseWriter, r *http.Request, l limiter) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req limitsView
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Down < 0 || req.Up < 0 {
+			writeError(w, http.StatusBadRequest, errors.New(`expected {"down": n, "up": n}`))
+			return
+		}
+		l.SetLimits(throttle.Limits{Down: req.Down, Up: req.Up})
+		if err := d.Save(); err != nil {
+			logging.Errorf("[daemon] saving state: %v\n", err)
+		}
+	default:
+		methodNotAllowed(w)
+		return
+	}
+	limits := l.Limits()
+	writeJSON(w, http.StatusOK, limitsView{Down: limits.Down, Up: limits.Up})
+}
+
+// add takes a multipart form with "torrent" files and "url" lines, a raw
+// application/x-bittorrent body, or an application/json addRequest. Other
+// types are refused, so a plain form posted from another site is not
+// taken for JSON.
+func (d *Daemon) add(w http.ResponseWriter, r *http.Request) {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var added []*client.Torrent
+	var err error
+	switch ct {
+	case "multipart/form-data":
+		added, err = d.addForm(r)
+	case "application/x-bittorrent":
+		q := r.URL.Query()
+		opts := AddOptions{Dir: q.Get("dir"), Category: q.Get("category")}
+		opts.Paused, _ = strconv.ParseBool(q.Get("paused"))
+		var data []byte
+		if data, err = readTorrent(r.Body); err == nil {
+			added, err = d.addOne(d.AddTorrent(data, opts))
+		}
+	case "application/json":
+		var req addRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err == nil {
+			opts := AddOptions{Dir: req.Dir, Paused: req.Paused, Category: req.Category}
+			added, err = d.addOne(d.AddURL(r.Context(), req.URL, opts))
+		}
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q", ct))
+		return
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, client.ErrExists) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	views := make([]torrentView, len(added))
+	for i, t := range added {
+		views[i] = d.view(t, false)
+	}
+	writeJSON(w, http.StatusCreated, views)
+}
+
+func (d *Daemon) addForm(r *http.Request) ([]*client.Torrent, error) {
+	if err := r.ParseMultipartForm(maxTorrentSize); err != nil {
+		return nil, err
+	}
+	opts := AddOptions{Dir: r.FormValue("dir"), Category: r.FormValue("category")}
+	opts.Paused, _ = strconv.ParseBool(r.FormValue("paused"))
+
+	var added []*client.Torrent
+	for _, fh := range r.MultipartForm.File["torrent"] {
+		f, err := fh.Open()
+		if err != nil {
+			return added, err
+		}
+		data, err := readTorrent(f)
+		f.Close()
+		if err != nil {
+			return added, err
+		}
+		t, err := d.AddTorrent(data, opts)
+		if err != nil {
+			return added, err
+		}
+		added = append(added, t)
+	}
+	for _, urls := range r.MultipartForm.Value["url"] {
+		for _, url := range strings.Fields(urls) {
+			t, err := d.AddURL(r.Context(), url, opts)
+			if err != nil {
+				return added, err
+			}
+			added = append(added, t)
+		}
+	}
+	if len(added) == 0 {
+		return nil, errors.New("no torrent or url given")
+	}
+	return added, nil
+}
+
+func (d *Daemon) addOne(t *client.Torrent, err error) ([]*client.Torrent, error) {
+	if err != nil {
+		return nil, err
+	}
+	return []*client.Torrent{t}, nil
+}
+
+// reply saves after a change and answers 204, or the error.
+func (d *Daemon) reply(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		if err := d.Save(); err != nil {
+			logging.Errorf("[daemon] saving state: %v\n", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (d *Daemon) view(t *client.Torrent, withFiles bool) torrentView {
+	infoHash := t.InfoHash()
+	meta := t.Meta()
+	stats := t.Stats()
+	r := t.ResumeData()
+	v := torrentView{
+		InfoHash:    hex.EncodeToString(infoHash[:]),
+		Name:        meta.Name,
+		State:       t.State().String(),
+		Path:        t.Path(),
+		HasMetadata: t.HasMetadata(),
+		Category:    d.Category(infoHash),
+		Size:        int64(meta.Length),
+		Downloaded:  stats.Downloaded,
+		DownRate:    stats.DownRate,
+		ETA:         -1,
+		Peers:       stats.Peers,
+		Unchoked:    stats.Unchoked,
+		Seeds:       stats.Seeds,
+		Leechers:    stats.Leechers,
+		Wasted:      stats.Wasted,
+		Limits:      limitsView{Down: r.Limits.Down, Up: r.Limits.Up},
+	}
+	if stats.ETA >= 0 {
+		v.ETA = int64(stats.ETA / time.Second)
+	}
+	if err := t.Err(); err != nil {
+		v.Error = err.Error()
+	}
+	if m := t.Magnet(); m != nil {
+		v.Magnet = m.String()
+	}
+	v.Progress = progress(meta, stats, r)
+	if !withFiles || !v.HasMetadata {
+		return v
+	}
+
+	v.Files = []fileView{}
+	for i, f := range files(t, meta, r) {
+		v.Files = append(v.Files, fileView{Index: i, Path: f.Path, Length: int64(f.Length), Priority: f.Priority})
+	}
+	return v
+}
+
+// files lists the torrent's files from its session, or before the first
+// run from the metainfo and resume data.
+func files(t *client.Torrent, meta descriptor.TorrentFile, r client.ResumeData) []engine.File {
+	if session := t.Session(); session != nil {
+		return session.FileList()
+	}
+	if len(meta.Files) == 0 {
+		return []engine.File{{Path: meta.Name, Length: meta.Length, Priority: priority(r, 0)}}
+	}
+	list := make([]engine.File, len(meta.Files))
+	offset := 0
+	for i, f := range meta.Files {
+		list[i] = engine.File{
+			Path:     f.DisplayPath(),
+			Offset:   offset,
+			Length:   f.Length,
+			Priority: priority(r, i),
+			Padding:  f.Padding,
+		}
+		offset += f.Length
+	}
+	return list
+}
+
+// progress is the share of wanted pieces done. Before the first run it
+// comes from the resume data.
+func progress(meta descriptor.TorrentFile, stats engine.Stats, r client.ResumeData) float64 {
+	if stats.Wanted > 0 {
+		return float64(stats.Pieces) / float64(stats.Wanted)
+	}
+	if n := len(meta.PieceHashes); n > 0 {
+		return float64(r.Have.Count()) / float64(n)
+	}
+	return 0
+}
+
+func priority(r client.ResumeData, index int) int {
+	if index < len(r.Priorities) {
+		return r.Priorities[index]
+	}
+	return engine.PriorityNormal
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter) {
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
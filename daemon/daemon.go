@@ -0,0 +1,423 @@
+package daemon
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/client"
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+	"github.com/Sabir222/torrent-at-home/logging"
+	"github.com/Sabir222/torrent-at-home/network/throttle"
+)
+
+const (
+	stateFile    = "state.json"
+	torrentsDir  = "torrents"
+	saveInterval = 30 * time.Second
+	// maxTorrentSize bounds .torrent files fetched by URL or uploaded.
+	maxTorrentSize = 16 << 20
+	fetchTimeout   = 30 * time.Second
+)
+
+var ErrBadInfoHash = errors.New("infohash must be 40 hex digits")
+
+// Config sets up a daemon.
+type Config struct {
+	Client client.Config
+	// StateDir keeps the torrent list and resume data across restarts.
+	StateDir string
+	// DownloadDir is where torrents go unless added with a directory.
+	DownloadDir string
+	// Username and Password guard every route of Handler; with no
+	// Password they are open.
+	Username string
+	Password string
+	// Hosts are the names, besides localhost, the daemon may be reached
+	// under. IP addresses are always accepted; any other Host header is
+	// refused, which stops DNS rebinding pages from reaching it.
+	Hosts []string
+}
+
+// Daemon runs a client for a long time, saving its torrents and their
+// progress so a restart carries on where it stopped.
+type Daemon struct {
+	Client *client.Client
+
+	stateDir    string
+	downloadDir string
+	username    string
+	password    string
+	hosts       []string
+	http        *http.Client
+
+	mu         sync.Mutex
+	categories map[[20]byte]string
+	// sids are the qBittorrent API logins and when they expire.
+	sids map[string]time.Time
+	// rid numbers the qBittorrent sync/maindata replies. Each is a full
+	// update, which clients accept in place of a diff.
+	rid atomic.Int64
+	// trIDs are the Transmission ids handed out this run.
+	trIDs       map[[20]byte]int
+	trNextID    int
+	trSessionID string
+	peerPort    uint16
+
+	saveMu sync.Mutex
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// AddOptions are the choices made when adding a torrent.
+type AddOptions struct {
+	// Dir replaces the download directory.
+	Dir      string
+	Paused   bool
+	Category string
+}
+
+// savedTorrent is one entry of the state file. The metainfo itself is
+// kept next to it as torrents/<infohash>.torrent once known.
+type savedTorrent struct {
+	InfoHash   string `json:"info_hash"`
+	Path       string `json:"path,omitempty"`
+	Dir        string `json:"dir,omitempty"`
+	Magnet     string `json:"magnet,omitempty"`
+	Have       []byte `json:"have,omitempty"`
+	Priorities []int  `json:"priorities,omitempty"`
+	Sequential bool   `json:"sequential,omitempty"`
+	Down       int    `json:"down,omitempty"`
+	Up         int    `json:"up,omitempty"`
+	Paused     bool   `json:"paused,omitempty"`
+	Category   string `json:"category,omitempty"`
+	Key        uint32 `json:"key,omitempty"`
+}
+
+type savedState struct {
+	Down     int            `json:"down,omitempty"`
+	Up       int            `json:"up,omitempty"`
+	Torrents []savedTorrent `json:"torrents"`
+}
+
+// New starts the client and restores the torrents saved in StateDir.
+func New(cfg Config) (*Daemon, error) {
+	if err := os.MkdirAll(filepath.Join(cfg.StateDir, torrentsDir), 0755); err != nil {
+		return nil, err
+	}
+	c, err := client.New(cfg.Client)
+	if err != nil {
+		return nil, err
+	}
+	d := &Daemon{
+		Client:      c,
+		stateDir:    cfg.StateDir,
+		downloadDir: cfg.DownloadDir,
+		username:    cfg.Username,
+		password:    cfg.Password,
+		hosts:       cfg.Hosts,
+		http:        cfg.Client.HTTP,
+		categories:  make(map[[20]byte]string),
+		sids:        make(map[string]time.Time),
+		trIDs:       make(map[[20]byte]int),
+		trSessionID: newSessionID(),
+		peerPort:    cfg.Client.Port,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if err := d.load(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	go d.autosave()
+	return d, nil
+}
+
+// Close saves the state and stops every torrent.
+func (d *Daemon) Close() error {
+	close(d.stop)
+	<-d.done
+	err := d.Save()
+	if cerr := d.Client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (d *Daemon) autosave() {
+	defer close(d.done)
+	t := time.NewTicker(saveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-t.C:
+			if err := d.Save(); err != nil {
+				logging.Errorf("[daemon] saving state: %v\n", err)
+			}
+		}
+	}
+}
+
+// AddTorrent adds the contents of a .torrent file, stored under its name
+// in the download directory. With client.ErrExists the torrent added
+// before is returned.
+func (d *Daemon) AddTorrent(data []byte, opts AddOptions) (*client.Torrent, error) {
+	meta, err := descriptor.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.writeMetainfo(meta.InfoHash, data); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(d.dir(opts.Dir), meta.Name)
+	t, err := d.Client.Restore(meta, path, descriptor.Options{}, client.ResumeData{Paused: opts.Paused})
+	if errors.Is(err, client.ErrExists) {
+		t, _ = d.Client.Get(meta.InfoHash)
+		return t, err
+	}
+	if err != nil {
+		os.Remove(d.metainfoPath(meta.InfoHash))
+		return nil, err
+	}
+	d.SetCategory(meta.InfoHash, opts.Category)
+	logging.Infof("[daemon] added %s\n", meta.Name)
+	return t, d.Save()
+}
+
+// AddMagnet adds a magnet link; its metadata is fetched once it starts.
+// With client.ErrExists the torrent added before is returned.
+func (d *Daemon) AddMagnet(uri string, opts AddOptions) (*client.Torrent, error) {
+	m, err := descriptor.ParseMagnet(uri)
+	if err != nil {
+		return nil, err
+	}
+	t, err := d.Client.RestoreMagnet(m, d.dir(opts.Dir), descriptor.Options{}, client.ResumeData{Paused: opts.Paused})
+	if errors.Is(err, client.ErrExists) {
+		t, _ = d.Client.Get(m.InfoHash)
+		return t, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.SetCategory(m.InfoHash, opts.Category)
+	logging.Infof("[daemon] added magnet %x\n", m.InfoHash)
+	return t, d.Save()
+}
+
+// AddURL adds a magnet link or downloads a .torrent file over HTTP.
+func (d *Daemon) AddURL(ctx context.Context, url string, opts AddOptions) (*client.Torrent, error) {
+	if strings.HasPrefix(url, "magnet:") {
+		return d.AddMagnet(url, opts)
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("unsupported URL %q", url)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := d.http
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	data, err := readTorrent(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return d.AddTorrent(data, opts)
+}
+
+// Remove forgets a torrent, deleting its data too when asked.
+func (d *Daemon) Remove(infoHash [20]byte, deleteData bool) error {
+	if err := d.Client.Remove(infoHash, deleteData); err != nil {
+		return err
+	}
+	os.Remove(d.metainfoPath(infoHash))
+	d.SetCategory(infoHash, "")
+	d.mu.Lock()
+	delete(d.trIDs, infoHash)
+	d.mu.Unlock()
+	return d.Save()
+}
+
+// Category is the label a torrent was added with, empty for none.
+func (d *Daemon) Category(infoHash [20]byte) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.categories[infoHash]
+}
+
+func (d *Daemon) SetCategory(infoHash [20]byte, category string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if category == "" {
+		delete(d.categories, infoHash)
+	} else {
+		d.categories[infoHash] = category
+	}
+}
+
+// Save writes the torrent list and resume data.
+func (d *Daemon) Save() error {
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+
+	limits := d.Client.Limits()
+	state := savedState{Down: limits.Down, Up: limits.Up, Torrents: []savedTorrent{}}
+	for _, t := range d.Client.Torrents() {
+		r := t.ResumeData()
+		infoHash := t.InfoHash()
+		saved := savedTorrent{
+			InfoHash:   hex.EncodeToString(infoHash[:]),
+			Path:       t.Path(),
+			Have:       r.Have,
+			Priorities: r.Priorities,
+			Sequential: r.Sequential,
+			Down:       r.Limits.Down,
+			Up:         r.Limits.Up,
+			Paused:     r.Paused,
+			Category:   d.Category(infoHash),
+			Key:        r.Key,
+		}
+		if m := t.Magnet(); m != nil {
+			saved.Magnet = m.String()
+			saved.Dir = t.Dir()
+			if data := t.Metainfo(); data != nil {
+				if err := d.writeMetainfo(infoHash, data); err != nil {
+					return err
+				}
+			}
+		}
+		state.Torrents = append(state.Torrents, saved)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(d.stateDir, stateFile), data)
+}
+
+func (d *Daemon) load() error {
+	data, err := os.ReadFile(filepath.Join(d.stateDir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("reading %s: %w", stateFile, err)
+	}
+	d.Client.SetLimits(throttle.Limits{Down: state.Down, Up: state.Up})
+
+	for _, saved := range state.Torrents {
+		if err := d.restore(saved); err != nil {
+			logging.Errorf("[daemon] not restoring %s: %v\n", saved.InfoHash, err)
+		}
+	}
+	logging.Infof("[daemon] restored %d torrent(s)\n", len(d.Client.Torrents()))
+	return nil
+}
+
+func (d *Daemon) restore(saved savedTorrent) error {
+	infoHash, err := ParseInfoHash(saved.InfoHash)
+	if err != nil {
+		return err
+	}
+	r := client.ResumeData{
+		Have:       saved.Have,
+		Priorities: saved.Priorities,
+		Sequential: saved.Sequential,
+		Limits:     throttle.Limits{Down: saved.Down, Up: saved.Up},
+		Paused:     saved.Paused,
+		Key:        saved.Key,
+	}
+	d.SetCategory(infoHash, saved.Category)
+
+	meta, err := descriptor.Open(d.metainfoPath(infoHash))
+	if err == nil && saved.Path != "" {
+		_, err = d.Client.Restore(meta, saved.Path, descriptor.Options{}, r)
+		return err
+	}
+	if saved.Magnet == "" {
+		return err
+	}
+	m, err := descriptor.ParseMagnet(saved.Magnet)
+	if err != nil {
+		return err
+	}
+	_, err = d.Client.RestoreMagnet(m, saved.Dir, descriptor.Options{}, r)
+	return err
+}
+
+func (d *Daemon) dir(dir string) string {
+	if dir == "" {
+		return d.downloadDir
+	}
+	return dir
+}
+
+func (d *Daemon) metainfoPath(infoHash [20]byte) string {
+	return filepath.Join(d.stateDir, torrentsDir, hex.EncodeToString(infoHash[:])+".torrent")
+}
+
+func (d *Daemon) writeMetainfo(infoHash [20]byte, data []byte) error {
+	return writeFile(d.metainfoPath(infoHash), data)
+}
+
+// writeFile replaces a file through a rename so a crash never leaves it
+// half written.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readTorrent(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxTorrentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxTorrentSize {
+		return nil, errors.New("torrent file too large")
+	}
+	return data, nil
+}
+
+// ParseInfoHash reads a hex encoded v1 infohash.
+func ParseInfoHash(s string) ([20]byte, error) {
+	var infoHash [20]byte
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != len(infoHash) {
+		return infoHash, ErrBadInfoHash
+	}
+	copy(infoHash[:], raw)
+	return infoHash, nil
+}
@@ -0,0 +1,327 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/client"
+	"github.com/Sabir222/torrent-at-home/data/descriptor"
+	"github.com/Sabir222/torrent-at-home/network/throttle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webSeeded builds a one piece .torrent file served by a web seed.
+func webSeeded(t *testing.T, name, content string) ([]byte, [20]byte) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, content)
+	}))
+	t.Cleanup(srv.Close)
+
+	hash := sha1.Sum([]byte(content))
+	info := fmt.Sprintf("d6:lengthi%de4:name%d:%s12:piece lengthi%de6:pieces20:%se",
+		len(content), len(name), name, len(content), hash[:])
+	data := fmt.Sprintf("d4:info%s8:url-list%d:%se", info, len(srv.URL), srv.URL)
+	return []byte(data), sha1.Sum([]byte(info))
+}
+
+func newDaemon(t *testing.T, stateDir, downloadDir string) (*Daemon, *httptest.Server) {
+	d, err := New(Config{StateDir: stateDir, DownloadDir: downloadDir})
+	require.NoError(t, err)
+	srv := httptest.NewServer(d.Handler())
+	t.Cleanup(srv.Close)
+	return d, srv
+}
+
+func call(t *testing.T, method, url, contentType string, body io.Reader, out any) int {
+	req, err := http.NewRequest(method, url, body)
+	require.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if out != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func wait(t *testing.T, d *Daemon, infoHash [20]byte) {
+	tor, err := d.Client.Get(infoHash)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, tor.Wait(ctx))
+}
+
+func TestDaemonAPI(t *testing.T) {
+	stateDir, downloadDir := t.TempDir(), t.TempDir()
+	d, srv := newDaemon(t, stateDir, downloadDir)
+	defer d.Close()
+
+	data, infoHash := webSeeded(t, "upload", "upload")
+	hash := fmt.Sprintf("%x", infoHash)
+	api := srv.URL + "/api/torrents"
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, err := mw.CreateFormFile("torrent", "upload.torrent")
+	require.NoError(t, err)
+	fw.Write(data)
+	mw.Close()
+	var added []torrentView
+	require.Equal(t, http.StatusCreated, call(t, "POST", api, mw.FormDataContentType(), &form, &added))
+	require.Len(t, added, 1)
+	assert.Equal(t, hash, added[0].InfoHash)
+	assert.Equal(t, "upload", added[0].Name)
+
+	wait(t, d, infoHash)
+	got, err := os.ReadFile(filepath.Join(downloadDir, "upload"))
+	require.NoError(t, err)
+	assert.Equal(t, "upload", string(got))
+
+	var list []torrentView
+	require.Equal(t, http.StatusOK, call(t, "GET", api, "", nil, &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, "done", list[0].State)
+	assert.Equal(t, 1.0, list[0].Progress)
+	assert.Zero(t, list[0].ETA)
+
+	// A raw torrent body, added paused.
+	raw, rawHash := webSeeded(t, "raw", "raw")
+	require.Equal(t, http.StatusCreated, call(t, "POST", api+"?paused=true", "application/x-bittorrent", bytes.NewReader(raw), nil))
+	assert.Equal(t, http.StatusConflict, call(t, "POST", api, "application/x-bittorrent", bytes.NewReader(raw), nil))
+	rawURL := fmt.Sprintf("%s/%x", api, rawHash)
+
+	var detail torrentView
+	require.Equal(t, http.StatusOK, call(t, "GET", rawURL, "", nil, &detail))
+	assert.Equal(t, "paused", detail.State)
+	assert.Equal(t, int64(-1), detail.ETA)
+	require.Len(t, detail.Files, 1)
+	assert.Equal(t, 4, detail.Files[0].Priority)
+
+	assert.Equal(t, http.StatusNoContent, call(t, "PUT", rawURL+"/files/0", "application/json", strings.NewReader(`{"priority": 6}`), nil))
+	assert.Equal(t, http.StatusNotFound, call(t, "PUT", rawURL+"/files/3", "application/json", strings.NewReader(`{"priority": 6}`), nil))
+	assert.Equal(t, http.StatusBadRequest, call(t, "PUT", rawURL+"/files/0", "application/json", strings.NewReader(`{}`), nil))
+	for _, bad := range []string{`{"priority": -1}`, `{"priority": 3}`, `{"priority": 8}`} {
+		assert.Equal(t, http.StatusBadRequest, call(t, "PUT", rawURL+"/files/0", "application/json", strings.NewReader(bad), nil), bad)
+	}
+	require.Equal(t, http.StatusOK, call(t, "GET", rawURL, "", nil, &detail))
+	assert.Equal(t, 6, detail.Files[0].Priority)
+
+	var limits limitsView
+	require.Equal(t, http.StatusOK, call(t, "PUT", rawURL+"/limits", "application/json", strings.NewReader(`{"down": 2048, "up": 1024}`), &limits))
+	assert.Equal(t, limitsView{Down: 2048, Up: 1024}, limits)
+	require.Equal(t, http.StatusOK, call(t, "PUT", srv.URL+"/api/limits", "application/json", strings.NewReader(`{"down": 4096}`), &limits))
+	assert.Equal(t, limitsView{Down: 4096}, limits)
+
+	var trackers []trackerView
+	require.Equal(t, http.StatusOK, call(t, "GET", rawURL+"/trackers", "", nil, &trackers))
+	assert.Empty(t, trackers)
+	var peers []peerView
+	require.Equal(t, http.StatusOK, call(t, "GET", api+"/"+hash+"/peers", "", nil, &peers))
+	assert.Empty(t, peers)
+
+	assert.Equal(t, http.StatusNoContent, call(t, "POST", rawURL+"/resume", "", nil, nil))
+	wait(t, d, rawHash)
+
+	assert.Equal(t, http.StatusBadRequest, call(t, "GET", api+"/nothex", "", nil, nil))
+	assert.Equal(t, http.StatusNotFound, call(t, "POST", api+"/"+strings.Repeat("0", 40)+"/pause", "", nil, nil))
+	assert.Equal(t, http.StatusBadRequest, call(t, "POST", api, "application/json", strings.NewReader(`{"url": "ftp://x"}`), nil))
+
+	assert.Equal(t, http.StatusNoContent, call(t, "DELETE", api+"/"+hash+"?data=true", "", nil, nil))
+	assert.NoFileExists(t, filepath.Join(downloadDir, "upload"))
+	assert.NoFileExists(t, filepath.Join(stateDir, torrentsDir, hash+".torrent"))
+	require.Equal(t, http.StatusOK, call(t, "GET", api, "", nil, &list))
+	assert.Len(t, list, 1)
+}
+
+func TestDaemonRestart(t *testing.T) {
+	stateDir, downloadDir := t.TempDir(), t.TempDir()
+	d, srv := newDaemon(t, stateDir, downloadDir)
+
+	done, doneHash := webSeeded(t, "done", "done")
+	later, laterHash := webSeeded(t, "later", "later")
+	torrentSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(later)
+	}))
+	defer torrentSrv.Close()
+
+	_, err := d.AddTorrent(done, AddOptions{Category: "iso"})
+	require.NoError(t, err)
+	wait(t, d, doneHash)
+	body := fmt.Sprintf(`{"url": %q, "paused": true}`, torrentSrv.URL)
+	require.Equal(t, http.StatusCreated, call(t, "POST", srv.URL+"/api/torrents", "application/json", strings.NewReader(body), nil))
+	magnet := "magnet:?xt=urn:btih:" + strings.Repeat("ab", 20) + "&dn=pending"
+	_, err = d.AddMagnet(magnet, AddOptions{Dir: filepath.Join(downloadDir, "magnets"), Paused: true})
+	require.NoError(t, err)
+	d.Client.SetLimits(throttle.Limits{Down: 1024})
+	keys := map[[20]byte]uint32{}
+	for _, tor := range d.Client.Torrents() {
+		keys[tor.InfoHash()] = tor.ResumeData().Key
+	}
+	require.NoError(t, d.Close())
+
+	d, _ = newDaemon(t, stateDir, downloadDir)
+	defer d.Close()
+	require.Len(t, d.Client.Torrents(), 3)
+	for _, tor := range d.Client.Torrents() {
+		assert.Equal(t, keys[tor.InfoHash()], tor.ResumeData().Key, "tracker keys survive a restart")
+	}
+	assert.Equal(t, 1024, d.Client.Limits().Down)
+
+	tor, err := d.Client.Get(doneHash)
+	require.NoError(t, err)
+	wait(t, d, doneHash)
+	assert.Equal(t, int64(0), tor.Stats().Downloaded, "restored pieces are not fetched again")
+	assert.Equal(t, "iso", d.Category(doneHash))
+
+	tor, err = d.Client.Get(laterHash)
+	require.NoError(t, err)
+	assert.Equal(t, client.StatePaused, tor.State())
+	assert.Equal(t, filepath.Join(downloadDir, "later"), tor.Path())
+
+	m, err := descriptor.ParseMagnet(magnet)
+	require.NoError(t, err)
+	tor, err = d.Client.Get(m.InfoHash)
+	require.NoError(t, err)
+	assert.Equal(t, client.StatePaused, tor.State())
+	assert.Equal(t, filepath.Join(downloadDir, "magnets"), tor.Dir())
+	assert.Equal(t, "pending", tor.Meta().Name)
+}
+
+func TestDaemonAPIRefusesOtherSites(t *testing.T) {
+	d, srv := newDaemon(t, t.TempDir(), t.TempDir())
+	defer d.Close()
+	api := srv.URL + "/api/torrents"
+	body := `{"url": "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567", "dir": "/tmp/evil"}`
+
+	// A form another site posts is neither JSON nor let through.
+	assert.Equal(t, http.StatusUnsupportedMediaType, call(t, "POST", api, "text/plain", strings.NewReader(body), nil))
+	assert.Equal(t, http.StatusUnsupportedMediaType, call(t, "POST", api, "", strings.NewReader(body), nil))
+
+	req, err := http.NewRequest("POST", api, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "http://evil.example")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Empty(t, d.Client.Torrents())
+
+	// The daemon's own pages may call it.
+	req, err = http.NewRequest("GET", api, nil)
+	require.NoError(t, err)
+	req.Header.Set("Origin", srv.URL)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestDaemonAPIHosts(t *testing.T) {
+	d, err := New(Config{StateDir: t.TempDir(), DownloadDir: t.TempDir(), Hosts: []string{"nas.lan"}})
+	require.NoError(t, err)
+	defer d.Close()
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	get := func(host string) int {
+		req, err := http.NewRequest("GET", srv.URL+"/api/torrents", nil)
+		require.NoError(t, err)
+		req.Host = host
+		req.Header.Set("Origin", "http://"+host)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// A rebound name points at the daemon and matches its own Origin.
+	assert.Equal(t, http.StatusForbidden, get("evil.example:8181"))
+	for _, host := range []string{"localhost:8181", "127.0.0.1:8181", "[::1]:8181", "192.168.1.2", "NAS.lan:8181"} {
+		assert.Equal(t, http.StatusOK, get(host), host)
+	}
+}
+
+func TestDaemonAPIPassword(t *testing.T) {
+	d, err := New(Config{StateDir: t.TempDir(), DownloadDir: t.TempDir(), Username: "admin", Password: "secret"})
+	require.NoError(t, err)
+	defer d.Close()
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	get := func(user, pass string) int {
+		req, err := http.NewRequest("GET", srv.URL+"/api/torrents", nil)
+		require.NoError(t, err)
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusUnauthorized, get("", ""))
+	assert.Equal(t, http.StatusUnauthorized, get("admin", "wrong"))
+	assert.Equal(t, http.StatusOK, get("admin", "secret"))
+}
+
+func TestDaemonPasswordEveryRoute(t *testing.T) {
+	d, err := New(Config{StateDir: t.TempDir(), DownloadDir: t.TempDir(), Username: "admin", Password: "secret"})
+	require.NoError(t, err)
+	defer d.Close()
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	for path, want := range map[string]int{
+		"/api/torrents":         http.StatusUnauthorized,
+		"/api/events":           http.StatusUnauthorized,
+		"/metrics":              http.StatusUnauthorized,
+		"/transmission/rpc":     http.StatusUnauthorized,
+		"/api/v2/torrents/info": http.StatusForbidden,
+	} {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, want, resp.StatusCode, path)
+	}
+
+	// One login works everywhere: the qBittorrent cookie for the
+	// native API, basic auth for metrics.
+	resp, err := http.PostForm(srv.URL+"/api/v2/auth/login", url.Values{"username": {"admin"}, "password": {"secret"}})
+	require.NoError(t, err)
+	resp.Body.Close()
+	req, err := http.NewRequest("GET", srv.URL+"/api/torrents", nil)
+	require.NoError(t, err)
+	for _, c := range resp.Cookies() {
+		req.AddCookie(c)
+	}
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err = http.NewRequest("GET", srv.URL+"/metrics", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,114 @@
+package daemon
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/engine"
+)
+
+const (
+	// eventBuffer is how far a slow events client may fall behind before
+	// it misses events.
+	eventBuffer = 256
+	// keepAlive is how often an idle event stream gets a comment, so
+	// proxies don't time it out.
+	keepAlive = 15 * time.Second
+)
+
+// EventView is how events are rendered as JSON: the data of a server-sent
+// event, named by its kind, and a line of the CLI's -json output.
+type EventView struct {
+	Type     string       `json:"type"`
+	Time     time.Time    `json:"time"`
+	InfoHash string       `json:"info_hash"`
+	Peer     string       `json:"peer,omitempty"`
+	Piece    *int         `json:"piece,omitempty"`
+	Done     *int         `json:"done,omitempty"`
+	Wanted   *int         `json:"wanted,omitempty"`
+	Tracker  *trackerView `json:"tracker,omitempty"`
+	State    string       `json:"state,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+func NewEventView(e engine.Event) EventView {
+	v := EventView{
+		Type:     e.Kind.String(),
+		Time:     e.Time,
+		InfoHash: hex.EncodeToString(e.InfoHash[:]),
+		Peer:     e.Peer,
+		State:    e.State,
+	}
+	if e.Err != nil {
+		v.Error = e.Err.Error()
+	}
+	switch e.Kind {
+	case engine.EventPieceVerified:
+		v.Piece, v.Done, v.Wanted = &e.Piece, &e.Done, &e.Wanted
+	case engine.EventPieceFailed, engine.EventError:
+		v.Piece = &e.Piece
+	case engine.EventCompleted:
+		v.Done, v.Wanted = &e.Done, &e.Wanted
+	case engine.EventTrackerAnnounce:
+		if tr := e.Tracker; tr != nil {
+			v.Tracker = &trackerView{URL: tr.URL, Peers: tr.Peers, Error: tr.Err, LatencyMS: tr.Latency.Milliseconds()}
+		}
+	}
+	return v
+}
+
+// serveEvents streams the client's events as server-sent events until the
+// request is cancelled. ?hash= limits them to one torrent.
+func (d *Daemon) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	var only *[20]byte
+	if hash := r.URL.Query().Get("hash"); hash != "" {
+		infoHash, err := ParseInfoHash(hash)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		only = &infoHash
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	events, unsubscribe := d.Client.Subscribe(eventBuffer)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e := <-events:
+			if only != nil && e.InfoHash != *only {
+				continue
+			}
+			data, err := json.Marshal(NewEventView(e))
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	d, srv := newDaemon(t, t.TempDir(), t.TempDir())
+	defer d.Close()
+
+	assert.Equal(t, http.StatusBadRequest, call(t, http.MethodGet, srv.URL+"/api/events?hash=nope", "", nil, nil))
+
+	data, infoHash := webSeeded(t, "events", "events")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/events?hash=%x", srv.URL, infoHash), nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	_, err = d.AddTorrent(data, AddOptions{})
+	require.NoError(t, err)
+
+	var seen []string
+	var last EventView
+	scanner := bufio.NewScanner(resp.Body)
+	for last.State != "done" && scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		last = EventView{}
+		require.NoError(t, json.Unmarshal([]byte(data), &last))
+		assert.Equal(t, fmt.Sprintf("%x", infoHash), last.InfoHash)
+		seen = append(seen, last.Type+" "+last.State)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{
+		"state_changed queued",
+		"state_changed downloading",
+		"piece_verified ",
+		"completed ",
+		"state_changed done",
+	}, seen)
+}
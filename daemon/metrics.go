@@ -0,0 +1,225 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sabir222/torrent-at-home/client"
+	"github.com/Sabir222/torrent-at-home/engine"
+)
+
+// /metrics serves the Prometheus text format, written by hand to keep the
+// client library out of the dependencies.
+
+const metricsPrefix = "torrent_at_home_"
+
+// torrentSample is one torrent's numbers, taken once per scrape.
+type torrentSample struct {
+	labels  []string
+	state   client.State
+	stats   engine.Stats
+	metrics engine.Metrics
+}
+
+// promWriter writes metric families; samples of a family must follow its
+// header without other families in between.
+type promWriter struct {
+	w *bufio.Writer
+}
+
+func (p promWriter) family(name, kind, help string) {
+	fmt.Fprintf(p.w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, kind)
+}
+
+// sample writes a value with labels given as name, value pairs.
+func (p promWriter) sample(name string, value float64, labels ...string) {
+	p.w.WriteString(metricsPrefix + name)
+	if len(labels) > 0 {
+		p.w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				p.w.WriteByte(',')
+			}
+			fmt.Fprintf(p.w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		p.w.WriteByte('}')
+	}
+	p.w.WriteByte(' ')
+	p.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	p.w.WriteByte('\n')
+}
+
+// histogram writes the samples of a histogram with labels as in sample.
+func (p promWriter) histogram(name string, h engine.Histogram, labels ...string) {
+	var cumulative int64
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		p.sample(name+"_bucket", float64(cumulative), withLabel(labels, "le", strconv.FormatFloat(bound, 'g', -1, 64))...)
+	}
+	p.sample(name+"_bucket", float64(h.Count), withLabel(labels, "le", "+Inf")...)
+	p.sample(name+"_sum", h.Sum, labels...)
+	p.sample(name+"_count", float64(h.Count), labels...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func (d *Daemon) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var samples []torrentSample
+	for _, t := range d.Client.Torrents() {
+		infoHash := t.InfoHash()
+		sample := torrentSample{
+			labels: []string{"infohash", hex.EncodeToString(infoHash[:]), "name", t.Meta().Name},
+			state:  t.State(),
+			stats:  t.Stats(),
+		}
+		if session := t.Session(); session != nil {
+			sample.metrics = session.Metrics()
+		}
+		samples = append(samples, sample)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	p := promWriter{bw}
+
+	perTorrent := func(name, kind, help string, value func(torrentSample) float64) {
+		p.family(name, kind, help)
+		for _, s := range samples {
+			p.sample(name, value(s), s.labels...)
+		}
+	}
+	perTorrent("downloaded_bytes_total", "counter", "Verified bytes downloaded since the torrent started.",
+		func(s torrentSample) float64 { return float64(s.stats.Downloaded) })
+	// Nothing is uploaded yet; the series are there for dashboards to
+	// keep once it is.
+	perTorrent("uploaded_bytes_total", "counter", "Bytes uploaded to peers since the torrent started.",
+		func(torrentSample) float64 { return 0 })
+	perTorrent("download_rate_bytes", "gauge", "Download rate in bytes per second.",
+		func(s torrentSample) float64 { return float64(s.stats.DownRate) })
+	perTorrent("pieces_verified_total", "counter", "Pieces downloaded and verified.",
+		func(s torrentSample) float64 { return float64(s.metrics.PiecesVerified) })
+	perTorrent("pieces_failed_total", "counter", "Pieces that failed verification.",
+		func(s torrentSample) float64 { return float64(s.metrics.PiecesFailed) })
+	perTorrent("pieces_done", "gauge", "Wanted pieces verified and written.",
+		func(s torrentSample) float64 { return float64(s.stats.Pieces) })
+	perTorrent("pieces_wanted", "gauge", "Pieces of the files not skipped.",
+		func(s torrentSample) float64 { return float64(s.stats.Wanted) })
+	perTorrent("peers_connected", "gauge", "Connected peers.",
+		func(s torrentSample) float64 { return float64(s.metrics.Peers) })
+	perTorrent("peers_half_open", "gauge", "Outgoing peer connections still being set up.",
+		func(s torrentSample) float64 { return float64(s.metrics.HalfOpen) })
+
+	p.family("peers_choke_state", "gauge", "Connected peers by whether they choke us.")
+	for _, s := range samples {
+		p.sample("peers_choke_state", float64(s.metrics.Choked), withLabel(s.labels, "state", "choked")...)
+		p.sample("peers_choke_state", float64(s.metrics.Peers-s.metrics.Choked), withLabel(s.labels, "state", "unchoked")...)
+	}
+
+	// Hash failures and disk writes are summed over the torrents, since
+	// their own labels matter more than the torrent's.
+	failures := map[string]int64{}
+	diskWrites := engine.Histogram{}
+	for _, s := range samples {
+		for c, n := range s.metrics.HashFailures {
+			failures[c] += n
+		}
+		diskWrites.Merge(s.metrics.DiskWrites)
+	}
+	p.family("hash_failures_total", "counter", "Pieces that failed verification, by the client that sent them.")
+	for _, c := range sortedKeys(failures) {
+		name := c
+		if name == "" {
+			name = "unknown"
+		}
+		p.sample("hash_failures_total", float64(failures[c]), "client", name)
+	}
+
+	// Announces are counted by the client as they are made, so they keep
+	// growing when torrents are removed.
+	trackers := map[string]*engine.AnnounceCounts{}
+	for raw, c := range d.Client.Announces() {
+		host := trackerHost(raw)
+		totals := trackers[host]
+		if totals == nil {
+			totals = &engine.AnnounceCounts{}
+			trackers[host] = totals
+		}
+		totals.Total += c.Total
+		totals.Errors += c.Errors
+		totals.Latency.Merge(c.Latency)
+	}
+	hosts := sortedKeys(trackers)
+	p.family("tracker_announces_total", "counter", "Announces made, by tracker.")
+	for _, host := range hosts {
+		p.sample("tracker_announces_total", float64(trackers[host].Total), "tracker", host)
+	}
+	p.family("tracker_announce_errors_total", "counter", "Announces that failed, by tracker.")
+	for _, host := range hosts {
+		p.sample("tracker_announce_errors_total", float64(trackers[host].Errors), "tracker", host)
+	}
+	p.family("tracker_announce_seconds", "histogram", "Time taken to announce, by tracker.")
+	for _, host := range hosts {
+		p.histogram("tracker_announce_seconds", trackers[host].Latency, "tracker", host)
+	}
+
+	p.family("disk_write_seconds", "histogram", "Time taken to write a verified piece.")
+	p.histogram("disk_write_seconds", diskWrites)
+
+	states := map[client.State]int{}
+	var downloaded, rate int64
+	for _, s := range samples {
+		states[s.state]++
+		downloaded += s.stats.Downloaded
+		rate += s.stats.DownRate
+	}
+	p.family("torrents", "gauge", "Torrents by state.")
+	for _, st := range []client.State{client.StateQueued, client.StateDownloading, client.StatePaused, client.StateDone, client.StateFailed} {
+		p.sample("torrents", float64(states[st]), "state", st.String())
+	}
+	p.family("client_downloaded_bytes_total", "counter", "Verified bytes downloaded by all torrents.")
+	p.sample("client_downloaded_bytes_total", float64(downloaded))
+	p.family("client_uploaded_bytes_total", "counter", "Bytes uploaded to peers by all torrents.")
+	p.sample("client_uploaded_bytes_total", 0)
+	p.family("client_download_rate_bytes", "gauge", "Download rate of all torrents in bytes per second.")
+	p.sample("client_download_rate_bytes", float64(rate))
+	p.family("client_connections", "gauge", "Peer connections held by all torrents.")
+	p.sample("client_connections", float64(d.Client.Connections()))
+	limits := d.Client.Limits()
+	p.family("client_rate_limit_bytes", "gauge", "Global rate limits in bytes per second, zero when unlimited.")
+	p.sample("client_rate_limit_bytes", float64(limits.Down), "direction", "down")
+	p.sample("client_rate_limit_bytes", float64(limits.Up), "direction", "up")
+}
+
+func withLabel(labels []string, name, value string) []string {
+	return append(append([]string(nil), labels...), name, value)
+}
+
+// trackerHost labels a tracker by scheme and host, leaving out any
+// passkey in its path or query.
+func trackerHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+package daemon
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	d, srv := newDaemon(t, t.TempDir(), t.TempDir())
+	defer d.Close()
+
+	data, infoHash := webSeeded(t, `say "hi"`, "metrics")
+	_, err := d.AddTorrent(data, AddOptions{})
+	require.NoError(t, err)
+	wait(t, d, infoHash)
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	out := string(body)
+
+	labels := fmt.Sprintf(`infohash="%x",name="say \"hi\""`, infoHash)
+	assert.Contains(t, out, "# TYPE torrent_at_home_downloaded_bytes_total counter\n")
+	assert.Contains(t, out, "torrent_at_home_downloaded_bytes_total{"+labels+"} 7\n")
+	assert.Contains(t, out, "# TYPE torrent_at_home_uploaded_bytes_total counter\n")
+	assert.Contains(t, out, "torrent_at_home_uploaded_bytes_total{"+labels+"} 0\n")
+	assert.Contains(t, out, "torrent_at_home_client_uploaded_bytes_total 0\n")
+	assert.Contains(t, out, "torrent_at_home_pieces_verified_total{"+labels+"} 1\n")
+	assert.Contains(t, out, "torrent_at_home_peers_choke_state{"+labels+`,state="unchoked"} 0`+"\n")
+	assert.Contains(t, out, `torrent_at_home_disk_write_seconds_bucket{le="+Inf"} 1`+"\n")
+	assert.Contains(t, out, "torrent_at_home_disk_write_seconds_count 1\n")
+	assert.Contains(t, out, `torrent_at_home_torrents{state="done"} 1`+"\n")
+}
+
+func TestMetricsAnnounces(t *testing.T) {
+	d, srv := newDaemon(t, t.TempDir(), t.TempDir())
+	defer d.Close()
+
+	// The tracker fails, so the torrent comes from its web seed instead.
+	content := "announced"
+	seed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/announce" {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, content)
+	}))
+	defer seed.Close()
+	hash := sha1.Sum([]byte(content))
+	info := fmt.Sprintf("d6:lengthi%de4:name7:tracked12:piece lengthi%de6:pieces20:%se", len(content), len(content), hash[:])
+	announce := seed.URL + "/announce"
+	data := []byte(fmt.Sprintf("d8:announce%d:%s4:info%s8:url-list%d:%se", len(announce), announce, info, len(seed.URL), seed.URL))
+	infoHash := sha1.Sum([]byte(info))
+
+	for i := 0; i < 2; i++ {
+		_, err := d.AddTorrent(data, AddOptions{})
+		require.NoError(t, err)
+		wait(t, d, infoHash)
+		require.NoError(t, d.Remove(infoHash, true))
+	}
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	out := string(body)
+
+	// Both announces still count after the torrent was removed.
+	tracker := fmt.Sprintf(`tracker="%s"`, trackerHost(announce))
+	assert.Contains(t, out, "torrent_at_home_tracker_announces_total{"+tracker+"} 2\n")
+	assert.Contains(t, out, "torrent_at_home_tracker_announce_errors_total{"+tracker+"} 2\n")
+	assert.Contains(t, out, "torrent_at_home_tracker_announce_seconds_bucket{"+tracker+`,le="+Inf"} 2`+"\n")
+	assert.Contains(t, out, "torrent_at_home_tracker_announce_seconds_count{"+tracker+"} 2\n")
+}
+
+func TestTrackerHost(t *testing.T) {
+	cases := map[string]string{
+		"http://tracker.example:6969/announce":           "http://tracker.example:6969",
+		"https://private.example/a1b2c3passkey/announce": "https://private.example",
+		"udp://open.example:1337":                        "udp://open.example:1337",
+		"not a url":                                      "invalid",
+	}
+	for raw, want := range cases {
+		assert.Equal(t, want, trackerHost(raw), raw)
+	}
+}
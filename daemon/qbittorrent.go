@@ -0,0 +1,446 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/client"
+	"github.com/Sabir222/torrent-at-home/logging"
+)
+
+// The qBittorrent Web API v2 subset under /api/v2/, enough for *arr style
+// automation and dashboards. Replies use qBittorrent's field names and
+// its "Ok."/"Fails." plain text answers.
+
+const (
+	qbtVersion    = "v4.6.0"
+	qbtAPIVersion = "2.9.3"
+	sidCookie     = "SID"
+	sidLifetime   = time.Hour
+	// qbtNoETA is what qBittorrent reports when the ETA is unknown.
+	qbtNoETA = 8640000
+)
+
+// qbtTorrent is an entry of torrents/info and sync/maindata.
+type qbtTorrent struct {
+	Hash        string  `json:"hash"`
+	Name        string  `json:"name"`
+	Size        int64   `json:"size"`
+	TotalSize   int64   `json:"total_size"`
+	Progress    float64 `json:"progress"`
+	DlSpeed     int64   `json:"dlspeed"`
+	UpSpeed     int64   `json:"upspeed"`
+	Downloaded  int64   `json:"downloaded"`
+	Uploaded    int64   `json:"uploaded"`
+	AmountLeft  int64   `json:"amount_left"`
+	Completed   int64   `json:"completed"`
+	Ratio       float64 `json:"ratio"`
+	ETA         int64   `json:"eta"`
+	State       string  `json:"state"`
+	NumSeeds    int     `json:"num_seeds"`
+	NumLeechs   int     `json:"num_leechs"`
+	SavePath    string  `json:"save_path"`
+	ContentPath string  `json:"content_path"`
+	Category    string  `json:"category"`
+	Tags        string  `json:"tags"`
+	MagnetURI   string  `json:"magnet_uri"`
+	DlLimit     int     `json:"dl_limit"`
+	UpLimit     int     `json:"up_limit"`
+	SeqDl       bool    `json:"seq_dl"`
+	Priority    int     `json:"priority"`
+}
+
+// qbtTransfer is transfer/info and the server_state of sync/maindata.
+type qbtTransfer struct {
+	DlInfoSpeed      int64  `json:"dl_info_speed"`
+	DlInfoData       int64  `json:"dl_info_data"`
+	UpInfoSpeed      int64  `json:"up_info_speed"`
+	UpInfoData       int64  `json:"up_info_data"`
+	DlRateLimit      int    `json:"dl_rate_limit"`
+	UpRateLimit      int    `json:"up_rate_limit"`
+	DHTNodes         int    `json:"dht_nodes"`
+	ConnectionStatus string `json:"connection_status"`
+}
+
+type qbtCategory struct {
+	Name     string `json:"name"`
+	SavePath string `json:"savePath"`
+}
+
+type qbtMainData struct {
+	Rid             int64                  `json:"rid"`
+	FullUpdate      bool                   `json:"full_update"`
+	Torrents        map[string]qbtTorrent  `json:"torrents"`
+	TorrentsRemoved []string               `json:"torrents_removed"`
+	Categories      map[string]qbtCategory `json:"categories"`
+	Tags            []string               `json:"tags"`
+	ServerState     qbtTransfer            `json:"server_state"`
+}
+
+func (d *Daemon) serveQBittorrent(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/api/v2/")
+	switch method {
+	case "auth/login":
+		d.qbtLogin(w, r)
+		return
+	case "auth/logout":
+		d.qbtLogout(w, r)
+		return
+	}
+
+	switch method {
+	case "app/version":
+		io.WriteString(w, qbtVersion)
+	case "app/webapiVersion":
+		io.WriteString(w, qbtAPIVersion)
+	case "torrents/info":
+		d.qbtInfo(w, r)
+	case "torrents/categories":
+		writeJSON(w, http.StatusOK, d.qbtCategories())
+	case "transfer/info":
+		writeJSON(w, http.StatusOK, d.qbtTransfer())
+	case "sync/maindata":
+		torrents := map[string]qbtTorrent{}
+		for _, t := range d.Client.Torrents() {
+			qt := d.qbtTorrent(t)
+			torrents[qt.Hash] = qt
+		}
+		writeJSON(w, http.StatusOK, qbtMainData{
+			Rid:             d.rid.Add(1),
+			FullUpdate:      true,
+			Torrents:        torrents,
+			TorrentsRemoved: []string{},
+			Categories:      d.qbtCategories(),
+			Tags:            []string{},
+			ServerState:     d.qbtTransfer(),
+		})
+	case "torrents/add":
+		if !qbtPost(w, r) {
+			return
+		}
+		d.qbtAdd(w, r)
+	case "torrents/pause", "torrents/stop":
+		d.qbtEach(w, r, d.Client.Pause)
+	case "torrents/resume", "torrents/start":
+		d.qbtEach(w, r, d.Client.Resume)
+	case "torrents/delete":
+		deleteFiles, _ := strconv.ParseBool(r.FormValue("deleteFiles"))
+		d.qbtEach(w, r, func(infoHash [20]byte) error {
+			return d.Remove(infoHash, deleteFiles)
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (d *Daemon) qbtLogin(w http.ResponseWriter, r *http.Request) {
+	if !qbtPost(w, r) {
+		return
+	}
+	if d.password != "" && !d.credentials(r.FormValue("username"), r.FormValue("password")) {
+		io.WriteString(w, "Fails.")
+		return
+	}
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sid := hex.EncodeToString(raw[:])
+	d.mu.Lock()
+	d.sids[sid] = time.Now().Add(sidLifetime)
+	d.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sidCookie,
+		Value:    sid,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	io.WriteString(w, "Ok.")
+}
+
+func (d *Daemon) qbtLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sidCookie); err == nil {
+		d.mu.Lock()
+		delete(d.sids, c.Value)
+		d.mu.Unlock()
+	}
+	io.WriteString(w, "Ok.")
+}
+
+// qbtAuthorized checks the SID cookie and extends its lifetime. guard
+// calls it for every route.
+func (d *Daemon) qbtAuthorized(r *http.Request) bool {
+	if d.password == "" {
+		return true
+	}
+	c, err := r.Cookie(sidCookie)
+	if err != nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for sid, expires := range d.sids {
+		if now.After(expires) {
+			delete(d.sids, sid)
+		}
+	}
+	if _, ok := d.sids[c.Value]; !ok {
+		return false
+	}
+	d.sids[c.Value] = now.Add(sidLifetime)
+	return true
+}
+
+func (d *Daemon) qbtInfo(w http.ResponseWriter, r *http.Request) {
+	filter := r.FormValue("filter")
+	category, filterCategory := r.Form["category"]
+	var hashes map[string]bool
+	if s := r.FormValue("hashes"); s != "" {
+		hashes = qbtHashes(s)
+	}
+
+	list := []qbtTorrent{}
+	for _, t := range d.Client.Torrents() {
+		qt := d.qbtTorrent(t)
+		if hashes != nil && !hashes[qt.Hash] {
+			continue
+		}
+		if filterCategory && qt.Category != category[0] {
+			continue
+		}
+		if !qbtMatches(filter, t.State(), qt) {
+			continue
+		}
+		list = append(list, qt)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func qbtMatches(filter string, st client.State, qt qbtTorrent) bool {
+	switch filter {
+	case "", "all":
+		return true
+	case "downloading":
+		return st == client.StateDownloading || st == client.StateQueued
+	case "seeding", "completed":
+		return st == client.StateDone
+	case "paused", "stopped":
+		return st == client.StatePaused
+	case "resumed", "running":
+		return st != client.StatePaused
+	case "active":
+		return qt.DlSpeed > 0 || qt.UpSpeed > 0
+	case "inactive":
+		return qt.DlSpeed == 0 && qt.UpSpeed == 0
+	case "stalled":
+		return st == client.StateDownloading && qt.DlSpeed == 0
+	case "errored":
+		return st == client.StateFailed
+	}
+	return false
+}
+
+// qbtEach applies op to the torrents named by the "hashes" field, a "|"
+// separated list or "all".
+func (d *Daemon) qbtEach(w http.ResponseWriter, r *http.Request, op func([20]byte) error) {
+	if !qbtPost(w, r) {
+		return
+	}
+	hashes := qbtHashes(r.FormValue("hashes"))
+	for _, t := range d.Client.Torrents() {
+		infoHash := t.InfoHash()
+		if hashes != nil && !hashes[hex.EncodeToString(infoHash[:])] {
+			continue
+		}
+		// Torrents removed meanwhile are skipped, as qBittorrent does
+		// with unknown hashes.
+		if err := op(infoHash); err != nil && !errors.Is(err, client.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := d.Save(); err != nil {
+		logging.Errorf("[daemon] saving state: %v\n", err)
+	}
+}
+
+// qbtAdd takes "urls" (newline separated) and "torrents" file parts.
+func (d *Daemon) qbtAdd(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxTorrentSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := AddOptions{Dir: r.FormValue("savepath"), Category: r.FormValue("category")}
+	paused := r.FormValue("paused")
+	if paused == "" {
+		paused = r.FormValue("stopped")
+	}
+	opts.Paused, _ = strconv.ParseBool(paused)
+
+	var datas [][]byte
+	if r.MultipartForm != nil {
+		for _, fh := range r.MultipartForm.File["torrents"] {
+			f, err := fh.Open()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data, err := readTorrent(f)
+			f.Close()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			datas = append(datas, data)
+		}
+	}
+	urls := strings.Fields(r.FormValue("urls"))
+	if len(datas) == 0 && len(urls) == 0 {
+		io.WriteString(w, "Fails.")
+		return
+	}
+
+	added := 0
+	for _, data := range datas {
+		if _, err := d.AddTorrent(data, opts); err == nil || errors.Is(err, client.ErrExists) {
+			added++
+		}
+	}
+	for _, url := range urls {
+		if _, err := d.AddURL(r.Context(), url, opts); err == nil || errors.Is(err, client.ErrExists) {
+			added++
+		}
+	}
+	if added == 0 {
+		http.Error(w, "Fails.", http.StatusUnsupportedMediaType)
+		return
+	}
+	io.WriteString(w, "Ok.")
+}
+
+func (d *Daemon) qbtTorrent(t *client.Torrent) qbtTorrent {
+	infoHash := t.InfoHash()
+	meta := t.Meta()
+	stats := t.Stats()
+	r := t.ResumeData()
+	st := t.State()
+
+	size := int64(meta.Length)
+	done := progress(meta, stats, r)
+	left := size - int64(done*float64(size))
+	eta := int64(qbtNoETA)
+	if st == client.StateDone {
+		eta = 0
+	} else if stats.DownRate > 0 {
+		eta = left / stats.DownRate
+	}
+	qt := qbtTorrent{
+		Hash:        hex.EncodeToString(infoHash[:]),
+		Name:        meta.Name,
+		Size:        size,
+		TotalSize:   size,
+		Progress:    done,
+		DlSpeed:     stats.DownRate,
+		Downloaded:  stats.Downloaded,
+		AmountLeft:  left,
+		Completed:   size - left,
+		ETA:         eta,
+		State:       qbtState(st, t.HasMetadata(), done, stats.DownRate),
+		NumSeeds:    stats.Seeds,
+		NumLeechs:   stats.Leechers,
+		SavePath:    t.Dir(),
+		ContentPath: t.Path(),
+		Category:    d.Category(infoHash),
+		DlLimit:     r.Limits.Down,
+		UpLimit:     r.Limits.Up,
+		SeqDl:       r.Sequential,
+	}
+	if qt.SavePath == "" {
+		qt.SavePath = filepath.Dir(qt.ContentPath)
+	}
+	if m := t.Magnet(); m != nil {
+		qt.MagnetURI = m.String()
+	}
+	return qt
+}
+
+// qbtState names our states as qBittorrent does; a finished torrent is
+// reported as a stalled seed since nothing is uploaded yet.
+func qbtState(st client.State, hasMetadata bool, done float64, rate int64) string {
+	switch st {
+	case client.StateQueued:
+		return "queuedDL"
+	case client.StatePaused:
+		if done >= 1 {
+			return "pausedUP"
+		}
+		return "pausedDL"
+	case client.StateDone:
+		return "stalledUP"
+	case client.StateFailed:
+		return "error"
+	}
+	switch {
+	case !hasMetadata:
+		return "metaDL"
+	case rate == 0:
+		return "stalledDL"
+	}
+	return "downloading"
+}
+
+func (d *Daemon) qbtTransfer() qbtTransfer {
+	limits := d.Client.Limits()
+	tr := qbtTransfer{
+		DlRateLimit:      limits.Down,
+		UpRateLimit:      limits.Up,
+		ConnectionStatus: "connected",
+	}
+	for _, t := range d.Client.Torrents() {
+		stats := t.Stats()
+		tr.DlInfoSpeed += stats.DownRate
+		tr.DlInfoData += stats.Downloaded
+	}
+	return tr
+}
+
+func (d *Daemon) qbtCategories() map[string]qbtCategory {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	categories := map[string]qbtCategory{}
+	for _, name := range d.categories {
+		categories[name] = qbtCategory{Name: name}
+	}
+	return categories
+}
+
+// qbtHashes parses a "|" separated hash list, nil for "all".
+func qbtHashes(s string) map[string]bool {
+	if s == "all" {
+		return nil
+	}
+	hashes := map[string]bool{}
+	for _, h := range strings.Split(s, "|") {
+		hashes[strings.ToLower(h)] = true
+	}
+	return hashes
+}
+
+func qbtPost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
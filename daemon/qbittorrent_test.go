@@ -0,0 +1,111 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type qbtClient struct {
+	t    *testing.T
+	base string
+	http *http.Client
+}
+
+func (c *qbtClient) post(method string, form url.Values) (int, string) {
+	resp, err := c.http.PostForm(c.base+"/api/v2/"+method, form)
+	require.NoError(c.t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func (c *qbtClient) get(method string, out any) int {
+	resp, err := c.http.Get(c.base + "/api/v2/" + method)
+	require.NoError(c.t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && out != nil {
+		require.NoError(c.t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func TestQBittorrentAPI(t *testing.T) {
+	d, err := New(Config{StateDir: t.TempDir(), DownloadDir: t.TempDir(), Username: "admin", Password: "secret"})
+	require.NoError(t, err)
+	defer d.Close()
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	jar, _ := cookiejar.New(nil)
+	c := &qbtClient{t: t, base: srv.URL, http: &http.Client{Jar: jar}}
+
+	assert.Equal(t, http.StatusForbidden, c.get("torrents/info", nil))
+	_, body := c.post("auth/login", url.Values{"username": {"admin"}, "password": {"wrong"}})
+	assert.Equal(t, "Fails.", body)
+	_, body = c.post("auth/login", url.Values{"username": {"admin"}, "password": {"secret"}})
+	require.Equal(t, "Ok.", body)
+
+	data, infoHash := webSeeded(t, "show", "episode")
+	hash := fmt.Sprintf("%x", infoHash)
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("torrents", "show.torrent")
+	fw.Write(data)
+	mw.WriteField("category", "tv")
+	mw.WriteField("paused", "true")
+	mw.Close()
+	resp, err := c.http.Post(srv.URL+"/api/v2/torrents/add", mw.FormDataContentType(), &form)
+	require.NoError(t, err)
+	reply, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Equal(t, "Ok.", string(reply))
+
+	var list []qbtTorrent
+	require.Equal(t, http.StatusOK, c.get("torrents/info?category=tv", &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, hash, list[0].Hash)
+	assert.Equal(t, "pausedDL", list[0].State)
+	assert.Equal(t, int64(7), list[0].Size)
+	assert.Equal(t, int64(qbtNoETA), list[0].ETA)
+	require.Equal(t, http.StatusOK, c.get("torrents/info?category=movies", &list))
+	assert.Empty(t, list)
+	require.Equal(t, http.StatusOK, c.get("torrents/info?filter=paused", &list))
+	assert.Len(t, list, 1)
+
+	status, _ := c.post("torrents/resume", url.Values{"hashes": {hash}})
+	require.Equal(t, http.StatusOK, status)
+	wait(t, d, infoHash)
+	require.Equal(t, http.StatusOK, c.get("torrents/info?filter=completed&hashes="+hash, &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, "stalledUP", list[0].State)
+	assert.Equal(t, 1.0, list[0].Progress)
+
+	var transfer qbtTransfer
+	require.Equal(t, http.StatusOK, c.get("transfer/info", &transfer))
+	assert.Equal(t, int64(7), transfer.DlInfoData)
+
+	var main qbtMainData
+	require.Equal(t, http.StatusOK, c.get("sync/maindata?rid=0", &main))
+	assert.True(t, main.FullUpdate)
+	assert.Contains(t, main.Torrents, hash)
+	assert.Contains(t, main.Categories, "tv")
+
+	status, _ = c.post("torrents/delete", url.Values{"hashes": {hash}, "deleteFiles": {"true"}})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, http.StatusOK, c.get("torrents/info", &list))
+	assert.Empty(t, list)
+
+	c.post("auth/logout", nil)
+	assert.Equal(t, http.StatusForbidden, c.get("app/version", nil))
+}
@@ -0,0 +1,591 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Sabir222/torrent-at-home/client"
+	"github.com/Sabir222/torrent-at-home/data/mask"
+	"github.com/Sabir222/torrent-at-home/engine"
+	"github.com/Sabir222/torrent-at-home/logging"
+)
+
+// The Transmission RPC at /transmission/rpc, enough for transmission-remote
+// and scripts: session-get/set and torrent-get/add/set/start/stop/remove.
+// Rates are in KiB/s as announced by session-get's units.
+
+const (
+	trSessionHeader = "X-Transmission-Session-Id"
+	trRPCVersion    = 17
+	trVersion       = "4.0.0 (torrent-at-home)"
+	trSpeedBytes    = 1024
+)
+
+// Torrent status codes.
+const (
+	trStopped      = 0
+	trDownloadWait = 3
+	trDownloading  = 4
+	trSeeding      = 6
+)
+
+// trError codes; only local errors are reported.
+const (
+	trErrorNone  = 0
+	trErrorLocal = 3
+)
+
+// ETA values for an idle and an unknown download.
+const (
+	trETANotAvailable = -1
+	trETAUnknown      = -2
+)
+
+var errTrUnknownMethod = errors.New("method name not recognized")
+
+type trRequest struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       *int            `json:"tag,omitempty"`
+}
+
+type trResponse struct {
+	Result    string `json:"result"`
+	Arguments any    `json:"arguments"`
+	Tag       *int   `json:"tag,omitempty"`
+}
+
+// trArguments holds every request argument the supported methods read.
+type trArguments struct {
+	IDs    json.RawMessage `json:"ids"`
+	Fields []string        `json:"fields"`
+
+	// torrent-add
+	Filename    string   `json:"filename"`
+	Metainfo    string   `json:"metainfo"`
+	DownloadDir string   `json:"download-dir"`
+	Paused      bool     `json:"paused"`
+	Labels      []string `json:"labels"`
+
+	// torrent-set
+	FilesWanted     []int `json:"files-wanted"`
+	FilesUnwanted   []int `json:"files-unwanted"`
+	PriorityHigh    []int `json:"priority-high"`
+	PriorityLow     []int `json:"priority-low"`
+	PriorityNormal  []int `json:"priority-normal"`
+	DownloadLimit   *int  `json:"downloadLimit"`
+	DownloadLimited *bool `json:"downloadLimited"`
+	UploadLimit     *int  `json:"uploadLimit"`
+	UploadLimited   *bool `json:"uploadLimited"`
+
+	// session-set
+	SpeedLimitDown        *int  `json:"speed-limit-down"`
+	SpeedLimitDownEnabled *bool `json:"speed-limit-down-enabled"`
+	SpeedLimitUp          *int  `json:"speed-limit-up"`
+	SpeedLimitUpEnabled   *bool `json:"speed-limit-up-enabled"`
+
+	// torrent-remove
+	DeleteLocalData bool `json:"delete-local-data"`
+}
+
+func (d *Daemon) serveTransmission(w http.ResponseWriter, r *http.Request) {
+	// The CSRF handshake: a request without the current id is refused
+	// with the id to retry with.
+	w.Header().Set(trSessionHeader, d.trSessionID)
+	if r.Header.Get(trSessionHeader) != d.trSessionID {
+		http.Error(w, "invalid or missing "+trSessionHeader, http.StatusConflict)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req trRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var args trArguments
+	if len(req.Arguments) > 0 {
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			writeJSON(w, http.StatusOK, trResponse{Result: err.Error(), Arguments: struct{}{}, Tag: req.Tag})
+			return
+		}
+	}
+
+	result, err := d.trCall(r, req.Method, args)
+	resp := trResponse{Result: "success", Arguments: result, Tag: req.Tag}
+	if err != nil {
+		resp.Result = err.Error()
+	}
+	if resp.Arguments == nil {
+		resp.Arguments = struct{}{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (d *Daemon) trCall(r *http.Request, method string, args trArguments) (any, error) {
+	switch method {
+	case "session-get":
+		return d.trSession(), nil
+	case "session-set":
+		limits := d.Client.Limits()
+		trSetLimit(&limits.Down, args.SpeedLimitDown, args.SpeedLimitDownEnabled)
+		trSetLimit(&limits.Up, args.SpeedLimitUp, args.SpeedLimitUpEnabled)
+		d.Client.SetLimits(limits)
+		return nil, d.Save()
+	case "torrent-get":
+		torrents, err := d.trSelect(args.IDs)
+		if err != nil {
+			return nil, err
+		}
+		list := []map[string]any{}
+		for _, t := range torrents {
+			list = append(list, d.trTorrent(t, args.Fields))
+		}
+		return map[string]any{"torrents": list}, nil
+	case "torrent-add":
+		return d.trAdd(r, args)
+	case "torrent-set":
+		return nil, d.trSet(args)
+	case "torrent-start", "torrent-start-now":
+		return nil, d.trEach(args.IDs, d.Client.Resume)
+	case "torrent-stop":
+		return nil, d.trEach(args.IDs, d.Client.Pause)
+	case "torrent-remove":
+		return nil, d.trEach(args.IDs, func(infoHash [20]byte) error {
+			return d.Remove(infoHash, args.DeleteLocalData)
+		})
+	}
+	return nil, errTrUnknownMethod
+}
+
+func (d *Daemon) trSession() map[string]any {
+	limits := d.Client.Limits()
+	return map[string]any{
+		"version":                  trVersion,
+		"rpc-version":              trRPCVersion,
+		"rpc-version-minimum":      14,
+		"download-dir":             d.downloadDir,
+		"peer-port":                d.peerPort,
+		"speed-limit-down":         limits.Down / trSpeedBytes,
+		"speed-limit-down-enabled": limits.Down > 0,
+		"speed-limit-up":           limits.Up / trSpeedBytes,
+		"speed-limit-up-enabled":   limits.Up > 0,
+		"units": map[string]any{
+			"speed-units":  []string{"KiB/s", "MiB/s", "GiB/s", "TiB/s"},
+			"speed-bytes":  trSpeedBytes,
+			"size-units":   []string{"KiB", "MiB", "GiB", "TiB"},
+			"size-bytes":   1024,
+			"memory-units": []string{"KiB", "MiB", "GiB", "TiB"},
+			"memory-bytes": 1024,
+		},
+	}
+}
+
+// trSetLimit applies a limit in KiB/s and its enabled flag to a rate in
+// bytes per second, where zero is unlimited.
+func trSetLimit(rate *int, limit *int, enabled *bool) {
+	on := *rate > 0
+	if enabled != nil {
+		on = *enabled
+	}
+	switch {
+	case !on:
+		*rate = 0
+	case limit != nil && *limit > 0:
+		*rate = *limit * trSpeedBytes
+	}
+}
+
+func (d *Daemon) trAdd(r *http.Request, args trArguments) (any, error) {
+	opts := AddOptions{Dir: args.DownloadDir, Paused: args.Paused}
+	if len(args.Labels) > 0 {
+		opts.Category = args.Labels[0]
+	}
+
+	var t *client.Torrent
+	var err error
+	switch {
+	case args.Metainfo != "":
+		var data []byte
+		if data, err = base64.StdEncoding.DecodeString(args.Metainfo); err == nil {
+			t, err = d.AddTorrent(data, opts)
+		}
+	case args.Filename != "":
+		t, err = d.AddURL(r.Context(), args.Filename, opts)
+	default:
+		err = errors.New("no filename or metainfo specified")
+	}
+
+	key := "torrent-added"
+	if errors.Is(err, client.ErrExists) && t != nil {
+		key, err = "torrent-duplicate", nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	infoHash := t.InfoHash()
+	return map[string]any{key: map[string]any{
+		"id":         d.trID(infoHash),
+		"name":       t.Meta().Name,
+		"hashString": hex.EncodeToString(infoHash[:]),
+	}}, nil
+}
+
+func (d *Daemon) trSet(args trArguments) error {
+	torrents, err := d.trSelect(args.IDs)
+	if err != nil {
+		return err
+	}
+	for _, t := range torrents {
+		current := t.ResumeData().Priorities
+		set := func(indexes []int, priority func(old int) int) error {
+			for _, i := range indexes {
+				old := engine.PriorityNormal
+				if i >= 0 && i < len(current) {
+					old = current[i]
+				}
+				if err := t.SetFilePriority(i, priority(old)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		errs := []error{
+			set(args.FilesUnwanted, func(int) int { return engine.PrioritySkip }),
+			set(args.FilesWanted, func(old int) int {
+				if old == engine.PrioritySkip {
+					return engine.PriorityNormal
+				}
+				return old
+			}),
+			set(args.PriorityHigh, func(int) int { return engine.PriorityHigh }),
+			set(args.PriorityNormal, func(int) int { return engine.PriorityNormal }),
+			set(args.PriorityLow, func(int) int { return engine.PriorityLow }),
+		}
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+
+		limits := t.Limits()
+		trSetLimit(&limits.Down, args.DownloadLimit, args.DownloadLimited)
+		trSetLimit(&limits.Up, args.UploadLimit, args.UploadLimited)
+		t.SetLimits(limits)
+		if len(args.Labels) > 0 {
+			d.SetCategory(t.InfoHash(), args.Labels[0])
+		}
+	}
+	return d.Save()
+}
+
+func (d *Daemon) trEach(ids json.RawMessage, op func([20]byte) error) error {
+	torrents, err := d.trSelect(ids)
+	if err != nil {
+		return err
+	}
+	for _, t := range torrents {
+		if err := op(t.InfoHash()); err != nil && !errors.Is(err, client.ErrNotFound) {
+			return err
+		}
+	}
+	if err := d.Save(); err != nil {
+		logging.Errorf("[daemon] saving state: %v\n", err)
+	}
+	return nil
+}
+
+// trSelect resolves "ids": absent for all torrents, an id, a hash, a list
+// of either, or "recently-active".
+func (d *Daemon) trSelect(raw json.RawMessage) ([]*client.Torrent, error) {
+	all := d.Client.Torrents()
+	if len(raw) == 0 {
+		return all, nil
+	}
+
+	var ids []any
+	var one any
+	if err := json.Unmarshal(raw, &one); err != nil {
+		return nil, err
+	}
+	switch v := one.(type) {
+	case []any:
+		ids = v
+	case string:
+		if v == "recently-active" {
+			return all, nil
+		}
+		ids = []any{v}
+	default:
+		ids = []any{v}
+	}
+
+	wanted := map[[20]byte]bool{}
+	for _, id := range ids {
+		switch v := id.(type) {
+		case float64:
+			for _, t := range all {
+				if d.trID(t.InfoHash()) == int(v) {
+					wanted[t.InfoHash()] = true
+				}
+			}
+		case string:
+			infoHash, err := ParseInfoHash(strings.ToLower(v))
+			if err != nil {
+				return nil, fmt.Errorf("invalid id %q", v)
+			}
+			wanted[infoHash] = true
+		default:
+			return nil, fmt.Errorf("invalid id %v", v)
+		}
+	}
+	var torrents []*client.Torrent
+	for _, t := range all {
+		if wanted[t.InfoHash()] {
+			torrents = append(torrents, t)
+		}
+	}
+	return torrents, nil
+}
+
+// trID numbers torrents for this run, as Transmission does.
+func (d *Daemon) trID(infoHash [20]byte) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id, ok := d.trIDs[infoHash]
+	if !ok {
+		d.trNextID++
+		id = d.trNextID
+		d.trIDs[infoHash] = id
+	}
+	return id
+}
+
+// trTorrent renders the requested fields of a torrent.
+func (d *Daemon) trTorrent(t *client.Torrent, fields []string) map[string]any {
+	infoHash := t.InfoHash()
+	meta := t.Meta()
+	stats := t.Stats()
+	r := t.ResumeData()
+	st := t.State()
+	list := files(t, meta, r)
+
+	size := int64(meta.Length)
+	var wanted, have int64
+	for _, f := range list {
+		if f.Padding || f.Priority == engine.PrioritySkip {
+			continue
+		}
+		wanted += int64(f.Length)
+		have += completed(f, meta.PieceLength, r.Have)
+	}
+	done := 0.0
+	if wanted > 0 {
+		done = float64(have) / float64(wanted)
+	}
+
+	status := trDownloading
+	switch st {
+	case client.StateQueued:
+		status = trDownloadWait
+	case client.StatePaused, client.StateFailed:
+		status = trStopped
+	case client.StateDone:
+		status = trSeeding
+	}
+	eta := int64(trETAUnknown)
+	switch {
+	case st == client.StateDone || st == client.StatePaused:
+		eta = trETANotAvailable
+	case stats.DownRate > 0:
+		eta = (wanted - have) / stats.DownRate
+	}
+	errCode, errString := trErrorNone, ""
+	if err := t.Err(); err != nil {
+		errCode, errString = trErrorLocal, err.Error()
+	}
+	metadataDone := 0.0
+	if t.HasMetadata() {
+		metadataDone = 1
+	}
+	labels := []string{}
+	if category := d.Category(infoHash); category != "" {
+		labels = append(labels, category)
+	}
+	magnet := ""
+	if m := t.Magnet(); m != nil {
+		magnet = m.String()
+	}
+	dir := t.Dir()
+	if dir == "" {
+		dir = filepath.Dir(t.Path())
+	}
+
+	// Fields are computed on demand since some need the peer list.
+	values := map[string]func() any{
+		"id":                      func() any { return d.trID(infoHash) },
+		"hashString":              func() any { return hex.EncodeToString(infoHash[:]) },
+		"name":                    func() any { return meta.Name },
+		"status":                  func() any { return status },
+		"error":                   func() any { return errCode },
+		"errorString":             func() any { return errString },
+		"percentDone":             func() any { return done },
+		"metadataPercentComplete": func() any { return metadataDone },
+		"totalSize":               func() any { return size },
+		"sizeWhenDone":            func() any { return wanted },
+		"leftUntilDone":           func() any { return wanted - have },
+		"haveValid":               func() any { return have },
+		"haveUnchecked":           func() any { return 0 },
+		"downloadedEver":          func() any { return stats.Downloaded },
+		"uploadedEver":            func() any { return 0 },
+		"uploadRatio":             func() any { return 0 },
+		"corruptEver":             func() any { return stats.Wasted },
+		"rateDownload":            func() any { return stats.DownRate },
+		"rateUpload":              func() any { return 0 },
+		"eta":                     func() any { return eta },
+		"isFinished":              func() any { return st == client.StateDone },
+		"isPrivate":               func() any { return meta.Private },
+		"downloadDir":             func() any { return dir },
+		"magnetLink":              func() any { return magnet },
+		"labels":                  func() any { return labels },
+		"peersConnected":          func() any { return stats.Peers },
+		"peersSendingToUs":        func() any { return stats.Unchoked },
+		"peersGettingFromUs":      func() any { return 0 },
+		"downloadLimit":           func() any { return r.Limits.Down / trSpeedBytes },
+		"downloadLimited":         func() any { return r.Limits.Down > 0 },
+		"uploadLimit":             func() any { return r.Limits.Up / trSpeedBytes },
+		"uploadLimited":           func() any { return r.Limits.Up > 0 },
+		"sequentialDownload":      func() any { return r.Sequential },
+		"pieceCount":              func() any { return len(meta.PieceHashes) },
+		"pieceSize":               func() any { return meta.PieceLength },
+		"files": func() any {
+			out := []map[string]any{}
+			for _, f := range list {
+				out = append(out, map[string]any{
+					"name":           f.Path,
+					"length":         f.Length,
+					"bytesCompleted": completed(f, meta.PieceLength, r.Have),
+				})
+			}
+			return out
+		},
+		"fileStats": func() any {
+			out := []map[string]any{}
+			for _, f := range list {
+				out = append(out, map[string]any{
+					"bytesCompleted": completed(f, meta.PieceLength, r.Have),
+					"wanted":         f.Priority != engine.PrioritySkip,
+					"priority":       trPriority(f.Priority),
+				})
+			}
+			return out
+		},
+		"priorities": func() any {
+			out := []int{}
+			for _, f := range list {
+				out = append(out, trPriority(f.Priority))
+			}
+			return out
+		},
+		"wanted": func() any {
+			out := []bool{}
+			for _, f := range list {
+				out = append(out, f.Priority != engine.PrioritySkip)
+			}
+			return out
+		},
+		"peers": func() any {
+			out := []map[string]any{}
+			if session := t.Session(); session != nil {
+				for _, p := range session.ConnectedPeers() {
+					host, port, _ := net.SplitHostPort(p.Addr)
+					n, _ := strconv.Atoi(port)
+					out = append(out, map[string]any{
+						"address":        host,
+						"port":           n,
+						"clientName":     p.Client,
+						"clientIsChoked": p.Choked,
+						"isUTP":          p.Network == "utp",
+						"rateToClient":   p.DownRate,
+						"rateToPeer":     0,
+					})
+				}
+			}
+			return out
+		},
+		"trackerStats": func() any {
+			out := []map[string]any{}
+			if session := t.Session(); session != nil {
+				for i, tr := range session.Trackers {
+					out = append(out, map[string]any{
+						"id":                    i,
+						"announce":              tr.URL,
+						"lastAnnounceSucceeded": tr.Err == "",
+						"lastAnnounceResult":    trAnnounceResult(tr),
+						"lastAnnouncePeerCount": tr.Peers,
+					})
+				}
+			}
+			return out
+		},
+	}
+
+	out := map[string]any{}
+	for _, field := range fields {
+		if value, ok := values[field]; ok {
+			out[field] = value()
+		}
+	}
+	return out
+}
+
+// completed counts the bytes of a file within verified pieces.
+func completed(f engine.File, pieceLength int, have mask.Mask) int64 {
+	if f.Length == 0 || pieceLength == 0 {
+		return 0
+	}
+	var n int64
+	end := f.Offset + f.Length
+	for i := f.Offset / pieceLength; i*pieceLength < end; i++ {
+		if !have.Check(i) {
+			continue
+		}
+		lo, hi := max(i*pieceLength, f.Offset), min((i+1)*pieceLength, end)
+		n += int64(hi - lo)
+	}
+	return n
+}
+
+// trPriority maps a file priority to Transmission's -1, 0 and 1.
+func trPriority(priority int) int {
+	switch {
+	case priority > engine.PriorityNormal:
+		return 1
+	case priority < engine.PriorityNormal && priority != engine.PrioritySkip:
+		return -1
+	}
+	return 0
+}
+
+func trAnnounceResult(tr engine.TrackerStatus) string {
+	if tr.Err != "" {
+		return tr.Err
+	}
+	return "Success"
+}
+
+func newSessionID() string {
+	var raw [24]byte
+	rand.Read(raw[:])
+	return base64.RawURLEncoding.EncodeToString(raw[:])
+}
@@ -0,0 +1,120 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type trClient struct {
+	t         *testing.T
+	url       string
+	sessionID string
+}
+
+// call runs one RPC, doing the session id handshake first when needed.
+func (c *trClient) call(method string, args any, out any) string {
+	body, err := json.Marshal(map[string]any{"method": method, "arguments": args, "tag": 7})
+	require.NoError(c.t, err)
+	for attempt := 0; ; attempt++ {
+		req, _ := http.NewRequest("POST", c.url, bytes.NewReader(body))
+		req.SetBasicAuth("admin", "secret")
+		req.Header.Set(trSessionHeader, c.sessionID)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(c.t, err)
+		if resp.StatusCode == http.StatusConflict && attempt == 0 {
+			c.sessionID = resp.Header.Get(trSessionHeader)
+			resp.Body.Close()
+			continue
+		}
+		require.Equal(c.t, http.StatusOK, resp.StatusCode)
+		var reply struct {
+			Result    string          `json:"result"`
+			Arguments json.RawMessage `json:"arguments"`
+			Tag       int             `json:"tag"`
+		}
+		require.NoError(c.t, json.NewDecoder(resp.Body).Decode(&reply))
+		resp.Body.Close()
+		assert.Equal(c.t, 7, reply.Tag)
+		if out != nil {
+			require.NoError(c.t, json.Unmarshal(reply.Arguments, out))
+		}
+		return reply.Result
+	}
+}
+
+type trTorrents struct {
+	Torrents []map[string]any `json:"torrents"`
+}
+
+func TestTransmissionRPC(t *testing.T) {
+	d, err := New(Config{StateDir: t.TempDir(), DownloadDir: t.TempDir(), Username: "admin", Password: "secret"})
+	require.NoError(t, err)
+	defer d.Close()
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+	c := &trClient{t: t, url: srv.URL + "/transmission/rpc"}
+
+	resp, err := http.Post(c.url, "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	var session map[string]any
+	require.Equal(t, "success", c.call("session-get", nil, &session))
+	assert.NotEmpty(t, c.sessionID)
+	assert.Equal(t, float64(trRPCVersion), session["rpc-version"])
+
+	data, infoHash := webSeeded(t, "linux", "kernel")
+	hash := fmt.Sprintf("%x", infoHash)
+	metainfo := base64.StdEncoding.EncodeToString(data)
+	var added map[string]map[string]any
+	require.Equal(t, "success", c.call("torrent-add", map[string]any{"metainfo": metainfo, "paused": true, "labels": []string{"os"}}, &added))
+	require.Contains(t, added, "torrent-added")
+	assert.Equal(t, hash, added["torrent-added"]["hashString"])
+	id := added["torrent-added"]["id"]
+	require.Equal(t, "success", c.call("torrent-add", map[string]any{"metainfo": metainfo}, &added))
+	assert.Contains(t, added, "torrent-duplicate")
+
+	fields := []string{"id", "name", "status", "percentDone", "totalSize", "labels", "wanted", "downloadLimit", "downloadLimited", "fileStats"}
+	var got trTorrents
+	require.Equal(t, "success", c.call("torrent-get", map[string]any{"ids": []any{id}, "fields": fields}, &got))
+	require.Len(t, got.Torrents, 1)
+	tor := got.Torrents[0]
+	assert.Equal(t, "linux", tor["name"])
+	assert.Equal(t, float64(trStopped), tor["status"])
+	assert.Equal(t, float64(6), tor["totalSize"])
+	assert.Equal(t, []any{"os"}, tor["labels"])
+	assert.NotContains(t, tor, "hashString")
+
+	set := map[string]any{"ids": hash, "files-unwanted": []int{0}, "downloadLimit": 100, "downloadLimited": true}
+	require.Equal(t, "success", c.call("torrent-set", set, nil))
+	require.Equal(t, "success", c.call("torrent-get", map[string]any{"ids": hash, "fields": fields}, &got))
+	tor = got.Torrents[0]
+	assert.Equal(t, []any{false}, tor["wanted"])
+	assert.Equal(t, float64(100), tor["downloadLimit"])
+	assert.Equal(t, true, tor["downloadLimited"])
+
+	require.Equal(t, "success", c.call("torrent-set", map[string]any{"ids": hash, "files-wanted": []int{0}, "downloadLimited": false}, nil))
+	require.Equal(t, "success", c.call("torrent-start", map[string]any{"ids": []any{id}}, nil))
+	wait(t, d, infoHash)
+	require.Equal(t, "success", c.call("torrent-get", map[string]any{"fields": fields}, &got))
+	tor = got.Torrents[0]
+	assert.Equal(t, float64(trSeeding), tor["status"])
+	assert.Equal(t, 1.0, tor["percentDone"])
+	assert.Equal(t, false, tor["downloadLimited"])
+	assert.Equal(t, []any{map[string]any{"bytesCompleted": float64(6), "wanted": true, "priority": float64(0)}}, tor["fileStats"])
+
+	require.Equal(t, "success", c.call("torrent-remove", map[string]any{"ids": []any{id}, "delete-local-data": true}, nil))
+	require.Equal(t, "success", c.call("torrent-get", map[string]any{"fields": fields}, &got))
+	assert.Empty(t, got.Torrents)
+
+	assert.Equal(t, errTrUnknownMethod.Error(), c.call("blocklist-update", nil, nil))
+}
@@ -2,12 +2,14 @@ package descriptor
 
 import (
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
 	"time"
 
+	"github.com/Sabir222/torrent-at-home/engine"
+	"github.com/Sabir222/torrent-at-home/logging"
 	"github.com/Sabir222/torrent-at-home/network/endpoints"
 	"github.com/jackpal/bencode-go"
 )
@@ -24,11 +26,13 @@ type trackerReply struct {
 	Peers    string `bencode:"peers"`
 }
 
-func (t *TorrentFile) announce(peerID [20]byte, port uint16) ([]endpoints.Endpoint, error) {
+// announce asks every tracker for peers, reporting how each one went.
+func (t *TorrentFile) announce(peerID [20]byte, port uint16, opts Options) ([]endpoints.Endpoint, []engine.TrackerStatus, error) {
 	var allPeers []endpoints.Endpoint
 
 	trackers := t.getTrackerList()
-	log.Printf("[tracker] querying %d tracker(s)\n", len(trackers))
+	logging.Infof("[tracker] querying %d tracker(s)\n", len(trackers))
+	statuses := make([]engine.TrackerStatus, len(trackers))
 
 	for i, trackerURL := range trackers {
 		t.Announce = trackerURL
@@ -37,21 +41,24 @@ func (t *TorrentFile) announce(peerID [20]byte, port uint16) ([]endpoints.Endpoi
 			protocol = "UDP"
 		}
 
-		peers, err := t.announceSingleTracker(peerID, port)
+		start := time.Now()
+		peers, err := t.announceSingleTracker(peerID, port, opts)
+		statuses[i] = engine.TrackerStatus{URL: trackerURL, Peers: len(peers), Latency: time.Since(start)}
 		if err != nil {
-			log.Printf("[tracker] [%d/%d] %s %s → failed: %v\n", i+1, len(trackers), protocol, truncateURL(trackerURL), err)
+			statuses[i].Err = err.Error()
+			logging.Errorf("[tracker] [%d/%d] %s %s → failed: %v\n", i+1, len(trackers), protocol, truncateURL(trackerURL), err)
 			continue
 		}
 		if len(peers) > 0 {
-			log.Printf("[tracker] [%d/%d] %s %s → %d peer(s)\n", i+1, len(trackers), protocol, truncateURL(trackerURL), len(peers))
+			logging.Infof("[tracker] [%d/%d] %s %s → %d peer(s)\n", i+1, len(trackers), protocol, truncateURL(trackerURL), len(peers))
 			allPeers = append(allPeers, peers...)
 		} else {
-			log.Printf("[tracker] [%d/%d] %s %s → no peers\n", i+1, len(trackers), protocol, truncateURL(trackerURL))
+			logging.Infof("[tracker] [%d/%d] %s %s → no peers\n", i+1, len(trackers), protocol, truncateURL(trackerURL))
 		}
 	}
 
 	if len(allPeers) == 0 {
-		return nil, errors.New("no peers received from any tracker")
+		return nil, statuses, errors.New("no peers received from any tracker")
 	}
 
 	// Remove duplicate peers
@@ -65,8 +72,29 @@ func (t *TorrentFile) announce(peerID [20]byte, port uint16) ([]endpoints.Endpoi
 		}
 	}
 
-	log.Printf("[tracker] total: %d unique peer(s) after deduplication\n", len(uniquePeers))
-	return uniquePeers, nil
+	logging.Infof("[tracker] total: %d unique peer(s) after deduplication\n", len(uniquePeers))
+	return uniquePeers, statuses, nil
+}
+
+// announced counts and publishes the outcome of announcing a torrent to
+// each tracker.
+func announced(opts Options, infoHash [20]byte, statuses []engine.TrackerStatus) {
+	for _, status := range statuses {
+		status := status
+		opts.Announces.Record(status)
+		opts.Events.Publish(engine.Event{Kind: engine.EventTrackerAnnounce, InfoHash: infoHash, Tracker: &status})
+	}
+}
+
+// withTimeout is a copy of c, or of the default client when c is nil,
+// that gives up after d.
+func withTimeout(c *http.Client, d time.Duration) *http.Client {
+	var out http.Client
+	if c != nil {
+		out = *c
+	}
+	out.Timeout = d
+	return &out
 }
 
 func truncateURL(u string) string {
@@ -101,9 +129,9 @@ func (t *TorrentFile) getTrackerList() []string {
 	return trackers
 }
 
-func (t *TorrentFile) announceSingleTracker(peerID [20]byte, port uint16) ([]endpoints.Endpoint, error) {
+func (t *TorrentFile) announceSingleTracker(peerID [20]byte, port uint16, opts Options) ([]endpoints.Endpoint, error) {
 	if t.isUDPTracker() {
-		peers, err := t.announceUDP(peerID, port)
+		peers, err := t.announceUDP(peerID, port, opts.UDPTrackers)
 		if err == nil {
 			return peers, nil
 		}
@@ -114,8 +142,7 @@ func (t *TorrentFile) announceSingleTracker(peerID [20]byte, port uint16) ([]end
 		return nil, err
 	}
 
-	client := &http.Client{Timeout: trackerTimeout}
-	reply, err := client.Get(target)
+	reply, err := withTimeout(opts.HTTP, trackerTimeout).Get(target)
 	if err != nil {
 		return nil, err
 	}
@@ -143,6 +170,7 @@ func (t *TorrentFile) assembleURL(id [20]byte, p uint16) (string, error) {
 	query.Set("downloaded", "0")
 	query.Set("compact", "1")
 	query.Set("left", strconv.Itoa(t.Length))
+	query.Set("key", fmt.Sprintf("%08x", t.Key))
 
 	base.RawQuery = query.Encode()
 	return base.String(), nil
@@ -5,12 +5,12 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
 	"math"
 	"net"
 	"net/url"
 	"time"
 
+	"github.com/Sabir222/torrent-at-home/logging"
 	"github.com/Sabir222/torrent-at-home/network/endpoints"
 )
 
@@ -33,29 +33,40 @@ type udpConnectionID struct {
 	obtained time.Time
 }
 
-func (t *TorrentFile) announceUDP(peerID [20]byte, port uint16) ([]endpoints.Endpoint, error) {
+// udpExchange sends one request and waits up to timeout for the reply.
+type udpExchange func(req []byte, timeout time.Duration) ([]byte, error)
+
+// announceUDP announces over trackers, the shared socket, or over a
+// socket of its own when that is nil.
+func (t *TorrentFile) announceUDP(peerID [20]byte, port uint16, trackers *UDPTrackers) ([]endpoints.Endpoint, error) {
 	udpAddr, err := net.ResolveUDPAddr("udp", t.trackerHost())
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := net.DialUDP("udp", nil, udpAddr)
-	if err != nil {
-		return nil, err
+	var conn udpExchange
+	if trackers != nil {
+		conn = trackers.exchange(udpAddr)
+	} else {
+		c, err := net.DialUDP("udp", nil, udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+		conn = connExchange(c)
 	}
-	defer conn.Close()
 
-	log.Printf("[udp] obtaining connection ID from %s\n", t.trackerHost())
+	logging.Debugf("[udp] obtaining connection ID from %s\n", t.trackerHost())
 	connID, err := t.getUDPConnectionID(conn)
 	if err != nil {
 		return nil, fmt.Errorf("connection failed: %w", err)
 	}
-	log.Printf("[udp] ✓ connection established\n")
+	logging.Debugf("[udp] ✓ connection established\n")
 
 	return t.sendUDPAnnounce(conn, connID, peerID, port)
 }
 
-func (t *TorrentFile) getUDPConnectionID(conn *net.UDPConn) (int64, error) {
+func (t *TorrentFile) getUDPConnectionID(conn udpExchange) (int64, error) {
 	txID := randomTransactionID()
 
 	req := make([]byte, 16)
@@ -86,7 +97,7 @@ func (t *TorrentFile) getUDPConnectionID(conn *net.UDPConn) (int64, error) {
 	return connID, nil
 }
 
-func (t *TorrentFile) sendUDPAnnounce(conn *net.UDPConn, connID int64, peerID [20]byte, port uint16) ([]endpoints.Endpoint, error) {
+func (t *TorrentFile) sendUDPAnnounce(conn udpExchange, connID int64, peerID [20]byte, port uint16) ([]endpoints.Endpoint, error) {
 	txID := randomTransactionID()
 
 	req := make([]byte, 98)
@@ -100,7 +111,7 @@ func (t *TorrentFile) sendUDPAnnounce(conn *net.UDPConn, connID int64, peerID [2
 	binary.BigEndian.PutUint64(req[72:80], 0) // uploaded
 	binary.BigEndian.PutUint32(req[80:84], 0) // event = none
 	binary.BigEndian.PutUint32(req[84:88], 0) // IP = default
-	binary.BigEndian.PutUint32(req[88:92], txID) // key
+	binary.BigEndian.PutUint32(req[88:92], t.Key) // key
 	binary.BigEndian.PutUint32(req[92:96], math.MaxInt32) // num_want = -1
 	binary.BigEndian.PutUint16(req[96:98], port)
 
@@ -143,21 +154,16 @@ func (t *TorrentFile) sendUDPAnnounce(conn *net.UDPConn, connID int64, peerID [2
 	return peerList, nil
 }
 
-func sendUDPRequest(conn *net.UDPConn, data []byte, timeout time.Duration) ([]byte, error) {
+func sendUDPRequest(conn udpExchange, data []byte, timeout time.Duration) ([]byte, error) {
 	maxRetries := 8
 	
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		conn.SetDeadline(time.Now().Add(timeout))
-		
-		_, err := conn.Write(data)
-		if err != nil {
-			return nil, err
-		}
-
-		buf := make([]byte, 2048)
-		n, err := conn.Read(buf)
+		resp, err := conn(data, timeout)
 		if err == nil {
-			return buf[:n], nil
+			return resp, nil
+		}
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return nil, err
 		}
 
 		// Exponential backoff: 15 × 2^n seconds
@@ -168,6 +174,22 @@ func sendUDPRequest(conn *net.UDPConn, data []byte, timeout time.Duration) ([]by
 	return nil, errors.New("UDP tracker timeout after all retries")
 }
 
+// connExchange talks to a tracker over a socket dialed for it alone.
+func connExchange(conn *net.UDPConn) udpExchange {
+	return func(req []byte, timeout time.Duration) ([]byte, error) {
+		conn.SetDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 2048)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
 func randomTransactionID() uint32 {
 	var b [4]byte
 	rand.Read(b[:])
@@ -0,0 +1,231 @@
+package descriptor
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/data/hasher"
+	"github.com/jackpal/bencode-go"
+)
+
+const (
+	// minPieceLength and maxPieceLength bound the piece length Create
+	// picks, aiming for about targetPieces pieces.
+	minPieceLength = 16 << 10
+	maxPieceLength = 16 << 20
+	targetPieces   = 1500
+)
+
+var ErrNothingToShare = errors.New("no data to share")
+
+// CreateOptions describes a torrent made by Create. Zero values are left
+// out of the torrent, except PieceLength which is then picked from the
+// size.
+type CreateOptions struct {
+	// Name defaults to the base name of the path.
+	Name        string
+	PieceLength int
+	// Trackers each get their own tier; the first is also the announce
+	// URL.
+	Trackers     []string
+	WebSeeds     []string
+	Private      bool
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	// Hasher hashes the pieces; hasher.Default when nil.
+	Hasher *hasher.Pool
+}
+
+type createFile struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type createInfo struct {
+	Files       []createFile `bencode:"files,omitempty"`
+	Length      int          `bencode:"length,omitempty"`
+	Name        string       `bencode:"name"`
+	PieceLength int          `bencode:"piece length"`
+	Pieces      string       `bencode:"pieces"`
+	Private     int          `bencode:"private,omitempty"`
+}
+
+type createTorrent struct {
+	Announce     string     `bencode:"announce,omitempty"`
+	AnnounceList [][]string `bencode:"announce-list,omitempty"`
+	Comment      string     `bencode:"comment,omitempty"`
+	CreatedBy    string     `bencode:"created by,omitempty"`
+	CreationDate int64      `bencode:"creation date,omitempty"`
+	Info         createInfo `bencode:"info"`
+	URLList      []string   `bencode:"url-list,omitempty"`
+}
+
+// Create hashes the file or directory at path into a v1 .torrent file.
+// A directory's regular files are added in path order; symlinks and
+// empty directories are skipped.
+func Create(path string, opts CreateOptions) ([]byte, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	info := createInfo{Name: opts.Name}
+	if info.Name == "" {
+		info.Name = filepath.Base(filepath.Clean(path))
+	}
+	var paths []string
+	total := 0
+	if stat.IsDir() {
+		files, err := listFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			info.Files = append(info.Files, f)
+			paths = append(paths, filepath.Join(append([]string{path}, f.Path...)...))
+			total += f.Length
+		}
+	} else {
+		info.Length = int(stat.Size())
+		paths = []string{path}
+		total = info.Length
+	}
+	if total == 0 {
+		return nil, ErrNothingToShare
+	}
+
+	info.PieceLength = opts.PieceLength
+	if info.PieceLength <= 0 {
+		info.PieceLength = pickPieceLength(total)
+	}
+	pieces, err := hashFiles(paths, info.PieceLength, opts.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	info.Pieces = string(pieces)
+	if opts.Private {
+		info.Private = 1
+	}
+
+	t := createTorrent{
+		Comment:   opts.Comment,
+		CreatedBy: opts.CreatedBy,
+		Info:      info,
+		URLList:   opts.WebSeeds,
+	}
+	if !opts.CreationDate.IsZero() {
+		t.CreationDate = opts.CreationDate.Unix()
+	}
+	if len(opts.Trackers) > 0 {
+		t.Announce = opts.Trackers[0]
+	}
+	if len(opts.Trackers) > 1 {
+		for _, tr := range opts.Trackers {
+			t.AnnounceList = append(t.AnnounceList, []string{tr})
+		}
+	}
+	var buf bytes.Buffer
+	if err := bencode.Marshal(&buf, t); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// listFiles finds the regular files under dir, with their paths relative
+// to it.
+func listFiles(dir string) ([]createFile, error) {
+	var files []createFile
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, createFile{Length: int(stat.Size()), Path: strings.Split(filepath.ToSlash(rel), "/")})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return strings.Join(files[i].Path, "/") < strings.Join(files[j].Path, "/")
+	})
+	return files, nil
+}
+
+// pickPieceLength is the power of two giving about targetPieces pieces.
+func pickPieceLength(total int) int {
+	length := minPieceLength
+	for length < maxPieceLength && total/length > targetPieces {
+		length *= 2
+	}
+	return length
+}
+
+// hashFiles hashes the files as one stream cut into pieces, reading
+// the next pieces while the pool hashes the last ones.
+func hashFiles(paths []string, pieceLength int, pool *hasher.Pool) ([]byte, error) {
+	readers := make([]io.Reader, 0, len(paths))
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	stream := io.MultiReader(readers...)
+
+	if pool == nil {
+		pool = hasher.Default()
+	}
+	bufs := make(chan []byte, pool.Workers()+1)
+	for i := 0; i < cap(bufs); i++ {
+		bufs <- make([]byte, pieceLength)
+	}
+	var (
+		mu     sync.Mutex
+		pieces []byte
+	)
+	batch := pool.Batch(nil)
+	defer batch.Wait()
+	for index := 0; ; index++ {
+		buf := <-bufs
+		n, err := io.ReadFull(stream, buf)
+		if n > 0 {
+			at := index * sha1.Size
+			batch.Go(func() {
+				sum := hasher.SHA1(buf[:n])
+				mu.Lock()
+				if len(pieces) < at+sha1.Size {
+					pieces = append(pieces, make([]byte, at+sha1.Size-len(pieces))...)
+				}
+				copy(pieces[at:], sum[:])
+				mu.Unlock()
+				bufs <- buf
+			})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			batch.Wait()
+			return pieces, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package descriptor
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "album")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "cd1"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cd1", "01.flac"), []byte("abcdefghij"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cover.jpg"), []byte("klmnop"), 0o644))
+
+	data, err := Create(dir, CreateOptions{
+		PieceLength:  4,
+		Trackers:     []string{"udp://a.example:1337/announce", "http://b.example/announce"},
+		WebSeeds:     []string{"http://mirror.example/"},
+		Private:      true,
+		Comment:      "live",
+		CreatedBy:    "test/1.0",
+		CreationDate: time.Unix(1700000000, 0),
+	})
+	require.NoError(t, err)
+
+	tf, err := Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "album", tf.Name)
+	assert.True(t, tf.MultiFile)
+	assert.True(t, tf.Private)
+	assert.Equal(t, 16, tf.Length)
+	assert.Equal(t, 4, tf.PieceLength)
+	assert.Equal(t, "udp://a.example:1337/announce", tf.Announce)
+	assert.Equal(t, [][]string{{"udp://a.example:1337/announce"}, {"http://b.example/announce"}}, tf.AnnounceList)
+	assert.Equal(t, []string{"http://mirror.example/"}, tf.URLList)
+	assert.Equal(t, "live", tf.Comment)
+	assert.Equal(t, "test/1.0", tf.CreatedBy)
+	assert.Equal(t, time.Unix(1700000000, 0), tf.CreationDate)
+	require.Len(t, tf.Files, 2)
+	assert.Equal(t, "cd1/01.flac", tf.Files[0].DisplayPath())
+	assert.Equal(t, "cover.jpg", tf.Files[1].DisplayPath())
+	// Pieces run across the file boundary.
+	require.Len(t, tf.PieceHashes, 4)
+	assert.Equal(t, sha1.Sum([]byte("ijkl")), tf.PieceHashes[2])
+	assert.Equal(t, sha1.Sum([]byte("mnop")), tf.PieceHashes[3])
+}
+
+func TestCreateSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0o644))
+
+	data, err := Create(path, CreateOptions{})
+	require.NoError(t, err)
+	tf, err := Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "notes.txt", tf.Name)
+	assert.False(t, tf.MultiFile)
+	assert.Equal(t, minPieceLength, tf.PieceLength)
+	assert.Equal(t, [][20]byte{sha1.Sum([]byte("hello"))}, tf.PieceHashes)
+	assert.True(t, tf.CreationDate.IsZero())
+
+	empty := t.TempDir()
+	_, err = Create(empty, CreateOptions{})
+	assert.ErrorIs(t, err, ErrNothingToShare)
+}
+
+func TestPickPieceLength(t *testing.T) {
+	assert.Equal(t, minPieceLength, pickPieceLength(1<<20))
+	assert.Equal(t, 1<<20, pickPieceLength(1<<30))
+	assert.Equal(t, maxPieceLength, pickPieceLength(1<<45))
+}
@@ -4,11 +4,25 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha1"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/jackpal/bencode-go"
+	"github.com/Sabir222/torrent-at-home/data/hasher"
+	"github.com/Sabir222/torrent-at-home/data/storage"
 	"github.com/Sabir222/torrent-at-home/engine"
+	"github.com/Sabir222/torrent-at-home/logging"
+	"github.com/Sabir222/torrent-at-home/network/connector"
+	"github.com/Sabir222/torrent-at-home/network/endpoints"
+	"github.com/Sabir222/torrent-at-home/network/lsd"
+	"github.com/Sabir222/torrent-at-home/network/throttle"
+	"github.com/Sabir222/torrent-at-home/protocol/mse"
 )
 
 // Port to listen on
@@ -23,84 +37,419 @@ type TorrentFile struct {
 	PieceLength  int
 	Length       int
 	Name         string
+	Files        []File
+	// MultiFile is set when the info dictionary has a files list, in
+	// which case Name is the root directory.
+	MultiFile bool
+	// URLList holds the web seeds (BEP 19)
+	URLList []string
+	// MetaVersion is 2 for v2 and hybrid torrents. InfoHashV2 is the
+	// full SHA-256 infohash; for a v2 only torrent InfoHash holds its
+	// truncated form. PieceLayers maps pieces roots to their hashes.
+	MetaVersion int
+	InfoHashV2  [32]byte
+	PieceLayers map[[32]byte][][32]byte
+	// Private is the BEP 27 flag: peers come from the trackers only
+	Private bool
+	// Key identifies this client to the trackers across announces and
+	// address changes
+	Key uint32
+	// CreationDate, CreatedBy and Comment are informational; the date is
+	// zero when the torrent has none
+	CreationDate time.Time
+	CreatedBy    string
+	Comment      string
+}
+
+// File is one entry of a torrent's file list
+type File struct {
+	Path   []string
+	Length int
+	Offset int
+	// PiecesRoot is the v2 merkle root of the file's blocks
+	PiecesRoot [32]byte
+	// Padding marks filler that aligns the next file to a piece
+	Padding bool
+	// Executable, Hidden and Symlink come from the BEP 47 attr flags;
+	// Symlink is the target's path from the torrent root
+	Executable bool
+	Hidden     bool
+	Symlink    []string
+}
+
+// DisplayPath joins the path components with slashes
+func (f File) DisplayPath() string {
+	return strings.Join(f.Path, "/")
+}
+
+type bencodeFile struct {
+	Length      int      `bencode:"length"`
+	Path        []string `bencode:"path"`
+	Attr        string   `bencode:"attr"`
+	SymlinkPath []string `bencode:"symlink path"`
 }
 
 type bencodeInfo struct {
-	Pieces      string `bencode:"pieces"`
-	PieceLength int    `bencode:"piece length"`
-	Length      int    `bencode:"length"`
-	Name        string `bencode:"name"`
+	Pieces      string        `bencode:"pieces"`
+	PieceLength int           `bencode:"piece length"`
+	Length      int           `bencode:"length"`
+	Name        string        `bencode:"name"`
+	Files       []bencodeFile `bencode:"files"`
+	MetaVersion int           `bencode:"meta version"`
+	Attr        string        `bencode:"attr"`
+	SymlinkPath []string      `bencode:"symlink path"`
+	Private     int           `bencode:"private"`
 }
 
 type bencodeTorrent struct {
 	Announce    string      `bencode:"announce"`
 	AnnounceList [][]string `bencode:"announce-list"`
 	Info         bencodeInfo `bencode:"info"`
+	CreationDate int64      `bencode:"creation date"`
+	CreatedBy    string     `bencode:"created by"`
+	Comment      string     `bencode:"comment"`
 }
 
-// DownloadToFile downloads a torrent and writes it to a file
-func (t *TorrentFile) DownloadToFile(path string) error {
+// Options tunes how a torrent is downloaded
+type Options struct {
+	Limits     *throttle.Set
+	Global     *throttle.Set
+	Sequential bool
+	// Select limits the download to files matching an index or a glob
+	Select []string
+	// Encryption and FallbackPlain configure MSE for outgoing peers
+	Encryption    mse.Mode
+	FallbackPlain bool
+	// Listener accepts incoming peers, announced on Port (default 6881)
+	Listener *connector.Listener
+	Port     uint16
+	// Transports are tried in order for outgoing peers
+	Transports []connector.Transport
+	// LocalDiscovery finds peers on the LAN; LocalUnlimited exempts
+	// them from the rate limits
+	LocalDiscovery *lsd.Service
+	LocalUnlimited bool
+	// UDPTrackers, when set, carries every UDP tracker announce over one
+	// shared socket
+	UDPTrackers *UDPTrackers
+	// Conns caps the peer connections, shared between sessions
+	Conns *engine.ConnLimit
+	// Events receives the announce results and the session's events
+	Events *engine.Feed
+	// Announces counts every announce, shared between sessions
+	Announces *engine.Announces
+	// Hasher verifies pieces, shared between sessions; hasher.Default
+	// when nil
+	Hasher *hasher.Pool
+	// HTTP makes the HTTP tracker announces and web seed requests, with
+	// this package's and the engine's timeouts; http.DefaultClient's
+	// transport when nil
+	HTTP *http.Client
+	// Key, when not zero, replaces the torrent's tracker key; keep it
+	// with the torrent so the trackers know this client after a restart
+	Key uint32
+}
+
+// NewKey picks a random tracker key.
+func NewKey() uint32 {
+	return randomTransactionID()
+}
+
+// NewSession announces to the trackers and prepares a download session
+func (t *TorrentFile) NewSession(opts Options) (*engine.Session, error) {
 	var peerID [20]byte
 	_, err := rand.Read(peerID[:])
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	peers, err := t.announce(peerID, Port)
+	port := opts.Port
+	if port == 0 {
+		port = Port
+	}
+	seeds := t.webSeeds()
+	tracked := *t
+	if opts.Key != 0 {
+		tracked.Key = opts.Key
+	}
+	peers, trackers, err := tracked.announce(peerID, port, opts)
+	var hybridPeers []endpoints.Endpoint
+	if t.IsHybrid() {
+		// The v2 swarm is announced separately under the truncated
+		// SHA-256 infohash.
+		v2 := tracked
+		v2.InfoHash = truncate(t.InfoHashV2)
+		var v2err error
+		var v2trackers []engine.TrackerStatus
+		hybridPeers, v2trackers, v2err = v2.announce(peerID, port, opts)
+		trackers = append(trackers, v2trackers...)
+		if err != nil && v2err == nil {
+			err = nil
+		}
+	}
+	announced(opts, t.InfoHash, trackers)
 	if err != nil {
-		return err
+		if len(seeds) == 0 {
+			return nil, err
+		}
+		logging.Infof("[tracker] %v, using %d web seed(s)\n", err, len(seeds))
+	}
+
+	session := t.baseSession()
+	session.Peers = peers
+	session.PeerID = peerID
+	session.Limits = opts.Limits
+	session.Global = opts.Global
+
+	session.Encryption = opts.Encryption
+	session.FallbackPlain = opts.FallbackPlain
+	session.Listener = opts.Listener
+	session.Transports = opts.Transports
+
+	session.LocalDiscovery = opts.LocalDiscovery
+	session.LocalUnlimited = opts.LocalUnlimited
+	session.WebSeeds = seeds
+	session.HybridPeers = hybridPeers
+	session.Conns = opts.Conns
+	session.Trackers = trackers
+	session.Events = opts.Events
+	session.Hasher = opts.Hasher
+	session.HTTP = opts.HTTP
+	session.SetSequential(opts.Sequential)
+	if len(opts.Select) > 0 {
+		if err := session.SelectFiles(opts.Select); err != nil {
+			return nil, err
+		}
 	}
+	return session, nil
+}
 
-	session := engine.Session{
-		Peers:       peers,
-		PeerID:      peerID,
+// baseSession is the session for the torrent's data alone, without
+// peers or trackers.
+func (t *TorrentFile) baseSession() *engine.Session {
+	session := &engine.Session{
 		InfoHash:    t.InfoHash,
 		PieceHashes: t.PieceHashes,
 		PieceLength: t.PieceLength,
 		Length:      t.Length,
 		Name:        t.Name,
+		Files:       t.engineFiles(),
+		Private:     t.Private,
 	}
-	buf, err := session.Download()
-	if err != nil {
+	if t.IsV2() {
+		session.Verifier = t.verifier()
+	}
+	if t.IsHybrid() {
+		session.HybridHash = truncate(t.InfoHashV2)
+	}
+	return session
+}
+
+// webSeeds maps every url-list entry to per-file URLs. A URL ending in a
+// slash is a directory holding the torrent under its name; otherwise, for
+// a single file torrent, it is the file itself.
+func (t *TorrentFile) webSeeds() []engine.WebSeed {
+	seeds := make([]engine.WebSeed, 0, len(t.URLList))
+	for _, base := range t.URLList {
+		if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
+			continue
+		}
+		if !t.MultiFile {
+			u := base
+			if strings.HasSuffix(base, "/") {
+				u += url.PathEscape(t.Name)
+			}
+			seeds = append(seeds, engine.WebSeed{URLs: []string{u}})
+			continue
+		}
+		if !strings.HasSuffix(base, "/") {
+			base += "/"
+		}
+		urls := make([]string, len(t.Files))
+		for i, f := range t.Files {
+			parts := append([]string{t.Name}, f.Path...)
+			for j, p := range parts {
+				parts[j] = url.PathEscape(p)
+			}
+			urls[i] = base + strings.Join(parts, "/")
+		}
+		seeds = append(seeds, engine.WebSeed{URLs: urls})
+	}
+	return seeds
+}
+
+func (t *TorrentFile) engineFiles() []engine.File {
+	files := make([]engine.File, len(t.Files))
+	for i, f := range t.Files {
+		files[i] = engine.File{
+			Path:     f.DisplayPath(),
+			Offset:   f.Offset,
+			Length:   f.Length,
+			Priority: engine.PriorityNormal,
+			Padding:  f.Padding,
+		}
+		if f.Padding || len(f.Symlink) > 0 {
+			files[i].Priority = engine.PrioritySkip
+		}
+	}
+	return files
+}
+
+// OpenStorage lays the torrent out under path. For a single file torrent
+// path is the file itself, otherwise it is the root directory. Files whose
+// priority is engine.PrioritySkip are not created.
+func (t *TorrentFile) OpenStorage(path string, priorities []int) (*storage.Files, error) {
+	root, files := t.layout(path, priorities)
+	return storage.Open(root, files, partsPath(path))
+}
+
+// OpenExisting opens the data already laid out under path, as by
+// OpenStorage, for reading only.
+func (t *TorrentFile) OpenExisting(path string) (*storage.Files, error) {
+	root, files := t.layout(path, nil)
+	return storage.OpenExisting(root, files)
+}
+
+// layout places the torrent's files under path, returning the directory
+// they are relative to.
+func (t *TorrentFile) layout(path string, priorities []int) (string, []storage.File) {
+	root := path
+	files := make([]storage.File, len(t.Files))
+	for i, f := range t.Files {
+		files[i] = storage.File{
+			Path:       filepath.Join(f.Path...),
+			Length:     int64(f.Length),
+			Skip:       i < len(priorities) && priorities[i] == engine.PrioritySkip,
+			Padding:    f.Padding,
+			Executable: f.Executable,
+			Hidden:     f.Hidden,
+		}
+		if len(f.Symlink) > 0 {
+			files[i].Symlink = filepath.Join(f.Symlink...)
+		}
+	}
+	if !t.MultiFile {
+		root = filepath.Dir(path)
+		files[0].Path = filepath.Base(path)
+	}
+	return root, files
+}
+
+// RemoveData deletes what OpenStorage laid out under path.
+func (t *TorrentFile) RemoveData(path string) error {
+	if err := os.RemoveAll(path); err != nil {
 		return err
 	}
+	return os.RemoveAll(partsPath(path))
+}
+
+// partsPath holds pieces that straddle skipped files.
+func partsPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".parts")
+}
 
-	outFile, err := os.Create(path)
+// DownloadToFile downloads a torrent and writes it to a file
+func (t *TorrentFile) DownloadToFile(path string, opts Options) error {
+	session, err := t.NewSession(opts)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
-	_, err = outFile.Write(buf)
+
+	store, err := t.OpenStorage(path, session.FilePriorities())
 	if err != nil {
 		return err
 	}
-	return nil
+	defer store.Close()
+
+	session.Storage = store
+	return session.Download()
 }
 
 // Open parses a torrent file
 func Open(path string) (TorrentFile, error) {
-	file, err := os.Open(path)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return TorrentFile{}, err
 	}
-	defer file.Close()
+	return Parse(data)
+}
 
+// Parse decodes the contents of a torrent file
+func Parse(data []byte) (TorrentFile, error) {
 	bto := bencodeTorrent{}
-	err = bencode.Unmarshal(file, &bto)
+	err := bencode.Unmarshal(bytes.NewReader(data), &bto)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	rawInfo, err := rawDictValue(data, "info")
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	t, err := bto.toTorrentFile(rawInfo)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	t.URLList, err = urlList(data)
 	if err != nil {
 		return TorrentFile{}, err
 	}
-	return bto.toTorrentFile()
+	if t.MetaVersion == 2 {
+		if err := t.parseV2(data, rawInfo); err != nil {
+			return TorrentFile{}, err
+		}
+	}
+	return t, nil
 }
 
-func (i *bencodeInfo) hash() ([20]byte, error) {
-	var buf bytes.Buffer
-	err := bencode.Marshal(&buf, *i)
-	if err != nil {
-		return [20]byte{}, err
+func (i *bencodeInfo) fileList() ([]File, error) {
+	if len(i.Files) == 0 {
+		f := File{Path: []string{i.Name}, Length: i.Length}
+		if err := f.setAttr(i.Attr, i.SymlinkPath); err != nil {
+			return nil, err
+		}
+		return []File{f}, nil
+	}
+
+	files := make([]File, len(i.Files))
+	offset := 0
+	for n, f := range i.Files {
+		if len(f.Path) == 0 || f.Length < 0 {
+			return nil, fmt.Errorf("invalid file entry %d", n)
+		}
+		if !safePath(f.Path) {
+			return nil, fmt.Errorf("unsafe path in file entry %d", n)
+		}
+		files[n] = File{Path: f.Path, Length: f.Length, Offset: offset}
+		if err := files[n].setAttr(f.Attr, f.SymlinkPath); err != nil {
+			return nil, fmt.Errorf("file entry %d: %w", n, err)
+		}
+		offset += f.Length
 	}
-	h := sha1.Sum(buf.Bytes())
-	return h, nil
+	return files, nil
+}
+
+func safePath(parts []string) bool {
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, "/\\") {
+			return false
+		}
+	}
+	return true
+}
+
+// setAttr applies BEP 47 attributes: p padding, x executable, h hidden
+// and l symlink. Unknown flags are ignored.
+func (f *File) setAttr(attr string, symlink []string) error {
+	f.Padding = strings.Contains(attr, "p")
+	f.Executable = strings.Contains(attr, "x")
+	f.Hidden = strings.Contains(attr, "h")
+	if strings.Contains(attr, "l") {
+		if len(symlink) == 0 || !safePath(symlink) {
+			return errors.New("invalid symlink path")
+		}
+		f.Symlink = symlink
+	}
+	return nil
 }
 
 func (i *bencodeInfo) splitPieceHashes() ([][20]byte, error) {
@@ -118,23 +467,56 @@ func (i *bencodeInfo) splitPieceHashes() ([][20]byte, error) {
 	return result, nil
 }
 
-func (bto *bencodeTorrent) toTorrentFile() (TorrentFile, error) {
-	infoHash, err := bto.Info.hash()
-	if err != nil {
-		return TorrentFile{}, err
+func (bto *bencodeTorrent) toTorrentFile(rawInfo []byte) (TorrentFile, error) {
+	infoHash := sha1.Sum(rawInfo)
+	// The name becomes a file or directory under the download directory.
+	if !safePath([]string{bto.Info.Name}) {
+		return TorrentFile{}, fmt.Errorf("invalid torrent name %q", bto.Info.Name)
+	}
+	if bto.Info.PieceLength <= 0 {
+		return TorrentFile{}, fmt.Errorf("invalid piece length %d", bto.Info.PieceLength)
 	}
 	pieceHashes, err := bto.Info.splitPieceHashes()
 	if err != nil {
 		return TorrentFile{}, err
 	}
+	var files []File
+	if bto.Info.MetaVersion != 2 || len(pieceHashes) > 0 {
+		files, err = bto.Info.fileList()
+		if err != nil {
+			return TorrentFile{}, err
+		}
+	}
+	length := 0
+	for _, f := range files {
+		length += f.Length
+	}
+	// A v2 only torrent has no v1 hashes; parseV2 sizes them from its
+	// own file list.
+	pl := bto.Info.PieceLength
+	if files != nil {
+		if want := (length + pl - 1) / pl; len(pieceHashes) != want {
+			return TorrentFile{}, fmt.Errorf("%d piece hashes for %d pieces", len(pieceHashes), want)
+		}
+	}
 	t := TorrentFile{
 		Announce:     bto.Announce,
 		AnnounceList: bto.AnnounceList,
 		InfoHash:     infoHash,
 		PieceHashes:  pieceHashes,
 		PieceLength:  bto.Info.PieceLength,
-		Length:       bto.Info.Length,
+		Length:       length,
 		Name:         bto.Info.Name,
+		Files:        files,
+		MultiFile:    len(bto.Info.Files) > 0,
+		MetaVersion:  bto.Info.MetaVersion,
+		Private:      bto.Info.Private == 1,
+		Key:          randomTransactionID(),
+		CreatedBy:    bto.CreatedBy,
+		Comment:      bto.Comment,
+	}
+	if bto.CreationDate > 0 {
+		t.CreationDate = time.Unix(bto.CreationDate, 0)
 	}
 	return t, nil
 }
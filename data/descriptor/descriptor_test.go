@@ -0,0 +1,157 @@
+package descriptor
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMultiFile(t *testing.T) {
+	pieces := strings.Repeat("a", 20)
+	info := "d5:filesld6:lengthi3e4:pathl1:aeed6:lengthi5e4:pathl3:sub1:beee" +
+		"4:name4:root12:piece lengthi16384e6:pieces20:" + pieces + "7:unknowni1ee"
+	data := "d8:announce13:http://x/anno4:info" + info + "e"
+
+	tf, err := Parse([]byte(data))
+	require.NoError(t, err)
+
+	assert.Equal(t, sha1.Sum([]byte(info)), tf.InfoHash)
+	assert.True(t, tf.MultiFile)
+	assert.Equal(t, 8, tf.Length)
+	assert.Equal(t, []File{
+		{Path: []string{"a"}, Length: 3, Offset: 0},
+		{Path: []string{"sub", "b"}, Length: 5, Offset: 3},
+	}, tf.Files)
+	assert.Equal(t, "sub/b", tf.Files[1].DisplayPath())
+}
+
+func TestParseRejectsUnsafePaths(t *testing.T) {
+	info := "d5:filesld6:lengthi3e4:pathl2:..6:passwdeee" +
+		"4:name4:root12:piece lengthi16384e6:pieces0:e"
+	_, err := Parse([]byte("d4:info" + info + "e"))
+	assert.Error(t, err)
+}
+
+func TestParseRejectsUnsafeName(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "../../evil", "a/b", `a\b`} {
+		info := fmt.Sprintf("d6:lengthi3e4:name%d:%s12:piece lengthi16384e6:pieces20:%se", len(name), name, strings.Repeat("a", 20))
+		_, err := Parse([]byte("d4:info" + info + "e"))
+		assert.Error(t, err, "name %q", name)
+	}
+}
+
+func TestParseRejectsBadPieces(t *testing.T) {
+	hash := strings.Repeat("a", 20)
+	cases := map[string]string{
+		"zero piece length":  "d6:lengthi3e4:name5:a.iso12:piece lengthi0e6:pieces20:" + hash + "e",
+		"extra piece hashes": "d6:lengthi3e4:name5:a.iso12:piece lengthi16384e6:pieces40:" + hash + hash + "e",
+		"missing hashes":     "d6:lengthi20000e4:name5:a.iso12:piece lengthi16384e6:pieces20:" + hash + "e",
+	}
+	for name, info := range cases {
+		_, err := Parse([]byte("d4:info" + info + "e"))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseURLList(t *testing.T) {
+	info := "d6:lengthi3e4:name5:a.iso12:piece lengthi16384e6:pieces20:" + strings.Repeat("a", 20) + "e"
+	cases := map[string]struct {
+		list string
+		want []string
+	}{
+		"absent": {list: "", want: nil},
+		"string": {list: "8:url-list13:http://m/a/b/", want: []string{"http://m/a/b/"}},
+		"list":   {list: "8:url-listl8:http://x0:8:http://ye", want: []string{"http://x", "http://y"}},
+	}
+	for name, c := range cases {
+		tf, err := Parse([]byte("d4:info" + info + c.list + "e"))
+		require.NoError(t, err, name)
+		assert.Equal(t, c.want, tf.URLList, name)
+	}
+}
+
+func TestWebSeeds(t *testing.T) {
+	single := TorrentFile{Name: "a b.iso", Files: []File{{Path: []string{"a b.iso"}}}}
+	single.URLList = []string{"http://m/pub/", "http://m/exact.iso", "ftp://m/"}
+	seeds := single.webSeeds()
+	require.Len(t, seeds, 2)
+	assert.Equal(t, []string{"http://m/pub/a%20b.iso"}, seeds[0].URLs)
+	assert.Equal(t, []string{"http://m/exact.iso"}, seeds[1].URLs)
+
+	multi := TorrentFile{
+		Name:      "root",
+		MultiFile: true,
+		Files:     []File{{Path: []string{"a"}}, {Path: []string{"sub", "b#1"}}},
+		URLList:   []string{"http://m/pub"},
+	}
+	seeds = multi.webSeeds()
+	require.Len(t, seeds, 1)
+	assert.Equal(t, []string{"http://m/pub/root/a", "http://m/pub/root/sub/b%231"}, seeds[0].URLs)
+}
+
+func TestParseAttributes(t *testing.T) {
+	info := "d5:filesl" +
+		"d4:attr1:x6:lengthi3e4:pathl3:runee" +
+		"d4:attr1:p6:lengthi5e4:pathl4:.pad1:5ee" +
+		"d4:attr2:hl6:lengthi0e4:pathl5:.linke12:symlink pathl3:runee" +
+		"e4:name4:root12:piece lengthi16384e6:pieces20:" + strings.Repeat("a", 20) + "e"
+
+	tf, err := Parse([]byte("d4:info" + info + "e"))
+	require.NoError(t, err)
+	require.Len(t, tf.Files, 3)
+	assert.True(t, tf.Files[0].Executable)
+	assert.True(t, tf.Files[1].Padding)
+	assert.True(t, tf.Files[2].Hidden)
+	assert.Equal(t, []string{"run"}, tf.Files[2].Symlink)
+
+	files := tf.engineFiles()
+	assert.True(t, files[1].Padding)
+	assert.Equal(t, 0, files[1].Priority)
+	assert.Equal(t, 0, files[2].Priority)
+
+	escape := strings.Replace(info, "l3:runee", "l2:..3:runee", 1)
+	_, err = Parse([]byte("d4:info" + escape + "e"))
+	assert.Error(t, err)
+}
+
+func TestParsePrivate(t *testing.T) {
+	info := "d6:lengthi3e4:name5:a.iso12:piece lengthi16384e6:pieces20:" + strings.Repeat("a", 20)
+
+	public, err := Parse([]byte("d8:announce13:http://x/anno4:info" + info + "ee"))
+	require.NoError(t, err)
+	assert.False(t, public.Private)
+
+	tf, err := Parse([]byte("d8:announce13:http://x/anno4:info" + info + "7:privatei1eee"))
+	require.NoError(t, err)
+	assert.True(t, tf.Private)
+
+	// The same key is sent on every announce of this torrent.
+	first, err := tf.assembleURL([20]byte{1}, 6881)
+	require.NoError(t, err)
+	second, err := tf.assembleURL([20]byte{1}, 6881)
+	require.NoError(t, err)
+	assert.Contains(t, first, fmt.Sprintf("key=%08x", tf.Key))
+	assert.Equal(t, first, second)
+}
+
+func TestSessionKey(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.URL.Query().Get("key"))
+		w.Write([]byte("d14:failure reason4:nopee"))
+	}))
+	defer srv.Close()
+
+	tf := TorrentFile{Announce: srv.URL + "/announce", Length: 1, Key: 1}
+	_, err := tf.NewSession(Options{Key: 0xabcd1234})
+	assert.Error(t, err)
+	_, err = tf.NewSession(Options{})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"abcd1234", "00000001"}, keys)
+}
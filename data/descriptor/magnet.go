@@ -0,0 +1,345 @@
+package descriptor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/logging"
+	"github.com/Sabir222/torrent-at-home/network/connector"
+	"github.com/Sabir222/torrent-at-home/network/endpoints"
+	"github.com/Sabir222/torrent-at-home/protocol/extension"
+	"github.com/Sabir222/torrent-at-home/protocol/frames"
+)
+
+const (
+	// metadataID is the id peers send ut_metadata messages to us under.
+	metadataID = 1
+	// maxMetadataSize bounds what a peer can make us allocate.
+	maxMetadataSize = 16 << 20
+	metadataTimeout = 30 * time.Second
+	metadataConns   = 8
+)
+
+var (
+	ErrBadMagnet       = errors.New("invalid magnet link")
+	ErrNoMetadata      = errors.New("no peer sent the metadata")
+	ErrMetadataRefused = errors.New("peer refused the metadata")
+)
+
+// Magnet is a parsed magnet link, which names a torrent by its infohash
+// alone; the info dictionary is then fetched from peers (BEP 9).
+type Magnet struct {
+	InfoHash [20]byte
+	// InfoHashV2 is the v2 infohash of v2 and hybrid torrents, zero
+	// otherwise. For a v2 only torrent InfoHash is its truncated form.
+	InfoHashV2 [32]byte
+	Name       string
+	Trackers   []string
+	WebSeeds   []string
+	Peers      []endpoints.Endpoint
+}
+
+// ParseMagnet reads a magnet URI with a BitTorrent v1 infohash in hex or
+// base32, a v2 one as a SHA-256 multihash, or both.
+func ParseMagnet(uri string) (Magnet, error) {
+	var m Magnet
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "magnet" {
+		return m, ErrBadMagnet
+	}
+	q := u.Query()
+
+	found := false
+	for _, xt := range q["xt"] {
+		if multihash, ok := strings.CutPrefix(xt, "urn:btmh:"); ok {
+			raw, err := hex.DecodeString(multihash)
+			if err != nil || len(raw) != 34 || raw[0] != 0x12 || raw[1] != 0x20 {
+				return m, fmt.Errorf("%w: bad v2 infohash %q", ErrBadMagnet, multihash)
+			}
+			copy(m.InfoHashV2[:], raw[2:])
+			continue
+		}
+		hash, ok := strings.CutPrefix(xt, "urn:btih:")
+		if !ok {
+			continue
+		}
+		var raw []byte
+		switch len(hash) {
+		case 40:
+			raw, err = hex.DecodeString(hash)
+		case 32:
+			raw, err = base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		default:
+			err = ErrBadMagnet
+		}
+		if err != nil {
+			return m, fmt.Errorf("%w: bad infohash %q", ErrBadMagnet, hash)
+		}
+		copy(m.InfoHash[:], raw)
+		found = true
+	}
+	if !found && m.InfoHashV2 != ([32]byte{}) {
+		m.InfoHash = truncate(m.InfoHashV2)
+		found = true
+	}
+	if !found {
+		return m, fmt.Errorf("%w: no urn:btih infohash", ErrBadMagnet)
+	}
+
+	m.Name = q.Get("dn")
+	m.Trackers = q["tr"]
+	m.WebSeeds = q["ws"]
+	for _, pe := range q["x.pe"] {
+		if peer, err := endpoints.ParseAddr(pe); err == nil {
+			m.Peers = append(m.Peers, peer)
+		}
+	}
+	return m, nil
+}
+
+// String renders the magnet URI.
+func (m Magnet) String() string {
+	q := url.Values{}
+	if m.Name != "" {
+		q.Set("dn", m.Name)
+	}
+	for _, tr := range m.Trackers {
+		q.Add("tr", tr)
+	}
+	for _, ws := range m.WebSeeds {
+		q.Add("ws", ws)
+	}
+	for _, p := range m.Peers {
+		q.Add("x.pe", p.String())
+	}
+	var xt []string
+	if m.InfoHashV2 == ([32]byte{}) || m.InfoHash != truncate(m.InfoHashV2) {
+		xt = append(xt, "xt=urn:btih:"+hex.EncodeToString(m.InfoHash[:]))
+	}
+	if m.InfoHashV2 != ([32]byte{}) {
+		xt = append(xt, "xt=urn:btmh:1220"+hex.EncodeToString(m.InfoHashV2[:]))
+	}
+	out := "magnet:?" + strings.Join(xt, "&")
+	if len(q) > 0 {
+		out += "&" + q.Encode()
+	}
+	return out
+}
+
+// Magnet links to the torrent by its infohashes, with its name, trackers
+// and web seeds.
+func (t *TorrentFile) Magnet() Magnet {
+	m := Magnet{
+		InfoHash: t.InfoHash,
+		Name:     t.Name,
+		Trackers: t.getTrackerList(),
+		WebSeeds: t.URLList,
+	}
+	if t.IsV2() {
+		m.InfoHashV2 = t.InfoHashV2
+	}
+	return m
+}
+
+// FetchMetadata finds peers through the magnet's trackers, downloads the
+// info dictionary from the first that has it and returns it as a .torrent
+// file, with the magnet's trackers and web seeds, ready for Parse. Only the
+// connection related fields of opts are used.
+func (m Magnet) FetchMetadata(ctx context.Context, opts Options) ([]byte, error) {
+	var peerID [20]byte
+	if _, err := rand.Read(peerID[:]); err != nil {
+		return nil, err
+	}
+	port := opts.Port
+	if port == 0 {
+		port = Port
+	}
+
+	probe := TorrentFile{
+		InfoHash: m.InfoHash,
+		// left is unknown; zero would tell the trackers we are a seed.
+		Length: extension.MetadataPieceSize,
+		Key:    opts.Key,
+	}
+	if probe.Key == 0 {
+		probe.Key = randomTransactionID()
+	}
+	if len(m.Trackers) > 0 {
+		probe.AnnounceList = [][]string{m.Trackers}
+	}
+	peers, trackers, err := probe.announce(peerID, port, opts)
+	announced(opts, m.InfoHash, trackers)
+	peers = append(append([]endpoints.Endpoint(nil), m.Peers...), peers...)
+	if len(peers) == 0 {
+		if err == nil {
+			err = ErrNoMetadata
+		}
+		return nil, err
+	}
+
+	d := &connector.Dialer{
+		Encryption:    opts.Encryption,
+		FallbackPlain: opts.FallbackPlain,
+		Transports:    opts.Transports,
+		Extensions:    map[string]int{extension.Metadata: metadataID},
+	}
+	if opts.Global != nil {
+		d.Down = append(d.Down, opts.Global.Down)
+		d.Up = append(d.Up, opts.Global.Up)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	found := make(chan []byte, 1)
+	slots := make(chan struct{}, metadataConns)
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(peer endpoints.Endpoint) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			info, err := fetchMetadata(ctx, d, peer, peerID, m.InfoHash)
+			if err != nil {
+				logging.Debugf("[magnet] %s: %v\n", peer, err)
+				return
+			}
+			select {
+			case found <- info:
+				cancel()
+			default:
+			}
+		}(peer)
+	}
+	wg.Wait()
+
+	select {
+	case info := <-found:
+		logging.Infof("[magnet] ✓ received %d byte(s) of metadata\n", len(info))
+		return m.metainfo(info), nil
+	default:
+	}
+	if err := ctx.Err(); err != nil && !errors.Is(err, context.Canceled) {
+		return nil, err
+	}
+	return nil, ErrNoMetadata
+}
+
+// fetchMetadata asks one peer for every metadata piece.
+func fetchMetadata(ctx context.Context, d *connector.Dialer, peer endpoints.Endpoint, peerID, infoHash [20]byte) ([]byte, error) {
+	conn, err := d.Connect(peer, peerID, infoHash)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Conn.Close() })
+	defer stop()
+	conn.Conn.SetDeadline(time.Now().Add(metadataTimeout))
+
+	var info []byte
+	received := 0
+	pieces := 0
+	for {
+		frm, err := conn.Read()
+		if err != nil {
+			return nil, err
+		}
+		if frm == nil || frm.Type != frames.TypeExtended {
+			continue
+		}
+		id, payload, err := extension.Split(frm)
+		if err != nil {
+			return nil, err
+		}
+
+		if id == extension.HandshakeID && info == nil {
+			size := conn.Extensions.Size
+			if conn.Extensions.M[extension.Metadata] == 0 || size <= 0 {
+				return nil, errors.New("peer does not offer metadata")
+			}
+			if size > maxMetadataSize {
+				return nil, fmt.Errorf("metadata of %d bytes is too large", size)
+			}
+			info = make([]byte, size)
+			pieces = (size + extension.MetadataPieceSize - 1) / extension.MetadataPieceSize
+			for i := 0; i < pieces; i++ {
+				req := extension.MetadataMessage{Type: extension.MetadataRequest, Piece: i}
+				if err := conn.SendExtended(extension.Metadata, req.Marshal()); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if id != metadataID || info == nil {
+			continue
+		}
+
+		msg, data, err := extension.ParseMetadata(payload)
+		if err != nil {
+			return nil, err
+		}
+		switch msg.Type {
+		case extension.MetadataReject:
+			return nil, ErrMetadataRefused
+		case extension.MetadataData:
+			offset := msg.Piece * extension.MetadataPieceSize
+			if msg.Piece < 0 || msg.Piece >= pieces || offset+len(data) > len(info) {
+				return nil, extension.ErrBadMetadataMessage
+			}
+			copy(info[offset:], data)
+			received++
+		}
+		if received == pieces {
+			if sha1.Sum(info) != infoHash {
+				return nil, errors.New("metadata does not match the infohash")
+			}
+			return info, nil
+		}
+	}
+}
+
+// metainfo wraps a fetched info dictionary with the magnet's trackers and
+// web seeds into a .torrent file.
+func (m Magnet) metainfo(info []byte) []byte {
+	var b strings.Builder
+	b.WriteString("d")
+	if len(m.Trackers) > 0 {
+		b.WriteString(bstring("announce") + bstring(m.Trackers[0]))
+		b.WriteString(bstring("announce-list") + "ll")
+		for _, tr := range m.Trackers {
+			b.WriteString(bstring(tr))
+		}
+		b.WriteString("ee")
+	}
+	b.WriteString(bstring("info"))
+	b.Write(info)
+	if len(m.WebSeeds) > 0 {
+		b.WriteString(bstring("url-list") + "l")
+		for _, ws := range m.WebSeeds {
+			b.WriteString(bstring(ws))
+		}
+		b.WriteString("e")
+	}
+	b.WriteString("e")
+	return []byte(b.String())
+}
+
+func bstring(s string) string {
+	return strconv.Itoa(len(s)) + ":" + s
+}
@@ -0,0 +1,152 @@
+package descriptor
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/network/endpoints"
+	"github.com/Sabir222/torrent-at-home/protocol/extension"
+	"github.com/Sabir222/torrent-at-home/protocol/frames"
+	"github.com/Sabir222/torrent-at-home/protocol/greeting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMagnet(t *testing.T) {
+	hash := "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	m, err := ParseMagnet("magnet:?xt=urn:btih:" + hash + "&dn=a+b&tr=udp%3A%2F%2Ft%2Fa&tr=http%3A%2F%2Fu%2Fb&x.pe=10.0.0.1%3A6881")
+	require.NoError(t, err)
+	assert.Equal(t, hash, hex.EncodeToString(m.InfoHash[:]))
+	assert.Equal(t, "a b", m.Name)
+	assert.Equal(t, []string{"udp://t/a", "http://u/b"}, m.Trackers)
+	assert.Equal(t, []endpoints.Endpoint{{Addr: net.ParseIP("10.0.0.1"), Port: 6881}}, m.Peers)
+
+	again, err := ParseMagnet(m.String())
+	require.NoError(t, err)
+	assert.Equal(t, m, again)
+
+	b32, err := ParseMagnet("magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK")
+	require.NoError(t, err)
+	assert.Equal(t, m.InfoHash, b32.InfoHash)
+
+	for _, bad := range []string{"http://x", "magnet:?dn=x", "magnet:?xt=urn:btih:abc"} {
+		_, err := ParseMagnet(bad)
+		assert.ErrorIs(t, err, ErrBadMagnet, bad)
+	}
+}
+
+// metadataSeed serves info over ut_metadata to one connection.
+func metadataSeed(t *testing.T, ln net.Listener, info []byte) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	hello, err := greeting.Unpack(conn)
+	if err != nil {
+		return
+	}
+	g := greeting.Build(hello.Hash, [20]byte{'s'})
+	g.SetBit(greeting.ExtensionProtocol)
+	conn.Write(g.Pack())
+	hs := extension.Handshake{M: map[string]int{extension.Metadata: 3}, Size: len(info)}
+	frm, _ := hs.Frame()
+	conn.Write(frm.Pack())
+
+	theirs := 0
+	for {
+		frm, err := frames.Unpack(conn)
+		if err != nil {
+			return
+		}
+		if frm == nil || frm.Type != frames.TypeExtended {
+			continue
+		}
+		id, payload, _ := extension.Split(frm)
+		if id == extension.HandshakeID {
+			remote, _ := extension.ParseHandshake(payload)
+			theirs = remote.M[extension.Metadata]
+			continue
+		}
+		msg, _, err := extension.ParseMetadata(payload)
+		require.NoError(t, err)
+		require.Equal(t, 3, int(id))
+		begin := msg.Piece * extension.MetadataPieceSize
+		end := min(begin+extension.MetadataPieceSize, len(info))
+		reply := extension.MetadataMessage{Type: extension.MetadataData, Piece: msg.Piece, TotalSize: len(info)}
+		conn.Write(extension.Frame(byte(theirs), append(reply.Marshal(), info[begin:end]...)).Pack())
+	}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	// Enough pieces for the info dictionary to span two metadata pieces.
+	pieces := strings.Repeat("p", 20*1000)
+	info := "d6:lengthi1000e4:name5:a.iso12:piece lengthi1e6:pieces20000:" + pieces + "e"
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go metadataSeed(t, ln, []byte(info))
+
+	peer, err := endpoints.ParseAddr(ln.Addr().String())
+	require.NoError(t, err)
+	m := Magnet{InfoHash: sha1.Sum([]byte(info)), Peers: []endpoints.Endpoint{peer}, WebSeeds: []string{"http://m/"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	data, err := m.FetchMetadata(ctx, Options{})
+	require.NoError(t, err)
+	tf, err := Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, m.InfoHash, tf.InfoHash)
+	assert.Equal(t, "a.iso", tf.Name)
+	assert.Len(t, tf.PieceHashes, 1000)
+	assert.Equal(t, []string{"http://m/"}, tf.URLList)
+}
+
+func TestTorrentMagnet(t *testing.T) {
+	tf := TorrentFile{
+		InfoHash:     [20]byte{0xab},
+		Name:         "debian.iso",
+		Announce:     "http://b.example/announce",
+		AnnounceList: [][]string{{"udp://a.example:1337"}},
+		URLList:      []string{"http://mirror.example/"},
+	}
+	m, err := ParseMagnet(tf.Magnet().String())
+	require.NoError(t, err)
+	assert.Equal(t, tf.InfoHash, m.InfoHash)
+	assert.Equal(t, "debian.iso", m.Name)
+	assert.Equal(t, []string{"udp://a.example:1337", "http://b.example/announce"}, m.Trackers)
+	assert.Equal(t, []string{"http://mirror.example/"}, m.WebSeeds)
+}
+
+func TestMagnetV2(t *testing.T) {
+	v2 := sha1.Sum([]byte("x"))
+	var root [32]byte
+	copy(root[:], append(v2[:], v2[:]...))
+	btmh := "urn:btmh:1220" + hex.EncodeToString(root[:])
+
+	// A v2 only link names the swarm by the truncated v2 infohash.
+	m, err := ParseMagnet("magnet:?xt=" + btmh)
+	require.NoError(t, err)
+	assert.Equal(t, root, m.InfoHashV2)
+	assert.Equal(t, truncate(root), m.InfoHash)
+	assert.Equal(t, "magnet:?xt="+btmh, m.String())
+
+	// A hybrid link carries both.
+	hybrid := Magnet{InfoHash: [20]byte{1}, InfoHashV2: root}
+	assert.Equal(t, "magnet:?xt=urn:btih:01"+strings.Repeat("0", 38)+"&xt="+btmh, hybrid.String())
+	again, err := ParseMagnet(hybrid.String())
+	require.NoError(t, err)
+	assert.Equal(t, hybrid, again)
+
+	_, err = ParseMagnet("magnet:?xt=urn:btmh:1114" + hex.EncodeToString(root[:]))
+	assert.ErrorIs(t, err, ErrBadMagnet)
+}
@@ -0,0 +1,122 @@
+package descriptor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var (
+	ErrMalformedBencode = errors.New("malformed bencode")
+	errMissingKey       = errors.New("missing key")
+)
+
+// skipValue returns the position just past the bencoded value starting at
+// pos. It is used to find the exact bytes of the info dictionary, since
+// re-encoding the decoded struct drops any key we do not model and would
+// produce a different infohash.
+func skipValue(data []byte, pos int) (int, error) {
+	if pos >= len(data) {
+		return 0, ErrMalformedBencode
+	}
+
+	switch c := data[pos]; {
+	case c == 'i':
+		for i := pos + 1; i < len(data); i++ {
+			if data[i] == 'e' {
+				return i + 1, nil
+			}
+		}
+		return 0, ErrMalformedBencode
+	case c == 'l' || c == 'd':
+		pos++
+		for pos < len(data) && data[pos] != 'e' {
+			next, err := skipValue(data, pos)
+			if err != nil {
+				return 0, err
+			}
+			pos = next
+		}
+		if pos >= len(data) {
+			return 0, ErrMalformedBencode
+		}
+		return pos + 1, nil
+	case c >= '0' && c <= '9':
+		_, end, err := readString(data, pos)
+		return end, err
+	default:
+		return 0, ErrMalformedBencode
+	}
+}
+
+func readString(data []byte, pos int) (string, int, error) {
+	colon := pos
+	for colon < len(data) && data[colon] != ':' {
+		colon++
+	}
+	if colon >= len(data) {
+		return "", 0, ErrMalformedBencode
+	}
+	n, err := strconv.Atoi(string(data[pos:colon]))
+	if err != nil || n < 0 || colon+1+n > len(data) {
+		return "", 0, ErrMalformedBencode
+	}
+	end := colon + 1 + n
+	return string(data[colon+1 : end]), end, nil
+}
+
+// rawDictValue returns the raw bytes stored under key in the top level
+// dictionary of data.
+func rawDictValue(data []byte, key string) ([]byte, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, ErrMalformedBencode
+	}
+
+	pos := 1
+	for pos < len(data) && data[pos] != 'e' {
+		k, next, err := readString(data, pos)
+		if err != nil {
+			return nil, err
+		}
+		end, err := skipValue(data, next)
+		if err != nil {
+			return nil, err
+		}
+		if k == key {
+			return data[next:end], nil
+		}
+		pos = end
+	}
+	return nil, fmt.Errorf("%w %q", errMissingKey, key)
+}
+
+// urlList reads the BEP 19 url-list, which is either a single string or a
+// list of strings.
+func urlList(data []byte) ([]string, error) {
+	raw, err := rawDictValue(data, "url-list")
+	if errors.Is(err, errMissingKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if raw[0] != 'l' {
+		s, _, err := readString(raw, 0)
+		if err != nil || s == "" {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+	var urls []string
+	for pos := 1; pos < len(raw) && raw[pos] != 'e'; {
+		s, next, err := readString(raw, pos)
+		if err != nil {
+			return nil, err
+		}
+		if s != "" {
+			urls = append(urls, s)
+		}
+		pos = next
+	}
+	return urls, nil
+}
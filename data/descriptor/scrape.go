@@ -0,0 +1,173 @@
+package descriptor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jackpal/bencode-go"
+)
+
+const udpActionScrape = 2
+
+var ErrNoScrape = errors.New("tracker does not support scraping")
+
+// ScrapeResult is what a tracker knows of a torrent's swarm: Seeders and
+// Leechers now, and how many peers Completed it in all.
+type ScrapeResult struct {
+	Tracker   string
+	Seeders   int
+	Leechers  int
+	Completed int
+	Err       error
+}
+
+// Scrape asks each tracker in turn about the torrent, waiting up to
+// timeout for each. UDP trackers get a single try.
+func (t *TorrentFile) Scrape(timeout time.Duration) []ScrapeResult {
+	var results []ScrapeResult
+	for _, tracker := range t.getTrackerList() {
+		r := ScrapeResult{Tracker: tracker}
+		if strings.HasPrefix(tracker, "udp://") {
+			r.Err = t.scrapeUDP(&r, timeout)
+		} else {
+			r.Err = t.scrapeHTTP(&r, timeout)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// scrapeURL derives the scrape URL of an HTTP tracker from its announce
+// URL, by the convention trackers follow.
+func scrapeURL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return "", err
+	}
+	dir, last := path.Split(u.Path)
+	if !strings.HasPrefix(last, "announce") {
+		return "", ErrNoScrape
+	}
+	u.Path = dir + "scrape" + strings.TrimPrefix(last, "announce")
+	return u.String(), nil
+}
+
+func (t *TorrentFile) scrapeHTTP(r *ScrapeResult, timeout time.Duration) error {
+	target, err := scrapeURL(r.Tracker)
+	if err != nil {
+		return err
+	}
+	u, _ := url.Parse(target)
+	q := u.Query()
+	q.Set("info_hash", string(t.InfoHash[:]))
+	u.RawQuery = q.Encode()
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tracker replied %s", resp.Status)
+	}
+	// The files dictionary is keyed by raw infohashes, which the struct
+	// decoder can't fill a map of structs from.
+	decoded, err := bencode.Decode(resp.Body)
+	if err != nil {
+		return err
+	}
+	reply, ok := decoded.(map[string]interface{})
+	if !ok {
+		return ErrTrackerResponse
+	}
+	if failure, ok := reply["failure reason"].(string); ok {
+		return errors.New(failure)
+	}
+	files, _ := reply["files"].(map[string]interface{})
+	f, ok := files[string(t.InfoHash[:])].(map[string]interface{})
+	if !ok {
+		return errors.New("tracker does not know the torrent")
+	}
+	count := func(key string) int {
+		n, _ := f[key].(int64)
+		return int(n)
+	}
+	r.Seeders, r.Leechers, r.Completed = count("complete"), count("incomplete"), count("downloaded")
+	return nil
+}
+
+func (t *TorrentFile) scrapeUDP(r *ScrapeResult, timeout time.Duration) error {
+	u, err := url.Parse(r.Tracker)
+	if err != nil {
+		return err
+	}
+	addr, err := net.ResolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return err
+	}
+	c, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	conn := connExchange(c)
+
+	txID := randomTransactionID()
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint64(req[0:8], udpProtocolID)
+	binary.BigEndian.PutUint32(req[8:12], udpActionConnect)
+	binary.BigEndian.PutUint32(req[12:16], txID)
+	resp, err := udpReply(conn, req, udpActionConnect, timeout)
+	if err != nil {
+		return err
+	}
+	if len(resp) < 16 {
+		return ErrTrackerResponse
+	}
+
+	req = make([]byte, 36)
+	copy(req[0:8], resp[8:16])
+	binary.BigEndian.PutUint32(req[8:12], udpActionScrape)
+	binary.BigEndian.PutUint32(req[12:16], randomTransactionID())
+	copy(req[16:36], t.InfoHash[:])
+	resp, err = udpReply(conn, req, udpActionScrape, timeout)
+	if err != nil {
+		return err
+	}
+	if len(resp) < 20 {
+		return ErrTrackerResponse
+	}
+	r.Seeders = int(binary.BigEndian.Uint32(resp[8:12]))
+	r.Completed = int(binary.BigEndian.Uint32(resp[12:16]))
+	r.Leechers = int(binary.BigEndian.Uint32(resp[16:20]))
+	return nil
+}
+
+// udpReply sends req once and checks the reply is for it and of action.
+func udpReply(conn udpExchange, req []byte, action uint32, timeout time.Duration) ([]byte, error) {
+	resp, err := conn(req, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 8 {
+		return nil, ErrTrackerResponse
+	}
+	if binary.BigEndian.Uint32(resp[4:8]) != binary.BigEndian.Uint32(req[12:16]) {
+		return nil, ErrUDPTransactionMismatch
+	}
+	switch binary.BigEndian.Uint32(resp[0:4]) {
+	case action:
+		return resp, nil
+	case udpActionError:
+		return nil, errors.New("UDP tracker error: " + string(resp[8:]))
+	}
+	return nil, ErrUDPUnexpectedAction
+}
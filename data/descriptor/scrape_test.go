@@ -0,0 +1,81 @@
+package descriptor
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeURL(t *testing.T) {
+	cases := map[string]string{
+		"http://t.example/announce":         "http://t.example/scrape",
+		"http://t.example/x/announce.php":   "http://t.example/x/scrape.php",
+		"https://t.example/announce?key=ab": "https://t.example/scrape?key=ab",
+	}
+	for announce, want := range cases {
+		got, err := scrapeURL(announce)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := scrapeURL("http://t.example/a")
+	assert.ErrorIs(t, err, ErrNoScrape)
+}
+
+// fakeUDPScrape answers connects and scrapes with 5 seeders, 7 completed
+// and 3 leechers.
+func fakeUDPScrape(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := buf[:n]
+			action := binary.BigEndian.Uint32(req[8:12])
+			resp := make([]byte, 20)
+			binary.BigEndian.PutUint32(resp[0:4], action)
+			copy(resp[4:8], req[12:16])
+			if action == udpActionScrape {
+				binary.BigEndian.PutUint32(resp[8:12], 5)
+				binary.BigEndian.PutUint32(resp[12:16], 7)
+				binary.BigEndian.PutUint32(resp[16:20], 3)
+			}
+			pc.WriteTo(resp, from)
+		}
+	}()
+	return pc
+}
+
+func TestScrape(t *testing.T) {
+	tf := TorrentFile{InfoHash: [20]byte{1, 2, 3}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" || r.URL.Query().Get("info_hash") != string(tf.InfoHash[:]) {
+			w.Write([]byte("d14:failure reason7:unknowne"))
+			return
+		}
+		w.Write([]byte("d5:filesd20:" + string(tf.InfoHash[:]) + "d8:completei2e10:downloadedi9e10:incompletei4eeee"))
+	}))
+	defer srv.Close()
+	udp := fakeUDPScrape(t)
+	defer udp.Close()
+
+	tf.AnnounceList = [][]string{
+		{srv.URL + "/announce", srv.URL + "/other/announce"},
+		{"udp://" + udp.LocalAddr().String() + "/announce", srv.URL + "/a"},
+	}
+	results := tf.Scrape(time.Second)
+	require.Len(t, results, 4)
+	assert.Equal(t, ScrapeResult{Tracker: srv.URL + "/announce", Seeders: 2, Leechers: 4, Completed: 9}, results[0])
+	assert.EqualError(t, results[1].Err, "unknown")
+	assert.Equal(t, ScrapeResult{Tracker: "udp://" + udp.LocalAddr().String() + "/announce", Seeders: 5, Leechers: 3, Completed: 7}, results[2])
+	assert.ErrorIs(t, results[3].Err, ErrNoScrape)
+}
@@ -0,0 +1,134 @@
+package descriptor
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrUDPTrackersClosed = errors.New("UDP tracker socket closed")
+
+// packetWriter sends datagrams; both net.PacketConn and a uTP socket
+// sharing its port with other protocols qualify.
+type packetWriter interface {
+	WriteTo(b []byte, addr net.Addr) (int, error)
+}
+
+// UDPTrackers shares one UDP socket between the announces of every
+// torrent, matching replies to requests by transaction ID. Replies are
+// fed to Handle, either by ListenUDPTrackers or by whatever else reads
+// the socket, such as utp.Socket.Unhandled.
+type UDPTrackers struct {
+	conn packetWriter
+
+	mu      sync.Mutex
+	waiting map[uint32]chan []byte
+	closed  bool
+}
+
+func NewUDPTrackers(conn packetWriter) *UDPTrackers {
+	return &UDPTrackers{conn: conn, waiting: make(map[uint32]chan []byte)}
+}
+
+// ListenUDPTrackers opens a UDP socket of its own on addr and reads the
+// replies from it until Close.
+func ListenUDPTrackers(addr string) (*UDPTrackers, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	u := NewUDPTrackers(pc)
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				u.Close()
+				return
+			}
+			u.Handle(buf[:n], from)
+		}
+	}()
+	return u, nil
+}
+
+// Handle delivers a datagram and reports whether it answered a pending
+// request. The buffer is copied.
+func (u *UDPTrackers) Handle(b []byte, addr net.Addr) bool {
+	if len(b) < 8 {
+		return false
+	}
+	txID := binary.BigEndian.Uint32(b[4:8])
+
+	u.mu.Lock()
+	reply, ok := u.waiting[txID]
+	delete(u.waiting, txID)
+	u.mu.Unlock()
+	if !ok {
+		return false
+	}
+	reply <- append([]byte(nil), b...)
+	return true
+}
+
+// Close fails pending requests and, for a socket opened by
+// ListenUDPTrackers, closes it.
+func (u *UDPTrackers) Close() error {
+	u.mu.Lock()
+	if u.closed {
+		u.mu.Unlock()
+		return nil
+	}
+	u.closed = true
+	for txID, reply := range u.waiting {
+		close(reply)
+		delete(u.waiting, txID)
+	}
+	u.mu.Unlock()
+
+	if pc, ok := u.conn.(net.PacketConn); ok {
+		return pc.Close()
+	}
+	return nil
+}
+
+func (u *UDPTrackers) exchange(addr *net.UDPAddr) udpExchange {
+	return func(req []byte, timeout time.Duration) ([]byte, error) {
+		txID := binary.BigEndian.Uint32(req[12:16])
+		reply := make(chan []byte, 1)
+
+		u.mu.Lock()
+		if u.closed {
+			u.mu.Unlock()
+			return nil, ErrUDPTrackersClosed
+		}
+		u.waiting[txID] = reply
+		u.mu.Unlock()
+		defer func() {
+			u.mu.Lock()
+			if u.waiting[txID] == reply {
+				delete(u.waiting, txID)
+			}
+			u.mu.Unlock()
+		}()
+
+		if _, err := u.conn.WriteTo(req, addr); err != nil {
+			return nil, err
+		}
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case resp, ok := <-reply:
+			if !ok {
+				return nil, ErrUDPTrackersClosed
+			}
+			return resp, nil
+		case <-timer.C:
+			return nil, os.ErrDeadlineExceeded
+		}
+	}
+}
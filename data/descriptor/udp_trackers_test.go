@@ -0,0 +1,68 @@
+package descriptor
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUDPTracker answers connects and announces with the announcing
+// infohash's first byte as the peer's last address byte.
+func fakeUDPTracker(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := buf[:n]
+			action := binary.BigEndian.Uint32(req[8:12])
+			resp := make([]byte, 16, 26)
+			copy(resp[4:8], req[12:16])
+			if action == udpActionAnnounce {
+				binary.BigEndian.PutUint32(resp[0:4], udpActionAnnounce)
+				resp = append(resp[:20], 10, 0, 0, req[16], 0x1a, 0xe1)
+			} else {
+				binary.BigEndian.PutUint64(resp[8:16], 42)
+			}
+			pc.WriteTo(resp, from)
+		}
+	}()
+	return pc
+}
+
+func TestUDPTrackersShared(t *testing.T) {
+	tracker := fakeUDPTracker(t)
+	defer tracker.Close()
+
+	trackers, err := ListenUDPTrackers("127.0.0.1:0")
+	require.NoError(t, err)
+	defer trackers.Close()
+
+	var wg sync.WaitGroup
+	for i := byte(1); i <= 8; i++ {
+		wg.Add(1)
+		go func(i byte) {
+			defer wg.Done()
+			tf := TorrentFile{Announce: "udp://" + tracker.LocalAddr().String() + "/announce", InfoHash: [20]byte{i}}
+			peers, err := tf.announceUDP([20]byte{}, 6881, trackers)
+			require.NoError(t, err)
+			require.Len(t, peers, 1)
+			assert.Equal(t, net.IP{10, 0, 0, i}, peers[0].Addr)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.False(t, trackers.Handle([]byte{0, 0, 0, 1, 0, 0, 0, 9}, tracker.LocalAddr()))
+	trackers.Close()
+	tf := TorrentFile{Announce: "udp://" + tracker.LocalAddr().String()}
+	_, err = tf.announceUDP([20]byte{}, 6881, trackers)
+	assert.ErrorIs(t, err, ErrUDPTrackersClosed)
+}
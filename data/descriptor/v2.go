@@ -0,0 +1,308 @@
+package descriptor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sabir222/torrent-at-home/data/merkle"
+	"github.com/Sabir222/torrent-at-home/protocol/frames"
+	"github.com/jackpal/bencode-go"
+)
+
+var (
+	ErrBadPieceLayer  = errors.New("piece layer does not match pieces root")
+	ErrHybridMismatch = errors.New("hybrid torrent v1 and v2 file lists differ")
+	errNoHashes       = errors.New("hashes not available")
+)
+
+type v2File struct {
+	path    []string
+	length  int
+	root    [32]byte
+	attr    string
+	symlink []string
+}
+
+// parseV2 reads the BEP 52 parts of a torrent: the file tree, the piece
+// layers and the SHA-256 infohash. For a v2 only torrent the file list is
+// laid out with each file starting on a piece boundary; a hybrid torrent
+// keeps its v1 layout, which must already be aligned with padding files.
+func (t *TorrentFile) parseV2(data, rawInfo []byte) error {
+	t.InfoHashV2 = sha256.Sum256(rawInfo)
+	pl := t.PieceLength
+	if pl < merkle.BlockSize || pl&(pl-1) != 0 {
+		return fmt.Errorf("invalid v2 piece length %d", pl)
+	}
+
+	rawTree, err := rawDictValue(rawInfo, "file tree")
+	if err != nil {
+		return err
+	}
+	tree, err := bencode.Decode(bytes.NewReader(rawTree))
+	if err != nil {
+		return err
+	}
+	var files []v2File
+	if err := walkFileTree(tree, nil, &files); err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("empty file tree")
+	}
+
+	layers, err := pieceLayers(data)
+	if err != nil {
+		return err
+	}
+	t.PieceLayers = make(map[[32]byte][][32]byte)
+	height := merkle.Log2(pl / merkle.BlockSize)
+	for _, f := range files {
+		if f.length <= pl {
+			continue
+		}
+		layer, ok := layers[f.root]
+		if !ok || len(layer) != (f.length+pl-1)/pl {
+			return fmt.Errorf("missing piece layer for %s", strings.Join(f.path, "/"))
+		}
+		if merkle.Root(layer, 0, height) != f.root {
+			return ErrBadPieceLayer
+		}
+		t.PieceLayers[f.root] = layer
+	}
+
+	if len(t.PieceHashes) > 0 {
+		return t.attachV2(files)
+	}
+	return t.layoutV2(files)
+}
+
+// walkFileTree collects the files of a file tree in key order. A file is a
+// dictionary with an empty key holding its length and pieces root.
+func walkFileTree(node interface{}, path []string, out *[]v2File) error {
+	dir, ok := node.(map[string]interface{})
+	if !ok {
+		return ErrMalformedBencode
+	}
+	if leaf, ok := dir[""]; ok {
+		props, ok := leaf.(map[string]interface{})
+		if !ok || len(path) == 0 {
+			return ErrMalformedBencode
+		}
+		length, ok := props["length"].(int64)
+		if !ok || length < 0 {
+			return fmt.Errorf("invalid length for %s", strings.Join(path, "/"))
+		}
+		f := v2File{path: path, length: int(length)}
+		f.attr, _ = props["attr"].(string)
+		if links, ok := props["symlink path"].([]interface{}); ok {
+			for _, l := range links {
+				part, ok := l.(string)
+				if !ok {
+					return ErrMalformedBencode
+				}
+				f.symlink = append(f.symlink, part)
+			}
+		}
+		if length > 0 && !strings.Contains(f.attr, "p") {
+			root, ok := props["pieces root"].(string)
+			if !ok || len(root) != 32 {
+				return fmt.Errorf("invalid pieces root for %s", strings.Join(path, "/"))
+			}
+			copy(f.root[:], root)
+		}
+		*out = append(*out, f)
+		return nil
+	}
+
+	keys := make([]string, 0, len(dir))
+	for k := range dir {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "." || k == ".." || strings.ContainsAny(k, "/\\") {
+			return fmt.Errorf("unsafe path component %q", k)
+		}
+		sub := append(append([]string(nil), path...), k)
+		if err := walkFileTree(dir[k], sub, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pieceLayers(data []byte) (map[[32]byte][][32]byte, error) {
+	raw, err := rawDictValue(data, "piece layers")
+	if errors.Is(err, errMissingKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := bencode.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, ErrMalformedBencode
+	}
+
+	layers := make(map[[32]byte][][32]byte, len(dict))
+	for k, v := range dict {
+		hashes, ok := v.(string)
+		if len(k) != 32 || !ok || len(hashes)%32 != 0 {
+			return nil, ErrMalformedBencode
+		}
+		var root [32]byte
+		copy(root[:], k)
+		layer := make([][32]byte, len(hashes)/32)
+		for i := range layer {
+			copy(layer[i][:], hashes[i*32:])
+		}
+		layers[root] = layer
+	}
+	return layers, nil
+}
+
+// attachV2 matches a hybrid torrent's v2 files to its v1 list. Any v1
+// file missing from the file tree is padding.
+func (t *TorrentFile) attachV2(files []v2File) error {
+	byPath := make(map[string]v2File, len(files))
+	for _, f := range files {
+		byPath[strings.Join(f.path, "/")] = f
+	}
+	matched := 0
+	for i := range t.Files {
+		f := &t.Files[i]
+		v2, ok := byPath[f.DisplayPath()]
+		if !ok {
+			f.Padding = true
+			continue
+		}
+		if v2.length != f.Length || (f.Length > 0 && f.Offset%t.PieceLength != 0) {
+			return ErrHybridMismatch
+		}
+		f.PiecesRoot = v2.root
+		matched++
+	}
+	if matched != len(files) {
+		return ErrHybridMismatch
+	}
+	return nil
+}
+
+// layoutV2 builds the file list of a v2 only torrent, filling the gap after
+// each file up to the next piece boundary with a padding entry.
+func (t *TorrentFile) layoutV2(files []v2File) error {
+	pl := t.PieceLength
+	t.Files = nil
+	offset := 0
+	for i, f := range files {
+		file := File{Path: f.path, Length: f.length, Offset: offset, PiecesRoot: f.root}
+		if err := file.setAttr(f.attr, f.symlink); err != nil {
+			return fmt.Errorf("%s: %w", strings.Join(f.path, "/"), err)
+		}
+		t.Files = append(t.Files, file)
+		offset += f.length
+		if gap := (pl - offset%pl) % pl; gap > 0 && i < len(files)-1 {
+			t.Files = append(t.Files, File{
+				Path:    []string{".pad", strconv.Itoa(gap)},
+				Length:  gap,
+				Offset:  offset,
+				Padding: true,
+			})
+			offset += gap
+		}
+	}
+	t.Length = offset
+	t.PieceHashes = make([][20]byte, (offset+pl-1)/pl)
+	t.MultiFile = len(files) > 1 || len(files[0].path) > 1 || files[0].path[0] != t.Name
+	copy(t.InfoHash[:], t.InfoHashV2[:20])
+	return nil
+}
+
+// IsV2 reports whether the torrent has v2 metadata, alone or as a hybrid.
+func (t *TorrentFile) IsV2() bool {
+	return t.MetaVersion == 2
+}
+
+// IsHybrid reports whether the torrent joins both v1 and v2 swarms.
+func (t *TorrentFile) IsHybrid() bool {
+	return t.IsV2() && t.InfoHash != truncate(t.InfoHashV2)
+}
+
+func truncate(h [32]byte) [20]byte {
+	var out [20]byte
+	copy(out[:], h[:20])
+	return out
+}
+
+type v2Piece struct {
+	expect [32]byte
+	length int
+	leaves int
+}
+
+// v2Verifier checks pieces against the merkle trees of their files and
+// answers hash requests from the piece layers.
+type v2Verifier struct {
+	pieces []v2Piece
+	layers map[[32]byte][][32]byte
+	height int
+}
+
+func (t *TorrentFile) verifier() *v2Verifier {
+	pl := t.PieceLength
+	v := &v2Verifier{
+		pieces: make([]v2Piece, len(t.PieceHashes)),
+		layers: t.PieceLayers,
+		height: merkle.Log2(pl / merkle.BlockSize),
+	}
+	for _, f := range t.Files {
+		if f.Padding || f.Length == 0 {
+			continue
+		}
+		first := f.Offset / pl
+		if f.Length <= pl {
+			blocks := (f.Length + merkle.BlockSize - 1) / merkle.BlockSize
+			v.pieces[first] = v2Piece{expect: f.PiecesRoot, length: f.Length, leaves: merkle.PowerOfTwo(blocks)}
+			continue
+		}
+		for k, h := range t.PieceLayers[f.PiecesRoot] {
+			v.pieces[first+k] = v2Piece{expect: h, length: min(pl, f.Length-k*pl), leaves: pl / merkle.BlockSize}
+		}
+	}
+	return v
+}
+
+func (v *v2Verifier) VerifyPiece(index int, data []byte) error {
+	if index < 0 || index >= len(v.pieces) {
+		return fmt.Errorf("piece %d out of range", index)
+	}
+	p := v.pieces[index]
+	if p.leaves == 0 {
+		return nil
+	}
+	if len(data) < p.length {
+		return fmt.Errorf("piece %d is short", index)
+	}
+	if merkle.Root(merkle.Leaves(data[:p.length]), p.leaves, 0) != p.expect {
+		return fmt.Errorf("piece %d failed merkle validation", index)
+	}
+	return nil
+}
+
+// Hashes serves requests for a range of a piece layer.
+func (v *v2Verifier) Hashes(req frames.HashRequest) ([][32]byte, error) {
+	layer, ok := v.layers[req.Root]
+	if !ok || req.BaseLayer != v.height {
+		return nil, errNoHashes
+	}
+	return merkle.Proof(layer, v.height, req.Index, req.Length, req.ProofLayers)
+}
@@ -0,0 +1,137 @@
+package descriptor
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/Sabir222/torrent-at-home/data/merkle"
+	"github.com/Sabir222/torrent-at-home/protocol/frames"
+	"github.com/jackpal/bencode-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const v2PieceLength = 2 * merkle.BlockSize
+
+type v2Fixture struct {
+	a, b   []byte
+	rootA  [32]byte
+	rootB  [32]byte
+	layerA [][32]byte
+	// content is the v2 piece space: a, padding to a piece boundary, b.
+	content []byte
+}
+
+func newV2Fixture() *v2Fixture {
+	f := &v2Fixture{a: bytes.Repeat([]byte("a"), 40000), b: []byte("hello")}
+	for i := 0; i < len(f.a); i += v2PieceLength {
+		piece := f.a[i:min(i+v2PieceLength, len(f.a))]
+		f.layerA = append(f.layerA, merkle.Root(merkle.Leaves(piece), 2, 0))
+	}
+	f.rootA = merkle.Root(f.layerA, 0, 1)
+	f.rootB = merkle.Root(merkle.Leaves(f.b), 1, 0)
+	f.content = append(append(append([]byte{}, f.a...), make([]byte, 2*v2PieceLength-len(f.a))...), f.b...)
+	return f
+}
+
+func (f *v2Fixture) info(hybrid bool) map[string]interface{} {
+	info := map[string]interface{}{
+		"file tree": map[string]interface{}{
+			"a": map[string]interface{}{"": map[string]interface{}{"length": len(f.a), "pieces root": string(f.rootA[:])}},
+			"b": map[string]interface{}{"": map[string]interface{}{"length": len(f.b), "pieces root": string(f.rootB[:])}},
+		},
+		"meta version": 2,
+		"name":         "root",
+		"piece length": v2PieceLength,
+	}
+	if hybrid {
+		var pieces []byte
+		for i := 0; i < len(f.content); i += v2PieceLength {
+			h := sha1.Sum(f.content[i:min(i+v2PieceLength, len(f.content))])
+			pieces = append(pieces, h[:]...)
+		}
+		info["pieces"] = string(pieces)
+		info["files"] = []interface{}{
+			map[string]interface{}{"length": len(f.a), "path": []interface{}{"a"}},
+			map[string]interface{}{"length": 2*v2PieceLength - len(f.a), "path": []interface{}{".pad", "25536"}, "attr": "p"},
+			map[string]interface{}{"length": len(f.b), "path": []interface{}{"b"}},
+		}
+	}
+	return info
+}
+
+func (f *v2Fixture) torrent(t *testing.T, info map[string]interface{}, layer [][32]byte) ([]byte, []byte) {
+	var rawInfo, rawLayers bytes.Buffer
+	require.NoError(t, bencode.Marshal(&rawInfo, info))
+	var hashes []byte
+	for _, h := range layer {
+		hashes = append(hashes, h[:]...)
+	}
+	require.NoError(t, bencode.Marshal(&rawLayers, map[string]interface{}{string(f.rootA[:]): string(hashes)}))
+	data := "d4:info" + rawInfo.String() + "12:piece layers" + rawLayers.String() + "e"
+	return []byte(data), rawInfo.Bytes()
+}
+
+func TestParseV2(t *testing.T) {
+	f := newV2Fixture()
+	data, rawInfo := f.torrent(t, f.info(false), f.layerA)
+
+	tf, err := Parse(data)
+	require.NoError(t, err)
+
+	v2 := sha256.Sum256(rawInfo)
+	assert.True(t, tf.IsV2())
+	assert.False(t, tf.IsHybrid())
+	assert.Equal(t, v2, tf.InfoHashV2)
+	assert.Equal(t, v2[:20], tf.InfoHash[:])
+	assert.True(t, tf.MultiFile)
+	assert.Equal(t, len(f.content), tf.Length)
+	assert.Len(t, tf.PieceHashes, 3)
+	require.Len(t, tf.Files, 3)
+	assert.Equal(t, File{Path: []string{".pad", "25536"}, Length: 25536, Offset: 40000, Padding: true}, tf.Files[1])
+	assert.Equal(t, f.rootB, tf.Files[2].PiecesRoot)
+
+	v := tf.verifier()
+	for i := 0; i < 3; i++ {
+		piece := f.content[i*v2PieceLength : min((i+1)*v2PieceLength, len(f.content))]
+		assert.NoError(t, v.VerifyPiece(i, piece), "piece %d", i)
+	}
+	bad := append([]byte{}, f.content[:v2PieceLength]...)
+	bad[5] = 'x'
+	assert.Error(t, v.VerifyPiece(0, bad))
+
+	hashes, err := v.Hashes(frames.HashRequest{Root: f.rootA, BaseLayer: 1, Index: 0, Length: 2})
+	require.NoError(t, err)
+	assert.Equal(t, f.layerA, hashes)
+	_, err = v.Hashes(frames.HashRequest{Root: f.rootB, BaseLayer: 1, Length: 1})
+	assert.Error(t, err)
+}
+
+func TestParseV2BadLayer(t *testing.T) {
+	f := newV2Fixture()
+	layer := [][32]byte{f.layerA[1], f.layerA[0]}
+	data, _ := f.torrent(t, f.info(false), layer)
+	_, err := Parse(data)
+	assert.ErrorIs(t, err, ErrBadPieceLayer)
+}
+
+func TestParseHybrid(t *testing.T) {
+	f := newV2Fixture()
+	data, rawInfo := f.torrent(t, f.info(true), f.layerA)
+
+	tf, err := Parse(data)
+	require.NoError(t, err)
+
+	assert.True(t, tf.IsHybrid())
+	assert.Equal(t, sha1.Sum(rawInfo), tf.InfoHash)
+	assert.Equal(t, sha256.Sum256(rawInfo), tf.InfoHashV2)
+	require.Len(t, tf.Files, 3)
+	assert.False(t, tf.Files[0].Padding)
+	assert.True(t, tf.Files[1].Padding)
+	assert.Equal(t, f.rootA, tf.Files[0].PiecesRoot)
+
+	v := tf.verifier()
+	assert.NoError(t, v.VerifyPiece(2, f.content[2*v2PieceLength:]))
+}
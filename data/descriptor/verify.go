@@ -0,0 +1,81 @@
+package descriptor
+
+import (
+	"context"
+
+	"github.com/Sabir222/torrent-at-home/data/hasher"
+)
+
+// VerifyReport is what Verify found in the data under a path. A piece
+// that fails is Missing when it overlaps a file that is absent or short,
+// Damaged otherwise.
+type VerifyReport struct {
+	Pieces  int
+	Damaged []int
+	Missing []int
+	Files   []FileReport
+}
+
+// FileReport is how one file fared: Bad of the Pieces it overlaps did
+// not match.
+type FileReport struct {
+	Path    string
+	Length  int
+	Missing bool
+	Pieces  int
+	Bad     int
+}
+
+// OK tells whether every piece matched.
+func (r VerifyReport) OK() bool {
+	return len(r.Damaged) == 0 && len(r.Missing) == 0
+}
+
+// Verify hashes the data laid out under path, as by OpenStorage, on
+// pool, hasher.Default when nil.
+func (t *TorrentFile) Verify(ctx context.Context, path string, pool *hasher.Pool) (VerifyReport, error) {
+	store, err := t.OpenExisting(path)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	defer store.Close()
+
+	session := t.baseSession()
+	session.Storage = store
+	session.Hasher = pool
+	have, err := session.Check(ctx, nil)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+
+	missingFile := make(map[int]bool)
+	for _, i := range store.Missing() {
+		missingFile[i] = true
+	}
+	missingPiece := make(map[int]bool)
+	report := VerifyReport{Pieces: len(t.PieceHashes)}
+	for _, c := range session.CheckFiles(have) {
+		report.Files = append(report.Files, FileReport{
+			Path:    c.Path,
+			Length:  c.Length,
+			Missing: missingFile[c.Index],
+			Pieces:  c.Last - c.First + 1,
+			Bad:     c.Bad,
+		})
+		if missingFile[c.Index] {
+			for i := c.First; i <= c.Last; i++ {
+				missingPiece[i] = true
+			}
+		}
+	}
+	for i := range t.PieceHashes {
+		switch {
+		case have.Check(i):
+		case missingPiece[i]:
+			report.Missing = append(report.Missing, i)
+		default:
+			report.Damaged = append(report.Damaged, i)
+		}
+	}
+	return report, nil
+}
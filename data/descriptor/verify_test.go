@@ -0,0 +1,69 @@
+package descriptor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Sabir222/torrent-at-home/data/hasher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "album")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "cd1"), 0o755))
+	flac := filepath.Join(dir, "cd1", "01.flac")
+	require.NoError(t, os.WriteFile(flac, []byte("abcdefghij"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cover.jpg"), []byte("klmnop"), 0o644))
+	data, err := Create(dir, CreateOptions{PieceLength: 4})
+	require.NoError(t, err)
+	tf, err := Parse(data)
+	require.NoError(t, err)
+
+	pool := hasher.New(2, 0)
+	defer pool.Close()
+	report, err := tf.Verify(context.Background(), dir, pool)
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 4, report.Pieces)
+	assert.Equal(t, []FileReport{
+		{Path: "cd1/01.flac", Length: 10, Pieces: 3},
+		{Path: "cover.jpg", Length: 6, Pieces: 2},
+	}, report.Files)
+
+	require.NoError(t, os.WriteFile(flac, []byte("Abcdefghij"), 0o644))
+	require.NoError(t, os.Remove(filepath.Join(dir, "cover.jpg")))
+	report, err = tf.Verify(context.Background(), dir, nil)
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, []int{0}, report.Damaged)
+	assert.Equal(t, []int{2, 3}, report.Missing)
+	assert.Equal(t, []FileReport{
+		{Path: "cd1/01.flac", Length: 10, Pieces: 3, Bad: 2},
+		{Path: "cover.jpg", Length: 6, Missing: true, Pieces: 2, Bad: 2},
+	}, report.Files)
+}
+
+func TestVerifySingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0o644))
+	data, err := Create(path, CreateOptions{})
+	require.NoError(t, err)
+	tf, err := Parse(data)
+	require.NoError(t, err)
+
+	// The data may be checked under another name.
+	moved := filepath.Join(t.TempDir(), "renamed.txt")
+	require.NoError(t, os.Rename(path, moved))
+	report, err := tf.Verify(context.Background(), moved, nil)
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+
+	require.NoError(t, os.Truncate(moved, 3))
+	report, err = tf.Verify(context.Background(), moved, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0}, report.Missing)
+	assert.True(t, report.Files[0].Missing)
+}
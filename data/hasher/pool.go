@@ -0,0 +1,147 @@
+// Package hasher runs piece hashing on a bounded set of goroutines, apart
+// from the network and disk workers that produce the pieces.
+package hasher
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Pool hashes on a fixed number of workers. Jobs past the ones running
+// wait in a queue of bounded length; once it is full, Submit blocks, so
+// producers slow down to the speed of hashing instead of piling pieces up
+// in memory.
+type Pool struct {
+	jobs    chan func()
+	workers int
+	closed  chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// New starts workers goroutines, one per CPU when workers is zero or
+// less, with room for queue jobs waiting, workers when queue is zero or
+// less.
+func New(workers, queue int) *Pool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if queue <= 0 {
+		queue = workers
+	}
+	p := &Pool{jobs: make(chan func(), queue), workers: workers, closed: make(chan struct{})}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+var (
+	defaultOnce sync.Once
+	defaultPool *Pool
+)
+
+// Default is the pool shared by everything in the process that has not
+// been given one, one worker per CPU.
+func Default() *Pool {
+	defaultOnce.Do(func() { defaultPool = New(0, 0) })
+	return defaultPool
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.closed:
+			return
+		default:
+		}
+		select {
+		case fn := <-p.jobs:
+			fn()
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+// Workers is the number of jobs run at once.
+func (p *Pool) Workers() int {
+	return p.workers
+}
+
+// Submit queues fn, waiting while the queue is full, and reports false
+// if done or the pool closed first. fn runs on a pool worker.
+func (p *Pool) Submit(fn func(), done <-chan struct{}) bool {
+	select {
+	case <-p.closed:
+		return false
+	default:
+	}
+	select {
+	case p.jobs <- fn:
+		return true
+	case <-done:
+		return false
+	case <-p.closed:
+		return false
+	}
+}
+
+// Do runs fn on a pool worker and waits for it to return, reporting
+// false if done closed before fn was queued or the pool closed before it
+// ran.
+func (p *Pool) Do(fn func(), done <-chan struct{}) bool {
+	finished := make(chan struct{})
+	if !p.Submit(func() {
+		defer close(finished)
+		fn()
+	}, done) {
+		return false
+	}
+	select {
+	case <-finished:
+		return true
+	case <-p.closed:
+		return false
+	}
+}
+
+// Close stops the workers once the jobs running have returned. Jobs
+// still queued are dropped, and later ones refused, so a Batch must not
+// be waited on across Close.
+func (p *Pool) Close() {
+	p.once.Do(func() { close(p.closed) })
+	p.wg.Wait()
+}
+
+// Batch is a group of jobs on a pool that are waited for together.
+type Batch struct {
+	pool *Pool
+	done <-chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Batch starts a group of jobs that stop being queued once done closes.
+func (p *Pool) Batch(done <-chan struct{}) *Batch {
+	return &Batch{pool: p, done: done}
+}
+
+// Go queues fn as Submit does.
+func (b *Batch) Go(fn func()) bool {
+	b.wg.Add(1)
+	ok := b.pool.Submit(func() {
+		defer b.wg.Done()
+		fn()
+	}, b.done)
+	if !ok {
+		b.wg.Done()
+	}
+	return ok
+}
+
+// Wait returns once every job queued by Go has run.
+func (b *Batch) Wait() {
+	b.wg.Wait()
+}
@@ -0,0 +1,74 @@
+package hasher
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolBounded(t *testing.T) {
+	p := New(2, 1)
+	defer p.Close()
+	assert.Equal(t, 2, p.Workers())
+
+	var running, peak atomic.Int32
+	release := make(chan struct{})
+	job := func() {
+		n := running.Add(1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		<-release
+		running.Add(-1)
+	}
+	// Two jobs run and one waits; the fourth has no room.
+	for i := 0; i < 3; i++ {
+		assert.True(t, p.Submit(job, nil))
+	}
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(done)
+	}()
+	assert.False(t, p.Submit(job, done))
+
+	close(release)
+	assert.True(t, p.Do(func() {}, nil))
+	assert.Equal(t, int32(2), peak.Load())
+}
+
+func TestPoolDo(t *testing.T) {
+	p := New(0, 0)
+	var sum [20]byte
+	assert.True(t, p.Do(func() { sum = SHA1([]byte("abc")) }, nil))
+	assert.Equal(t, SHA1([]byte("abc")), sum)
+	p.Close()
+	assert.Same(t, Default(), Default())
+}
+
+func TestPoolClose(t *testing.T) {
+	p := New(1, 1)
+	release := make(chan struct{})
+	assert.True(t, p.Submit(func() { <-release }, nil))
+	ran := false
+	assert.True(t, p.Submit(func() { ran = true }, nil))
+
+	closed := make(chan struct{})
+	go func() {
+		p.Close()
+		close(closed)
+	}()
+	// Close waits for the running job, then drops the queued one.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	<-closed
+	assert.False(t, ran)
+	assert.False(t, p.Submit(func() {}, nil))
+	assert.False(t, p.Do(func() {}, nil))
+	p.Close()
+}
@@ -0,0 +1,11 @@
+package hasher
+
+import "testing"
+
+func BenchmarkSHA1(b *testing.B) {
+	data := make([]byte, 1<<20)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		SHA1(data)
+	}
+}
@@ -25,3 +25,39 @@ func (m Mask) Mark(idx int) {
 	bitPos := 7 - (idx & 7)
 	m[byteIdx] |= 1 << bitPos
 }
+
+// New returns an empty mask large enough for n pieces.
+func New(n int) Mask {
+	return make(Mask, (n+7)/8)
+}
+
+// Full returns a mask with the first n bits set.
+func Full(n int) Mask {
+	m := New(n)
+	for i := 0; i < n; i++ {
+		m.Mark(i)
+	}
+	return m
+}
+
+func (m Mask) Count() int {
+	count := 0
+	for _, b := range m {
+		for ; b != 0; b &= b - 1 {
+			count++
+		}
+	}
+	return count
+}
+
+// Valid reports whether m is a well formed bitfield for n pieces: exactly
+// the right number of bytes and no spare bits set in the last one.
+func (m Mask) Valid(n int) bool {
+	if len(m) != (n+7)/8 {
+		return false
+	}
+	if spare := n & 7; spare != 0 {
+		return m[len(m)-1]&(0xff>>spare) == 0
+	}
+	return true
+}
@@ -47,3 +47,28 @@ func TestMark(t *testing.T) {
 		assert.Equal(t, c.want, m)
 	}
 }
+
+func TestFullAndCount(t *testing.T) {
+	m := Full(10)
+	assert.Equal(t, Mask{0xff, 0b11000000}, m)
+	assert.Equal(t, 10, m.Count())
+	assert.Equal(t, 0, New(10).Count())
+	assert.Len(t, New(17), 3)
+}
+
+func TestValid(t *testing.T) {
+	cases := []struct {
+		data Mask
+		n    int
+		want bool
+	}{
+		{data: Mask{0xff, 0b11000000}, n: 10, want: true},
+		{data: Mask{0xff, 0b11100000}, n: 10, want: false},
+		{data: Mask{0xff}, n: 10, want: false},
+		{data: Mask{0xff, 0xff}, n: 16, want: true},
+		{data: Mask{}, n: 0, want: true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, c.data.Valid(c.n))
+	}
+}
@@ -0,0 +1,114 @@
+package merkle
+
+import (
+	"errors"
+
+	"github.com/Sabir222/torrent-at-home/data/hasher"
+)
+
+// BlockSize is the size of the data hashed into each leaf (BEP 52).
+const BlockSize = 16384
+
+var ErrBadRange = errors.New("merkle: hash range out of bounds")
+
+// Hash is a SHA-256 node of the tree.
+type Hash = [32]byte
+
+func pair(a, b Hash) Hash {
+	var buf [64]byte
+	copy(buf[:32], a[:])
+	copy(buf[32:], b[:])
+	return hasher.SHA256(buf[:])
+}
+
+// Pad is the root of a subtree of 2^height zero leaves, used to fill a
+// layer up to a power of two.
+func Pad(height int) Hash {
+	var h Hash
+	for i := 0; i < height; i++ {
+		h = pair(h, h)
+	}
+	return h
+}
+
+// PowerOfTwo returns the smallest power of two that is at least n.
+func PowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// Log2 returns the exponent of a power of two.
+func Log2(n int) int {
+	h := 0
+	for n > 1 {
+		n >>= 1
+		h++
+	}
+	return h
+}
+
+// Leaves hashes data in BlockSize blocks; the last block may be short.
+func Leaves(data []byte) []Hash {
+	leaves := make([]Hash, 0, (len(data)+BlockSize-1)/BlockSize)
+	for off := 0; off < len(data); off += BlockSize {
+		end := min(off+BlockSize, len(data))
+		leaves = append(leaves, hasher.SHA256(data[off:end]))
+	}
+	return leaves
+}
+
+// Layers builds the tree over layer, whose nodes sit height levels above
+// the leaves, padded to width nodes. The result runs from layer up to the
+// root.
+func Layers(layer []Hash, width, height int) [][]Hash {
+	cur := make([]Hash, PowerOfTwo(max(width, len(layer))))
+	copy(cur, layer)
+	pad := Pad(height)
+	for i := len(layer); i < len(cur); i++ {
+		cur[i] = pad
+	}
+
+	out := [][]Hash{cur}
+	for len(cur) > 1 {
+		next := make([]Hash, len(cur)/2)
+		for i := range next {
+			next[i] = pair(cur[2*i], cur[2*i+1])
+		}
+		out = append(out, next)
+		cur = next
+	}
+	return out
+}
+
+// Root is the top of Layers.
+func Root(layer []Hash, width, height int) Hash {
+	layers := Layers(layer, width, height)
+	return layers[len(layers)-1][0]
+}
+
+// Proof returns the hashes of layer[index:index+length] followed by the
+// uncle hashes needed to verify them up to proofLayers levels above the
+// subtree they span, as sent in a BEP 52 hashes message.
+func Proof(layer []Hash, height, index, length, proofLayers int) ([]Hash, error) {
+	if length <= 0 || length&(length-1) != 0 || index%length != 0 || index < 0 {
+		return nil, ErrBadRange
+	}
+	layers := Layers(layer, 0, height)
+	base := layers[0]
+	if index+length > len(base) {
+		return nil, ErrBadRange
+	}
+
+	out := append([]Hash(nil), base[index:index+length]...)
+	level := Log2(length)
+	pos := index / length
+	for i := 0; i < proofLayers && level < len(layers)-1; i++ {
+		out = append(out, layers[level][pos^1])
+		level++
+		pos /= 2
+	}
+	return out, nil
+}
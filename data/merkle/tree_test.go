@@ -0,0 +1,55 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoot(t *testing.T) {
+	a := sha256.Sum256([]byte("a"))
+	b := sha256.Sum256([]byte("b"))
+	c := sha256.Sum256([]byte("c"))
+
+	assert.Equal(t, a, Root([]Hash{a}, 1, 0))
+	assert.Equal(t, pair(a, b), Root([]Hash{a, b}, 0, 0))
+	assert.Equal(t, pair(pair(a, b), pair(c, Hash{})), Root([]Hash{a, b, c}, 0, 0))
+	// Padding to a wider tree adds zero subtrees.
+	assert.Equal(t, pair(pair(a, Hash{}), Pad(1)), Root([]Hash{a}, 4, 0))
+	assert.Equal(t, pair(a, Pad(2)), Root([]Hash{a}, 2, 2))
+}
+
+func TestLeaves(t *testing.T) {
+	data := make([]byte, BlockSize+10)
+	leaves := Leaves(data)
+	require.Len(t, leaves, 2)
+	assert.Equal(t, sha256.Sum256(data[:BlockSize]), leaves[0])
+	assert.Equal(t, sha256.Sum256(data[BlockSize:]), leaves[1])
+}
+
+func TestProof(t *testing.T) {
+	var layer []Hash
+	for i := 0; i < 8; i++ {
+		layer = append(layer, sha256.Sum256([]byte{byte(i)}))
+	}
+	layers := Layers(layer, 0, 0)
+
+	got, err := Proof(layer, 0, 2, 2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []Hash{layer[2], layer[3], layers[1][0], layers[2][1]}, got)
+
+	_, err = Proof(layer, 0, 1, 2, 0)
+	assert.ErrorIs(t, err, ErrBadRange)
+	_, err = Proof(layer, 0, 8, 2, 0)
+	assert.ErrorIs(t, err, ErrBadRange)
+}
+
+func TestPowerOfTwo(t *testing.T) {
+	assert.Equal(t, 1, PowerOfTwo(0))
+	assert.Equal(t, 1, PowerOfTwo(1))
+	assert.Equal(t, 4, PowerOfTwo(3))
+	assert.Equal(t, 8, PowerOfTwo(8))
+	assert.Equal(t, 3, Log2(8))
+}
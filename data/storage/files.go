@@ -0,0 +1,340 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	ErrOutOfRange = errors.New("offset outside of torrent")
+	ErrReadOnly   = errors.New("storage is read only")
+)
+
+// File is one file of the torrent as laid out on disk.
+type File struct {
+	// Path is relative to the storage root and uses the OS separator.
+	Path   string
+	Length int64
+	// Skip keeps the file off disk. Bytes of shared boundary pieces that
+	// fall inside it go to the parts file instead.
+	Skip bool
+	// Padding is alignment filler: never written, read back as zeros.
+	Padding bool
+	// Executable and Hidden are applied when the file is created.
+	Executable bool
+	Hidden     bool
+	// Symlink, when set, makes the file a link to this path relative to
+	// the storage root instead of a regular file.
+	Symlink string
+}
+
+type entry struct {
+	File
+	offset int64
+	handle *os.File
+}
+
+// Files maps the torrent's contiguous byte space onto its files.
+type Files struct {
+	mu        sync.Mutex
+	root      string
+	partsPath string
+	parts     *os.File
+	entries   []*entry
+	length    int64
+	readOnly  bool
+	missing   []int
+}
+
+// Open creates every file that is not skipped under root. The parts file is
+// only created once a skipped region is written.
+func Open(root string, files []File, partsPath string) (*Files, error) {
+	f := &Files{root: root, partsPath: partsPath}
+	for _, file := range files {
+		e := &entry{File: file, offset: f.length}
+		f.entries = append(f.entries, e)
+		f.length += file.Length
+		if file.Symlink != "" {
+			if err := f.link(e); err != nil {
+				f.Close()
+				return nil, err
+			}
+			continue
+		}
+		if !file.Skip && !file.Padding {
+			if err := f.create(e); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+// OpenExisting opens the files already under root for reading, creating
+// nothing. Files that are not there read as zeros; they and files shorter
+// than their length are listed by Missing. Writes fail with ErrReadOnly.
+func OpenExisting(root string, files []File) (*Files, error) {
+	f := &Files{root: root, readOnly: true}
+	for i, file := range files {
+		e := &entry{File: file, offset: f.length}
+		f.entries = append(f.entries, e)
+		f.length += file.Length
+		if file.Padding || file.Symlink != "" {
+			continue
+		}
+		handle, err := os.Open(filepath.Join(root, file.Path))
+		if errors.Is(err, fs.ErrNotExist) {
+			f.missing = append(f.missing, i)
+			continue
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		e.handle = handle
+		if stat, err := handle.Stat(); err != nil || stat.Size() < file.Length {
+			f.missing = append(f.missing, i)
+		}
+	}
+	return f, nil
+}
+
+// Missing lists the indexes of the files OpenExisting found absent or
+// short.
+func (f *Files) Missing() []int {
+	return f.missing
+}
+
+func (f *Files) create(e *entry) error {
+	path := filepath.Join(f.root, e.Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if e.Executable {
+		mode = 0755
+	}
+	handle, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, mode)
+	if err != nil {
+		return err
+	}
+	if err := handle.Truncate(e.Length); err != nil {
+		handle.Close()
+		return err
+	}
+	if e.Executable {
+		// The umask may have dropped the bits on creation.
+		handle.Chmod(mode)
+	}
+	if e.Hidden {
+		if err := setHidden(path); err != nil {
+			handle.Close()
+			return err
+		}
+	}
+	e.handle = handle
+	return nil
+}
+
+// link creates a symlink entry, pointing relative to its own directory so
+// the tree can be moved as a whole.
+func (f *Files) link(e *entry) error {
+	path := filepath.Join(f.root, e.Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	target, err := filepath.Rel(filepath.Dir(path), filepath.Join(f.root, e.Symlink))
+	if err != nil {
+		return err
+	}
+	if existing, err := os.Readlink(path); err == nil && existing == target {
+		return nil
+	}
+	return os.Symlink(target, path)
+}
+
+func (f *Files) openParts() (*os.File, error) {
+	if f.parts != nil {
+		return f.parts, nil
+	}
+	parts, err := os.OpenFile(f.partsPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.parts = parts
+	return parts, nil
+}
+
+// SetSkip changes whether a file is kept on disk. A file that becomes
+// wanted is created and seeded with whatever the parts file holds for it.
+func (f *Files) SetSkip(index int, skip bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	e := f.entries[index]
+	if e.Skip == skip || e.Padding || e.Symlink != "" {
+		return nil
+	}
+	e.Skip = skip
+	if skip || e.handle != nil {
+		return nil
+	}
+
+	if err := f.create(e); err != nil {
+		return err
+	}
+	if f.parts == nil {
+		if _, err := os.Stat(f.partsPath); err != nil {
+			return nil
+		}
+		if _, err := f.openParts(); err != nil {
+			return err
+		}
+	}
+	return copyNonZero(e.handle, f.parts, e.offset, e.Length)
+}
+
+// copyNonZero moves a file's region out of the sparse parts file, skipping
+// holes so unwritten ranges stay sparse in the destination too.
+func copyNonZero(dst, parts *os.File, offset, length int64) error {
+	buf := make([]byte, 64*1024)
+	zero := make([]byte, len(buf))
+	for pos := int64(0); pos < length; pos += int64(len(buf)) {
+		chunk := buf
+		if rest := length - pos; rest < int64(len(chunk)) {
+			chunk = chunk[:rest]
+		}
+		n, err := parts.ReadAt(chunk, offset+pos)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if bytes.Equal(chunk[:n], zero[:n]) {
+			continue
+		}
+		if _, err := dst.WriteAt(chunk[:n], pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// span calls fn for every file overlapping [off, off+n) with the slice
+// bounds of p that fall into it.
+func (f *Files) span(off int64, n int, fn func(e *entry, lo, hi int) error) error {
+	if off < 0 || off+int64(n) > f.length {
+		return ErrOutOfRange
+	}
+	end := off + int64(n)
+	for _, e := range f.entries {
+		eEnd := e.offset + e.Length
+		if eEnd <= off || e.offset >= end {
+			continue
+		}
+		lo := e.offset - off
+		if lo < 0 {
+			lo = 0
+		}
+		hi := eEnd - off
+		if hi > int64(n) {
+			hi = int64(n)
+		}
+		if err := fn(e, int(lo), int(hi)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Files) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	err := f.span(off, len(p), func(e *entry, lo, hi int) error {
+		if e.Padding || e.Symlink != "" {
+			return nil
+		}
+		if e.handle != nil {
+			_, err := e.handle.WriteAt(p[lo:hi], off+int64(lo)-e.offset)
+			return err
+		}
+		parts, err := f.openParts()
+		if err != nil {
+			return err
+		}
+		_, err = parts.WriteAt(p[lo:hi], off+int64(lo))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f *Files) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.span(off, len(p), func(e *entry, lo, hi int) error {
+		var (
+			src *os.File
+			at  int64
+		)
+		switch {
+		case e.Padding || e.Symlink != "":
+			clear(p[lo:hi])
+			return nil
+		case e.handle != nil:
+			src, at = e.handle, off+int64(lo)-e.offset
+		case f.parts != nil:
+			src, at = f.parts, off+int64(lo)
+		default:
+			clear(p[lo:hi])
+			return nil
+		}
+		n, err := src.ReadAt(p[lo:hi], at)
+		if err == io.EOF {
+			clear(p[lo+n : hi])
+			err = nil
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f *Files) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var first error
+	for _, e := range f.entries {
+		if e.handle == nil {
+			continue
+		}
+		if err := e.handle.Close(); err != nil && first == nil {
+			first = err
+		}
+		e.handle = nil
+	}
+	if f.parts != nil {
+		if err := f.parts.Close(); err != nil && first == nil {
+			first = err
+		}
+		f.parts = nil
+	}
+	return first
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilesSpanning(t *testing.T) {
+	root := t.TempDir()
+	parts := filepath.Join(root, ".parts")
+	files := []File{
+		{Path: "a.bin", Length: 3},
+		{Path: filepath.Join("sub", "b.bin"), Length: 4, Skip: true},
+		{Path: "c.bin", Length: 3},
+	}
+
+	f, err := Open(root, files, parts)
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = os.Stat(filepath.Join(root, "sub", "b.bin"))
+	assert.True(t, os.IsNotExist(err))
+
+	n, err := f.WriteAt([]byte("abcdefghij"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+
+	a, _ := os.ReadFile(filepath.Join(root, "a.bin"))
+	c, _ := os.ReadFile(filepath.Join(root, "c.bin"))
+	assert.Equal(t, []byte("abc"), a)
+	assert.Equal(t, []byte("hij"), c)
+
+	buf := make([]byte, 6)
+	_, err = f.ReadAt(buf, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("cdefgh"), buf)
+
+	_, err = f.WriteAt([]byte("x"), 10)
+	assert.ErrorIs(t, err, ErrOutOfRange)
+}
+
+func TestFilesUnskip(t *testing.T) {
+	root := t.TempDir()
+	parts := filepath.Join(root, ".parts")
+	files := []File{
+		{Path: "a.bin", Length: 2},
+		{Path: "b.bin", Length: 2, Skip: true},
+	}
+
+	f, err := Open(root, files, parts)
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.WriteAt([]byte("wxyz"), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.SetSkip(1, false))
+
+	b, err := os.ReadFile(filepath.Join(root, "b.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("yz"), b)
+}
+
+func TestFilesAttributes(t *testing.T) {
+	root := t.TempDir()
+	files := []File{
+		{Path: "run.sh", Length: 2, Executable: true},
+		{Path: filepath.Join(".pad", "2"), Length: 2, Padding: true},
+		{Path: "data.bin", Length: 2},
+		{Path: filepath.Join("sub", "latest"), Symlink: "data.bin"},
+	}
+
+	f, err := Open(root, files, filepath.Join(root, ".parts"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.WriteAt([]byte("ab\x00\x00cd"), 0)
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(root, ".pad"))
+	assert.True(t, os.IsNotExist(err), "padding must not reach the disk")
+	_, err = os.Stat(filepath.Join(root, ".parts"))
+	assert.True(t, os.IsNotExist(err))
+
+	buf := make([]byte, 6)
+	_, err = f.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ab\x00\x00cd"), buf)
+
+	info, err := os.Stat(filepath.Join(root, "run.sh"))
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&0100)
+
+	target, err := os.Readlink(filepath.Join(root, "sub", "latest"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "data.bin"), target)
+	linked, err := os.ReadFile(filepath.Join(root, "sub", "latest"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("cd"), linked)
+}
+
+func TestOpenExisting(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.bin"), []byte("abc"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "c.bin"), []byte("h"), 0644))
+	files := []File{
+		{Path: "a.bin", Length: 3},
+		{Path: "b.bin", Length: 4},
+		{Path: "pad", Length: 2, Padding: true},
+		{Path: "c.bin", Length: 3},
+	}
+
+	f, err := OpenExisting(root, files)
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Equal(t, []int{1, 3}, f.Missing())
+
+	buf := make([]byte, 12)
+	_, err = f.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc\x00\x00\x00\x00\x00\x00h\x00\x00"), buf)
+
+	_, err = f.WriteAt([]byte("x"), 0)
+	assert.ErrorIs(t, err, ErrReadOnly)
+	_, err = os.Stat(filepath.Join(root, "b.bin"))
+	assert.True(t, os.IsNotExist(err))
+}
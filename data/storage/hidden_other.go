@@ -0,0 +1,9 @@
+//go:build !windows
+
+package storage
+
+// setHidden does nothing where hiding is a naming convention; the torrent
+// names hidden files with a leading dot already.
+func setHidden(path string) error {
+	return nil
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Sabir222/torrent-at-home/data/mask"
+)
+
+// FileCheck is how a file fared in Check: Index is its place in Files,
+// First and Last are the pieces it overlaps and Bad how many of them did
+// not match.
+type FileCheck struct {
+	File
+	Index       int
+	First, Last int
+	Bad         int
+}
+
+// Check reads the pieces in Storage and hashes them on the session's
+// hashing pool, verifying them as downloaded pieces are. Only the pieces
+// in only are read, unless it is nil. It returns the pieces that match,
+// which Restore takes as verified.
+func (s *Session) Check(ctx context.Context, only mask.Mask) (mask.Mask, error) {
+	if s.Storage == nil {
+		return nil, ErrNoStorage
+	}
+	pool := s.hashPool()
+	have := mask.New(len(s.PieceHashes))
+	// A buffer for every piece being hashed and one being read; taking one
+	// waits for the pool to catch up.
+	bufs := make(chan []byte, pool.Workers()+1)
+	for i := 0; i < cap(bufs); i++ {
+		bufs <- make([]byte, s.PieceLength)
+	}
+	var mu sync.Mutex
+	batch := pool.Batch(ctx.Done())
+	var err error
+	for index := range s.PieceHashes {
+		if only != nil && !only.Check(index) {
+			continue
+		}
+		var buf []byte
+		select {
+		case buf = <-bufs:
+		case <-ctx.Done():
+		}
+		if buf == nil {
+			break
+		}
+		j := &job{index: index, hash: s.PieceHashes[index], length: s.pieceSize(index)}
+		begin, _ := s.pieceRange(index)
+		if _, err = s.Storage.ReadAt(buf[:j.length], int64(begin)); err != nil {
+			break
+		}
+		if !batch.Go(func() {
+			if s.verifyPiece(j, buf[:j.length]) == nil {
+				mu.Lock()
+				have.Mark(j.index)
+				mu.Unlock()
+			}
+			bufs <- buf
+		}) {
+			break
+		}
+	}
+	batch.Wait()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return have, nil
+}
+
+// CheckFiles maps the result of Check onto the files, leaving out
+// padding.
+func (s *Session) CheckFiles(have mask.Mask) []FileCheck {
+	var out []FileCheck
+	for i, f := range s.files() {
+		if f.Padding {
+			continue
+		}
+		c := FileCheck{File: f, Index: i, First: 0, Last: -1}
+		if f.Length > 0 {
+			c.First, c.Last = s.pieceSpan(f)
+		}
+		for i := c.First; i <= c.Last; i++ {
+			if !have.Check(i) {
+				c.Bad++
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha1"
+	"testing"
+
+	"github.com/Sabir222/torrent-at-home/data/hasher"
+	"github.com/Sabir222/torrent-at-home/data/mask"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	content := []byte("abcdefghij")
+	s := &Session{
+		PieceHashes: [][20]byte{sha1.Sum(content[0:4]), sha1.Sum(content[4:8]), sha1.Sum(content[8:10])},
+		PieceLength: 4,
+		Length:      len(content),
+		Files: []File{
+			{Path: "a", Length: 6},
+			{Path: "pad", Offset: 6, Length: 0, Padding: true},
+			{Path: "empty", Offset: 6},
+			{Path: "b", Offset: 6, Length: 4},
+		},
+		Storage: memStorage("abcdeXghij"),
+		Hasher:  hasher.New(2, 1),
+	}
+	defer s.Hasher.Close()
+
+	have, err := s.Check(context.Background(), nil)
+	require.NoError(t, err)
+	assert.True(t, have.Check(0))
+	assert.False(t, have.Check(1))
+	assert.True(t, have.Check(2))
+
+	assert.Equal(t, []FileCheck{
+		{File: s.Files[0], Index: 0, First: 0, Last: 1, Bad: 1},
+		{File: s.Files[2], Index: 2, First: 0, Last: -1},
+		{File: s.Files[3], Index: 3, First: 1, Last: 2, Bad: 1},
+	}, s.CheckFiles(have))
+
+	// Pieces left out are not read, so they do not match.
+	only := mask.New(3)
+	only.Mark(2)
+	have, err = s.Check(context.Background(), only)
+	require.NoError(t, err)
+	assert.False(t, have.Check(0))
+	assert.True(t, have.Check(2))
+}
+
+func TestCheckCanceled(t *testing.T) {
+	s := &Session{
+		PieceHashes: make([][20]byte, 3),
+		PieceLength: 4,
+		Length:      10,
+		Storage:     make(memStorage, 10),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.Check(ctx, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	s.Storage = nil
+	_, err = s.Check(context.Background(), nil)
+	assert.ErrorIs(t, err, ErrNoStorage)
+}
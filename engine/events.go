@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// EventKind says what an Event reports.
+type EventKind int
+
+const (
+	EventPeerConnected EventKind = iota
+	EventPeerDisconnected
+	EventPieceVerified
+	EventPieceFailed
+	EventTrackerAnnounce
+	EventStateChanged
+	EventCompleted
+	EventError
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventPeerConnected:
+		return "peer_connected"
+	case EventPeerDisconnected:
+		return "peer_disconnected"
+	case EventPieceVerified:
+		return "piece_verified"
+	case EventPieceFailed:
+		return "piece_failed"
+	case EventTrackerAnnounce:
+		return "tracker_announce"
+	case EventStateChanged:
+		return "state_changed"
+	case EventCompleted:
+		return "completed"
+	}
+	return "error"
+}
+
+// Event is something that happened to a torrent. Only the fields of its
+// Kind are set.
+type Event struct {
+	Kind     EventKind
+	Time     time.Time
+	InfoHash [20]byte
+	// Peer is the address of the peer for peer events, and of the peer
+	// that sent the piece for piece events; empty for web seeds.
+	Peer string
+	// Piece is the index for piece events. Done and Wanted count the
+	// pieces after a piece was verified, and on completion.
+	Piece  int
+	Done   int
+	Wanted int
+	// Tracker is the result of an announce.
+	Tracker *TrackerStatus
+	// State is the new state of a torrent, as named by its owner.
+	State string
+	// Err is set for errors and for state changes caused by one.
+	Err error
+}
+
+// Feed fans events out to subscribers. Publishing never blocks: a
+// subscriber more than its buffer behind misses events. The zero value is
+// ready to use and publishing to a nil Feed does nothing, so one can be
+// shared between sessions to watch them all.
+type Feed struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Subscribe returns a channel receiving events from now on, and a func
+// that unsubscribes and closes it.
+func (f *Feed) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	f.mu.Lock()
+	if f.subs == nil {
+		f.subs = make(map[chan Event]struct{})
+	}
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			f.mu.Lock()
+			delete(f.subs, ch)
+			f.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends an event to every subscriber, stamping its Time if unset.
+func (f *Feed) Publish(e Event) {
+	if f == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// publish sends an event about this session to its Events.
+func (s *Session) publish(e Event) {
+	e.InfoHash = s.InfoHash
+	s.Events.Publish(e)
+}
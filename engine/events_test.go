@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeed(t *testing.T) {
+	var nilFeed *Feed
+	nilFeed.Publish(Event{Kind: EventError})
+
+	var f Feed
+	events, unsubscribe := f.Subscribe(1)
+	f.Publish(Event{Kind: EventPieceVerified, Piece: 1})
+	// The buffer is full, so the subscriber misses this one.
+	f.Publish(Event{Kind: EventPieceVerified, Piece: 2})
+
+	e := <-events
+	assert.Equal(t, 1, e.Piece)
+	assert.False(t, e.Time.IsZero())
+
+	unsubscribe()
+	unsubscribe()
+	_, open := <-events
+	assert.False(t, open)
+	f.Publish(Event{Kind: EventError})
+}
+
+func TestSessionEvents(t *testing.T) {
+	content := []byte("first---second")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "events", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	feed := &Feed{}
+	events, unsubscribe := feed.Subscribe(16)
+	defer unsubscribe()
+	s := &Session{
+		InfoHash:    [20]byte{1},
+		PieceHashes: [][20]byte{sha1.Sum(content[:7]), sha1.Sum(content[7:])},
+		PieceLength: 7,
+		Length:      len(content),
+		Name:        "events",
+		WebSeeds:    []WebSeed{{URLs: []string{srv.URL}}},
+		Storage:     make(memStorage, len(content)),
+		Events:      feed,
+	}
+	require.NoError(t, s.Download())
+
+	var kinds []EventKind
+	var last Event
+	for len(events) > 0 {
+		last = <-events
+		assert.Equal(t, s.InfoHash, last.InfoHash)
+		kinds = append(kinds, last.Kind)
+	}
+	assert.Equal(t, []EventKind{EventPieceVerified, EventPieceVerified, EventCompleted}, kinds)
+	assert.Equal(t, 2, last.Done)
+	assert.Equal(t, 2, last.Wanted)
+}
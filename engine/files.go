@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+)
+
+var (
+	ErrNoFile      = errors.New("no file")
+	ErrBadPriority = errors.New("bad file priority")
+)
+
+// File is one file of the torrent within the session's byte space.
+type File struct {
+	Path     string
+	Offset   int
+	Length   int
+	Priority int
+	// Padding files are zeros aligning the next file; they stay skipped.
+	Padding bool
+}
+
+// skipper is implemented by storages that can keep skipped files off disk.
+type skipper interface {
+	SetSkip(index int, skip bool) error
+}
+
+func (s *Session) files() []File {
+	if len(s.Files) == 0 {
+		s.Files = []File{{Path: s.Name, Length: s.Length, Priority: PriorityNormal}}
+	}
+	return s.Files
+}
+
+// pieceSpan returns the pieces a file overlaps as [first, last].
+func (s *Session) pieceSpan(f File) (first, last int) {
+	first = f.Offset / s.PieceLength
+	last = (f.Offset + f.Length - 1) / s.PieceLength
+	return first, last
+}
+
+// applyPriorities gives every piece the highest priority of the files it
+// overlaps. A piece shared by a skipped and a wanted file is downloaded.
+func (s *Session) applyPriorities() {
+	s.mu.Lock()
+	files := append([]File(nil), s.files()...)
+	s.mu.Unlock()
+	prio := make([]int, len(s.PieceHashes))
+	for _, f := range files {
+		if f.Length == 0 {
+			continue
+		}
+		first, last := s.pieceSpan(f)
+		for i := first; i <= last && i < len(prio); i++ {
+			if f.Priority > prio[i] {
+				prio[i] = f.Priority
+			}
+		}
+	}
+	for i, p := range prio {
+		s.picker.setPriority(i, p)
+	}
+}
+
+// FileList returns a copy of the session's files.
+func (s *Session) FileList() []File {
+	s.setup()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]File(nil), s.Files...)
+}
+
+func (s *Session) FilePriorities() []int {
+	s.setup()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prio := make([]int, len(s.Files))
+	for i, f := range s.Files {
+		prio[i] = f.Priority
+	}
+	return prio
+}
+
+// CheckFilePriority rejects priorities other than PrioritySkip, Low,
+// Normal and High. PriorityUrgent is the picker's own, for pieces a
+// reader waits on.
+func CheckFilePriority(priority int) error {
+	switch priority {
+	case PrioritySkip, PriorityLow, PriorityNormal, PriorityHigh:
+		return nil
+	}
+	return fmt.Errorf("%w %d", ErrBadPriority, priority)
+}
+
+func (s *Session) SetFilePriority(index, priority int) error {
+	if err := CheckFilePriority(priority); err != nil {
+		return err
+	}
+	s.setup()
+	s.mu.Lock()
+	if index < 0 || index >= len(s.Files) {
+		s.mu.Unlock()
+		return fmt.Errorf("%w with index %d", ErrNoFile, index)
+	}
+	if s.Files[index].Padding {
+		s.mu.Unlock()
+		return nil
+	}
+	s.Files[index].Priority = priority
+	s.mu.Unlock()
+
+	if sk, ok := s.Storage.(skipper); ok {
+		if err := sk.SetSkip(index, priority == PrioritySkip); err != nil {
+			return err
+		}
+	}
+	s.applyPriorities()
+	return nil
+}
+
+// SelectFiles skips every file that matches none of the patterns. A pattern
+// is either a file index or a glob matched against the path or base name.
+func (s *Session) SelectFiles(patterns []string) error {
+	s.setup()
+	s.mu.Lock()
+	skip := make([]bool, len(s.Files))
+	for i, f := range s.Files {
+		selected, err := matchFile(i, f.Path, patterns)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		skip[i] = !selected
+	}
+	for i := range s.Files {
+		if skip[i] {
+			s.Files[i].Priority = PrioritySkip
+		}
+	}
+	s.mu.Unlock()
+
+	s.applyPriorities()
+	return nil
+}
+
+func matchFile(index int, name string, patterns []string) (bool, error) {
+	for _, p := range patterns {
+		if n, err := strconv.Atoi(p); err == nil {
+			if n == index {
+				return true, nil
+			}
+			continue
+		}
+		for _, candidate := range []string{name, path.Base(name)} {
+			ok, err := path.Match(p, candidate)
+			if err != nil {
+				return false, fmt.Errorf("bad file pattern %q: %w", p, err)
+			}
+			if ok {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,64 @@
+package engine
+
+// ConnLimit caps the number of peer connections, usually across every
+// session of a client. A nil ConnLimit allows any number.
+type ConnLimit struct {
+	slots chan struct{}
+}
+
+// NewConnLimit allows max connections at once; zero or less means no
+// limit and returns nil.
+func NewConnLimit(max int) *ConnLimit {
+	if max <= 0 {
+		return nil
+	}
+	return &ConnLimit{slots: make(chan struct{}, max)}
+}
+
+// acquire waits for a free slot and reports false if done closed first.
+func (l *ConnLimit) acquire(done chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// tryAcquire takes a slot only if one is free right away.
+func (l *ConnLimit) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *ConnLimit) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// Active is the number of connections holding a slot.
+func (l *ConnLimit) Active() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots)
+}
+
+// Max is the configured limit, zero when unlimited.
+func (l *ConnLimit) Max() int {
+	if l == nil {
+		return 0
+	}
+	return cap(l.slots)
+}
@@ -0,0 +1,26 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnLimit(t *testing.T) {
+	assert.Nil(t, NewConnLimit(0))
+	var unlimited *ConnLimit
+	assert.True(t, unlimited.tryAcquire())
+
+	l := NewConnLimit(2)
+	done := make(chan struct{})
+	assert.True(t, l.acquire(done))
+	assert.True(t, l.tryAcquire())
+	assert.False(t, l.tryAcquire())
+	assert.Equal(t, 2, l.Active())
+
+	close(done)
+	assert.False(t, l.acquire(done))
+
+	l.release()
+	assert.True(t, l.tryAcquire())
+}
@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/network/connector"
+)
+
+// webSeedClient is the client name web seed hash failures are counted under.
+const webSeedClient = "webseed"
+
+// diskWriteBounds are the bucket upper bounds, in seconds, of the disk
+// write latency histogram.
+var diskWriteBounds = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// announceBounds are the bucket upper bounds, in seconds, of the tracker
+// announce latency histogram.
+var announceBounds = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15}
+
+// Histogram counts observations by bucket.
+type Histogram struct {
+	// Counts[i] holds the observations up to Bounds[i] and above the
+	// bound before it; the last entry of Counts is the rest.
+	Bounds []float64
+	Counts []int64
+	Sum    float64
+	Count  int64
+}
+
+func newHistogram(bounds []float64) Histogram {
+	return Histogram{Bounds: bounds, Counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *Histogram) observe(v float64) {
+	i := 0
+	for i < len(h.Bounds) && v > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+}
+
+func (h Histogram) clone() Histogram {
+	h.Counts = append([]int64(nil), h.Counts...)
+	return h
+}
+
+// Merge adds the observations of o, which must have the same bounds or
+// be empty, to h.
+func (h *Histogram) Merge(o Histogram) {
+	if len(o.Counts) == 0 {
+		return
+	}
+	if h.Counts == nil {
+		h.Bounds = o.Bounds
+		h.Counts = make([]int64, len(o.Counts))
+	}
+	for i, n := range o.Counts {
+		h.Counts[i] += n
+	}
+	h.Sum += o.Sum
+	h.Count += o.Count
+}
+
+// Announces counts tracker announces by tracker URL as they are made.
+// Share one between sessions for process wide totals that only grow. The
+// zero value is ready to use and a nil Announces counts nothing.
+type Announces struct {
+	mu       sync.Mutex
+	trackers map[string]*AnnounceCounts
+}
+
+// AnnounceCounts are the announces made to one tracker.
+type AnnounceCounts struct {
+	Total  int64
+	Errors int64
+	// Latency is how long the announces took, in seconds.
+	Latency Histogram
+}
+
+// Record counts one announce.
+func (a *Announces) Record(status TrackerStatus) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.trackers == nil {
+		a.trackers = make(map[string]*AnnounceCounts)
+	}
+	c := a.trackers[status.URL]
+	if c == nil {
+		c = &AnnounceCounts{Latency: newHistogram(announceBounds)}
+		a.trackers[status.URL] = c
+	}
+	c.Total++
+	if status.Err != "" {
+		c.Errors++
+	}
+	c.Latency.observe(status.Latency.Seconds())
+}
+
+// Counts returns a copy of the counts by tracker URL.
+func (a *Announces) Counts() map[string]AnnounceCounts {
+	out := make(map[string]AnnounceCounts)
+	if a == nil {
+		return out
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for url, c := range a.trackers {
+		out[url] = AnnounceCounts{Total: c.Total, Errors: c.Errors, Latency: c.Latency.clone()}
+	}
+	return out
+}
+
+// Metrics are the running counters of a session, for monitoring.
+type Metrics struct {
+	PiecesVerified int64
+	PiecesFailed   int64
+	// HashFailures counts failed pieces by the software of the peer that
+	// sent them, "webseed" for web seeds and "" when unknown.
+	HashFailures map[string]int64
+	// Peers are connected, HalfOpen are still being dialed. Choked of the
+	// connected peers are choking us.
+	Peers    int
+	HalfOpen int
+	Choked   int
+	// DiskWrites is the latency of writing verified pieces, in seconds.
+	DiskWrites Histogram
+}
+
+// Metrics can be called at any time, including before and after Run.
+func (s *Session) Metrics() Metrics {
+	s.setup()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := Metrics{
+		PiecesVerified: s.verified,
+		PiecesFailed:   s.failed,
+		HashFailures:   make(map[string]int64, len(s.hashFailures)),
+		Peers:          len(s.connected),
+		HalfOpen:       s.halfOpen,
+		DiskWrites:     s.diskWrites.clone(),
+	}
+	for client, n := range s.hashFailures {
+		m.HashFailures[client] = n
+	}
+	for _, info := range s.connected {
+		if info.Choked {
+			m.Choked++
+		}
+	}
+	return m
+}
+
+// dialing counts a connection attempt as half open until the returned
+// func is called.
+func (s *Session) dialing() func() {
+	s.mu.Lock()
+	s.halfOpen++
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		s.halfOpen--
+		s.mu.Unlock()
+	}
+}
+
+// observe copies the state the peer's goroutine owns into its PeerInfo.
+func (s *Session) observe(info *PeerInfo, conn *connector.PeerConn) {
+	client := conn.ClientName()
+	seed := conn.Bitfield.Count() >= len(s.PieceHashes)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info.Client = client
+	info.Choked = conn.Choked
+	info.Seed = seed
+}
+
+// hashFailed counts a piece that failed verification, from a peer or,
+// when from is nil, a web seed.
+func (s *Session) hashFailed(index int, from *PeerInfo) {
+	failed := Event{Kind: EventPieceFailed, Piece: index}
+	s.mu.Lock()
+	client := webSeedClient
+	if from != nil {
+		client = from.Client
+		failed.Peer = from.Addr
+	}
+	s.failed++
+	s.hashFailures[client]++
+	s.wasted += int64(s.pieceSize(index))
+	s.mu.Unlock()
+	s.publish(failed)
+}
+
+func (s *Session) wrote(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.diskWrites.observe(d.Seconds())
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"crypto/sha1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 2})
+	for _, v := range []float64{0.5, 1, 1.5, 3} {
+		h.observe(v)
+	}
+	assert.Equal(t, []int64{2, 1, 1}, h.Counts)
+	assert.Equal(t, int64(4), h.Count)
+	assert.Equal(t, 6.0, h.Sum)
+}
+
+func TestAnnounces(t *testing.T) {
+	var a Announces
+	a.Record(TrackerStatus{URL: "udp://a", Latency: 30 * time.Millisecond})
+	a.Record(TrackerStatus{URL: "udp://a", Latency: 3 * time.Second, Err: "timeout"})
+	a.Record(TrackerStatus{URL: "udp://b", Latency: time.Second})
+	counts := a.Counts()
+	assert.Equal(t, int64(2), counts["udp://a"].Total)
+	assert.Equal(t, int64(1), counts["udp://a"].Errors)
+	assert.Equal(t, int64(2), counts["udp://a"].Latency.Count)
+	assert.Equal(t, int64(1), counts["udp://b"].Total)
+
+	var none *Announces
+	none.Record(TrackerStatus{URL: "udp://a"})
+	assert.Empty(t, none.Counts())
+}
+
+func TestMetrics(t *testing.T) {
+	content := []byte("piece")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	s := &Session{
+		PieceHashes: [][20]byte{sha1.Sum(content)},
+		PieceLength: len(content),
+		Length:      len(content),
+		Name:        "piece",
+		WebSeeds:    []WebSeed{{URLs: []string{srv.URL}}},
+		Storage:     make(memStorage, len(content)),
+	}
+	require.NoError(t, s.Download())
+
+	s.hashFailed(0, nil)
+	s.hashFailed(0, &PeerInfo{Client: "qB4650"})
+	s.hashFailed(0, &PeerInfo{Client: "qB4650"})
+	done := s.dialing()
+
+	m := s.Metrics()
+	assert.Equal(t, int64(1), m.PiecesVerified)
+	assert.Equal(t, int64(3), m.PiecesFailed)
+	assert.Equal(t, map[string]int64{"webseed": 1, "qB4650": 2}, m.HashFailures)
+	assert.Equal(t, 1, m.HalfOpen)
+	assert.Equal(t, int64(1), m.DiskWrites.Count)
+	assert.Less(t, m.DiskWrites.Sum, time.Second.Seconds())
+
+	done()
+	assert.Equal(t, 0, s.Metrics().HalfOpen)
+}
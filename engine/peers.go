@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/Sabir222/torrent-at-home/logging"
+	"github.com/Sabir222/torrent-at-home/network/endpoints"
+)
+
+// foundBacklog is how many discovered peers may wait to be dialed.
+const foundBacklog = 256
+
+// Source is where a peer address was learned.
+type Source int
+
+const (
+	SourceTracker Source = iota
+	SourceDHT
+	SourcePEX
+	SourceLSD
+)
+
+func (src Source) String() string {
+	switch src {
+	case SourceDHT:
+		return "dht"
+	case SourcePEX:
+		return "pex"
+	case SourceLSD:
+		return "lsd"
+	}
+	return "tracker"
+}
+
+// AllowSource reports whether peers from src may be used. Private torrents
+// (BEP 27) only take peers from their own trackers.
+func (s *Session) AllowSource(src Source) bool {
+	return !s.Private || src == SourceTracker
+}
+
+// AddPeers queues peers to be dialed by the download, LAN peers first.
+// Peers the session already knows about are skipped, so sources that
+// repeat themselves, like periodic local discovery announces, are safe.
+func (s *Session) AddPeers(src Source, peers ...endpoints.Endpoint) {
+	if !s.AllowSource(src) {
+		return
+	}
+	s.setup()
+	for _, peer := range orderPeers(peers) {
+		if !s.remember(peer) {
+			continue
+		}
+
+		select {
+		case s.found <- peer:
+			if peer.IsLocal() {
+				logging.Debugf("[peer] found local peer %s\n", peer)
+			}
+		default:
+			// Too many waiting; forget it so a later announce can retry.
+			s.mu.Lock()
+			delete(s.known, peer.String())
+			s.mu.Unlock()
+		}
+	}
+}
+
+// remember records a peer and reports whether it was new.
+func (s *Session) remember(peer endpoints.Endpoint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := peer.String()
+	if s.known[key] {
+		return false
+	}
+	s.known[key] = true
+	return true
+}
+
+// orderPeers moves peers on the local network to the front, keeping the
+// order within each group.
+func orderPeers(peers []endpoints.Endpoint) []endpoints.Endpoint {
+	out := append([]endpoints.Endpoint(nil), peers...)
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].IsLocal() && !out[j].IsLocal()
+	})
+	return out
+}
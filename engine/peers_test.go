@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Sabir222/torrent-at-home/network/endpoints"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderPeers(t *testing.T) {
+	wan1 := endpoints.Endpoint{Addr: net.IP{1, 1, 1, 1}, Port: 1}
+	wan2 := endpoints.Endpoint{Addr: net.IP{8, 8, 8, 8}, Port: 2}
+	lan1 := endpoints.Endpoint{Addr: net.IP{192, 168, 0, 2}, Port: 3}
+	lan2 := endpoints.Endpoint{Addr: net.IP{10, 0, 0, 9}, Port: 4}
+
+	got := orderPeers([]endpoints.Endpoint{wan1, lan1, wan2, lan2})
+	assert.Equal(t, []endpoints.Endpoint{lan1, lan2, wan1, wan2}, got)
+}
+
+func TestAddPeersSkipsKnown(t *testing.T) {
+	s := &Session{PieceHashes: make([][20]byte, 1)}
+	lan := endpoints.Endpoint{Addr: net.IP{192, 168, 0, 2}, Port: 6881}
+	wan := endpoints.Endpoint{Addr: net.IP{1, 1, 1, 1}, Port: 6881}
+
+	s.AddPeers(SourceTracker, wan, lan)
+	s.AddPeers(SourceLSD, lan)
+
+	assert.Equal(t, lan, <-s.found)
+	assert.Equal(t, wan, <-s.found)
+	assert.Empty(t, s.found)
+}
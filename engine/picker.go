@@ -0,0 +1,222 @@
+package engine
+
+import "sync"
+
+const (
+	PrioritySkip   = 0
+	PriorityLow    = 2
+	PriorityNormal = 4
+	PriorityHigh   = 6
+	// PriorityUrgent is given to pieces a reader is blocked on.
+	PriorityUrgent = 8
+)
+
+const (
+	pieceMissing = iota
+	pieceActive
+	pieceDone
+)
+
+// picker decides which piece a worker downloads next. Pieces at
+// PrioritySkip are never picked and higher priorities always win; ties
+// are broken by distance from the read head in sequential mode and by
+// index otherwise.
+type picker struct {
+	mu    sync.Mutex
+	state []int
+	// priority is what pieces are picked by: PriorityUrgent while a
+	// reader's window covers them, base, the files' priority, otherwise.
+	priority   []int
+	base       []int
+	urgent     []int
+	sequential bool
+	head       int
+	remaining  int
+}
+
+func newPicker(count int) *picker {
+	p := &picker{
+		state:     make([]int, count),
+		priority:  make([]int, count),
+		base:      make([]int, count),
+		urgent:    make([]int, count),
+		remaining: count,
+	}
+	for i := range p.priority {
+		p.priority[i] = PriorityNormal
+		p.base[i] = PriorityNormal
+	}
+	return p
+}
+
+// next claims the best missing piece the peer has.
+func (p *picker) next(has func(int) bool) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best := -1
+	for i, st := range p.state {
+		if st != pieceMissing || p.priority[i] == PrioritySkip || !has(i) {
+			continue
+		}
+		if best < 0 || p.better(i, best) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	p.state[best] = pieceActive
+	return best, true
+}
+
+// claim takes a specific piece if it is still wanted and unclaimed.
+func (p *picker) claim(i int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i < 0 || i >= len(p.state) || p.state[i] != pieceMissing || p.priority[i] == PrioritySkip {
+		return false
+	}
+	p.state[i] = pieceActive
+	return true
+}
+
+func (p *picker) better(a, b int) bool {
+	if p.priority[a] != p.priority[b] {
+		return p.priority[a] > p.priority[b]
+	}
+	if p.sequential {
+		return p.distance(a) < p.distance(b)
+	}
+	return a < b
+}
+
+// distance measures how far ahead of the head a piece is, wrapping pieces
+// behind the head to the end.
+func (p *picker) distance(i int) int {
+	if i >= p.head {
+		return i - p.head
+	}
+	return len(p.state) - p.head + i
+}
+
+// release puts a piece back so another worker can claim it.
+func (p *picker) release(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state[i] == pieceActive {
+		p.state[i] = pieceMissing
+	}
+}
+
+func (p *picker) done(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state[i] != pieceDone {
+		p.state[i] = pieceDone
+		if p.priority[i] != PrioritySkip {
+			p.remaining--
+		}
+	}
+}
+
+func (p *picker) finished() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.remaining == 0
+}
+
+func (p *picker) setSequential(on bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sequential = on
+}
+
+func (p *picker) isSequential() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sequential
+}
+
+func (p *picker) setHead(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.head = i
+}
+
+// bump makes the pieces in [from, to) urgent until unbump is called
+// with the same range. Ranges of several readers may overlap.
+func (p *picker) bump(from, to int) {
+	p.adjust(from, to, 1)
+}
+
+func (p *picker) unbump(from, to int) {
+	p.adjust(from, to, -1)
+}
+
+func (p *picker) adjust(from, to, delta int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	from, to = max(from, 0), min(to, len(p.state))
+	for i := from; i < to; i++ {
+		p.urgent[i] += delta
+		p.set(i, p.effective(i))
+	}
+}
+
+// setPriority changes the priority a piece has outside reader windows.
+func (p *picker) setPriority(i, priority int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.base[i] = priority
+	p.set(i, p.effective(i))
+}
+
+func (p *picker) effective(i int) int {
+	if p.urgent[i] > 0 {
+		return PriorityUrgent
+	}
+	return p.base[i]
+}
+
+// set keeps the count of wanted pieces in step with skip changes.
+func (p *picker) set(i, priority int) {
+	if p.state[i] != pieceDone {
+		if p.priority[i] == PrioritySkip && priority != PrioritySkip {
+			p.remaining++
+		}
+		if p.priority[i] != PrioritySkip && priority == PrioritySkip {
+			p.remaining--
+		}
+	}
+	p.priority[i] = priority
+}
+
+// left sums the sizes of the wanted pieces not done yet.
+func (p *picker) left(size func(int) int) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var n int64
+	for i, st := range p.state {
+		if st != pieceDone && p.priority[i] != PrioritySkip {
+			n += int64(size(i))
+		}
+	}
+	return n
+}
+
+// progress returns how many wanted pieces are done out of the total.
+func (p *picker) progress() (done, wanted int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, st := range p.state {
+		if p.priority[i] == PrioritySkip {
+			continue
+		}
+		wanted++
+		if st == pieceDone {
+			done++
+		}
+	}
+	return done, wanted
+}
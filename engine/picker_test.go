@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func hasAll(int) bool { return true }
+
+func TestPickerOrder(t *testing.T) {
+	cases := map[string]struct {
+		sequential bool
+		head       int
+		bumped     []int
+		want       []int
+	}{
+		"index order": {
+			want: []int{0, 1, 2, 3, 4, 5},
+		},
+		"sequential wraps around head": {
+			sequential: true,
+			head:       3,
+			want:       []int{3, 4, 5, 0, 1, 2},
+		},
+		"bumped pieces first": {
+			bumped: []int{4},
+			want:   []int{4, 0, 1, 2, 3, 5},
+		},
+	}
+
+	for name, c := range cases {
+		p := newPicker(6)
+		p.setSequential(c.sequential)
+		p.setHead(c.head)
+		for _, i := range c.bumped {
+			p.bump(i, i+1)
+		}
+
+		var got []int
+		for {
+			i, ok := p.next(hasAll)
+			if !ok {
+				break
+			}
+			got = append(got, i)
+		}
+		assert.Equal(t, c.want, got, name)
+	}
+}
+
+func TestPickerRelease(t *testing.T) {
+	p := newPicker(2)
+
+	i, ok := p.next(func(i int) bool { return i == 1 })
+	assert.True(t, ok)
+	assert.Equal(t, 1, i)
+
+	_, ok = p.next(func(i int) bool { return i == 1 })
+	assert.False(t, ok)
+
+	p.release(1)
+	i, ok = p.next(hasAll)
+	assert.True(t, ok)
+	assert.Equal(t, 0, i)
+
+	p.done(0)
+	assert.False(t, p.finished())
+	p.done(1)
+	assert.True(t, p.finished())
+}
+
+func TestSelectFiles(t *testing.T) {
+	s := &Session{
+		PieceHashes: make([][20]byte, 4),
+		PieceLength: 4,
+		Length:      16,
+		Files: []File{
+			{Path: "a/one.txt", Offset: 0, Length: 6, Priority: PriorityNormal},
+			{Path: "a/two.mkv", Offset: 6, Length: 6, Priority: PriorityNormal},
+			{Path: "three.txt", Offset: 12, Length: 4, Priority: PriorityNormal},
+		},
+	}
+	assert.NoError(t, s.SelectFiles([]string{"*.mkv"}))
+	assert.Equal(t, []int{PrioritySkip, PriorityNormal, PrioritySkip}, s.FilePriorities())
+
+	// Pieces 1 and 2 overlap the selected file, piece 1 is shared with a
+	// skipped one and must still be downloaded.
+	assert.Equal(t, []int{PrioritySkip, PriorityNormal, PriorityNormal, PrioritySkip}, s.picker.priority)
+	_, wanted := s.picker.progress()
+	assert.Equal(t, 2, wanted)
+
+	assert.ErrorIs(t, s.SetFilePriority(2, -1), ErrBadPriority)
+	assert.ErrorIs(t, s.SetFilePriority(2, PriorityUrgent), ErrBadPriority)
+	assert.Equal(t, []int{PrioritySkip, PriorityNormal, PrioritySkip}, s.FilePriorities())
+
+	assert.NoError(t, s.SetFilePriority(2, PriorityHigh))
+	i, ok := s.picker.next(hasAll)
+	assert.True(t, ok)
+	assert.Equal(t, 3, i)
+}
+
+func TestSelectFilesBadPattern(t *testing.T) {
+	s := &Session{
+		PieceHashes: make([][20]byte, 2),
+		PieceLength: 4,
+		Length:      8,
+		Files: []File{
+			{Path: "one.txt", Length: 4, Priority: PriorityNormal},
+			{Path: "two.txt", Offset: 4, Length: 4, Priority: PriorityNormal},
+		},
+	}
+	// A bad pattern leaves every file as it was.
+	assert.Error(t, s.SelectFiles([]string{"one.txt", "[", "1"}))
+	assert.Equal(t, []int{PriorityNormal, PriorityNormal}, s.FilePriorities())
+
+	// Selecting while the picker reads the files is safe.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.picker.next(hasAll)
+		s.FileList()
+	}()
+	assert.NoError(t, s.SelectFiles([]string{"1"}))
+	<-done
+	assert.Equal(t, []int{PrioritySkip, PriorityNormal}, s.FilePriorities())
+}
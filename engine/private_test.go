@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/network/endpoints"
+	"github.com/Sabir222/torrent-at-home/protocol/extension"
+	"github.com/Sabir222/torrent-at-home/protocol/frames"
+	"github.com/Sabir222/torrent-at-home/protocol/greeting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrivateSources(t *testing.T) {
+	s := &Session{PieceHashes: make([][20]byte, 1), Private: true}
+	peer := endpoints.Endpoint{Addr: net.IP{10, 0, 0, 1}, Port: 6881}
+
+	for _, src := range []Source{SourceDHT, SourcePEX, SourceLSD} {
+		assert.False(t, s.AllowSource(src), src.String())
+		s.AddPeers(src, peer)
+	}
+	assert.Empty(t, s.found)
+
+	s.AddPeers(SourceTracker, peer)
+	assert.Len(t, s.found, 1)
+}
+
+// fakeSeed serves one piece and records every frame the client sends.
+func fakeSeed(t *testing.T, ln net.Listener, infoHash [20]byte, piece []byte, seen chan<- *frames.Frame) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	defer close(seen)
+
+	if _, err := greeting.Unpack(conn); err != nil {
+		return
+	}
+	g := greeting.Build(infoHash, [20]byte{'s'})
+	g.SetBit(greeting.ExtensionProtocol)
+	conn.Write(g.Pack())
+	conn.Write((&frames.Frame{Type: frames.TypeBitfield, Data: []byte{0x80}}).Pack())
+	conn.Write((&frames.Frame{Type: frames.TypeUnchoke}).Pack())
+
+	for {
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		frm, err := frames.Unpack(conn)
+		if err != nil {
+			return
+		}
+		if frm == nil {
+			continue
+		}
+		seen <- frm
+		if frm.Type != frames.TypeRequest {
+			continue
+		}
+		index, begin, length, _ := frames.ReadRequest(frm)
+		data := make([]byte, 8+length)
+		binary.BigEndian.PutUint32(data[0:4], uint32(index))
+		binary.BigEndian.PutUint32(data[4:8], uint32(begin))
+		copy(data[8:], piece[begin:begin+length])
+		conn.Write((&frames.Frame{Type: frames.TypePiece, Data: data}).Pack())
+	}
+}
+
+func TestPrivateSessionWire(t *testing.T) {
+	piece := []byte("private piece")
+	infoHash := [20]byte{'p'}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	seen := make(chan *frames.Frame, 64)
+	go fakeSeed(t, ln, infoHash, piece, seen)
+
+	addr := ln.Addr().(*net.TCPAddr)
+	out := make(memStorage, len(piece))
+	s := &Session{
+		Peers:       []endpoints.Endpoint{{Addr: addr.IP, Port: uint16(addr.Port)}},
+		InfoHash:    infoHash,
+		PieceHashes: [][20]byte{sha1.Sum(piece)},
+		PieceLength: len(piece),
+		Length:      len(piece),
+		Storage:     out,
+		Private:     true,
+	}
+	require.NoError(t, s.Download())
+	assert.Equal(t, piece, []byte(out))
+
+	ln.Close()
+	for frm := range seen {
+		// The DHT port message (BEP 5) must never be sent.
+		assert.NotEqual(t, uint8(9), frm.Type)
+		if frm.Type != frames.TypeExtended {
+			continue
+		}
+		id, payload, err := extension.Split(frm)
+		require.NoError(t, err)
+		if id == extension.HandshakeID {
+			hs, err := extension.ParseHandshake(payload)
+			require.NoError(t, err)
+			assert.NotContains(t, hs.M, "ut_pex")
+		}
+	}
+}
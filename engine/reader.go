@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// readahead is how many pieces past the one being read are bumped along
+// with it, so playback does not stall at every piece boundary.
+const readahead = 4
+
+var ErrInvalidSeek = errors.New("invalid seek position")
+
+// Reader reads a byte range of the torrent, blocking until the pieces it
+// needs are verified and moving the read head as it goes. The piece being
+// read and the readahead after it are urgent until the reader moves on
+// or is closed.
+type Reader struct {
+	s      *Session
+	ctx    context.Context
+	offset int64
+	length int64
+	pos    int64
+	// window is the first piece this reader made urgent, -1 for none.
+	window int
+}
+
+// NewReader returns a reader over length bytes starting at offset in the
+// torrent. Reads fail once ctx is done.
+func (s *Session) NewReader(ctx context.Context, offset, length int64) *Reader {
+	s.setup()
+	return &Reader{
+		s:      s,
+		ctx:    ctx,
+		offset: offset,
+		length: length,
+		window: -1,
+	}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.pos >= r.length {
+		r.focus(-1)
+		return 0, io.EOF
+	}
+
+	abs := r.offset + r.pos
+	index := int(abs / int64(r.s.PieceLength))
+	r.focus(index)
+	if err := r.s.waitPiece(r.ctx, index); err != nil {
+		return 0, err
+	}
+
+	_, end := r.s.pieceRange(index)
+	avail := int64(end) - abs
+	if rest := r.length - r.pos; rest < avail {
+		avail = rest
+	}
+	if int64(len(p)) > avail {
+		p = p[:avail]
+	}
+
+	n, err := r.s.Storage.ReadAt(p, abs)
+	r.pos += int64(n)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.length + offset
+	default:
+		return 0, ErrInvalidSeek
+	}
+	if pos < 0 {
+		return 0, ErrInvalidSeek
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+// Close gives the pieces the reader made urgent back their files'
+// priority.
+func (r *Reader) Close() error {
+	r.focus(-1)
+	return nil
+}
+
+// focus moves the reader's urgent window to start at index, releasing
+// the one before; -1 only releases it.
+func (r *Reader) focus(index int) {
+	if index == r.window {
+		return
+	}
+	if r.window >= 0 {
+		r.s.picker.unbump(r.window, r.window+readahead+1)
+	}
+	r.window = index
+	if index >= 0 {
+		r.s.picker.setHead(index)
+		r.s.picker.bump(index, index+readahead+1)
+	}
+}
+
+// waitPiece blocks until the piece is available.
+func (s *Session) waitPiece(ctx context.Context, index int) error {
+	for {
+		s.mu.Lock()
+		ready := s.have.Check(index)
+		changed := s.changed
+		s.mu.Unlock()
+		if ready {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
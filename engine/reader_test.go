@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memStorage []byte
+
+func (m memStorage) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m)) {
+		return 0, io.EOF
+	}
+	return copy(p, m[off:]), nil
+}
+
+func (m memStorage) WriteAt(p []byte, off int64) (int, error) {
+	return copy(m[off:], p), nil
+}
+
+func TestReaderWaitsForPiece(t *testing.T) {
+	s := &Session{
+		PieceHashes: make([][20]byte, 3),
+		PieceLength: 4,
+		Length:      10,
+		Storage:     memStorage("abcdefghij"),
+	}
+	s.SetSequential(true)
+
+	r := s.NewReader(context.Background(), 2, 8)
+	got := make(chan []byte)
+	go func() {
+		buf, _ := io.ReadAll(r)
+		got <- buf
+	}()
+
+	s.markDone(0)
+	s.markDone(2)
+	select {
+	case <-got:
+		t.Fatal("read finished before piece 1 was available")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.picker.mu.Lock()
+	assert.Equal(t, 1, s.picker.head)
+	assert.Equal(t, PriorityUrgent, s.picker.priority[1])
+	s.picker.mu.Unlock()
+
+	s.markDone(1)
+	assert.Equal(t, []byte("cdefghij"), <-got)
+}
+
+func TestReaderContext(t *testing.T) {
+	s := &Session{
+		PieceHashes: make([][20]byte, 1),
+		PieceLength: 4,
+		Length:      4,
+		Storage:     make(memStorage, 4),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.NewReader(ctx, 0, 4).Read(make([]byte, 4))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestReaderWindowFollowsHead(t *testing.T) {
+	s := &Session{
+		PieceHashes: make([][20]byte, 10),
+		PieceLength: 4,
+		Length:      40,
+		Storage:     make(memStorage, 40),
+	}
+	priorities := func() []int {
+		s.picker.mu.Lock()
+		defer s.picker.mu.Unlock()
+		return append([]int(nil), s.picker.priority...)
+	}
+	const (
+		n = PriorityNormal
+		h = PriorityHigh
+		u = PriorityUrgent
+	)
+
+	// A done context lets each read set its window and return at once.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := s.NewReader(ctx, 0, 40)
+	r.Read(make([]byte, 1))
+	assert.Equal(t, []int{u, u, u, u, u, n, n, n, n, n}, priorities())
+
+	// After a seek the old window goes back to the file's priority.
+	r.Seek(24, io.SeekStart)
+	r.Read(make([]byte, 1))
+	assert.Equal(t, []int{n, n, n, n, n, n, u, u, u, u}, priorities())
+
+	// File priority changes keep the window urgent, and apply once it
+	// is released.
+	s.Files[0].Priority = h
+	s.applyPriorities()
+	assert.Equal(t, []int{h, h, h, h, h, h, u, u, u, u}, priorities())
+	r.Close()
+	assert.Equal(t, []int{h, h, h, h, h, h, h, h, h, h}, priorities())
+}
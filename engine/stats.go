@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/data/mask"
+	"github.com/Sabir222/torrent-at-home/network/connector"
+)
+
+// meterWindow is how many seconds download rates are averaged over.
+const meterWindow = 5
+
+// Stats is a snapshot of a session's progress.
+type Stats struct {
+	// Pieces of the Wanted ones are verified and written.
+	Pieces int
+	Wanted int
+	// Downloaded counts verified bytes fetched by this session, DownRate
+	// is their rate in bytes per second over the last few seconds.
+	Downloaded int64
+	DownRate   int64
+	// Left is the wanted bytes still missing and ETA how long they take
+	// at DownRate: zero once done, negative while there is no rate.
+	Left int64
+	ETA  time.Duration
+	// Peers are connected; Unchoked of them let us request pieces. Seeds
+	// have every piece, Leechers are the rest.
+	Peers    int
+	Unchoked int
+	Seeds    int
+	Leechers int
+	// Wasted counts bytes received in pieces that failed verification.
+	Wasted int64
+}
+
+// PeerInfo describes a connected peer.
+type PeerInfo struct {
+	Addr    string
+	Network string
+	// Client is the peer's software, see connector.PeerConn.ClientName.
+	Client string
+	// Choked is set while the peer refuses our requests. Seed is set
+	// once it has every piece.
+	Choked bool
+	Seed   bool
+	// Downloaded counts verified bytes received from the peer, DownRate
+	// is their rate like Stats.DownRate.
+	Downloaded int64
+	DownRate   int64
+
+	down meter
+}
+
+// TrackerStatus is the outcome of the last announce to a tracker.
+type TrackerStatus struct {
+	URL     string
+	Peers   int
+	Err     string
+	Latency time.Duration
+}
+
+// meter sums bytes into one second buckets.
+type meter struct {
+	buckets [meterWindow]int64
+	second  int64
+}
+
+func (m *meter) advance(now time.Time) {
+	sec := now.Unix()
+	if sec-m.second >= meterWindow {
+		m.buckets = [meterWindow]int64{}
+	} else {
+		for s := m.second + 1; s <= sec; s++ {
+			m.buckets[s%meterWindow] = 0
+		}
+	}
+	m.second = sec
+}
+
+func (m *meter) add(n int, now time.Time) {
+	m.advance(now)
+	m.buckets[m.second%meterWindow] += int64(n)
+}
+
+func (m *meter) rate(now time.Time) int64 {
+	m.advance(now)
+	var sum int64
+	for _, b := range m.buckets {
+		sum += b
+	}
+	return sum / meterWindow
+}
+
+// Stats can be called at any time, including before and after Run.
+func (s *Session) Stats() Stats {
+	s.setup()
+	done, wanted := s.picker.progress()
+	left := s.picker.left(s.pieceSize)
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := Stats{
+		Pieces:     done,
+		Wanted:     wanted,
+		Downloaded: s.downloaded,
+		DownRate:   s.down.rate(now),
+		Left:       left,
+		Peers:      len(s.connected),
+		Wasted:     s.wasted,
+	}
+	switch {
+	case left == 0:
+	case stats.DownRate > 0:
+		stats.ETA = time.Duration((left+stats.DownRate-1)/stats.DownRate) * time.Second
+	default:
+		stats.ETA = -1
+	}
+	for _, info := range s.connected {
+		if !info.Choked {
+			stats.Unchoked++
+		}
+		if info.Seed {
+			stats.Seeds++
+		} else {
+			stats.Leechers++
+		}
+	}
+	return stats
+}
+
+// ConnectedPeers lists the peers the session is talking to.
+func (s *Session) ConnectedPeers() []PeerInfo {
+	s.setup()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	peers := make([]PeerInfo, 0, len(s.connected))
+	for _, info := range s.connected {
+		info.DownRate = info.down.rate(now)
+		peers = append(peers, *info)
+	}
+	return peers
+}
+
+// connect records a peer for ConnectedPeers; the returned func forgets it.
+func (s *Session) connect(conn *connector.PeerConn) (*PeerInfo, func()) {
+	info := &PeerInfo{
+		Addr:    conn.Peer().String(),
+		Network: conn.Conn.RemoteAddr().Network(),
+	}
+	s.mu.Lock()
+	s.connected[conn] = info
+	s.mu.Unlock()
+	s.publish(Event{Kind: EventPeerConnected, Peer: info.Addr})
+	return info, func() {
+		s.mu.Lock()
+		delete(s.connected, conn)
+		s.mu.Unlock()
+		s.publish(Event{Kind: EventPeerDisconnected, Peer: info.Addr})
+	}
+}
+
+// received counts a verified piece towards the session's and, when it
+// came from a peer, that peer's totals.
+func (s *Session) received(from *PeerInfo, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.downloaded += int64(n)
+	s.verified++
+	s.down.add(n, now)
+	if from != nil {
+		from.Downloaded += int64(n)
+		from.down.add(n, now)
+	}
+}
+
+// Restore marks pieces as already verified, as recorded in resume data
+// saved by an earlier run of this torrent. It must be called before Run.
+func (s *Session) Restore(have mask.Mask) {
+	s.setup()
+	s.mu.Lock()
+	for i := range s.PieceHashes {
+		if have.Check(i) {
+			s.have.Mark(i)
+		}
+	}
+	s.mu.Unlock()
+	for i := range s.PieceHashes {
+		if have.Check(i) {
+			s.picker.done(i)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/data/mask"
+	"github.com/Sabir222/torrent-at-home/network/connector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeter(t *testing.T) {
+	var m meter
+	start := time.Unix(1000, 0)
+	m.add(500, start)
+	m.add(500, start.Add(time.Second))
+	assert.Equal(t, int64(200), m.rate(start.Add(2*time.Second)))
+	assert.Equal(t, int64(100), m.rate(start.Add(5*time.Second)))
+	assert.Equal(t, int64(0), m.rate(start.Add(time.Minute)))
+}
+
+func TestRestore(t *testing.T) {
+	s := &Session{PieceHashes: make([][20]byte, 3), PieceLength: 4, Length: 12}
+	have := mask.New(3)
+	have.Mark(0)
+	have.Mark(2)
+	s.Restore(have)
+
+	assert.True(t, s.HasPiece(2))
+	assert.False(t, s.HasPiece(1))
+	stats := s.Stats()
+	assert.Equal(t, 2, stats.Pieces)
+	assert.Equal(t, 3, stats.Wanted)
+	assert.Zero(t, stats.Downloaded)
+
+	index, ok := s.picker.next(func(int) bool { return true })
+	require.True(t, ok)
+	assert.Equal(t, 1, index)
+}
+
+func TestStatsPeersAndETA(t *testing.T) {
+	s := &Session{PieceHashes: make([][20]byte, 3), PieceLength: 4, Length: 10}
+	assert.Equal(t, time.Duration(-1), s.Stats().ETA)
+	assert.Equal(t, int64(10), s.Stats().Left)
+
+	seed := &PeerInfo{Addr: "seed", Seed: true}
+	choking := &PeerInfo{Addr: "choking", Choked: true}
+	s.connected[&connector.PeerConn{}] = seed
+	s.connected[&connector.PeerConn{}] = choking
+
+	s.markDone(0)
+	s.received(seed, 4)
+	s.hashFailed(2, choking)
+
+	stats := s.Stats()
+	assert.Equal(t, int64(6), stats.Left)
+	assert.Equal(t, 2, stats.Peers)
+	assert.Equal(t, 1, stats.Unchoked)
+	assert.Equal(t, 1, stats.Seeds)
+	assert.Equal(t, 1, stats.Leechers)
+	assert.Equal(t, int64(2), stats.Wasted)
+	// 4 bytes over the 5 second window rounds down to no rate.
+	assert.Equal(t, time.Duration(-1), stats.ETA)
+
+	s.received(seed, 40)
+	stats = s.Stats()
+	assert.Equal(t, int64(8), stats.DownRate)
+	assert.Equal(t, time.Second, stats.ETA)
+
+	rates := map[string]int64{}
+	for _, p := range s.ConnectedPeers() {
+		rates[p.Addr] = p.DownRate
+	}
+	assert.Equal(t, map[string]int64{"seed": 8, "choking": 0}, rates)
+
+	s.markDone(1)
+	s.markDone(2)
+	assert.Zero(t, s.Stats().ETA)
+}
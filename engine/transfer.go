@@ -1,23 +1,39 @@
 package engine
 
 import (
-	"bytes"
-	"crypto/sha1"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
-	"runtime"
+	"io"
+	"net/http"
+	"sync"
 	"time"
 
+	"github.com/Sabir222/torrent-at-home/data/hasher"
+	"github.com/Sabir222/torrent-at-home/data/mask"
+	"github.com/Sabir222/torrent-at-home/logging"
 	"github.com/Sabir222/torrent-at-home/network/connector"
 	"github.com/Sabir222/torrent-at-home/protocol/frames"
 	"github.com/Sabir222/torrent-at-home/network/endpoints"
+	"github.com/Sabir222/torrent-at-home/network/lsd"
+	"github.com/Sabir222/torrent-at-home/network/throttle"
+	"github.com/Sabir222/torrent-at-home/protocol/mse"
 )
 
 const (
 	DefaultChunkSize = 16384
-	MaxPending       = 5
+	idleDelay        = time.Second
 )
 
+var ErrNoStorage = errors.New("session has no storage")
+
+// Storage is where verified pieces are written and read back from.
+type Storage interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
 type Session struct {
 	Peers       []endpoints.Endpoint
 	PeerID      [20]byte
@@ -26,6 +42,75 @@ type Session struct {
 	PieceLength int
 	Length      int
 	Name        string
+	Files       []File
+
+	// Limits throttles this session alone, Global is shared with every
+	// other session in the process. Either may be nil.
+	Limits *throttle.Set
+	Global *throttle.Set
+
+	Storage Storage
+
+	// Encryption is the MSE mode for outgoing connections; FallbackPlain
+	// retries peers that fail the encrypted handshake in prefer mode.
+	Encryption    mse.Mode
+	FallbackPlain bool
+	// Listener, when set, feeds incoming connections for this torrent
+	// into the download.
+	Listener *connector.Listener
+	// Transports are tried in order for each peer, TCP only when empty.
+	Transports []connector.Transport
+	// LocalDiscovery, when set, announces the torrent on the LAN and
+	// dials the local peers it reports.
+	LocalDiscovery *lsd.Service
+	// LocalUnlimited exempts LAN peers from Limits and Global.
+	LocalUnlimited bool
+	// WebSeeds are HTTP servers downloaded from alongside the peers.
+	WebSeeds []WebSeed
+	// Verifier checks pieces of v2 and hybrid torrents against their
+	// merkle trees, in addition to any SHA-1 PieceHashes.
+	Verifier Verifier
+	// HybridHash is the truncated v2 infohash of a hybrid torrent, whose
+	// swarm is joined alongside InfoHash's. HybridPeers came from
+	// announcing it.
+	HybridHash  [20]byte
+	HybridPeers []endpoints.Endpoint
+	// Private torrents (BEP 27) only use peers from their trackers: no
+	// DHT, PEX or local discovery. See AllowSource.
+	Private bool
+	// Conns caps the peer connections, dialed and accepted; share one
+	// between sessions for a process wide limit.
+	Conns *ConnLimit
+	// Trackers holds the outcome of announcing to each tracker.
+	Trackers []TrackerStatus
+	// Events, when set, receives what happens to the session; share one
+	// between sessions to watch them all.
+	Events *Feed
+	// Hasher verifies pieces off the peer goroutines; hasher.Default
+	// when nil.
+	Hasher *hasher.Pool
+	// HTTP makes the web seed requests; http.DefaultClient's transport
+	// when nil.
+	HTTP *http.Client
+
+	once    sync.Once
+	picker  *picker
+	mu      sync.Mutex
+	have    mask.Mask
+	changed chan struct{}
+	known   map[string]bool
+	found   chan endpoints.Endpoint
+
+	connected  map[*connector.PeerConn]*PeerInfo
+	downloaded int64
+	down       meter
+
+	verified     int64
+	failed       int64
+	wasted       int64
+	hashFailures map[string]int64
+	halfOpen     int
+	diskWrites   Histogram
 }
 
 type job struct {
@@ -37,128 +122,395 @@ type job struct {
 type result struct {
 	index int
 	buf   []byte
+	// from is the peer the piece came from, nil for web seeds.
+	from *PeerInfo
 }
 
-type transferState struct {
-	index      int
-	conn       *connector.PeerConn
-	buf        []byte
-	received   int
-	requested  int
-	pending    int
+// piece is a piece being fetched from one peer.
+type piece struct {
+	job       *job
+	buf       []byte
+	received  int
+	requested int
+	// rejected holds offsets the peer refused, to be requested again.
+	rejected []int
 }
 
-func (s *transferState) processMsg() error {
-	msg, err := s.conn.Read()
-	if err != nil {
-		return err
+func (p *piece) blockSize(begin int) int {
+	size := DefaultChunkSize
+	if len(p.buf)-begin < size {
+		size = len(p.buf) - begin
 	}
+	return size
+}
 
-	if msg == nil {
-		return nil
-	}
+// allRequested tells whether every block has been asked for.
+func (p *piece) allRequested() bool {
+	return p.requested >= len(p.buf) && len(p.rejected) == 0
+}
 
-	switch msg.Type {
-	case frames.TypeUnchoke:
-		s.conn.Choked = false
-	case frames.TypeChoke:
-		s.conn.Choked = true
-	case frames.TypeHave:
-		idx, err := frames.ReadHave(msg)
-		if err != nil {
+// requestQueue is the pieces in flight on one connection. Blocks are
+// requested up to the connection's queue depth, moving on to the next
+// piece while the last blocks of the one before are still on their way,
+// so the link stays full across piece boundaries.
+type requestQueue struct {
+	session *Session
+	conn    *connector.PeerConn
+	pieces  []*piece
+	pending int
+}
+
+// fill requests blocks until the queue is as deep as the connection
+// wants, claiming more pieces once those in flight are fully requested.
+// A piece that cannot be requested yet, the peer choking us, is held
+// without claiming any further.
+func (q *requestQueue) fill() error {
+	depth := q.conn.QueueDepth()
+	for _, p := range q.pieces {
+		if err := q.request(p, depth); err != nil {
 			return err
 		}
-		s.conn.Bitfield.Mark(idx)
-	case frames.TypePiece:
-		n, err := frames.ReadPieceData(s.buf, s.index, msg)
-		if err != nil {
+	}
+	for q.pending < depth && q.allRequested() {
+		index, ok := q.session.pickFor(q.conn)
+		if !ok {
+			break
+		}
+		j := &job{index, q.session.PieceHashes[index], q.session.pieceSize(index)}
+		p := &piece{job: j, buf: make([]byte, j.length)}
+		q.pieces = append(q.pieces, p)
+		if !q.conn.CanRequest(index) {
+			break
+		}
+		if err := q.request(p, depth); err != nil {
 			return err
 		}
-		s.received += n
-		s.pending--
 	}
 	return nil
 }
 
-func fetchPiece(conn *connector.PeerConn, j *job) ([]byte, error) {
-	state := transferState{
-		index: j.index,
-		conn:  conn,
-		buf:   make([]byte, j.length),
+func (q *requestQueue) allRequested() bool {
+	for _, p := range q.pieces {
+		if !p.allRequested() {
+			return false
+		}
 	}
+	return true
+}
 
-	conn.Conn.SetDeadline(time.Now().Add(30 * time.Second))
-	defer conn.Conn.SetDeadline(time.Time{})
-
-	for state.received < j.length {
-		if !state.conn.Choked {
-			for state.pending < MaxPending && state.requested < j.length {
-				chunkSize := DefaultChunkSize
-				if j.length-state.requested < chunkSize {
-					chunkSize = j.length - state.requested
-				}
+func (q *requestQueue) request(p *piece, depth int) error {
+	if !q.conn.CanRequest(p.job.index) {
+		return nil
+	}
+	for q.pending < depth && len(p.rejected) > 0 {
+		begin := p.rejected[0]
+		p.rejected = p.rejected[1:]
+		if err := q.conn.SendRequest(p.job.index, begin, p.blockSize(begin)); err != nil {
+			return err
+		}
+		q.pending++
+	}
+	for q.pending < depth && p.requested < len(p.buf) {
+		size := p.blockSize(p.requested)
+		if err := q.conn.SendRequest(p.job.index, p.requested, size); err != nil {
+			return err
+		}
+		q.pending++
+		p.requested += size
+	}
+	return nil
+}
 
-				err := conn.SendRequest(j.index, state.requested, chunkSize)
-				if err != nil {
-					return nil, err
-				}
-				state.pending++
-				state.requested += chunkSize
-			}
+func (q *requestQueue) find(index int) *piece {
+	for _, p := range q.pieces {
+		if p.job.index == index {
+			return p
 		}
+	}
+	return nil
+}
+
+// processMsg reads one message and returns the piece it completed, if any.
+func (q *requestQueue) processMsg() (*piece, error) {
+	msg, err := q.conn.Read()
+	if err != nil || msg == nil {
+		return nil, err
+	}
 
-		err := state.processMsg()
+	switch msg.Type {
+	case frames.TypePiece:
+		if len(msg.Data) < 8 {
+			return nil, frames.ErrPayloadTooShort
+		}
+		idx := int(binary.BigEndian.Uint32(msg.Data[0:4]))
+		p := q.find(idx)
+		if p == nil {
+			return nil, nil
+		}
+		n, err := frames.ReadPieceData(p.buf, idx, msg)
+		if err != nil {
+			return nil, err
+		}
+		p.received += n
+		q.pending--
+		if p.received < len(p.buf) {
+			return nil, nil
+		}
+		q.remove(p)
+		return p, nil
+	case frames.TypeReject:
+		idx, begin, _, err := frames.ReadRequest(msg)
 		if err != nil {
 			return nil, err
 		}
+		if p := q.find(idx); p != nil {
+			q.pending--
+			p.rejected = append(p.rejected, begin)
+		}
+	default:
+		return nil, q.session.serveFrame(q.conn, msg)
 	}
+	return nil, nil
+}
 
-	return state.buf, nil
+func (q *requestQueue) remove(p *piece) {
+	for i, other := range q.pieces {
+		if other == p {
+			q.pieces = append(q.pieces[:i], q.pieces[i+1:]...)
+			return
+		}
+	}
 }
 
-func verifyPiece(j *job, buf []byte) error {
-	hash := sha1.Sum(buf)
-	if !bytes.Equal(hash[:], j.hash[:]) {
+// release hands the unfinished pieces back to the picker.
+func (q *requestQueue) release() {
+	for _, p := range q.pieces {
+		q.session.picker.release(p.job.index)
+	}
+	q.pieces = nil
+}
+
+// serveFrame handles peer messages unrelated to the block being fetched.
+// Choke state and piece availability are already tracked by the connector.
+func (s *Session) serveFrame(conn *connector.PeerConn, msg *frames.Frame) error {
+	switch msg.Type {
+	case frames.TypeHashRequest:
+		return s.serveHashes(conn, msg)
+	case frames.TypeRequest:
+		// Nothing is served yet, so fast peers get an explicit refusal.
+		if conn.Fast {
+			idx, begin, length, err := frames.ReadRequest(msg)
+			if err != nil {
+				return err
+			}
+			return conn.SendReject(idx, begin, length)
+		}
+	}
+	return nil
+}
+
+// verifyPiece checks a piece against the v2 verifier if there is one and
+// against its SHA-1 hash unless that is unknown, as in v2 only torrents.
+func (s *Session) verifyPiece(j *job, buf []byte) error {
+	if s.Verifier != nil {
+		if err := s.Verifier.VerifyPiece(j.index, buf); err != nil {
+			return err
+		}
+	} else if j.hash == ([20]byte{}) {
+		return fmt.Errorf("piece %d has no hash", j.index)
+	}
+	if j.hash == ([20]byte{}) {
+		return nil
+	}
+	if hasher.SHA1(buf) != j.hash {
 		return fmt.Errorf("piece %d failed validation", j.index)
 	}
 	return nil
 }
 
-func (s *Session) spawnWorker(peer endpoints.Endpoint, jobs chan *job, results chan *result) {
-	conn, err := connector.Connect(peer, s.PeerID, s.InfoHash)
+func (s *Session) hashPool() *hasher.Pool {
+	if s.Hasher != nil {
+		return s.Hasher
+	}
+	return hasher.Default()
+}
+
+func (s *Session) dialer() *connector.Dialer {
+	d := &connector.Dialer{
+		PieceCount:    len(s.PieceHashes),
+		Have:          s.Bitfield,
+		Encryption:    s.Encryption,
+		FallbackPlain: s.FallbackPlain,
+		Transports:    s.Transports,
+
+		LocalUnlimited: s.LocalUnlimited,
+		V2:             s.Verifier != nil,
+	}
+	for _, set := range []*throttle.Set{s.limits(), s.Global} {
+		if set == nil {
+			continue
+		}
+		d.Down = append(d.Down, set.Down)
+		d.Up = append(d.Up, set.Up)
+	}
+	return d
+}
+
+// SetLimits changes the per-session rates while the download is running.
+func (s *Session) SetLimits(l throttle.Limits) {
+	s.limits().SetLimits(l)
+}
+
+// limits is the session's own throttle, made unlimited on first use when
+// Limits was left nil. The set is never replaced, so peers that took it
+// follow later SetLimits calls.
+func (s *Session) limits() *throttle.Set {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Limits == nil {
+		s.Limits = throttle.NewSet(0, 0)
+	}
+	return s.Limits
+}
+
+func (s *Session) spawnWorker(peer endpoints.Endpoint, infoHash [20]byte, results chan *result, done chan struct{}) {
+	if !s.Conns.acquire(done) {
+		return
+	}
+	defer s.Conns.release()
+
+	dialed := s.dialing()
+	conn, err := s.dialer().Connect(peer, s.PeerID, infoHash)
+	dialed()
 	if err != nil {
-		log.Printf("[peer] ✗ %s unreachable\n", peer.Addr)
+		logging.Debugf("[peer] ✗ %s unreachable\n", peer.Addr)
 		return
 	}
+	logging.Debugf("[peer] ✓ connected to %s\n", peer.Addr)
+	s.runPeer(conn, results, done)
+}
+
+// runPeer downloads from an established connection, dialed or accepted,
+// until the session is finished or the peer fails.
+func (s *Session) runPeer(conn *connector.PeerConn, results chan *result, done chan struct{}) {
 	defer conn.Conn.Close()
-	log.Printf("[peer] ✓ connected to %s\n", peer.Addr)
+	peer := conn.Peer()
+	info, forget := s.connect(conn)
+	defer forget()
+
+	// Unblock reads when the session stops.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-done:
+			conn.Conn.Close()
+		case <-stop:
+		}
+	}()
 
 	conn.SendUnchoke()
 	conn.SendInterested()
 
-	for j := range jobs {
-		if !conn.Bitfield.Check(j.index) {
-			jobs <- j
+	var haves haveQueue
+	q := &requestQueue{session: s, conn: conn}
+	defer q.release()
+	for !s.picker.finished() {
+		for _, index := range haves.take() {
+			conn.SendHave(index)
+		}
+		s.observe(info, conn)
+		if err := q.fill(); err != nil {
+			logging.Debugf("[peer] fetch error: %v\n", err)
+			return
+		}
+		if len(q.pieces) == 0 {
+			// Nothing this peer has is still needed. Keep reading so its
+			// bitfield and Have messages can change that.
+			msg, err := conn.Poll(idleDelay)
+			if err != nil {
+				logging.Debugf("[peer] %s dropped: %v\n", peer.Addr, err)
+				return
+			}
+			if msg != nil {
+				if err := s.serveFrame(conn, msg); err != nil {
+					return
+				}
+			}
 			continue
 		}
 
-		buf, err := fetchPiece(conn, j)
+		conn.Conn.SetDeadline(time.Now().Add(30 * time.Second))
+		p, err := q.processMsg()
+		conn.Conn.SetDeadline(time.Time{})
 		if err != nil {
-			log.Printf("[peer] fetch error: %v\n", err)
-			jobs <- j
+			logging.Debugf("[peer] fetch error: %v\n", err)
 			return
 		}
-
-		err = verifyPiece(j, buf)
-		if err != nil {
-			log.Printf("piece %d corrupted\n", j.index)
-			jobs <- j
+		if p == nil {
 			continue
 		}
+		j, buf := p.job, p.buf
+
+		// The piece is hashed on the pool while the next ones are
+		// fetched; a full pool holds the peer back.
+		queued := s.hashPool().Submit(func() {
+			if err := s.verifyPiece(j, buf); err != nil {
+				logging.Infof("piece %d corrupted\n", j.index)
+				s.hashFailed(j.index, info)
+				s.picker.release(j.index)
+				return
+			}
+			haves.add(j.index)
+			select {
+			case results <- &result{j.index, buf, info}:
+			case <-done:
+				s.picker.release(j.index)
+			}
+		}, done)
+		if !queued {
+			s.picker.release(j.index)
+			return
+		}
+	}
+}
+
+// haveQueue holds the pieces a peer sent that have been verified, for the
+// peer's goroutine, the only one writing to its connection, to announce.
+type haveQueue struct {
+	mu     sync.Mutex
+	pieces []int
+}
+
+func (q *haveQueue) add(index int) {
+	q.mu.Lock()
+	q.pieces = append(q.pieces, index)
+	q.mu.Unlock()
+}
 
-		conn.SendHave(j.index)
-		results <- &result{j.index, buf}
+func (q *haveQueue) take() []int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pieces := q.pieces
+	q.pieces = nil
+	return pieces
+}
+
+// pickFor claims the next piece for a peer, honouring its suggestions and,
+// while it chokes us, the pieces it allowed fast.
+func (s *Session) pickFor(conn *connector.PeerConn) (int, bool) {
+	for _, index := range conn.Suggestions() {
+		if conn.Bitfield.Check(index) && s.picker.claim(index) {
+			return index, true
+		}
+	}
+	if conn.Choked {
+		allowed := func(i int) bool { return conn.AllowedFast(i) && conn.Bitfield.Check(i) }
+		if index, ok := s.picker.next(allowed); ok {
+			return index, true
+		}
 	}
+	return s.picker.next(conn.Bitfield.Check)
 }
 
 func (s *Session) pieceRange(index int) (begin int, end int) {
@@ -175,36 +527,152 @@ func (s *Session) pieceSize(index int) int {
 	return end - begin
 }
 
-func (s *Session) Download() ([]byte, error) {
-	log.Printf("[session] starting download: %s\n", s.Name)
-	log.Printf("[session] %d piece(s), %d peer(s) available\n", len(s.PieceHashes), len(s.Peers))
+// setup prepares the piece state so readers and mode changes can be made
+// before Download starts.
+func (s *Session) setup() {
+	s.once.Do(func() {
+		s.picker = newPicker(len(s.PieceHashes))
+		s.have = mask.New(len(s.PieceHashes))
+		s.changed = make(chan struct{})
+		s.known = make(map[string]bool)
+		s.found = make(chan endpoints.Endpoint, foundBacklog)
+		s.connected = make(map[*connector.PeerConn]*PeerInfo)
+		s.hashFailures = make(map[string]int64)
+		s.diskWrites = newHistogram(diskWriteBounds)
+		s.applyPriorities()
+	})
+}
+
+// SetSequential switches the picker between index order and downloading
+// outward from the read head, which suits streaming.
+func (s *Session) SetSequential(on bool) {
+	s.setup()
+	s.picker.setSequential(on)
+}
+
+func (s *Session) Sequential() bool {
+	s.setup()
+	return s.picker.isSequential()
+}
+
+func (s *Session) markDone(index int) {
+	s.mu.Lock()
+	s.have.Mark(index)
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+
+	s.picker.done(index)
+}
+
+// Bitfield returns a snapshot of the verified pieces.
+func (s *Session) Bitfield() mask.Mask {
+	s.setup()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(mask.Mask(nil), s.have...)
+}
+
+// HasPiece reports whether a piece has been verified and written.
+func (s *Session) HasPiece(index int) bool {
+	s.setup()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.have.Check(index)
+}
+
+// Download runs the session until every wanted piece is written.
+func (s *Session) Download() error {
+	return s.Run(context.Background())
+}
+
+// Run downloads until every wanted piece is written or ctx is cancelled,
+// in which case it returns ctx.Err(). Verified pieces are kept, so a
+// stopped session can be paused and resumed by calling Run again.
+func (s *Session) Run(ctx context.Context) error {
+	if s.Storage == nil {
+		return ErrNoStorage
+	}
+	s.setup()
+	s.mu.Lock()
+	s.known = make(map[string]bool)
+	s.mu.Unlock()
+
+	logging.Infof("[session] starting download: %s\n", s.Name)
+	logging.Infof("[session] %d piece(s), %d peer(s), %d web seed(s) available\n", len(s.PieceHashes), len(s.Peers), len(s.WebSeeds))
 
-	jobs := make(chan *job, len(s.PieceHashes))
 	results := make(chan *result)
+	done := make(chan struct{})
+	defer close(done)
+
+	if s.Listener != nil {
+		for _, hash := range s.swarms() {
+			s.Listener.Register(hash, s.PeerID, s.dialer(), func(conn *connector.PeerConn) {
+				if !s.Conns.tryAcquire() {
+					conn.Conn.Close()
+					return
+				}
+				go func() {
+					defer s.Conns.release()
+					s.runPeer(conn, results, done)
+				}()
+			})
+			defer s.Listener.Unregister(hash)
+		}
+	}
 
-	for index, hash := range s.PieceHashes {
-		length := s.pieceSize(index)
-		jobs <- &job{index, hash, length}
+	if s.LocalDiscovery != nil && s.AllowSource(SourceLSD) {
+		s.LocalDiscovery.Register(s.InfoHash, func(peer endpoints.Endpoint) {
+			s.AddPeers(SourceLSD, peer)
+		})
+		defer s.LocalDiscovery.Unregister(s.InfoHash)
 	}
 
-	for _, peer := range s.Peers {
-		go s.spawnWorker(peer, jobs, results)
+	for _, seed := range s.WebSeeds {
+		go s.runWebSeed(seed, results, done)
 	}
 
-	buf := make([]byte, s.Length)
-	completed := 0
-	for completed < len(s.PieceHashes) {
-		res := <-results
-		begin, end := s.pieceRange(res.index)
-		copy(buf[begin:end], res.buf)
-		completed++
+	for _, peer := range orderPeers(s.Peers) {
+		if s.remember(peer) {
+			go s.spawnWorker(peer, s.InfoHash, results, done)
+		}
+	}
+	for _, peer := range orderPeers(s.HybridPeers) {
+		if s.remember(peer) {
+			go s.spawnWorker(peer, s.HybridHash, results, done)
+		}
+	}
 
-		pct := float64(completed) / float64(len(s.PieceHashes)) * 100
-		workers := runtime.NumGoroutine() - 1
-		log.Printf("[progress] [%5.1f%%] ✓ piece %d (%d worker(s))\n", pct, res.index, workers)
+	for !s.picker.finished() {
+		var res *result
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case peer := <-s.found:
+			go s.spawnWorker(peer, s.InfoHash, results, done)
+			continue
+		case res = <-results:
+		}
+		begin, _ := s.pieceRange(res.index)
+		start := time.Now()
+		if _, err := s.Storage.WriteAt(res.buf, int64(begin)); err != nil {
+			s.publish(Event{Kind: EventError, Piece: res.index, Err: err})
+			return err
+		}
+		s.wrote(time.Since(start))
+		s.markDone(res.index)
+		s.received(res.from, len(res.buf))
+
+		completed, wanted := s.picker.progress()
+		verified := Event{Kind: EventPieceVerified, Piece: res.index, Done: completed, Wanted: wanted}
+		if res.from != nil {
+			verified.Peer = res.from.Addr
+		}
+		s.publish(verified)
 	}
-	close(jobs)
 
-	log.Printf("[session] ✓ download complete: %d piece(s)\n", completed)
-	return buf, nil
+	completed, wanted := s.picker.progress()
+	logging.Infof("[session] ✓ download complete: %d piece(s)\n", completed)
+	s.publish(Event{Kind: EventCompleted, Done: completed, Wanted: wanted})
+	return nil
 }
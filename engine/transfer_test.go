@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/network/connector"
+	"github.com/Sabir222/torrent-at-home/network/endpoints"
+	"github.com/Sabir222/torrent-at-home/network/throttle"
+	"github.com/Sabir222/torrent-at-home/protocol/frames"
+	"github.com/Sabir222/torrent-at-home/protocol/greeting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunResume(t *testing.T) {
+	content := []byte("resumable")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	out := make(memStorage, len(content))
+	s := &Session{
+		PieceHashes: [][20]byte{sha1.Sum(content)},
+		PieceLength: len(content),
+		Length:      len(content),
+		Storage:     out,
+	}
+
+	// Nothing to download from: Run waits until it is stopped.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Run(ctx), context.DeadlineExceeded)
+	assert.False(t, s.HasPiece(0))
+
+	s.WebSeeds = []WebSeed{{URLs: []string{srv.URL}}}
+	require.NoError(t, s.Run(context.Background()))
+	assert.Equal(t, content, []byte(out))
+
+	stats := s.Stats()
+	assert.Equal(t, 1, stats.Pieces)
+	assert.Equal(t, int64(len(content)), stats.Downloaded)
+	assert.Positive(t, stats.DownRate)
+}
+
+func TestSetLimitsKeepsSet(t *testing.T) {
+	s := &Session{PieceHashes: make([][20]byte, 1), PieceLength: 1, Length: 1}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.SetLimits(throttle.Limits{Down: 1024})
+		}()
+		go func() {
+			defer wg.Done()
+			s.dialer()
+		}()
+	}
+	wg.Wait()
+	set := s.Limits
+	s.SetLimits(throttle.Limits{Down: 2048})
+	assert.Same(t, set, s.Limits)
+	assert.Equal(t, 2048, s.Limits.Limits().Down)
+}
+
+// heldSeed serves content as three pieces, but only once the first held
+// requests have arrived, and sends back the pieces those asked for.
+func heldSeed(ln net.Listener, infoHash [20]byte, content []byte, pieceLength, held int, first chan<- []int) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if _, err := greeting.Unpack(conn); err != nil {
+		return
+	}
+	conn.Write(greeting.Build(infoHash, [20]byte{'s'}).Pack())
+	conn.Write((&frames.Frame{Type: frames.TypeBitfield, Data: []byte{0xe0}}).Pack())
+	conn.Write((&frames.Frame{Type: frames.TypeUnchoke}).Pack())
+
+	var queued []*frames.Frame
+	var indexes []int
+	for {
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		frm, err := frames.Unpack(conn)
+		if err != nil {
+			return
+		}
+		if frm == nil || frm.Type != frames.TypeRequest {
+			continue
+		}
+		queued = append(queued, frm)
+		if len(indexes) < held {
+			index, _, _, _ := frames.ReadRequest(frm)
+			indexes = append(indexes, index)
+			if len(indexes) < held {
+				continue
+			}
+			first <- indexes
+		}
+		for _, req := range queued {
+			index, begin, length, _ := frames.ReadRequest(req)
+			data := make([]byte, 8+length)
+			binary.BigEndian.PutUint32(data[0:4], uint32(index))
+			binary.BigEndian.PutUint32(data[4:8], uint32(begin))
+			at := index*pieceLength + begin
+			copy(data[8:], content[at:at+length])
+			conn.Write((&frames.Frame{Type: frames.TypePiece, Data: data}).Pack())
+		}
+		queued = nil
+	}
+}
+
+func TestPipelineCrossesPieces(t *testing.T) {
+	// Two blocks a piece, so the default queue depth spans three pieces.
+	pieceLength := 2 * DefaultChunkSize
+	content := make([]byte, 2*pieceLength+100)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	var hashes [][20]byte
+	for at := 0; at < len(content); at += pieceLength {
+		end := at + pieceLength
+		if end > len(content) {
+			end = len(content)
+		}
+		hashes = append(hashes, sha1.Sum(content[at:end]))
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	infoHash := [20]byte{'q'}
+	first := make(chan []int, 1)
+	go heldSeed(ln, infoHash, content, pieceLength, connector.DefaultQueueDepth, first)
+
+	addr := ln.Addr().(*net.TCPAddr)
+	out := make(memStorage, len(content))
+	s := &Session{
+		Peers:       []endpoints.Endpoint{{Addr: addr.IP, Port: uint16(addr.Port)}},
+		InfoHash:    infoHash,
+		PieceHashes: hashes,
+		PieceLength: pieceLength,
+		Length:      len(content),
+		Storage:     out,
+	}
+	require.NoError(t, s.Download())
+	assert.Equal(t, content, []byte(out))
+
+	// Nothing had been sent back, so piece 0 was incomplete when the
+	// requests for the pieces after it went out.
+	assert.Equal(t, []int{0, 0, 1, 1, 2}, <-first)
+}
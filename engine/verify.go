@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"github.com/Sabir222/torrent-at-home/network/connector"
+	"github.com/Sabir222/torrent-at-home/protocol/frames"
+)
+
+// Verifier checks a downloaded piece by index.
+type Verifier interface {
+	VerifyPiece(index int, data []byte) error
+}
+
+// HashSource is implemented by verifiers that can answer BEP 52 hash
+// requests from the hashes they hold.
+type HashSource interface {
+	Hashes(req frames.HashRequest) ([][32]byte, error)
+}
+
+// serveHashes answers a hash request, rejecting it when the hashes are not
+// available.
+func (s *Session) serveHashes(conn *connector.PeerConn, msg *frames.Frame) error {
+	req, err := frames.ReadHashRequest(msg)
+	if err != nil {
+		return err
+	}
+	src, ok := s.Verifier.(HashSource)
+	if !ok {
+		return conn.SendHashReject(req)
+	}
+	hashes, err := src.Hashes(req)
+	if err != nil {
+		return conn.SendHashReject(req)
+	}
+	return conn.SendHashes(req, hashes)
+}
+
+// swarms lists the infohashes the session takes part under.
+func (s *Session) swarms() [][20]byte {
+	if s.HybridHash == ([20]byte{}) {
+		return [][20]byte{s.InfoHash}
+	}
+	return [][20]byte{s.InfoHash, s.HybridHash}
+}
@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"crypto/sha1"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type verifierFunc func(int, []byte) error
+
+func (f verifierFunc) VerifyPiece(index int, data []byte) error { return f(index, data) }
+
+func TestVerifyPiece(t *testing.T) {
+	data := []byte("piece")
+	good := &job{index: 0, hash: sha1.Sum(data)}
+	unknown := &job{index: 0}
+	errBad := errors.New("bad")
+
+	cases := map[string]struct {
+		verifier Verifier
+		job      *job
+		fail     bool
+	}{
+		"sha1 only":           {job: good},
+		"no hash at all":      {job: unknown, fail: true},
+		"v2 only":             {verifier: verifierFunc(func(int, []byte) error { return nil }), job: unknown},
+		"v2 rejects":          {verifier: verifierFunc(func(int, []byte) error { return errBad }), job: good, fail: true},
+		"hybrid sha1 rejects": {verifier: verifierFunc(func(int, []byte) error { return nil }), job: &job{hash: [20]byte{1}}, fail: true},
+	}
+	for name, c := range cases {
+		s := &Session{Verifier: c.verifier}
+		err := s.verifyPiece(c.job, data)
+		if c.fail {
+			assert.Error(t, err, name)
+		} else {
+			assert.NoError(t, err, name)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/logging"
+)
+
+const (
+	// webSeedBackoff is the first delay after a failed web seed request,
+	// doubled on each further failure up to webSeedMaxBackoff.
+	webSeedBackoff    = 5 * time.Second
+	webSeedMaxBackoff = 5 * time.Minute
+	webSeedTimeout    = 60 * time.Second
+)
+
+// errNoRanges means a web seed ignored a Range request past the start of a
+// file. Reading up to the offset instead would fetch the file's prefix again
+// for every piece, so the seed is dropped.
+var errNoRanges = errors.New("web seed does not support range requests")
+
+// WebSeed is an HTTP server holding the torrent's content (BEP 19). URLs
+// has one entry per file of the session, in the same order.
+type WebSeed struct {
+	URLs []string
+}
+
+// runWebSeed downloads pieces from a web seed as if it were a peer that
+// has everything, backing off while requests fail.
+func (s *Session) runWebSeed(seed WebSeed, results chan *result, done chan struct{}) {
+	var client http.Client
+	if s.HTTP != nil {
+		client = *s.HTTP
+	}
+	client.Timeout = webSeedTimeout
+	failures := 0
+
+	for !s.picker.finished() {
+		index, ok := s.picker.next(func(int) bool { return true })
+		if !ok {
+			if !sleep(idleDelay, done) {
+				return
+			}
+			continue
+		}
+		j := &job{index, s.PieceHashes[index], s.pieceSize(index)}
+
+		buf, err := s.fetchWeb(&client, seed, index)
+		if err == nil {
+			if !s.hashPool().Do(func() { err = s.verifyPiece(j, buf) }, done) {
+				s.picker.release(index)
+				return
+			}
+			if err != nil {
+				s.hashFailed(index, nil)
+			}
+		}
+		if errors.Is(err, errNoRanges) {
+			s.picker.release(index)
+			logging.Infof("[webseed] %v, dropping it\n", err)
+			return
+		}
+		if err != nil {
+			s.picker.release(index)
+			delay := webSeedBackoff << failures
+			if delay > webSeedMaxBackoff || delay <= 0 {
+				delay = webSeedMaxBackoff
+			} else {
+				failures++
+			}
+			logging.Debugf("[webseed] ✗ piece %d: %v, retrying in %v\n", index, err, delay)
+			if !sleep(delay, done) {
+				return
+			}
+			continue
+		}
+		failures = 0
+
+		select {
+		case results <- &result{index, buf, nil}:
+		case <-done:
+			s.picker.release(index)
+			return
+		}
+	}
+}
+
+// fetchWeb reads a piece with one Range request per file it overlaps.
+func (s *Session) fetchWeb(client *http.Client, seed WebSeed, index int) ([]byte, error) {
+	begin, end := s.pieceRange(index)
+	buf := make([]byte, end-begin)
+
+	for i, f := range s.files() {
+		from, to := max(begin, f.Offset), min(end, f.Offset+f.Length)
+		if from >= to || f.Padding {
+			continue
+		}
+		if i >= len(seed.URLs) {
+			return nil, fmt.Errorf("web seed has no URL for file %d", i)
+		}
+		if err := fetchRange(client, seed.URLs[i], from-f.Offset, buf[from-begin:to-begin]); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func fetchRange(client *http.Client, url string, offset int, dst []byte) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+len(dst)-1))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		var start int
+		contentRange := resp.Header.Get("Content-Range")
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-", &start); err != nil || start != offset {
+			return fmt.Errorf("%s: got range %q for offset %d", url, contentRange, offset)
+		}
+	case http.StatusOK:
+		// The whole file is only good when it starts where we asked.
+		if offset > 0 {
+			return fmt.Errorf("%s: %w", url, errNoRanges)
+		}
+	default:
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	_, err = io.ReadFull(resp.Body, dst)
+	return err
+}
+
+// sleep waits for d and reports false if done was closed first.
+func sleep(d time.Duration, done chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-done:
+		return false
+	}
+}
@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSeedDownload(t *testing.T) {
+	content := []byte("abcdefghijklm")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "a", time.Time{}, bytes.NewReader(content[:6]))
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "b", time.Time{}, bytes.NewReader(content[6:]))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	const pieceLength = 4
+	var hashes [][20]byte
+	for i := 0; i < len(content); i += pieceLength {
+		hashes = append(hashes, sha1.Sum(content[i:min(i+pieceLength, len(content))]))
+	}
+
+	out := make(memStorage, len(content))
+	s := &Session{
+		PieceHashes: hashes,
+		PieceLength: pieceLength,
+		Length:      len(content),
+		Files: []File{
+			{Path: "a", Offset: 0, Length: 6, Priority: PriorityNormal},
+			{Path: "b", Offset: 6, Length: 7, Priority: PriorityNormal},
+		},
+		WebSeeds: []WebSeed{{URLs: []string{srv.URL + "/a", srv.URL + "/b"}}},
+		Storage:  out,
+	}
+
+	require.NoError(t, s.Download())
+	assert.Equal(t, content, []byte(out))
+}
+
+type countingTransport struct {
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.requests++
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestWebSeedHTTPClient(t *testing.T) {
+	content := []byte("piece")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	transport := &countingTransport{}
+	s := &Session{
+		PieceHashes: [][20]byte{sha1.Sum(content)},
+		PieceLength: len(content),
+		Length:      len(content),
+		WebSeeds:    []WebSeed{{URLs: []string{srv.URL}}},
+		Storage:     make(memStorage, len(content)),
+		HTTP:        &http.Client{Transport: transport},
+	}
+	require.NoError(t, s.Download())
+	assert.Equal(t, 1, transport.requests)
+}
+
+func TestFetchWebError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	s := &Session{PieceHashes: make([][20]byte, 1), PieceLength: 4, Length: 4, Name: "x"}
+	_, err := s.fetchWeb(http.DefaultClient, WebSeed{URLs: []string{srv.URL + "/x"}}, 0)
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestWebSeedIgnoresRange(t *testing.T) {
+	content := []byte("abcdefghijklm")
+	var whole atomic.Int32
+	mux := http.NewServeMux()
+	// whole ignores Range and always sends the whole file.
+	mux.HandleFunc("/whole", func(w http.ResponseWriter, r *http.Request) {
+		whole.Add(1)
+		w.Write(content)
+	})
+	mux.HandleFunc("/ranged", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "ranged", time.Time{}, bytes.NewReader(content))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	const pieceLength = 4
+	var hashes [][20]byte
+	for i := 0; i < len(content); i += pieceLength {
+		hashes = append(hashes, sha1.Sum(content[i:min(i+pieceLength, len(content))]))
+	}
+	out := make(memStorage, len(content))
+	s := &Session{
+		PieceHashes: hashes,
+		PieceLength: pieceLength,
+		Length:      len(content),
+		Files:       []File{{Path: "f", Length: len(content), Priority: PriorityNormal}},
+		WebSeeds: []WebSeed{
+			{URLs: []string{srv.URL + "/whole"}},
+			{URLs: []string{srv.URL + "/ranged"}},
+		},
+		Storage: out,
+	}
+
+	require.NoError(t, s.Download())
+	assert.Equal(t, content, []byte(out))
+	// Only the first piece can come from the start of the whole file; the
+	// seed is dropped on its first piece past it.
+	assert.LessOrEqual(t, whole.Load(), int32(2))
+}
+
+func TestFetchRangeChecksContentRange(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-3/13")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("abcd"))
+	}))
+	defer srv.Close()
+
+	buf := make([]byte, 4)
+	assert.NoError(t, fetchRange(http.DefaultClient, srv.URL, 0, buf))
+	assert.ErrorContains(t, fetchRange(http.DefaultClient, srv.URL, 4, buf), "for offset 4")
+
+	whole := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("abcdefgh"))
+	}))
+	defer whole.Close()
+	assert.NoError(t, fetchRange(http.DefaultClient, whole.URL, 0, buf))
+	assert.ErrorIs(t, fetchRange(http.DefaultClient, whole.URL, 4, buf), errNoRanges)
+}
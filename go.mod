@@ -5,10 +5,10 @@ go 1.21
 require (
 	github.com/jackpal/bencode-go v0.0.0-20180813173944-227668e840fa
 	github.com/stretchr/testify v1.8.4
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,62 @@
+// Package logging writes log lines at a level chosen where they are
+// logged, through the standard log package, dropping those below the
+// level set for the process.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// Level orders log lines by importance.
+type Level int32
+
+const (
+	// Debug is for single peers, packets and retries.
+	Debug Level = iota
+	// Info is for what a torrent or the process as a whole is doing.
+	Info
+	// Error is for something that failed.
+	Error
+)
+
+// min is the least important level written, Info by default.
+var min atomic.Int32
+
+func init() {
+	min.Store(int32(Info))
+}
+
+// ParseLevel reads debug, info or error.
+func ParseLevel(s string) (Level, error) {
+	switch s {
+	case "debug":
+		return Debug, nil
+	case "info":
+		return Info, nil
+	case "error":
+		return Error, nil
+	}
+	return 0, fmt.Errorf("bad log level %q, want debug, info or error", s)
+}
+
+// SetLevel drops the lines below l from now on.
+func SetLevel(l Level) {
+	min.Store(int32(l))
+}
+
+// Enabled tells whether lines at l are written.
+func Enabled(l Level) bool {
+	return int32(l) >= min.Load()
+}
+
+func Debugf(format string, args ...any) { output(Debug, format, args) }
+func Infof(format string, args ...any)  { output(Info, format, args) }
+func Errorf(format string, args ...any) { output(Error, format, args) }
+
+func output(l Level, format string, args []any) {
+	if Enabled(l) {
+		log.Output(3, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+		SetLevel(Info)
+	}()
+
+	Debugf("[peer] connected to %s", "a")
+	Infof("[session] starting %s", "b")
+	Errorf("[client] %s failed", "c")
+	assert.Equal(t, "[session] starting b\n[client] c failed\n", buf.String())
+
+	buf.Reset()
+	level, err := ParseLevel("error")
+	require.NoError(t, err)
+	SetLevel(level)
+	Infof("dropped")
+	Errorf("kept")
+	assert.Equal(t, "kept\n", buf.String())
+	assert.False(t, Enabled(Debug))
+
+	_, err = ParseLevel("loud")
+	assert.Error(t, err)
+}
@@ -1,31 +1,84 @@
 package connector
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"net"
 	"time"
 
 	"github.com/Sabir222/torrent-at-home/data/mask"
+	"github.com/Sabir222/torrent-at-home/protocol/extension"
 	"github.com/Sabir222/torrent-at-home/protocol/greeting"
 	"github.com/Sabir222/torrent-at-home/protocol/frames"
 	"github.com/Sabir222/torrent-at-home/network/endpoints"
+	"github.com/Sabir222/torrent-at-home/network/throttle"
+	"github.com/Sabir222/torrent-at-home/protocol/mse"
+)
+
+// Dialer holds the options used to open peer connections. The zero value
+// dials without any rate limiting.
+type Dialer struct {
+	Down []*throttle.Bucket
+	Up   []*throttle.Bucket
+	// PieceCount sizes the mask built from a Have All message.
+	PieceCount int
+	// Have returns the pieces we can offer, announced right after the
+	// handshake. Nil means we have nothing yet.
+	Have func() mask.Mask
+	// Encryption selects Message Stream Encryption for outgoing
+	// connections. With FallbackPlain a failed encrypted attempt in
+	// prefer mode is retried as a plain connection.
+	Encryption    mse.Mode
+	FallbackPlain bool
+	// Transports are tried in order until one connects. Nil means TCP
+	// only.
+	Transports []Transport
+	// LocalUnlimited exempts peers on the local network from Down and Up.
+	LocalUnlimited bool
+	// V2 advertises BitTorrent v2 support for v2 and hybrid torrents.
+	V2 bool
+	// Extensions are the BEP 10 messages we accept, by name, with the
+	// ids peers should send them under.
+	Extensions map[string]int
+}
+
+const (
+	// localReqq is the request queue depth advertised to peers.
+	localReqq = 250
+	// encryptTimeout bounds the MSE key exchange, which costs an extra
+	// round trip and some padding before the BitTorrent handshake.
+	encryptTimeout = 10 * time.Second
 )
 
 type PeerConn struct {
 	Conn     net.Conn
 	Choked   bool
 	Bitfield mask.Mask
-	peer     endpoints.Endpoint
-	infoHash [20]byte
-	peerID   [20]byte
+	// Extensions is the peer's extended handshake, nil until received.
+	Extensions *extension.Handshake
+	peer       endpoints.Endpoint
+	infoHash   [20]byte
+	peerID     [20]byte
+	// Fast is set when both sides negotiated the Fast extension (BEP 6).
+	Fast       bool
+	// V2 is set when both sides support BitTorrent v2 hash messages.
+	V2         bool
+	reader     *bufio.Reader
+	pieceCount int
+	stateSeen  bool
+	remote     *greeting.Greeting
+	pipe       *pipeline
+	allowed    map[int]bool
+	suggested  []int
 }
 
-func doHandshake(conn net.Conn, infohash, peerID [20]byte) (*greeting.Greeting, error) {
+func doHandshake(conn net.Conn, outbound *greeting.Greeting) (*greeting.Greeting, error) {
 	conn.SetDeadline(time.Now().Add(3 * time.Second))
 	defer conn.SetDeadline(time.Time{})
 
-	outbound := greeting.Build(infohash, peerID)
+	infohash := outbound.Hash
 	if _, err := conn.Write(outbound.Pack()); err != nil {
 		return nil, err
 	}
@@ -42,57 +95,293 @@ func doHandshake(conn net.Conn, infohash, peerID [20]byte) (*greeting.Greeting,
 	return incoming, nil
 }
 
-func readBitfield(conn net.Conn) (mask.Mask, error) {
-	conn.SetDeadline(time.Now().Add(5 * time.Second))
-	defer conn.SetDeadline(time.Time{})
+var (
+	ErrBadBitfield  = errors.New("malformed bitfield")
+	ErrLateBitfield = errors.New("bitfield after other piece state")
+	ErrBadHave      = errors.New("have index out of range")
+)
 
-	frm, err := frames.Unpack(conn)
-	if err != nil {
-		return nil, err
+// applyState folds piece availability messages into the peer's mask. A
+// bitfield, Have All or Have None is only accepted before any Have, since
+// the spec allows it solely as the first message after the handshake.
+func (p *PeerConn) applyState(frm *frames.Frame) error {
+	switch frm.Type {
+	case frames.TypeBitfield, frames.TypeHaveAll, frames.TypeHaveNone:
+		if p.stateSeen {
+			return ErrLateBitfield
+		}
+		p.stateSeen = true
+	case frames.TypeHave:
+		p.stateSeen = true
 	}
 
-	if frm == nil || frm.Type != frames.TypeBitfield {
-		return nil, fmt.Errorf("bitfield frame expected")
+	switch frm.Type {
+	case frames.TypeBitfield:
+		bf := mask.Mask(frm.Data)
+		if p.pieceCount > 0 && !bf.Valid(p.pieceCount) {
+			return ErrBadBitfield
+		}
+		p.Bitfield = append(mask.Mask(nil), bf...)
+	case frames.TypeHaveAll, frames.TypeHaveNone:
+		if !p.Fast {
+			return fmt.Errorf("%s without the fast extension", frm.Label())
+		}
+		if frm.Type == frames.TypeHaveAll {
+			p.Bitfield = mask.Full(p.pieceCount)
+		} else {
+			p.Bitfield = mask.New(p.pieceCount)
+		}
+	case frames.TypeHave:
+		idx, err := frames.ReadHave(frm)
+		if err != nil {
+			return err
+		}
+		if idx < 0 || (p.pieceCount > 0 && idx >= p.pieceCount) {
+			return ErrBadHave
+		}
+		if idx >= len(p.Bitfield)*8 {
+			grown := mask.New(idx + 1)
+			copy(grown, p.Bitfield)
+			p.Bitfield = grown
+		}
+		p.Bitfield.Mark(idx)
+	}
+	return nil
+}
+
+// sendHave announces our pieces. With the Fast extension one of Bitfield,
+// Have All or Have None is mandatory, otherwise an empty bitfield is left out.
+func (d *Dialer) sendHave(conn net.Conn, fast bool) error {
+	var have mask.Mask
+	if d.Have != nil {
+		have = d.Have()
 	}
+	count := have.Count()
 
-	return frm.Data, nil
+	var frm *frames.Frame
+	switch {
+	case fast && count == 0:
+		frm = &frames.Frame{Type: frames.TypeHaveNone}
+	case fast && d.PieceCount > 0 && count == d.PieceCount:
+		frm = &frames.Frame{Type: frames.TypeHaveAll}
+	case count > 0:
+		frm = &frames.Frame{Type: frames.TypeBitfield, Data: have}
+	default:
+		return nil
+	}
+	_, err := conn.Write(frm.Pack())
+	return err
 }
 
 func Connect(p endpoints.Endpoint, peerID, infoHash [20]byte) (*PeerConn, error) {
-	conn, err := net.DialTimeout("tcp", p.String(), 3*time.Second)
+	var d Dialer
+	return d.Connect(p, peerID, infoHash)
+}
+
+func (d *Dialer) Connect(p endpoints.Endpoint, peerID, infoHash [20]byte) (*PeerConn, error) {
+	transports := d.Transports
+	if len(transports) == 0 {
+		transports = []Transport{TCP}
+	}
+	var err error
+	for _, t := range transports {
+		var pc *PeerConn
+		pc, err = d.connect(t, p, peerID, infoHash, d.Encryption)
+		if err != nil && d.Encryption == mse.ModePrefer && d.FallbackPlain {
+			pc, err = d.connect(t, p, peerID, infoHash, mse.ModeDisable)
+		}
+		if err == nil {
+			return pc, nil
+		}
+	}
+	return nil, err
+}
+
+func (d *Dialer) connect(t Transport, p endpoints.Endpoint, peerID, infoHash [20]byte, mode mse.Mode) (*PeerConn, error) {
+	raw, err := t.Dial(p.String(), dialTimeout)
 	if err != nil {
 		return nil, err
 	}
+	down, up := d.buckets(p)
+	conn := throttle.Wrap(raw, down, up)
+
+	if mode != mse.ModeDisable {
+		conn.SetDeadline(time.Now().Add(encryptTimeout))
+		encrypted, err := mse.Initiate(conn, infoHash, mode)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		conn = encrypted
+	}
 
-	_, err = doHandshake(conn, infoHash, peerID)
+	remote, err := doHandshake(conn, d.greeting(infoHash, peerID))
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
 
-	bf, err := readBitfield(conn)
+	pc, err := d.establish(conn, p, remote, peerID)
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
+	return pc, nil
+}
+
+// buckets returns the rate limits that apply to a peer.
+func (d *Dialer) buckets(p endpoints.Endpoint) ([]*throttle.Bucket, []*throttle.Bucket) {
+	if d.LocalUnlimited && p.IsLocal() {
+		return nil, nil
+	}
+	return d.Down, d.Up
+}
+
+func (d *Dialer) greeting(infoHash, peerID [20]byte) *greeting.Greeting {
+	g := greeting.Build(infoHash, peerID)
+	g.SetBit(greeting.ExtensionProtocol)
+	g.SetBit(greeting.FastExtension)
+	if d.V2 {
+		g.SetBit(greeting.V2Upgrade)
+	}
+	return g
+}
+
+// establish finishes a connection once both handshakes are exchanged,
+// whichever side opened it.
+func (d *Dialer) establish(conn net.Conn, p endpoints.Endpoint, remote *greeting.Greeting, peerID [20]byte) (*PeerConn, error) {
+	fast := remote.HasBit(greeting.FastExtension)
+	if err := d.sendHave(conn, fast); err != nil {
+		return nil, err
+	}
 
-	return &PeerConn{
-		Conn:     conn,
-		Choked:   true,
-		Bitfield: bf,
-		peer:     p,
-		infoHash: infoHash,
-		peerID:   peerID,
-	}, nil
+	pc := &PeerConn{
+		Conn:       conn,
+		Choked:     true,
+		Bitfield:   mask.New(d.PieceCount),
+		Fast:       fast,
+		V2:         d.V2 && remote.HasBit(greeting.V2Upgrade),
+		reader:     bufio.NewReader(conn),
+		pieceCount: d.PieceCount,
+		peer:       p,
+		infoHash:   remote.Hash,
+		peerID:     peerID,
+		remote:     remote,
+		pipe:       newPipeline(),
+		allowed:    make(map[int]bool),
+	}
+	if remote.HasBit(greeting.ExtensionProtocol) {
+		if err := pc.sendExtendedHandshake(d.Extensions); err != nil {
+			return nil, err
+		}
+	}
+	return pc, nil
+}
+
+func (p *PeerConn) sendExtendedHandshake(m map[string]int) error {
+	hs := extension.Handshake{M: m, Reqq: localReqq}
+	frm, err := hs.Frame()
+	if err != nil {
+		return err
+	}
+	_, err = p.Conn.Write(frm.Pack())
+	return err
 }
 
+// Read returns the next frame. Choke state, piece availability, extended
+// handshakes and piece timings are recorded on the way through; every frame
+// is still returned.
 func (p *PeerConn) Read() (*frames.Frame, error) {
-	return frames.Unpack(p.Conn)
+	frm, err := frames.Unpack(p.reader)
+	if err != nil || frm == nil {
+		return frm, err
+	}
+
+	switch frm.Type {
+	case frames.TypeChoke:
+		p.Choked = true
+	case frames.TypeUnchoke:
+		p.Choked = false
+	case frames.TypeBitfield, frames.TypeHaveAll, frames.TypeHaveNone, frames.TypeHave:
+		if err := p.applyState(frm); err != nil {
+			return nil, err
+		}
+	case frames.TypePiece:
+		if index, begin, ok := pieceHeader(frm.Data); ok {
+			p.pipe.received(index, begin, len(frm.Data)-8, time.Now())
+		}
+	case frames.TypeReject:
+		if index, begin, _, err := frames.ReadRequest(frm); err == nil {
+			p.pipe.cancelled(index, begin)
+		}
+	case frames.TypeAllowedFast:
+		if index, err := frames.ReadIndex(frm); err == nil {
+			p.allowed[index] = true
+		}
+	case frames.TypeSuggest:
+		if index, err := frames.ReadIndex(frm); err == nil {
+			p.suggested = append(p.suggested, index)
+		}
+	case frames.TypeExtended:
+		id, payload, err := extension.Split(frm)
+		if err == nil && id == extension.HandshakeID {
+			if hs, err := extension.ParseHandshake(payload); err == nil {
+				p.Extensions = hs
+			}
+		}
+	}
+	return frm, nil
+}
+
+// Poll waits up to timeout for the peer to send something and reads it. A
+// nil frame with a nil error means nothing arrived or it was a keep-alive.
+// The wait only peeks, so a timeout never leaves half a frame consumed.
+func (p *PeerConn) Poll(timeout time.Duration) (*frames.Frame, error) {
+	p.Conn.SetReadDeadline(time.Now().Add(timeout))
+	_, err := p.reader.Peek(1)
+	p.Conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return p.Read()
+}
+
+// CanRequest reports whether blocks of a piece may be requested now: either
+// the peer unchoked us or it allowed the piece while choked.
+func (p *PeerConn) CanRequest(index int) bool {
+	return !p.Choked || p.allowed[index]
+}
+
+// AllowedFast reports whether the peer lets us fetch a piece while choked.
+func (p *PeerConn) AllowedFast(index int) bool {
+	return p.allowed[index]
+}
+
+// Suggestions returns and clears the pieces the peer suggested.
+func (p *PeerConn) Suggestions() []int {
+	s := p.suggested
+	p.suggested = nil
+	return s
+}
+
+// QueueDepth is how many block requests should be outstanding to keep this
+// peer's link full, limited by the reqq it advertised.
+func (p *PeerConn) QueueDepth() int {
+	limit := 0
+	if p.Extensions != nil {
+		limit = p.Extensions.Reqq
+	}
+	return p.pipe.depth(limit)
 }
 
 func (p *PeerConn) SendRequest(index, begin, length int) error {
 	codec := frames.NewCodec()
 	frm := codec.Request(index, begin, length)
+	p.pipe.requested(index, begin, time.Now())
 	_, err := p.Conn.Write(frm.Pack())
 	return err
 }
@@ -121,3 +410,57 @@ func (p *PeerConn) SendHave(index int) error {
 	_, err := p.Conn.Write(frm.Pack())
 	return err
 }
+
+func (p *PeerConn) SendReject(index, begin, length int) error {
+	codec := frames.NewCodec()
+	frm := codec.Reject(index, begin, length)
+	_, err := p.Conn.Write(frm.Pack())
+	return err
+}
+
+// SendExtended sends a BEP 10 message under the id the peer chose for
+// name in its extended handshake.
+func (p *PeerConn) SendExtended(name string, payload []byte) error {
+	if p.Extensions == nil || p.Extensions.M[name] == 0 {
+		return fmt.Errorf("peer does not support %s", name)
+	}
+	frm := extension.Frame(byte(p.Extensions.M[name]), payload)
+	_, err := p.Conn.Write(frm.Pack())
+	return err
+}
+
+func (p *PeerConn) SendHashes(req frames.HashRequest, hashes [][32]byte) error {
+	codec := frames.NewCodec()
+	frm := codec.Hashes(req, hashes)
+	_, err := p.Conn.Write(frm.Pack())
+	return err
+}
+
+func (p *PeerConn) SendHashReject(req frames.HashRequest) error {
+	codec := frames.NewCodec()
+	frm := codec.HashReject(req)
+	_, err := p.Conn.Write(frm.Pack())
+	return err
+}
+
+// Peer is the address of the other side.
+func (p *PeerConn) Peer() endpoints.Endpoint {
+	return p.peer
+}
+
+// ClientName is the peer's software as announced in its extended
+// handshake, else the code and version of an Azureus style peer id such
+// as "-qB4650-", else empty.
+func (p *PeerConn) ClientName() string {
+	if p.Extensions != nil && p.Extensions.V != "" {
+		return p.Extensions.V
+	}
+	if p.remote == nil {
+		return ""
+	}
+	id := p.remote.ID
+	if id[0] == '-' && id[7] == '-' {
+		return string(id[1:7])
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package connector
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/data/mask"
+	"github.com/Sabir222/torrent-at-home/protocol/extension"
+	"github.com/Sabir222/torrent-at-home/protocol/frames"
+	"github.com/Sabir222/torrent-at-home/protocol/greeting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyState(t *testing.T) {
+	codec := frames.NewCodec()
+	cases := map[string]struct {
+		frames []*frames.Frame
+		fast   bool
+		want   mask.Mask
+		fail   bool
+	}{
+		"nothing sent": {
+			want: mask.Mask{0, 0},
+		},
+		"bitfield": {
+			frames: []*frames.Frame{{Type: frames.TypeBitfield, Data: []byte{0b10100000, 0b01000000}}},
+			want:   mask.Mask{0b10100000, 0b01000000},
+		},
+		"have without bitfield": {
+			frames: []*frames.Frame{codec.Have(1), codec.Have(9)},
+			want:   mask.Mask{0b01000000, 0b01000000},
+		},
+		"have all": {
+			frames: []*frames.Frame{{Type: frames.TypeHaveAll}},
+			fast:   true,
+			want:   mask.Mask{0xff, 0b11000000},
+		},
+		"have none then have": {
+			frames: []*frames.Frame{{Type: frames.TypeHaveNone}, codec.Have(0)},
+			fast:   true,
+			want:   mask.Mask{0b10000000, 0},
+		},
+		"have all without fast extension": {
+			frames: []*frames.Frame{{Type: frames.TypeHaveAll}},
+			fail:   true,
+		},
+		"wrong length": {
+			frames: []*frames.Frame{{Type: frames.TypeBitfield, Data: []byte{0xff}}},
+			fail:   true,
+		},
+		"spare bits set": {
+			frames: []*frames.Frame{{Type: frames.TypeBitfield, Data: []byte{0xff, 0xff}}},
+			fail:   true,
+		},
+		"bitfield after have": {
+			frames: []*frames.Frame{codec.Have(0), {Type: frames.TypeBitfield, Data: []byte{0, 0}}},
+			fail:   true,
+		},
+		"have out of range": {
+			frames: []*frames.Frame{codec.Have(10)},
+			fail:   true,
+		},
+	}
+
+	for name, c := range cases {
+		p := &PeerConn{Bitfield: mask.New(10), pieceCount: 10, Fast: c.fast}
+		var err error
+		for _, frm := range c.frames {
+			if err = p.applyState(frm); err != nil {
+				break
+			}
+		}
+		if c.fail {
+			assert.Error(t, err, name)
+			continue
+		}
+		assert.NoError(t, err, name)
+		assert.Equal(t, c.want, p.Bitfield, name)
+	}
+}
+
+func TestPollTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	p := &PeerConn{Conn: local, reader: bufio.NewReader(local), pipe: newPipeline()}
+
+	frm, err := p.Poll(10 * time.Millisecond)
+	assert.NoError(t, err)
+	assert.Nil(t, frm)
+
+	go remote.Write(frames.NewCodec().Have(2).Pack())
+	frm, err = p.Poll(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(frames.TypeHave), frm.Type)
+	assert.True(t, p.Bitfield.Check(2))
+}
+
+func TestSendHave(t *testing.T) {
+	cases := map[string]struct {
+		have mask.Mask
+		fast bool
+		want uint8
+	}{
+		"fast and empty":    {have: mask.New(3), fast: true, want: frames.TypeHaveNone},
+		"fast and complete": {have: mask.Full(3), fast: true, want: frames.TypeHaveAll},
+		"partial":           {have: mask.Mask{0b01000000}, want: frames.TypeBitfield},
+	}
+
+	for name, c := range cases {
+		local, remote := net.Pipe()
+		d := Dialer{PieceCount: 3, Have: func() mask.Mask { return c.have }}
+		go d.sendHave(local, c.fast)
+
+		frm, err := frames.Unpack(remote)
+		assert.NoError(t, err, name)
+		assert.Equal(t, c.want, frm.Type, name)
+		local.Close()
+		remote.Close()
+	}
+}
+
+func TestClientName(t *testing.T) {
+	id := func(s string) *greeting.Greeting {
+		g := &greeting.Greeting{}
+		copy(g.ID[:], s)
+		return g
+	}
+	cases := map[string]struct {
+		conn PeerConn
+		want string
+	}{
+		"azureus":   {conn: PeerConn{remote: id("-qB4650-abcdefghijkl")}, want: "qB4650"},
+		"extension": {conn: PeerConn{remote: id("-qB4650-abcdefghijkl"), Extensions: &extension.Handshake{V: "qBittorrent/4.6.5"}}, want: "qBittorrent/4.6.5"},
+		"opaque":    {conn: PeerConn{remote: id("M7-4-3--abcdefghijkl")}, want: ""},
+		"unknown":   {conn: PeerConn{}, want: ""},
+	}
+	for name, c := range cases {
+		assert.Equal(t, c.want, c.conn.ClientName(), name)
+	}
+}
@@ -0,0 +1,199 @@
+package connector
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/logging"
+	"github.com/Sabir222/torrent-at-home/network/endpoints"
+	"github.com/Sabir222/torrent-at-home/network/throttle"
+	"github.com/Sabir222/torrent-at-home/protocol/greeting"
+	"github.com/Sabir222/torrent-at-home/protocol/mse"
+)
+
+const acceptTimeout = 10 * time.Second
+
+var (
+	ErrUnknownTorrent  = errors.New("handshake for unknown torrent")
+	ErrPlainRefused    = errors.New("plaintext connection refused")
+	ErrEncryptRefused  = errors.New("encrypted connection refused")
+	plainProtocolStart = append([]byte{19}, "BitTorrent protocol"...)
+)
+
+type registration struct {
+	peerID [20]byte
+	dialer *Dialer
+	handle func(*PeerConn)
+}
+
+// Listener accepts incoming peer connections, plain or MSE encrypted, and
+// hands each one to the torrent named in its handshake.
+type Listener struct {
+	// Encryption decides which incoming streams are accepted: disable
+	// takes plaintext only, require takes encrypted only.
+	Encryption mse.Mode
+
+	ln       net.Listener
+	mu       sync.Mutex
+	torrents map[[20]byte]*registration
+}
+
+func Listen(addr string, mode mse.Mode) (*Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		Encryption: mode,
+		ln:         ln,
+		torrents:   make(map[[20]byte]*registration),
+	}
+	go l.serve(ln)
+	return l, nil
+}
+
+// Serve accepts connections from another listener as well, such as a uTP
+// socket sharing the port number.
+func (l *Listener) Serve(ln net.Listener) {
+	go l.serve(ln)
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+func (l *Listener) Close() error {
+	return l.ln.Close()
+}
+
+// Register routes connections for infoHash to handle. The dialer supplies
+// the same per-torrent options outgoing connections use.
+func (l *Listener) Register(infoHash, peerID [20]byte, d *Dialer, handle func(*PeerConn)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.torrents[infoHash] = &registration{peerID: peerID, dialer: d, handle: handle}
+}
+
+func (l *Listener) Unregister(infoHash [20]byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.torrents, infoHash)
+}
+
+func (l *Listener) lookup(infoHash [20]byte) *registration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.torrents[infoHash]
+}
+
+func (l *Listener) skeys() [][20]byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keys := make([][20]byte, 0, len(l.torrents))
+	for k := range l.torrents {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (l *Listener) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		go func() {
+			if err := l.accept(conn); err != nil {
+				logging.Debugf("[listen] ✗ %s rejected: %v\n", conn.RemoteAddr(), err)
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// bufferedConn keeps bytes peeked while detecting the stream type.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (l *Listener) accept(raw net.Conn) error {
+	raw.SetDeadline(time.Now().Add(acceptTimeout))
+
+	r := bufio.NewReader(raw)
+	head, err := r.Peek(len(plainProtocolStart))
+	if err != nil {
+		return err
+	}
+
+	var (
+		conn net.Conn
+		skey *[20]byte
+	)
+	if bytes.Equal(head, plainProtocolStart) {
+		if l.Encryption == mse.ModeRequire {
+			return ErrPlainRefused
+		}
+		conn = &bufferedConn{Conn: raw, r: r}
+	} else {
+		if l.Encryption == mse.ModeDisable {
+			return ErrEncryptRefused
+		}
+		ya, err := mse.ReadKey(r)
+		if err != nil {
+			return err
+		}
+		encrypted, key, err := mse.Accept(raw, r, ya, l.skeys(), l.Encryption)
+		if err != nil {
+			return err
+		}
+		conn, skey = encrypted, &key
+	}
+
+	remote, err := greeting.Unpack(conn)
+	if err != nil {
+		return err
+	}
+	reg := l.lookup(remote.Hash)
+	if reg == nil || (skey != nil && *skey != remote.Hash) {
+		return ErrUnknownTorrent
+	}
+
+	peer := remoteEndpoint(raw)
+	down, up := reg.dialer.buckets(peer)
+	conn = throttle.Wrap(conn, down, up)
+	if _, err := conn.Write(reg.dialer.greeting(remote.Hash, reg.peerID).Pack()); err != nil {
+		return err
+	}
+
+	pc, err := reg.dialer.establish(conn, peer, remote, reg.peerID)
+	if err != nil {
+		return err
+	}
+	raw.SetDeadline(time.Time{})
+
+	logging.Debugf("[listen] ✓ accepted %s\n", raw.RemoteAddr())
+	reg.handle(pc)
+	return nil
+}
+
+func remoteEndpoint(conn net.Conn) endpoints.Endpoint {
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return endpoints.Endpoint{Addr: addr.IP, Port: uint16(addr.Port)}
+	case *net.UDPAddr:
+		return endpoints.Endpoint{Addr: addr.IP, Port: uint16(addr.Port)}
+	}
+	return endpoints.Endpoint{}
+}
@@ -0,0 +1,99 @@
+package connector
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/network/endpoints"
+	"github.com/Sabir222/torrent-at-home/network/utp"
+	"github.com/Sabir222/torrent-at-home/protocol/mse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenerEncryption(t *testing.T) {
+	infoHash := [20]byte{1, 2, 3}
+	cases := map[string]struct {
+		dial     mse.Mode
+		fallback bool
+		listen   mse.Mode
+		fail     bool
+	}{
+		"plain to plain":              {dial: mse.ModeDisable, listen: mse.ModeDisable},
+		"encrypted to prefer":         {dial: mse.ModePrefer, listen: mse.ModePrefer},
+		"encrypted to require":        {dial: mse.ModeRequire, listen: mse.ModeRequire},
+		"plain to require":            {dial: mse.ModeDisable, listen: mse.ModeRequire, fail: true},
+		"encrypted to plain":          {dial: mse.ModePrefer, listen: mse.ModeDisable, fail: true},
+		"encrypted to plain, retry":   {dial: mse.ModePrefer, fallback: true, listen: mse.ModeDisable},
+		"required to plain, no retry": {dial: mse.ModeRequire, fallback: true, listen: mse.ModeDisable, fail: true},
+	}
+
+	for name, c := range cases {
+		l, err := Listen("127.0.0.1:0", c.listen)
+		require.NoError(t, err)
+
+		accepted := make(chan *PeerConn, 2)
+		l.Register(infoHash, [20]byte{9}, &Dialer{PieceCount: 8}, func(pc *PeerConn) {
+			accepted <- pc
+		})
+
+		addr := l.Addr().(*net.TCPAddr)
+		d := &Dialer{PieceCount: 8, Encryption: c.dial, FallbackPlain: c.fallback}
+		pc, err := d.Connect(endpoints.Endpoint{Addr: addr.IP, Port: uint16(addr.Port)}, [20]byte{7}, infoHash)
+		if c.fail {
+			assert.Error(t, err, name)
+			l.Close()
+			continue
+		}
+		require.NoError(t, err, name)
+		assert.True(t, pc.Fast, name)
+
+		select {
+		case in := <-accepted:
+			assert.Equal(t, infoHash, in.infoHash, name)
+			in.Conn.Close()
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: no inbound connection", name)
+		}
+		pc.Conn.Close()
+		l.Close()
+	}
+}
+
+func TestListenerUTP(t *testing.T) {
+	infoHash := [20]byte{4, 5, 6}
+
+	l, err := Listen("127.0.0.1:0", mse.ModePrefer)
+	require.NoError(t, err)
+	defer l.Close()
+	addr := l.Addr().(*net.TCPAddr)
+
+	server, err := utp.Listen(addr.String())
+	require.NoError(t, err)
+	defer server.Close()
+	l.Serve(server)
+
+	client, err := utp.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer client.Close()
+
+	accepted := make(chan *PeerConn, 1)
+	l.Register(infoHash, [20]byte{9}, &Dialer{PieceCount: 8}, func(pc *PeerConn) {
+		accepted <- pc
+	})
+
+	d := &Dialer{PieceCount: 8, Encryption: mse.ModePrefer, Transports: []Transport{UTP{client}, TCP}}
+	pc, err := d.Connect(endpoints.Endpoint{Addr: addr.IP, Port: uint16(addr.Port)}, [20]byte{7}, infoHash)
+	require.NoError(t, err)
+	defer pc.Conn.Close()
+	assert.Equal(t, "udp", pc.Conn.RemoteAddr().Network())
+
+	select {
+	case in := <-accepted:
+		assert.Equal(t, uint16(client.Addr().(*net.UDPAddr).Port), in.Peer().Port)
+		in.Conn.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatal("no inbound connection")
+	}
+}
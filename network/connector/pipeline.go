@@ -0,0 +1,120 @@
+package connector
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// MinQueueDepth and MaxQueueDepth bound the number of outstanding block
+	// requests per peer. DefaultQueueDepth is used until there are samples.
+	MinQueueDepth     = 2
+	MaxQueueDepth     = 500
+	DefaultQueueDepth = 5
+
+	blockSize = 16384
+	// rateWindow is how long received bytes are accumulated before the
+	// throughput estimate is updated.
+	rateWindow = 500 * time.Millisecond
+	// rttWindow is how long the minimum round trip is trusted before a
+	// fresh sample replaces it, so route changes are picked up.
+	rttWindow = 10 * time.Second
+)
+
+type blockKey struct {
+	index int
+	begin int
+}
+
+// pipeline estimates the bandwidth-delay product of a connection from the
+// round trip of block requests and the rate blocks arrive at.
+type pipeline struct {
+	mu      sync.Mutex
+	sent    map[blockKey]time.Time
+	rtt     time.Duration
+	rttAt   time.Time
+	rate    float64
+	window  time.Time
+	pending int
+}
+
+func newPipeline() *pipeline {
+	return &pipeline{sent: make(map[blockKey]time.Time)}
+}
+
+func (p *pipeline) requested(index, begin int, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent[blockKey{index, begin}] = now
+}
+
+func (p *pipeline) cancelled(index, begin int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.sent, blockKey{index, begin})
+}
+
+func (p *pipeline) received(index, begin, n int, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := blockKey{index, begin}
+	if at, ok := p.sent[key]; ok {
+		delete(p.sent, key)
+		// Later blocks also wait behind the ones queued before them, so
+		// only the minimum sample reflects the path itself.
+		sample := now.Sub(at)
+		if p.rtt == 0 || sample < p.rtt || now.Sub(p.rttAt) > rttWindow {
+			p.rtt = sample
+			p.rttAt = now
+		}
+	}
+
+	if p.window.IsZero() {
+		p.window = now
+	}
+	p.pending += n
+	if elapsed := now.Sub(p.window); elapsed >= rateWindow {
+		sample := float64(p.pending) / elapsed.Seconds()
+		if p.rate == 0 {
+			p.rate = sample
+		} else {
+			p.rate += (sample - p.rate) / 4
+		}
+		p.pending = 0
+		p.window = now
+	}
+}
+
+// depth is the bandwidth-delay product in blocks plus a little slack so the
+// link stays busy while the next request is on its way.
+func (p *pipeline) depth(limit int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	d := DefaultQueueDepth
+	if p.rtt > 0 && p.rate > 0 {
+		bdp := p.rate * p.rtt.Seconds() / blockSize
+		d = int(math.Ceil(bdp)) + 2
+	}
+	if d < MinQueueDepth {
+		d = MinQueueDepth
+	}
+	if d > MaxQueueDepth {
+		d = MaxQueueDepth
+	}
+	if limit > 0 && d > limit {
+		d = limit
+	}
+	return d
+}
+
+// pieceHeader reads the index and offset of a piece frame payload.
+func pieceHeader(data []byte) (int, int, bool) {
+	if len(data) < 8 {
+		return 0, 0, false
+	}
+	return int(binary.BigEndian.Uint32(data[0:4])), int(binary.BigEndian.Uint32(data[4:8])), true
+}
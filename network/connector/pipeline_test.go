@@ -0,0 +1,67 @@
+package connector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipelineDepth(t *testing.T) {
+	cases := map[string]struct {
+		rtt   time.Duration
+		rate  int // bytes per second
+		limit int
+		want  int
+	}{
+		"no samples": {
+			want: DefaultQueueDepth,
+		},
+		"slow local peer": {
+			rtt:  5 * time.Millisecond,
+			rate: 64 * 1024,
+			want: MinQueueDepth + 1,
+		},
+		"fast distant peer": {
+			rtt:  200 * time.Millisecond,
+			rate: 4 * 1024 * 1024,
+			want: 54,
+		},
+		"limited by reqq": {
+			rtt:   200 * time.Millisecond,
+			rate:  4 * 1024 * 1024,
+			limit: 16,
+			want:  16,
+		},
+		"capped": {
+			rtt:  2 * time.Second,
+			rate: 100 * 1024 * 1024,
+			want: MaxQueueDepth,
+		},
+	}
+
+	for name, c := range cases {
+		p := newPipeline()
+		if c.rate > 0 {
+			start := time.Now()
+			p.requested(0, 0, start)
+			p.received(0, 0, blockSize, start.Add(c.rtt))
+			p.rtt = c.rtt
+			p.rate = float64(c.rate)
+		}
+		assert.Equal(t, c.want, p.depth(c.limit), name)
+	}
+}
+
+func TestPipelineMinRTT(t *testing.T) {
+	p := newPipeline()
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		p.requested(0, i*blockSize, start)
+	}
+	for i := 0; i < 4; i++ {
+		p.received(0, i*blockSize, blockSize, start.Add(time.Duration(i+1)*50*time.Millisecond))
+	}
+	assert.Equal(t, 50*time.Millisecond, p.rtt)
+	assert.Empty(t, p.sent)
+}
@@ -0,0 +1,161 @@
+package connector
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	socksVersion  = 5
+	socksNoAuth   = 0
+	socksPassword = 2
+	socksConnect  = 1
+	socksIPv4     = 1
+	socksDomain   = 3
+	socksIPv6     = 4
+)
+
+var ErrSOCKSAuth = errors.New("socks5 proxy refused authentication")
+
+// SOCKS5 dials TCP connections through a SOCKS5 proxy (RFC 1928), logging
+// in with User and Password (RFC 1929) when User is set.
+type SOCKS5 struct {
+	Addr     string
+	User     string
+	Password string
+}
+
+// ParseSOCKS5 reads a proxy given as socks5://[user:password@]host:port.
+func ParseSOCKS5(raw string) (SOCKS5, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return SOCKS5{}, err
+	}
+	if u.Scheme != "socks5" || u.Host == "" {
+		return SOCKS5{}, fmt.Errorf("proxy %q is not socks5://host:port", raw)
+	}
+	s := SOCKS5{Addr: u.Host}
+	if u.User != nil {
+		s.User = u.User.Username()
+		s.Password, _ = u.User.Password()
+	}
+	return s, nil
+}
+
+func (s SOCKS5) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", s.Addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := s.connect(conn, addr); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks5: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (SOCKS5) String() string { return "socks5" }
+
+func (s SOCKS5) connect(conn net.Conn, addr string) error {
+	methods := []byte{socksVersion, 1, socksNoAuth}
+	if s.User != "" {
+		methods = []byte{socksVersion, 2, socksNoAuth, socksPassword}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	switch {
+	case reply[0] != socksVersion:
+		return fmt.Errorf("unexpected version %d", reply[0])
+	case reply[1] == socksPassword && s.User != "":
+		if err := s.login(conn); err != nil {
+			return err
+		}
+	case reply[1] != socksNoAuth:
+		return ErrSOCKSAuth
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return err
+	}
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q too long", host)
+		}
+		req = append(req, socksDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, socksIPv4), ip4...)
+	} else {
+		req = append(append(req, socksIPv6), ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// The reply ends with the address the proxy bound, which is skipped.
+	var head [4]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("connect to %s failed with code %d", addr, head[1])
+	}
+	var skip int
+	switch head[3] {
+	case socksIPv4:
+		skip = net.IPv4len
+	case socksIPv6:
+		skip = net.IPv6len
+	case socksDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("unexpected address type %d", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+func (s SOCKS5) login(conn net.Conn) error {
+	if len(s.User) > 255 || len(s.Password) > 255 {
+		return errors.New("user name or password too long")
+	}
+	req := []byte{1, byte(len(s.User))}
+	req = append(req, s.User...)
+	req = append(req, byte(len(s.Password)))
+	req = append(req, s.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return ErrSOCKSAuth
+	}
+	return nil
+}
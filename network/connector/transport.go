@@ -0,0 +1,38 @@
+package connector
+
+import (
+	"net"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/network/utp"
+)
+
+const dialTimeout = 3 * time.Second
+
+// Transport opens the stream a peer connection runs over.
+type Transport interface {
+	Dial(addr string, timeout time.Duration) (net.Conn, error)
+	String() string
+}
+
+type tcpTransport struct{}
+
+// TCP dials plain TCP connections.
+var TCP Transport = tcpTransport{}
+
+func (tcpTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+func (tcpTransport) String() string { return "tcp" }
+
+// UTP dials uTP connections over a shared UDP socket.
+type UTP struct {
+	Socket *utp.Socket
+}
+
+func (u UTP) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	return u.Socket.Dial(addr, timeout)
+}
+
+func (UTP) String() string { return "utp" }
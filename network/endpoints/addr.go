@@ -33,6 +33,26 @@ func Parse(raw []byte) ([]Endpoint, error) {
 	return result, nil
 }
 
+// ParseAddr reads an "ip:port" pair, as in a magnet link's x.pe.
+func ParseAddr(s string) (Endpoint, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	ip := net.ParseIP(host)
+	n, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return Endpoint{}, ErrMalformedPeerData
+	}
+	return Endpoint{Addr: ip, Port: uint16(n)}, nil
+}
+
 func (e Endpoint) String() string {
 	return net.JoinHostPort(e.Addr.String(), strconv.Itoa(int(e.Port)))
 }
+
+// IsLocal reports whether the peer is on a private, loopback or link-local
+// address, i.e. most likely on the same LAN.
+func (e Endpoint) IsLocal() bool {
+	return e.Addr.IsPrivate() || e.Addr.IsLoopback() || e.Addr.IsLinkLocalUnicast()
+}
@@ -52,3 +52,28 @@ func TestEndpointString(t *testing.T) {
 		assert.Equal(t, c.want, c.ep.String())
 	}
 }
+
+func TestEndpointIsLocal(t *testing.T) {
+	cases := map[string]bool{
+		"192.168.1.20": true,
+		"10.0.0.5":     true,
+		"127.0.0.1":    true,
+		"169.254.3.4":  true,
+		"1.1.1.1":      false,
+		"8.8.8.8":      false,
+	}
+	for ip, want := range cases {
+		assert.Equal(t, want, Endpoint{Addr: net.ParseIP(ip)}.IsLocal(), ip)
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	e, err := ParseAddr("10.0.0.1:6881")
+	assert.NoError(t, err)
+	assert.Equal(t, Endpoint{Addr: net.ParseIP("10.0.0.1"), Port: 6881}, e)
+
+	for _, bad := range []string{"10.0.0.1", "host:1", "10.0.0.1:70000"} {
+		_, err := ParseAddr(bad)
+		assert.Error(t, err, bad)
+	}
+}
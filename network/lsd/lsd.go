@@ -0,0 +1,212 @@
+package lsd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/network/endpoints"
+)
+
+const (
+	// Group is the IPv4 multicast address local peers announce to.
+	Group = "239.192.152.143:6771"
+	// Interval is how often every registered torrent is re-announced.
+	Interval = 5 * time.Minute
+)
+
+var ErrMalformedAnnounce = errors.New("malformed LSD announce")
+
+// Announce is a BT-SEARCH message.
+type Announce struct {
+	Port       uint16
+	InfoHashes [][20]byte
+	Cookie     string
+}
+
+func (a *Announce) Marshal() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "BT-SEARCH * HTTP/1.1\r\nHost: %s\r\nPort: %d\r\n", Group, a.Port)
+	for _, h := range a.InfoHashes {
+		fmt.Fprintf(&b, "Infohash: %x\r\n", h)
+	}
+	if a.Cookie != "" {
+		fmt.Fprintf(&b, "cookie: %s\r\n", a.Cookie)
+	}
+	b.WriteString("\r\n\r\n")
+	return b.Bytes()
+}
+
+func Unmarshal(data []byte) (*Announce, error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil || req.Method != "BT-SEARCH" {
+		return nil, ErrMalformedAnnounce
+	}
+	port, err := strconv.ParseUint(req.Header.Get("Port"), 10, 16)
+	if err != nil || port == 0 {
+		return nil, ErrMalformedAnnounce
+	}
+
+	a := &Announce{Port: uint16(port), Cookie: req.Header.Get("Cookie")}
+	for _, v := range req.Header.Values("Infohash") {
+		raw, err := hex.DecodeString(strings.TrimSpace(v))
+		if err != nil || len(raw) != 20 {
+			return nil, ErrMalformedAnnounce
+		}
+		var h [20]byte
+		copy(h[:], raw)
+		a.InfoHashes = append(a.InfoHashes, h)
+	}
+	if len(a.InfoHashes) == 0 {
+		return nil, ErrMalformedAnnounce
+	}
+	return a, nil
+}
+
+// Service announces torrents on the local network and reports peers that
+// announce the same ones.
+type Service struct {
+	// Port is the peer port advertised in announces.
+	Port uint16
+
+	cookie string
+	group  *net.UDPAddr
+	recv   *net.UDPConn
+	send   *net.UDPConn
+
+	mu       sync.Mutex
+	torrents map[[20]byte]func(endpoints.Endpoint)
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Listen joins the LSD multicast group and starts announcing every
+// Interval.
+func Listen(port uint16) (*Service, error) {
+	group, err := net.ResolveUDPAddr("udp4", Group)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, err
+	}
+	send, err := net.DialUDP("udp4", nil, group)
+	if err != nil {
+		recv.Close()
+		return nil, err
+	}
+
+	s := newService(port)
+	s.group, s.recv, s.send = group, recv, send
+	go s.serve()
+	go s.run()
+	return s, nil
+}
+
+func newService(port uint16) *Service {
+	cookie := make([]byte, 8)
+	rand.Read(cookie)
+	return &Service{
+		Port:     port,
+		cookie:   hex.EncodeToString(cookie),
+		torrents: make(map[[20]byte]func(endpoints.Endpoint)),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (s *Service) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+		s.recv.Close()
+		s.send.Close()
+	})
+	return nil
+}
+
+// Register announces infoHash right away and passes local peers that
+// announce it to found.
+func (s *Service) Register(infoHash [20]byte, found func(endpoints.Endpoint)) {
+	s.mu.Lock()
+	s.torrents[infoHash] = found
+	s.mu.Unlock()
+	s.announce([][20]byte{infoHash})
+}
+
+func (s *Service) Unregister(infoHash [20]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.torrents, infoHash)
+}
+
+func (s *Service) announce(hashes [][20]byte) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	a := Announce{Port: s.Port, InfoHashes: hashes, Cookie: s.cookie}
+	_, err := s.send.Write(a.Marshal())
+	return err
+}
+
+func (s *Service) run() {
+	t := time.NewTicker(Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-t.C:
+			s.mu.Lock()
+			hashes := make([][20]byte, 0, len(s.torrents))
+			for h := range s.torrents {
+				hashes = append(hashes, h)
+			}
+			s.mu.Unlock()
+			s.announce(hashes)
+		}
+	}
+}
+
+func (s *Service) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := s.recv.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.closed:
+				return
+			default:
+				continue
+			}
+		}
+		s.handle(buf[:n], from)
+	}
+}
+
+// handle reports the sender of an announce to every torrent it names that
+// is registered here. Our own announces are looped back and ignored.
+func (s *Service) handle(data []byte, from *net.UDPAddr) {
+	a, err := Unmarshal(data)
+	if err != nil || a.Cookie == s.cookie {
+		return
+	}
+	peer := endpoints.Endpoint{Addr: from.IP, Port: a.Port}
+	for _, h := range a.InfoHashes {
+		s.mu.Lock()
+		found := s.torrents[h]
+		s.mu.Unlock()
+		if found != nil {
+			found(peer)
+		}
+	}
+}
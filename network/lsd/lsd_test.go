@@ -0,0 +1,66 @@
+package lsd
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Sabir222/torrent-at-home/network/endpoints"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnounceRoundTrip(t *testing.T) {
+	a := Announce{Port: 6881, InfoHashes: [][20]byte{{1}, {2}}, Cookie: "abc"}
+	got, err := Unmarshal(a.Marshal())
+	require.NoError(t, err)
+	assert.Equal(t, &a, got)
+}
+
+func TestUnmarshal(t *testing.T) {
+	cases := map[string]struct {
+		raw  string
+		fail bool
+	}{
+		"minimal": {
+			raw: "BT-SEARCH * HTTP/1.1\r\nHost: 239.192.152.143:6771\r\nPort: 51413\r\nInfohash: 0102030405060708090a0b0c0d0e0f1011121314\r\n\r\n\r\n",
+		},
+		"no infohash": {
+			raw:  "BT-SEARCH * HTTP/1.1\r\nHost: 239.192.152.143:6771\r\nPort: 51413\r\n\r\n\r\n",
+			fail: true,
+		},
+		"bad port": {
+			raw:  "BT-SEARCH * HTTP/1.1\r\nPort: x\r\nInfohash: 0102030405060708090a0b0c0d0e0f1011121314\r\n\r\n\r\n",
+			fail: true,
+		},
+		"short infohash": {
+			raw:  "BT-SEARCH * HTTP/1.1\r\nPort: 1\r\nInfohash: 0102\r\n\r\n\r\n",
+			fail: true,
+		},
+		"other method": {
+			raw:  "M-SEARCH * HTTP/1.1\r\nPort: 1\r\nInfohash: 0102030405060708090a0b0c0d0e0f1011121314\r\n\r\n\r\n",
+			fail: true,
+		},
+	}
+	for name, c := range cases {
+		_, err := Unmarshal([]byte(c.raw))
+		if c.fail {
+			assert.ErrorIs(t, err, ErrMalformedAnnounce, name)
+		} else {
+			assert.NoError(t, err, name)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	s := newService(6881)
+	var found []endpoints.Endpoint
+	s.torrents[[20]byte{1}] = func(p endpoints.Endpoint) { found = append(found, p) }
+
+	from := &net.UDPAddr{IP: net.IP{192, 168, 1, 7}, Port: 6771}
+	other := Announce{Port: 7000, InfoHashes: [][20]byte{{1}, {2}}, Cookie: "peer"}
+	s.handle(other.Marshal(), from)
+	own := Announce{Port: 6881, InfoHashes: [][20]byte{{1}}, Cookie: s.cookie}
+	s.handle(own.Marshal(), from)
+
+	assert.Equal(t, []endpoints.Endpoint{{Addr: from.IP, Port: 7000}}, found)
+}
@@ -0,0 +1,76 @@
+package stream
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/engine"
+	"github.com/Sabir222/torrent-at-home/logging"
+)
+
+// Server exposes the files of a session over HTTP with Range support, so a
+// media player can start while the download is still running.
+type Server struct {
+	session *engine.Session
+	started time.Time
+}
+
+func NewServer(s *engine.Session) *Server {
+	return &Server{session: s, started: time.Now()}
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "" {
+		srv.index(w)
+		return
+	}
+
+	for _, f := range srv.session.FileList() {
+		if f.Path != name {
+			continue
+		}
+		logging.Debugf("[stream] %s %s (range %q)\n", r.Method, name, r.Header.Get("Range"))
+		reader := srv.session.NewReader(r.Context(), int64(f.Offset), int64(f.Length))
+		defer reader.Close()
+		http.ServeContent(w, r, path.Base(name), srv.started, reader)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (srv *Server) index(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintln(w, "<ul>")
+	for _, f := range srv.session.FileList() {
+		if f.Priority == engine.PrioritySkip {
+			continue
+		}
+		fmt.Fprintf(w, "<li><a href=\"/%s\">%s</a></li>\n", escapePath(f.Path), html.EscapeString(f.Path))
+	}
+	fmt.Fprintln(w, "</ul>")
+}
+
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// ListenAndServe serves the session on addr until the listener fails.
+func ListenAndServe(addr string, s *engine.Session) error {
+	logging.Infof("[stream] serving %s on http://%s/\n", s.Name, addr)
+	return http.ListenAndServe(addr, NewServer(s))
+}
@@ -0,0 +1,132 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/data/mask"
+	"github.com/Sabir222/torrent-at-home/engine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var content = []byte("abcdefghij")
+
+func newSession(storage engine.Storage) *engine.Session {
+	return &engine.Session{
+		Name:        "movie.mkv",
+		PieceHashes: [][20]byte{sha1.Sum(content[0:4]), sha1.Sum(content[4:8]), sha1.Sum(content[8:10])},
+		PieceLength: 4,
+		Length:      len(content),
+		Storage:     storage,
+	}
+}
+
+type memStorage []byte
+
+func (m memStorage) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m)) {
+		return 0, io.EOF
+	}
+	return copy(p, m[off:]), nil
+}
+
+func (m memStorage) WriteAt(p []byte, off int64) (int, error) {
+	return copy(m[off:], p), nil
+}
+
+func get(t *testing.T, srv *httptest.Server, path, rng string) (*http.Response, string) {
+	req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	require.NoError(t, err)
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestServerRange(t *testing.T) {
+	s := newSession(memStorage(append([]byte{}, content...)))
+	have := mask.New(3)
+	for i := 0; i < 3; i++ {
+		have.Mark(i)
+	}
+	s.Restore(have)
+	srv := httptest.NewServer(NewServer(s))
+	defer srv.Close()
+
+	resp, body := get(t, srv, "/movie.mkv", "bytes=2-5")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "bytes 2-5/10", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "cdef", body)
+
+	resp, body = get(t, srv, "/movie.mkv", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.Equal(t, string(content), body)
+
+	resp, body = get(t, srv, "/", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `<a href="/movie.mkv">movie.mkv</a>`)
+
+	resp, _ = get(t, srv, "/other.mkv", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServerWaitsForPiece(t *testing.T) {
+	release := make(chan struct{})
+	seed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		http.ServeContent(w, r, "movie.mkv", time.Time{}, bytes.NewReader(content))
+	}))
+	defer seed.Close()
+
+	s := newSession(make(memStorage, len(content)))
+	s.WebSeeds = []engine.WebSeed{{URLs: []string{seed.URL}}}
+	s.SetSequential(true)
+	srv := httptest.NewServer(NewServer(s))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ran := make(chan error, 1)
+	go func() { ran <- s.Run(ctx) }()
+
+	got := make(chan string, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/movie.mkv", nil)
+		req.Header.Set("Range", "bytes=5-6")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		got <- string(body)
+	}()
+	select {
+	case body := <-got:
+		t.Fatalf("read %q before piece 1 was downloaded", body)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case body := <-got:
+		assert.Equal(t, "fg", body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read did not finish once the piece was downloaded")
+	}
+	require.NoError(t, <-ran)
+}
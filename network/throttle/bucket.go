@@ -0,0 +1,129 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// maxSleep bounds a single wait so rate changes made at runtime take
+// effect quickly even for callers already blocked in Wait.
+const maxSleep = 100 * time.Millisecond
+
+// Bucket is a token bucket limiting throughput to a number of bytes per
+// second. A rate of zero means unlimited. A nil *Bucket is also unlimited.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   int
+	tokens float64
+	last   time.Time
+}
+
+func NewBucket(rate int) *Bucket {
+	return &Bucket{
+		rate:   rate,
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+func (b *Bucket) Rate() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate
+}
+
+func (b *Bucket) SetRate(rate int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.rate = rate
+	if b.tokens > float64(b.burst()) {
+		b.tokens = float64(b.burst())
+	}
+}
+
+// burst is the bucket capacity: one second worth of traffic.
+func (b *Bucket) burst() int {
+	return b.rate
+}
+
+func (b *Bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	if b.rate == 0 {
+		return
+	}
+	b.tokens += elapsed * float64(b.rate)
+	if b.tokens > float64(b.burst()) {
+		b.tokens = float64(b.burst())
+	}
+}
+
+// Wait blocks until n bytes may pass through the bucket.
+func (b *Bucket) Wait(n int) {
+	if b == nil {
+		return
+	}
+	for n > 0 {
+		b.mu.Lock()
+		b.refill(time.Now())
+		if b.rate == 0 {
+			b.mu.Unlock()
+			return
+		}
+
+		take := n
+		if take > b.burst() {
+			take = b.burst()
+		}
+		if b.tokens >= float64(take) {
+			b.tokens -= float64(take)
+			n -= take
+			b.mu.Unlock()
+			continue
+		}
+
+		missing := float64(take) - b.tokens
+		delay := time.Duration(missing / float64(b.rate) * float64(time.Second))
+		b.mu.Unlock()
+
+		if delay > maxSleep {
+			delay = maxSleep
+		}
+		time.Sleep(delay)
+	}
+}
+
+// Set pairs a download and an upload bucket.
+type Set struct {
+	Down *Bucket
+	Up   *Bucket
+}
+
+func NewSet(down, up int) *Set {
+	return &Set{
+		Down: NewBucket(down),
+		Up:   NewBucket(up),
+	}
+}
+
+func (s *Set) SetLimits(l Limits) {
+	if s == nil {
+		return
+	}
+	s.Down.SetRate(l.Down)
+	s.Up.SetRate(l.Up)
+}
+
+func (s *Set) Limits() Limits {
+	if s == nil {
+		return Limits{}
+	}
+	return Limits{Down: s.Down.Rate(), Up: s.Up.Rate()}
+}
@@ -0,0 +1,53 @@
+package throttle
+
+import "net"
+
+// chunkSize caps a single read or write so that one large frame does not
+// starve the other connections sharing a bucket.
+const chunkSize = 16 * 1024
+
+// Conn charges every byte read or written, including protocol overhead,
+// against a list of buckets.
+type Conn struct {
+	net.Conn
+	Down []*Bucket
+	Up   []*Bucket
+}
+
+// Wrap returns conn unchanged when there is nothing to limit.
+func Wrap(conn net.Conn, down, up []*Bucket) net.Conn {
+	if len(down) == 0 && len(up) == 0 {
+		return conn
+	}
+	return &Conn{Conn: conn, Down: down, Up: up}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := c.Conn.Read(p)
+	for _, b := range c.Down {
+		b.Wait(n)
+	}
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + chunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		for _, b := range c.Up {
+			b.Wait(end - written)
+		}
+		n, err := c.Conn.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,125 @@
+package throttle
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// Limits are rates in bytes per second; zero means unlimited.
+type Limits struct {
+	Down int
+	Up   int
+}
+
+// Rule replaces the normal limits between From and To, measured from
+// midnight local time. A window whose end is before its start wraps past
+// midnight.
+type Rule struct {
+	From   time.Duration
+	To     time.Duration
+	Limits Limits
+}
+
+func (r Rule) covers(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if r.From <= r.To {
+		return offset >= r.From && offset < r.To
+	}
+	return offset >= r.From || offset < r.To
+}
+
+// Schedule is the alternative, time-of-day limit set. The first rule
+// covering the current time wins, otherwise Normal applies.
+type Schedule struct {
+	Normal Limits
+	Rules  []Rule
+}
+
+func (s *Schedule) At(t time.Time) Limits {
+	for _, r := range s.Rules {
+		if r.covers(t) {
+			return r.Limits
+		}
+	}
+	return s.Normal
+}
+
+// Run applies the schedule to set every minute until stop is closed.
+func (s *Schedule) Run(set *Set, stop <-chan struct{}) {
+	set.SetLimits(s.At(time.Now()))
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			set.SetLimits(s.At(now))
+		}
+	}
+}
+
+// ParseSchedule reads comma separated rules of the form
+// "HH:MM-HH:MM=down/up" with rates in KiB/s, e.g. "08:00-18:00=512/64".
+func ParseSchedule(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		window, rates, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSchedule, part)
+		}
+		from, to, ok := strings.Cut(window, "-")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSchedule, part)
+		}
+		down, up, ok := strings.Cut(rates, "/")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSchedule, part)
+		}
+
+		var r Rule
+		var err error
+		if r.From, err = parseClock(from); err != nil {
+			return nil, err
+		}
+		if r.To, err = parseClock(to); err != nil {
+			return nil, err
+		}
+		if r.Limits.Down, err = parseKiB(down); err != nil {
+			return nil, err
+		}
+		if r.Limits.Up, err = parseKiB(up); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%w: clock %q", ErrInvalidSchedule, s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseKiB(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: rate %q", ErrInvalidSchedule, s)
+	}
+	return n * 1024, nil
+}
@@ -0,0 +1,76 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	cases := map[string]struct {
+		spec string
+		want []Rule
+		fail bool
+	}{
+		"single window": {
+			spec: "08:00-18:30=512/64",
+			want: []Rule{
+				{From: 8 * time.Hour, To: 18*time.Hour + 30*time.Minute, Limits: Limits{Down: 512 * 1024, Up: 64 * 1024}},
+			},
+		},
+		"two windows": {
+			spec: "22:00-06:00=0/0, 06:00-22:00=100/10",
+			want: []Rule{
+				{From: 22 * time.Hour, To: 6 * time.Hour, Limits: Limits{}},
+				{From: 6 * time.Hour, To: 22 * time.Hour, Limits: Limits{Down: 100 * 1024, Up: 10 * 1024}},
+			},
+		},
+		"missing rates": {
+			spec: "08:00-18:00",
+			fail: true,
+		},
+		"bad clock": {
+			spec: "8h-18:00=1/1",
+			fail: true,
+		},
+	}
+
+	for _, c := range cases {
+		got, err := ParseSchedule(c.spec)
+		if c.fail {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, c.want, got)
+	}
+}
+
+func TestScheduleAt(t *testing.T) {
+	s := Schedule{
+		Normal: Limits{Down: 1, Up: 1},
+		Rules: []Rule{
+			{From: 22 * time.Hour, To: 6 * time.Hour, Limits: Limits{Down: 2, Up: 2}},
+		},
+	}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+
+	assert.Equal(t, Limits{Down: 2, Up: 2}, s.At(day.Add(23*time.Hour)))
+	assert.Equal(t, Limits{Down: 2, Up: 2}, s.At(day.Add(3*time.Hour)))
+	assert.Equal(t, Limits{Down: 1, Up: 1}, s.At(day.Add(12*time.Hour)))
+}
+
+func TestBucketWait(t *testing.T) {
+	b := NewBucket(100 * 1024)
+	b.Wait(100 * 1024)
+
+	start := time.Now()
+	b.Wait(20 * 1024)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	b.SetRate(0)
+	start = time.Now()
+	b.Wait(10 * 1024 * 1024)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
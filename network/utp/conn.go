@@ -0,0 +1,491 @@
+package utp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPayload keeps datagrams below common tunnel MTUs.
+	maxPayload = 1200
+	// recvWindow is the receive buffer advertised to the other side.
+	recvWindow = 1 << 20
+
+	minWindow  = 2 * maxPayload
+	maxWindow  = 1 << 20
+	initWindow = 4 * maxPayload
+	// targetDelay is the queuing delay LEDBAT aims for.
+	targetDelay = 100000 // µs
+	// maxGain is how far the window may grow per round trip.
+	maxGain = 3000
+
+	minRTO       = 500 * time.Millisecond
+	maxRTO       = 30 * time.Second
+	maxResends   = 6
+	synResends   = 3
+	idleTimeout  = 60 * time.Second
+	keepalive    = 29 * time.Second
+	baseInterval = time.Minute
+)
+
+var (
+	ErrClosed  = errors.New("utp: connection closed")
+	ErrReset   = errors.New("utp: connection reset by peer")
+	ErrTimeout = errors.New("utp: connection timed out")
+)
+
+const (
+	stateSynSent = iota
+	stateConnected
+	stateFinSent
+	stateClosed
+)
+
+type outPacket struct {
+	typ           uint8
+	seq           uint16
+	payload       []byte
+	sent          time.Time
+	transmissions int
+}
+
+// Conn is a single uTP stream. It implements net.Conn.
+type Conn struct {
+	sock   *Socket
+	remote net.Addr
+	recvID uint16
+	sendID uint16
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	state int
+	err   error
+
+	seqNr uint16
+	ackNr uint16
+
+	inflight []*outPacket
+	inBytes  int
+	window   float64
+	peerWnd  int
+	dupAcks  int
+
+	rtt    time.Duration
+	rttVar time.Duration
+	rto    time.Duration
+
+	// baseDelay is the lowest one-way delay seen over the last two
+	// intervals, the reference LEDBAT measures queuing against.
+	baseDelay  [2]uint32
+	baseAt     time.Time
+	replyMicro uint32
+
+	buf      []byte
+	ooo      map[uint16][]byte
+	finSeq   uint16
+	gotFin   bool
+	eof      bool
+	lastRecv time.Time
+	lastSend time.Time
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readTimer     *time.Timer
+	writeTimer    *time.Timer
+}
+
+func newConn(s *Socket, remote net.Addr, recvID, sendID uint16) *Conn {
+	now := time.Now()
+	c := &Conn{
+		sock:      s,
+		remote:    remote,
+		recvID:    recvID,
+		sendID:    sendID,
+		window:    initWindow,
+		peerWnd:   maxPayload,
+		rto:       time.Second,
+		baseDelay: [2]uint32{^uint32(0), ^uint32(0)},
+		baseAt:    now,
+		ooo:       make(map[uint16][]byte),
+		lastRecv:  now,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// send writes a packet, caller holds mu.
+func (c *Conn) send(typ uint8, seq uint16, payload []byte) {
+	now := time.Now()
+	wnd := recvWindow - len(c.buf)
+	if wnd < 0 {
+		wnd = 0
+	}
+	h := header{
+		typ:       typ,
+		connID:    c.sendID,
+		timestamp: micros(now),
+		timeDiff:  c.replyMicro,
+		wndSize:   uint32(wnd),
+		seqNr:     seq,
+		ackNr:     c.ackNr,
+	}
+	if typ == stSyn {
+		h.connID = c.recvID
+	}
+	c.lastSend = now
+	c.sock.pc.WriteTo(h.marshal(payload), c.remote)
+}
+
+// queue sends a packet that takes a sequence number and keeps it until it
+// is acknowledged, caller holds mu.
+func (c *Conn) queue(typ uint8, payload []byte) {
+	p := &outPacket{typ: typ, seq: c.seqNr, payload: payload, sent: time.Now(), transmissions: 1}
+	c.seqNr++
+	c.inflight = append(c.inflight, p)
+	c.inBytes += len(payload)
+	c.send(typ, p.seq, payload)
+}
+
+func (c *Conn) resend(p *outPacket) {
+	p.sent = time.Now()
+	p.transmissions++
+	c.send(p.typ, p.seq, p.payload)
+}
+
+func (c *Conn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	c.state = stateClosed
+	c.cond.Broadcast()
+}
+
+// handle processes an incoming packet for this connection.
+func (c *Conn) handle(h header, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.lastRecv = now
+	c.replyMicro = micros(now) - h.timestamp
+	c.peerWnd = int(h.wndSize)
+
+	if h.typ == stReset {
+		c.fail(ErrReset)
+		c.sock.forget(c)
+		return
+	}
+
+	if c.state == stateSynSent && h.typ == stState {
+		c.state = stateConnected
+		c.ackNr = h.seqNr - 1
+	}
+
+	c.processAck(h, now)
+
+	switch h.typ {
+	case stData, stFin:
+		if h.typ == stFin && !c.gotFin {
+			c.gotFin = true
+			c.finSeq = h.seqNr
+		}
+		c.receive(h.seqNr, payload)
+		c.send(stState, c.seqNr, nil)
+	}
+
+	if c.state == stateFinSent && len(c.inflight) == 0 && c.eof {
+		c.fail(ErrClosed)
+		c.sock.forget(c)
+	}
+	c.cond.Broadcast()
+}
+
+func (c *Conn) processAck(h header, now time.Time) {
+	acked := 0
+	for len(c.inflight) > 0 && !seqLess(h.ackNr, c.inflight[0].seq) {
+		p := c.inflight[0]
+		c.inflight = c.inflight[1:]
+		c.inBytes -= len(p.payload)
+		acked += len(p.payload)
+		if p.transmissions == 1 {
+			c.sampleRTT(now.Sub(p.sent))
+		}
+	}
+
+	if acked > 0 {
+		c.dupAcks = 0
+		if h.timeDiff != 0 {
+			c.ledbat(h.timeDiff, acked, now)
+		}
+		return
+	}
+	if h.typ == stState && len(c.inflight) > 0 && h.ackNr+1 == c.inflight[0].seq {
+		c.dupAcks++
+		if c.dupAcks == 3 {
+			c.window /= 2
+			if c.window < minWindow {
+				c.window = minWindow
+			}
+			c.resend(c.inflight[0])
+		}
+	}
+}
+
+func (c *Conn) sampleRTT(sample time.Duration) {
+	if c.rtt == 0 {
+		c.rtt = sample
+		c.rttVar = sample / 2
+	} else {
+		delta := c.rtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttVar += (delta - c.rttVar) / 4
+		c.rtt += (sample - c.rtt) / 8
+	}
+	c.rto = c.rtt + 4*c.rttVar
+	if c.rto < minRTO {
+		c.rto = minRTO
+	}
+}
+
+// ledbat grows the window while the measured queuing delay is below target
+// and shrinks it when above, so uTP yields to other traffic.
+func (c *Conn) ledbat(delay uint32, acked int, now time.Time) {
+	if now.Sub(c.baseAt) > baseInterval {
+		c.baseDelay[1] = c.baseDelay[0]
+		c.baseDelay[0] = ^uint32(0)
+		c.baseAt = now
+	}
+	if delay < c.baseDelay[0] {
+		c.baseDelay[0] = delay
+	}
+	base := c.baseDelay[0]
+	if c.baseDelay[1] < base {
+		base = c.baseDelay[1]
+	}
+
+	queuing := float64(delay - base)
+	offTarget := (targetDelay - queuing) / targetDelay
+	factor := float64(acked) / c.window
+	if factor > 1 {
+		factor = 1
+	}
+	c.window += maxGain * offTarget * factor
+	if c.window < minWindow {
+		c.window = minWindow
+	}
+	if c.window > maxWindow {
+		c.window = maxWindow
+	}
+}
+
+// receive delivers data in sequence order, caller holds mu.
+func (c *Conn) receive(seq uint16, payload []byte) {
+	if !seqLess(c.ackNr, seq) {
+		return // duplicate
+	}
+	if seq != c.ackNr+1 {
+		if len(c.ooo) < recvWindow/maxPayload {
+			c.ooo[seq] = append([]byte(nil), payload...)
+		}
+		return
+	}
+	c.buf = append(c.buf, payload...)
+	c.ackNr = seq
+	for {
+		next, ok := c.ooo[c.ackNr+1]
+		if !ok {
+			break
+		}
+		delete(c.ooo, c.ackNr+1)
+		c.buf = append(c.buf, next...)
+		c.ackNr++
+	}
+	if c.gotFin && c.ackNr == c.finSeq {
+		c.eof = true
+	}
+}
+
+// tick runs retransmission timers and keepalives.
+func (c *Conn) tick(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == stateClosed {
+		return
+	}
+	if now.Sub(c.lastRecv) > idleTimeout {
+		c.fail(ErrTimeout)
+		c.sock.forget(c)
+		return
+	}
+	if len(c.inflight) == 0 {
+		if c.state == stateConnected && now.Sub(c.lastSend) > keepalive {
+			c.send(stState, c.seqNr, nil)
+		}
+		return
+	}
+
+	first := c.inflight[0]
+	if now.Sub(first.sent) < c.rto {
+		return
+	}
+	limit := maxResends
+	if first.typ == stSyn {
+		limit = synResends
+	}
+	if first.transmissions > limit {
+		c.fail(ErrTimeout)
+		c.sock.forget(c)
+		return
+	}
+	c.window = minWindow
+	c.rto *= 2
+	if c.rto > maxRTO {
+		c.rto = maxRTO
+	}
+	c.resend(first)
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.buf) == 0 {
+		switch {
+		case c.eof:
+			return 0, io.EOF
+		case c.err != nil:
+			return 0, c.err
+		case expired(c.readDeadline):
+			return 0, os.ErrDeadlineExceeded
+		}
+		c.cond.Wait()
+	}
+	n := copy(p, c.buf)
+	opened := len(c.buf) >= recvWindow-maxPayload
+	c.buf = c.buf[n:]
+	if opened {
+		// Let the sender know the window opened again.
+		c.send(stState, c.seqNr, nil)
+	}
+	return n, nil
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	written := 0
+	for written < len(p) {
+		if c.err != nil {
+			return written, c.err
+		}
+		if c.state != stateConnected {
+			return written, ErrClosed
+		}
+		if expired(c.writeDeadline) {
+			return written, os.ErrDeadlineExceeded
+		}
+		n := len(p) - written
+		if n > maxPayload {
+			n = maxPayload
+		}
+		if len(c.inflight) > 0 && c.inBytes+n > c.sendWindow() {
+			c.cond.Wait()
+			continue
+		}
+		c.queue(stData, append([]byte(nil), p[written:written+n]...))
+		written += n
+	}
+	return written, nil
+}
+
+func (c *Conn) sendWindow() int {
+	w := int(c.window)
+	if c.peerWnd < w {
+		w = c.peerWnd
+	}
+	return w
+}
+
+// CloseWrite sends a FIN while leaving the connection open for reading.
+func (c *Conn) CloseWrite() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeWrite()
+	return nil
+}
+
+func (c *Conn) closeWrite() {
+	if c.state == stateConnected {
+		c.queue(stFin, nil)
+		c.state = stateFinSent
+		c.cond.Broadcast()
+	}
+}
+
+// Close sends a FIN and returns without waiting for it to be acknowledged.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state {
+	case stateConnected, stateFinSent:
+		c.closeWrite()
+		if c.err == nil {
+			c.err = ErrClosed
+		}
+		c.cond.Broadcast()
+	case stateSynSent:
+		c.fail(ErrClosed)
+		c.sock.forget(c)
+	}
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.sock.Addr() }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.readTimer = c.wakeAt(c.readTimer, t)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	c.writeTimer = c.wakeAt(c.writeTimer, t)
+	return nil
+}
+
+// wakeAt arranges for blocked readers and writers to recheck their
+// deadline at t.
+func (c *Conn) wakeAt(timer *time.Timer, t time.Time) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+	}
+	if t.IsZero() {
+		return nil
+	}
+	return time.AfterFunc(time.Until(t), func() {
+		c.mu.Lock()
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	})
+}
+
+func expired(t time.Time) bool {
+	return !t.IsZero() && !time.Now().Before(t)
+}
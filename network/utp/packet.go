@@ -0,0 +1,87 @@
+package utp
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	stData  = 0
+	stFin   = 1
+	stState = 2
+	stReset = 3
+	stSyn   = 4
+
+	version    = 1
+	headerSize = 20
+)
+
+var errNotUTP = errors.New("not a uTP packet")
+
+type header struct {
+	typ       uint8
+	extension uint8
+	connID    uint16
+	timestamp uint32
+	timeDiff  uint32
+	wndSize   uint32
+	seqNr     uint16
+	ackNr     uint16
+}
+
+func (h *header) marshal(payload []byte) []byte {
+	out := make([]byte, headerSize+len(payload))
+	out[0] = h.typ<<4 | version
+	out[1] = 0
+	binary.BigEndian.PutUint16(out[2:4], h.connID)
+	binary.BigEndian.PutUint32(out[4:8], h.timestamp)
+	binary.BigEndian.PutUint32(out[8:12], h.timeDiff)
+	binary.BigEndian.PutUint32(out[12:16], h.wndSize)
+	binary.BigEndian.PutUint16(out[16:18], h.seqNr)
+	binary.BigEndian.PutUint16(out[18:20], h.ackNr)
+	copy(out[headerSize:], payload)
+	return out
+}
+
+// unmarshal parses a datagram. Anything that is not a well formed uTP
+// packet, such as a bencoded DHT message or a UDP tracker reply, is
+// rejected so the socket can hand it on.
+func unmarshal(b []byte) (header, []byte, error) {
+	var h header
+	if len(b) < headerSize || b[0]&0x0f != version || b[0]>>4 > stSyn {
+		return h, nil, errNotUTP
+	}
+	h.typ = b[0] >> 4
+	h.extension = b[1]
+	h.connID = binary.BigEndian.Uint16(b[2:4])
+	h.timestamp = binary.BigEndian.Uint32(b[4:8])
+	h.timeDiff = binary.BigEndian.Uint32(b[8:12])
+	h.wndSize = binary.BigEndian.Uint32(b[12:16])
+	h.seqNr = binary.BigEndian.Uint16(b[16:18])
+	h.ackNr = binary.BigEndian.Uint16(b[18:20])
+
+	// Skip extension headers; selective acks are not used.
+	payload := b[headerSize:]
+	for next := h.extension; next != 0; {
+		if len(payload) < 2 {
+			return h, nil, errNotUTP
+		}
+		size := int(payload[1])
+		if len(payload) < 2+size {
+			return h, nil, errNotUTP
+		}
+		next = payload[0]
+		payload = payload[2+size:]
+	}
+	return h, payload, nil
+}
+
+// seqLess compares sequence numbers modulo 2^16.
+func seqLess(a, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+func micros(t time.Time) uint32 {
+	return uint32(t.UnixMicro())
+}
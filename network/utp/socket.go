@@ -0,0 +1,260 @@
+package utp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	tickInterval = 100 * time.Millisecond
+	backlog      = 32
+)
+
+var ErrSocketClosed = errors.New("utp: socket closed")
+
+type connKey struct {
+	addr string
+	id   uint16
+}
+
+// Socket multiplexes uTP connections over one UDP socket. Datagrams that
+// are not uTP, such as DHT queries, are passed to the Unhandled callback
+// so other protocols can share the port. It implements net.Listener.
+type Socket struct {
+	pc net.PacketConn
+
+	mu        sync.Mutex
+	conns     map[connKey]*Conn
+	unhandled func(b []byte, addr net.Addr)
+
+	accept chan *Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Listen opens a UDP socket on addr and starts serving uTP on it.
+func Listen(addr string) (*Socket, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewSocket(pc), nil
+}
+
+func NewSocket(pc net.PacketConn) *Socket {
+	s := &Socket{
+		pc:     pc,
+		conns:  make(map[connKey]*Conn),
+		accept: make(chan *Conn, backlog),
+		closed: make(chan struct{}),
+	}
+	go s.serve()
+	go s.timers()
+	return s
+}
+
+// Unhandled sets the callback for datagrams that are not uTP packets. The
+// buffer is only valid for the duration of the call.
+func (s *Socket) Unhandled(fn func(b []byte, addr net.Addr)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unhandled = fn
+}
+
+// WriteTo sends a raw datagram from the shared socket.
+func (s *Socket) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return s.pc.WriteTo(b, addr)
+}
+
+func (s *Socket) Addr() net.Addr {
+	return s.pc.LocalAddr()
+}
+
+func (s *Socket) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.closed)
+		err = s.pc.Close()
+
+		s.mu.Lock()
+		conns := make([]*Conn, 0, len(s.conns))
+		for _, c := range s.conns {
+			conns = append(conns, c)
+		}
+		s.conns = map[connKey]*Conn{}
+		s.mu.Unlock()
+
+		for _, c := range conns {
+			c.mu.Lock()
+			c.fail(ErrSocketClosed)
+			c.mu.Unlock()
+		}
+	})
+	return err
+}
+
+// Accept waits for the next incoming connection.
+func (s *Socket) Accept() (net.Conn, error) {
+	select {
+	case c := <-s.accept:
+		return c, nil
+	case <-s.closed:
+		return nil, ErrSocketClosed
+	}
+}
+
+// Dial opens a uTP connection to addr, giving up after timeout.
+func (s *Socket) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	var id uint16
+	for {
+		id = randomID()
+		_, taken := s.conns[connKey{raddr.String(), id}]
+		_, takenSend := s.conns[connKey{raddr.String(), id + 1}]
+		if !taken && !takenSend {
+			break
+		}
+	}
+	c := newConn(s, raddr, id, id+1)
+	s.conns[connKey{raddr.String(), id}] = c
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seqNr = 1
+	c.queue(stSyn, nil)
+
+	deadline := time.Now().Add(timeout)
+	timer := c.wakeAt(nil, deadline)
+	defer timer.Stop()
+	for c.state == stateSynSent {
+		if expired(deadline) {
+			c.fail(ErrTimeout)
+			s.forget(c)
+			return nil, ErrTimeout
+		}
+		c.cond.Wait()
+	}
+	if c.state != stateConnected {
+		return nil, c.err
+	}
+	return c, nil
+}
+
+func (s *Socket) forget(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := connKey{c.remote.String(), c.recvID}
+	if s.conns[key] == c {
+		delete(s.conns, key)
+	}
+}
+
+func (s *Socket) serve() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			s.Close()
+			return
+		}
+
+		h, payload, err := unmarshal(buf[:n])
+		if err != nil {
+			s.mu.Lock()
+			fn := s.unhandled
+			s.mu.Unlock()
+			if fn != nil {
+				fn(buf[:n], addr)
+			}
+			continue
+		}
+
+		if h.typ == stSyn {
+			s.incoming(h, addr)
+			continue
+		}
+
+		s.mu.Lock()
+		c := s.conns[connKey{addr.String(), h.connID}]
+		s.mu.Unlock()
+		if c != nil {
+			c.handle(h, payload)
+		} else if h.typ != stReset && h.typ != stState {
+			s.reset(h, addr)
+		}
+	}
+}
+
+// incoming answers a SYN, repeating the reply if the SYN was resent.
+func (s *Socket) incoming(h header, addr net.Addr) {
+	key := connKey{addr.String(), h.connID + 1}
+
+	s.mu.Lock()
+	c, ok := s.conns[key]
+	if !ok {
+		c = newConn(s, addr, h.connID+1, h.connID)
+		c.state = stateConnected
+		c.ackNr = h.seqNr
+		c.seqNr = randomID()
+		c.replyMicro = micros(time.Now()) - h.timestamp
+		c.peerWnd = int(h.wndSize)
+		select {
+		case s.accept <- c:
+			s.conns[key] = c
+		default:
+			s.mu.Unlock()
+			s.reset(h, addr)
+			return
+		}
+	}
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	c.send(stState, c.seqNr, nil)
+	c.mu.Unlock()
+}
+
+func (s *Socket) reset(h header, addr net.Addr) {
+	r := header{typ: stReset, connID: h.connID, timestamp: micros(time.Now()), ackNr: h.seqNr}
+	s.pc.WriteTo(r.marshal(nil), addr)
+}
+
+func (s *Socket) timers() {
+	t := time.NewTicker(tickInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case now := <-t.C:
+			s.mu.Lock()
+			conns := make([]*Conn, 0, len(s.conns))
+			for _, c := range s.conns {
+				conns = append(conns, c)
+			}
+			s.mu.Unlock()
+			for _, c := range conns {
+				c.tick(now)
+			}
+		}
+	}
+}
+
+func randomID() uint16 {
+	var b [2]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
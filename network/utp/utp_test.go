@@ -0,0 +1,181 @@
+package utp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	h := header{typ: stData, connID: 7, timestamp: 1, timeDiff: 2, wndSize: 3, seqNr: 4, ackNr: 5}
+	got, payload, err := unmarshal(h.marshal([]byte("abc")))
+	require.NoError(t, err)
+	assert.Equal(t, h, got)
+	assert.Equal(t, []byte("abc"), payload)
+}
+
+func TestUnmarshalRejectsOtherProtocols(t *testing.T) {
+	tests := map[string][]byte{
+		"short":       {0x41, 0},
+		"dht":         []byte("d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe"),
+		"udp tracker": append(make([]byte, 4), make([]byte, 16)...),
+	}
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := unmarshal(b)
+			assert.ErrorIs(t, err, errNotUTP)
+		})
+	}
+}
+
+func TestSeqLess(t *testing.T) {
+	assert.True(t, seqLess(1, 2))
+	assert.False(t, seqLess(2, 1))
+	assert.True(t, seqLess(65535, 0))
+	assert.False(t, seqLess(3, 3))
+}
+
+func pair(t *testing.T) (*Socket, *Socket) {
+	a, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	b, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestTransfer(t *testing.T) {
+	client, server := pair(t)
+
+	data := make([]byte, 512*1024)
+	rand.Read(data)
+
+	done := make(chan []byte)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			done <- nil
+			return
+		}
+		got, _ := io.ReadAll(conn)
+		conn.Write([]byte("ok"))
+		conn.Close()
+		done <- got
+	}()
+
+	conn, err := client.Dial(server.Addr().String(), 2*time.Second)
+	require.NoError(t, err)
+	_, err = conn.Write(data)
+	require.NoError(t, err)
+
+	// Half close: the server reads to EOF, then still answers.
+	require.NoError(t, conn.(*Conn).CloseWrite())
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(reply))
+
+	assert.True(t, bytes.Equal(data, <-done))
+	conn.Close()
+}
+
+func TestDialTimeout(t *testing.T) {
+	client, _ := pair(t)
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer silent.Close()
+
+	_, err = client.Dial(silent.LocalAddr().String(), 300*time.Millisecond)
+	assert.ErrorIs(t, err, ErrTimeout)
+}
+
+func TestUnhandled(t *testing.T) {
+	a, b := pair(t)
+	got := make(chan string, 1)
+	b.Unhandled(func(p []byte, addr net.Addr) {
+		got <- string(p)
+	})
+
+	_, err := a.WriteTo([]byte("d1:y1:qe"), b.Addr())
+	require.NoError(t, err)
+	select {
+	case msg := <-got:
+		assert.Equal(t, "d1:y1:qe", msg)
+	case <-time.After(time.Second):
+		t.Fatal("datagram not passed on")
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	client, server := pair(t)
+	go server.Accept()
+
+	conn, err := client.Dial(server.Addr().String(), 2*time.Second)
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	var ne net.Error
+	require.ErrorAs(t, err, &ne)
+	assert.True(t, ne.Timeout())
+}
+
+// lossy drops every nth datagram it sends.
+type lossy struct {
+	net.PacketConn
+	n, sent int
+}
+
+func (l *lossy) WriteTo(b []byte, addr net.Addr) (int, error) {
+	l.sent++
+	if l.sent%l.n == 0 {
+		return len(b), nil
+	}
+	return l.PacketConn.WriteTo(b, addr)
+}
+
+func TestTransferWithLoss(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	client := NewSocket(&lossy{PacketConn: pc, n: 7})
+	server, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer client.Close()
+	defer server.Close()
+
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+
+	done := make(chan []byte)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			done <- nil
+			return
+		}
+		got := make([]byte, len(data))
+		io.ReadFull(conn, got)
+		done <- got
+	}()
+
+	conn, err := client.Dial(server.Addr().String(), 5*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(data)
+	require.NoError(t, err)
+
+	select {
+	case got := <-done:
+		assert.True(t, bytes.Equal(data, got))
+	case <-time.After(20 * time.Second):
+		t.Fatal("transfer did not complete")
+	}
+}
@@ -0,0 +1,59 @@
+package extension
+
+import (
+	"bytes"
+
+	"github.com/Sabir222/torrent-at-home/protocol/frames"
+	"github.com/jackpal/bencode-go"
+)
+
+// HandshakeID is the extended message id reserved for the handshake itself.
+const HandshakeID = 0
+
+// Handshake is the BEP 10 extended handshake dictionary.
+type Handshake struct {
+	M      map[string]int `bencode:"m"`
+	V      string         `bencode:"v,omitempty"`
+	Port   int            `bencode:"p,omitempty"`
+	Reqq   int            `bencode:"reqq,omitempty"`
+	Size   int            `bencode:"metadata_size,omitempty"`
+	YourIP string         `bencode:"yourip,omitempty"`
+}
+
+// Frame wraps a payload in an extended message with the given id.
+func Frame(id byte, payload []byte) *frames.Frame {
+	data := make([]byte, 1+len(payload))
+	data[0] = id
+	copy(data[1:], payload)
+	return &frames.Frame{Type: frames.TypeExtended, Data: data}
+}
+
+func (h *Handshake) Frame() (*frames.Frame, error) {
+	if h.M == nil {
+		h.M = map[string]int{}
+	}
+	var buf bytes.Buffer
+	if err := bencode.Marshal(&buf, *h); err != nil {
+		return nil, err
+	}
+	return Frame(HandshakeID, buf.Bytes()), nil
+}
+
+// Split returns the extended message id and payload of an extended frame.
+func Split(frm *frames.Frame) (byte, []byte, error) {
+	if frm.Type != frames.TypeExtended {
+		return 0, nil, frames.ErrInvalidType
+	}
+	if len(frm.Data) < 1 {
+		return 0, nil, frames.ErrPayloadTooShort
+	}
+	return frm.Data[0], frm.Data[1:], nil
+}
+
+func ParseHandshake(payload []byte) (*Handshake, error) {
+	var h Handshake
+	if err := bencode.Unmarshal(bytes.NewReader(payload), &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
@@ -0,0 +1,90 @@
+package extension
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/jackpal/bencode-go"
+)
+
+// Metadata is the BEP 9 extension that transfers the info dictionary,
+// used to start from a magnet link.
+const Metadata = "ut_metadata"
+
+// MetadataPieceSize is the size of every metadata piece but the last.
+const MetadataPieceSize = 16384
+
+const (
+	MetadataRequest = 0
+	MetadataData    = 1
+	MetadataReject  = 2
+)
+
+var ErrBadMetadataMessage = errors.New("malformed ut_metadata message")
+
+// MetadataMessage is the dictionary heading every ut_metadata message. A
+// data message is followed by the piece itself.
+type MetadataMessage struct {
+	Type      int `bencode:"msg_type"`
+	Piece     int `bencode:"piece"`
+	TotalSize int `bencode:"total_size,omitempty"`
+}
+
+func (m MetadataMessage) Marshal() []byte {
+	var buf bytes.Buffer
+	bencode.Marshal(&buf, m)
+	return buf.Bytes()
+}
+
+// ParseMetadata splits a ut_metadata payload into its dictionary and, for
+// data messages, the piece that follows it.
+func ParseMetadata(payload []byte) (MetadataMessage, []byte, error) {
+	var m MetadataMessage
+	end := dictEnd(payload)
+	if end < 0 {
+		return m, nil, ErrBadMetadataMessage
+	}
+	if err := bencode.Unmarshal(bytes.NewReader(payload[:end]), &m); err != nil {
+		return m, nil, ErrBadMetadataMessage
+	}
+	return m, payload[end:], nil
+}
+
+// dictEnd finds the end of a flat dictionary of integers and strings,
+// which is all ut_metadata uses, or -1.
+func dictEnd(b []byte) int {
+	if len(b) == 0 || b[0] != 'd' {
+		return -1
+	}
+	for pos := 1; pos < len(b); {
+		switch c := b[pos]; {
+		case c == 'e':
+			return pos + 1
+		case c == 'i':
+			end := bytes.IndexByte(b[pos:], 'e')
+			if end < 0 {
+				return -1
+			}
+			pos += end + 1
+		case c >= '0' && c <= '9':
+			colon := bytes.IndexByte(b[pos:], ':')
+			if colon < 0 {
+				return -1
+			}
+			n := 0
+			for _, d := range b[pos : pos+colon] {
+				if d < '0' || d > '9' {
+					return -1
+				}
+				n = n*10 + int(d-'0')
+				if n > len(b) {
+					return -1
+				}
+			}
+			pos += colon + 1 + n
+		default:
+			return -1
+		}
+	}
+	return -1
+}
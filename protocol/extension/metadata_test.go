@@ -0,0 +1,27 @@
+package extension
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMetadata(t *testing.T) {
+	req := MetadataMessage{Type: MetadataRequest, Piece: 2}
+	m, rest, err := ParseMetadata(req.Marshal())
+	require.NoError(t, err)
+	assert.Equal(t, req, m)
+	assert.Empty(t, rest)
+
+	data := MetadataMessage{Type: MetadataData, Piece: 0, TotalSize: 5}
+	m, rest, err = ParseMetadata(append(data.Marshal(), "d1:ae"...))
+	require.NoError(t, err)
+	assert.Equal(t, data, m)
+	assert.Equal(t, "d1:ae", string(rest))
+
+	for _, bad := range []string{"", "le", "d8:msg_typei1e", "d5:piece99:xe"} {
+		_, _, err := ParseMetadata([]byte(bad))
+		assert.ErrorIs(t, err, ErrBadMetadataMessage, bad)
+	}
+}
@@ -18,6 +18,18 @@ const (
 	TypeCancel
 )
 
+// Fast extension (BEP 6) messages.
+const (
+	TypeSuggest     = 0x0D
+	TypeHaveAll     = 0x0E
+	TypeHaveNone    = 0x0F
+	TypeReject      = 0x10
+	TypeAllowedFast = 0x11
+)
+
+// TypeExtended carries BEP 10 extension messages.
+const TypeExtended = 20
+
 var (
 	ErrInvalidType     = errors.New("invalid message type")
 	ErrPayloadTooShort = errors.New("payload too short")
@@ -51,6 +63,24 @@ func (c *Codec) Have(pieceIdx int) *Frame {
 	return &Frame{Type: TypeHave, Data: buf}
 }
 
+func (c *Codec) Reject(pieceIdx, offset, size int) *Frame {
+	frm := c.Request(pieceIdx, offset, size)
+	frm.Type = TypeReject
+	return frm
+}
+
+func (c *Codec) Suggest(pieceIdx int) *Frame {
+	frm := c.Have(pieceIdx)
+	frm.Type = TypeSuggest
+	return frm
+}
+
+func (c *Codec) AllowedFast(pieceIdx int) *Frame {
+	frm := c.Have(pieceIdx)
+	frm.Type = TypeAllowedFast
+	return frm
+}
+
 func ReadPieceData(target []byte, pieceIdx int, frm *Frame) (int, error) {
 	if frm.Type != TypePiece {
 		return 0, ErrInvalidType
@@ -88,6 +118,36 @@ func ReadHave(frm *Frame) (int, error) {
 	return int(binary.BigEndian.Uint32(frm.Data)), nil
 }
 
+// ReadIndex reads the piece index of a Have, Suggest Piece or Allowed Fast
+// frame.
+func ReadIndex(frm *Frame) (int, error) {
+	switch frm.Type {
+	case TypeHave, TypeSuggest, TypeAllowedFast:
+	default:
+		return 0, ErrInvalidType
+	}
+	if len(frm.Data) != 4 {
+		return 0, ErrPayloadTooShort
+	}
+	return int(binary.BigEndian.Uint32(frm.Data)), nil
+}
+
+// ReadRequest reads the block of a Request, Cancel or Reject Request frame.
+func ReadRequest(frm *Frame) (index, begin, length int, err error) {
+	switch frm.Type {
+	case TypeRequest, TypeCancel, TypeReject:
+	default:
+		return 0, 0, 0, ErrInvalidType
+	}
+	if len(frm.Data) != 12 {
+		return 0, 0, 0, ErrPayloadTooShort
+	}
+	index = int(binary.BigEndian.Uint32(frm.Data[0:4]))
+	begin = int(binary.BigEndian.Uint32(frm.Data[4:8]))
+	length = int(binary.BigEndian.Uint32(frm.Data[8:12]))
+	return index, begin, length, nil
+}
+
 func (f *Frame) Pack() []byte {
 	if f == nil {
 		return []byte{0, 0, 0, 0}
@@ -131,11 +191,23 @@ func (f *Frame) Label() string {
 	names := []string{
 		"Choke", "Unchoke", "Interested", "NotInterested",
 		"Have", "Bitfield", "Request", "Piece", "Cancel",
+		"Port", "", "", "", "SuggestPiece", "HaveAll", "HaveNone",
+		"RejectRequest", "AllowedFast",
 	}
 
-	if int(f.Type) < len(names) {
+	if int(f.Type) < len(names) && names[f.Type] != "" {
 		return names[f.Type]
 	}
+	switch f.Type {
+	case TypeExtended:
+		return "Extended"
+	case TypeHashRequest:
+		return "HashRequest"
+	case TypeHashes:
+		return "Hashes"
+	case TypeHashReject:
+		return "HashReject"
+	}
 	return "Unknown"
 }
 
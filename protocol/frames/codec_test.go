@@ -84,3 +84,45 @@ func TestReadPieceData(t *testing.T) {
 	assert.Equal(t, 6, n)
 	assert.Equal(t, []byte{0x00, 0x00, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x00, 0x00}, buf)
 }
+
+func TestFastFrames(t *testing.T) {
+	codec := NewCodec()
+
+	reject := codec.Reject(3, 16384, 100)
+	assert.Equal(t, "RejectRequest", reject.Label())
+	index, begin, length, err := ReadRequest(reject)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 16384, 100}, []int{index, begin, length})
+
+	for _, frm := range []*Frame{codec.Suggest(7), codec.AllowedFast(7)} {
+		idx, err := ReadIndex(frm)
+		assert.NoError(t, err)
+		assert.Equal(t, 7, idx)
+	}
+
+	_, err = ReadIndex(&Frame{Type: TypeHaveAll})
+	assert.ErrorIs(t, err, ErrInvalidType)
+	assert.Equal(t, "HaveNone", (&Frame{Type: TypeHaveNone}).Label())
+}
+
+func TestHashFrames(t *testing.T) {
+	codec := NewCodec()
+	req := HashRequest{Root: [32]byte{1}, BaseLayer: 2, Index: 4, Length: 2, ProofLayers: 1}
+
+	frm := codec.HashRequest(req)
+	assert.Equal(t, "HashRequest", frm.Label())
+	got, err := ReadHashRequest(frm)
+	assert.NoError(t, err)
+	assert.Equal(t, req, got)
+
+	hashes := [][32]byte{{9}, {8}, {7}}
+	got, gotHashes, err := ReadHashes(codec.Hashes(req, hashes))
+	assert.NoError(t, err)
+	assert.Equal(t, req, got)
+	assert.Equal(t, hashes, gotHashes)
+
+	_, _, err = ReadHashes(codec.HashReject(req))
+	assert.ErrorIs(t, err, ErrInvalidType)
+	_, err = ReadHashRequest(&Frame{Type: TypeHashRequest, Data: make([]byte, 10)})
+	assert.ErrorIs(t, err, ErrPayloadTooShort)
+}
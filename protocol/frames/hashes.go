@@ -0,0 +1,89 @@
+package frames
+
+import "encoding/binary"
+
+// BitTorrent v2 (BEP 52) hash transfer messages.
+const (
+	TypeHashRequest = 21
+	TypeHashes      = 22
+	TypeHashReject  = 23
+)
+
+const hashRequestSize = 48
+
+// HashRequest names a run of hashes in one layer of a file's merkle tree.
+// BaseLayer counts up from the 16 KiB block leaves.
+type HashRequest struct {
+	Root        [32]byte
+	BaseLayer   int
+	Index       int
+	Length      int
+	ProofLayers int
+}
+
+func (r HashRequest) pack(typ uint8, extra int) *Frame {
+	buf := make([]byte, hashRequestSize, hashRequestSize+extra)
+	copy(buf[0:32], r.Root[:])
+	binary.BigEndian.PutUint32(buf[32:36], uint32(r.BaseLayer))
+	binary.BigEndian.PutUint32(buf[36:40], uint32(r.Index))
+	binary.BigEndian.PutUint32(buf[40:44], uint32(r.Length))
+	binary.BigEndian.PutUint32(buf[44:48], uint32(r.ProofLayers))
+	return &Frame{Type: typ, Data: buf}
+}
+
+func (c *Codec) HashRequest(r HashRequest) *Frame {
+	return r.pack(TypeHashRequest, 0)
+}
+
+func (c *Codec) HashReject(r HashRequest) *Frame {
+	return r.pack(TypeHashReject, 0)
+}
+
+// Hashes answers r with the requested hashes followed by their proof.
+func (c *Codec) Hashes(r HashRequest, hashes [][32]byte) *Frame {
+	frm := r.pack(TypeHashes, 32*len(hashes))
+	for _, h := range hashes {
+		frm.Data = append(frm.Data, h[:]...)
+	}
+	return frm
+}
+
+// ReadHashRequest reads the header of a Hash Request, Hashes or Hash
+// Reject frame.
+func ReadHashRequest(frm *Frame) (HashRequest, error) {
+	var r HashRequest
+	switch frm.Type {
+	case TypeHashRequest, TypeHashes, TypeHashReject:
+	default:
+		return r, ErrInvalidType
+	}
+	if len(frm.Data) < hashRequestSize {
+		return r, ErrPayloadTooShort
+	}
+	copy(r.Root[:], frm.Data[0:32])
+	r.BaseLayer = int(binary.BigEndian.Uint32(frm.Data[32:36]))
+	r.Index = int(binary.BigEndian.Uint32(frm.Data[36:40]))
+	r.Length = int(binary.BigEndian.Uint32(frm.Data[40:44]))
+	r.ProofLayers = int(binary.BigEndian.Uint32(frm.Data[44:48]))
+	return r, nil
+}
+
+// ReadHashes reads a Hashes frame.
+func ReadHashes(frm *Frame) (HashRequest, [][32]byte, error) {
+	if frm.Type != TypeHashes {
+		return HashRequest{}, nil, ErrInvalidType
+	}
+	r, err := ReadHashRequest(frm)
+	if err != nil {
+		return r, nil, err
+	}
+	rest := frm.Data[hashRequestSize:]
+	if len(rest)%32 != 0 {
+		return r, nil, ErrPayloadTooShort
+	}
+	hashes := make([][32]byte, len(rest)/32)
+	for i := range hashes {
+		copy(hashes[i][:], rest[i*32:])
+	}
+	return r, hashes, nil
+}
@@ -12,10 +12,29 @@ var ErrInvalidProtocolLen = errors.New("invalid protocol length")
 
 type Greeting struct {
 	Protocol string
+	Reserved [reservedBytes]byte
 	Hash     [hashSize]byte
 	ID       [hashSize]byte
 }
 
+// Reserved bits are numbered from the left of the 64 bit field, so the
+// extension protocol (BEP 10) bit 43 lives in byte 5 as 0x10.
+const (
+	ExtensionProtocol = 43
+	// V2Upgrade marks support for BitTorrent v2 (BEP 52) on a hybrid
+	// torrent's v1 swarm.
+	V2Upgrade     = 59
+	FastExtension = 61
+)
+
+func (g *Greeting) SetBit(bit int) {
+	g.Reserved[bit/8] |= 0x80 >> (bit % 8)
+}
+
+func (g *Greeting) HasBit(bit int) bool {
+	return g.Reserved[bit/8]&(0x80>>(bit%8)) != 0
+}
+
 func Build(hash, id [hashSize]byte) *Greeting {
 	return &Greeting{
 		Protocol: "BitTorrent protocol",
@@ -32,7 +51,7 @@ func (g *Greeting) Pack() []byte {
 	pos := 1
 
 	pos += copy(out[pos:], g.Protocol)
-	pos += copy(out[pos:], make([]byte, reservedBytes))
+	pos += copy(out[pos:], g.Reserved[:])
 	copy(out[pos:], append(g.Hash[:], g.ID[:]...))
 
 	return out
@@ -58,6 +77,8 @@ func Unpack(stream io.Reader) (*Greeting, error) {
 	var result Greeting
 	result.Protocol = string(raw[:pstrLen])
 
+	copy(result.Reserved[:], raw[pstrLen:pstrLen+reservedBytes])
+
 	hashStart := pstrLen + reservedBytes
 	copy(result.Hash[:], raw[hashStart:hashStart+hashSize])
 	copy(result.ID[:], raw[hashStart+hashSize:])
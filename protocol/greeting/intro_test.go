@@ -0,0 +1,32 @@
+package greeting
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var hash, id [hashSize]byte
+	copy(hash[:], "infohashinfohashinfo")
+	copy(id[:], "-TH0001-abcdefghijkl")
+
+	g := Build(hash, id)
+	g.SetBit(ExtensionProtocol)
+
+	out := g.Pack()
+	assert.Equal(t, byte(0x10), out[1+len(g.Protocol)+5])
+
+	got, err := Unpack(bytes.NewReader(out))
+	assert.NoError(t, err)
+	assert.Equal(t, g, got)
+	assert.True(t, got.HasBit(ExtensionProtocol))
+}
+
+func TestV2UpgradeBit(t *testing.T) {
+	var g Greeting
+	g.SetBit(V2Upgrade)
+	assert.Equal(t, byte(0x10), g.Reserved[7])
+	assert.False(t, g.HasBit(FastExtension))
+}
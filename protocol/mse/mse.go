@@ -0,0 +1,373 @@
+package mse
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+)
+
+const (
+	CryptoPlaintext = 0x01
+	CryptoRC4       = 0x02
+
+	keySize    = 96
+	maxPadding = 512
+	// discard is how much RC4 keystream both sides throw away up front.
+	discard = 1024
+)
+
+var (
+	ErrNoSync         = errors.New("mse: could not synchronise on handshake")
+	ErrUnknownSKey    = errors.New("mse: unknown stream key")
+	ErrBadVC          = errors.New("mse: bad verification constant")
+	ErrNoCommonCrypto = errors.New("mse: no common crypto method")
+)
+
+var (
+	prime, _  = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563", 16)
+	generator = big.NewInt(2)
+	vc        = make([]byte, 8)
+)
+
+// Mode is how eagerly a connection uses encryption.
+type Mode int
+
+const (
+	// ModeDisable speaks the plain BitTorrent handshake only.
+	ModeDisable Mode = iota
+	// ModePrefer offers RC4 and plaintext and picks RC4 when both sides can.
+	ModePrefer
+	// ModeRequire refuses anything but an RC4 obfuscated stream.
+	ModeRequire
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "prefer":
+		return ModePrefer, nil
+	case "require":
+		return ModeRequire, nil
+	case "disable":
+		return ModeDisable, nil
+	}
+	return 0, fmt.Errorf("unknown encryption mode %q", s)
+}
+
+func (m Mode) String() string {
+	switch m {
+	case ModeRequire:
+		return "require"
+	case ModePrefer:
+		return "prefer"
+	}
+	return "disable"
+}
+
+// Provide is the crypto_provide bitmask a mode offers.
+func (m Mode) Provide() uint32 {
+	if m == ModeRequire {
+		return CryptoRC4
+	}
+	return CryptoRC4 | CryptoPlaintext
+}
+
+// Select picks from what the other side provided, RC4 first.
+func (m Mode) Select(provided uint32) (uint32, error) {
+	offer := m.Provide() & provided
+	switch {
+	case offer&CryptoRC4 != 0:
+		return CryptoRC4, nil
+	case offer&CryptoPlaintext != 0:
+		return CryptoPlaintext, nil
+	}
+	return 0, ErrNoCommonCrypto
+}
+
+func hash(parts ...[]byte) []byte {
+	h := sha1.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+type keyPair struct {
+	private *big.Int
+	public  []byte
+}
+
+func newKeyPair() (*keyPair, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	x := new(big.Int).SetBytes(raw)
+	y := new(big.Int).Exp(generator, x, prime)
+	return &keyPair{private: x, public: pad(y.Bytes())}, nil
+}
+
+func (k *keyPair) secret(remote []byte) []byte {
+	y := new(big.Int).SetBytes(remote)
+	return pad(new(big.Int).Exp(y, k.private, prime).Bytes())
+}
+
+// pad left-pads a big-endian number to the 768 bit key size.
+func pad(b []byte) []byte {
+	out := make([]byte, keySize)
+	copy(out[keySize-len(b):], b)
+	return out
+}
+
+func randomPad() ([]byte, error) {
+	var n [2]byte
+	if _, err := rand.Read(n[:]); err != nil {
+		return nil, err
+	}
+	padding := make([]byte, int(binary.BigEndian.Uint16(n[:]))%(maxPadding+1))
+	_, err := rand.Read(padding)
+	return padding, err
+}
+
+func newCipher(name string, secret, skey []byte) *rc4.Cipher {
+	c, _ := rc4.NewCipher(hash([]byte(name), secret, skey))
+	burn := make([]byte, discard)
+	c.XORKeyStream(burn, burn)
+	return c
+}
+
+func xor(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+// syncOn reads from r until needle has been seen, giving up after limit
+// bytes of padding.
+func syncOn(r *bufio.Reader, needle []byte, limit int) error {
+	window := make([]byte, 0, len(needle))
+	for read := 0; read <= limit+len(needle); read++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if len(window) == len(needle) {
+			copy(window, window[1:])
+			window = window[:len(needle)-1]
+		}
+		window = append(window, b)
+		if bytes.Equal(window, needle) {
+			return nil
+		}
+	}
+	return ErrNoSync
+}
+
+// Conn is a connection after the MSE handshake. With plaintext selected it
+// only replays what was buffered during the handshake.
+type Conn struct {
+	net.Conn
+	r   io.Reader
+	mu  sync.Mutex
+	enc *rc4.Cipher
+	// Selected is the crypto method both sides agreed on.
+	Selected uint32
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	if c.enc == nil {
+		return c.Conn.Write(p)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]byte, len(p))
+	c.enc.XORKeyStream(out, p)
+	return c.Conn.Write(out)
+}
+
+func newConn(conn net.Conn, r *bufio.Reader, prefix []byte, selected uint32, enc, dec *rc4.Cipher) *Conn {
+	var src io.Reader = r
+	if selected == CryptoRC4 {
+		src = cipher.StreamReader{S: dec, R: r}
+	} else {
+		enc = nil
+	}
+	if len(prefix) > 0 {
+		src = io.MultiReader(bytes.NewReader(prefix), src)
+	}
+	return &Conn{Conn: conn, r: src, enc: enc, Selected: selected}
+}
+
+// Initiate runs the outgoing side of the handshake for the torrent skey
+// (its infohash) and returns the connection to speak BitTorrent over.
+func Initiate(conn net.Conn, skey [20]byte, mode Mode) (*Conn, error) {
+	keys, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	padA, err := randomPad()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(append([]byte{}, keys.public...), padA...)); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	yb := make([]byte, keySize)
+	if _, err := io.ReadFull(r, yb); err != nil {
+		return nil, err
+	}
+	secret := keys.secret(yb)
+
+	enc := newCipher("keyA", secret, skey[:])
+	dec := newCipher("keyB", secret, skey[:])
+
+	var msg bytes.Buffer
+	msg.Write(hash([]byte("req1"), secret))
+	msg.Write(xor(hash([]byte("req2"), skey[:]), hash([]byte("req3"), secret)))
+
+	plain := make([]byte, 0, 16)
+	plain = append(plain, vc...)
+	plain = binary.BigEndian.AppendUint32(plain, mode.Provide())
+	plain = binary.BigEndian.AppendUint16(plain, 0) // len(PadC)
+	plain = binary.BigEndian.AppendUint16(plain, 0) // len(IA)
+	sealed := make([]byte, len(plain))
+	enc.XORKeyStream(sealed, plain)
+	msg.Write(sealed)
+
+	if _, err := conn.Write(msg.Bytes()); err != nil {
+		return nil, err
+	}
+
+	// The responder's reply starts with VC encrypted under keyB, after up
+	// to 512 bytes of its padding.
+	expect := make([]byte, len(vc))
+	probe := newCipher("keyB", secret, skey[:])
+	probe.XORKeyStream(expect, vc)
+	if err := syncOn(r, expect, maxPadding); err != nil {
+		return nil, err
+	}
+	skip := make([]byte, len(vc))
+	dec.XORKeyStream(skip, skip)
+
+	stream := cipher.StreamReader{S: dec, R: r}
+	head := make([]byte, 6)
+	if _, err := io.ReadFull(stream, head); err != nil {
+		return nil, err
+	}
+	selected := binary.BigEndian.Uint32(head[0:4])
+	if selected&mode.Provide() == 0 || (selected != CryptoRC4 && selected != CryptoPlaintext) {
+		return nil, ErrNoCommonCrypto
+	}
+	padD := make([]byte, binary.BigEndian.Uint16(head[4:6]))
+	if _, err := io.ReadFull(stream, padD); err != nil {
+		return nil, err
+	}
+
+	return newConn(conn, r, nil, selected, enc, dec), nil
+}
+
+// Accept runs the receiving side. Ya has to be read by the caller first,
+// since it also decides whether the stream is MSE at all. The stream key
+// is matched against skeys and returned.
+func Accept(conn net.Conn, r *bufio.Reader, ya []byte, skeys [][20]byte, mode Mode) (*Conn, [20]byte, error) {
+	var skey [20]byte
+
+	keys, err := newKeyPair()
+	if err != nil {
+		return nil, skey, err
+	}
+	padB, err := randomPad()
+	if err != nil {
+		return nil, skey, err
+	}
+	if _, err := conn.Write(append(append([]byte{}, keys.public...), padB...)); err != nil {
+		return nil, skey, err
+	}
+	secret := keys.secret(ya)
+
+	if err := syncOn(r, hash([]byte("req1"), secret), maxPadding); err != nil {
+		return nil, skey, err
+	}
+
+	obfuscated := make([]byte, 20)
+	if _, err := io.ReadFull(r, obfuscated); err != nil {
+		return nil, skey, err
+	}
+	req2 := xor(obfuscated, hash([]byte("req3"), secret))
+	found := false
+	for _, candidate := range skeys {
+		if bytes.Equal(req2, hash([]byte("req2"), candidate[:])) {
+			skey, found = candidate, true
+			break
+		}
+	}
+	if !found {
+		return nil, skey, ErrUnknownSKey
+	}
+
+	dec := newCipher("keyA", secret, skey[:])
+	enc := newCipher("keyB", secret, skey[:])
+	stream := cipher.StreamReader{S: dec, R: r}
+
+	head := make([]byte, 14)
+	if _, err := io.ReadFull(stream, head); err != nil {
+		return nil, skey, err
+	}
+	if !bytes.Equal(head[0:8], vc) {
+		return nil, skey, ErrBadVC
+	}
+	provided := binary.BigEndian.Uint32(head[8:12])
+	padC := make([]byte, binary.BigEndian.Uint16(head[12:14]))
+	if _, err := io.ReadFull(stream, padC); err != nil {
+		return nil, skey, err
+	}
+	var iaLen [2]byte
+	if _, err := io.ReadFull(stream, iaLen[:]); err != nil {
+		return nil, skey, err
+	}
+	ia := make([]byte, binary.BigEndian.Uint16(iaLen[:]))
+	if _, err := io.ReadFull(stream, ia); err != nil {
+		return nil, skey, err
+	}
+
+	selected, err := mode.Select(provided)
+	if err != nil {
+		return nil, skey, err
+	}
+
+	plain := make([]byte, 0, 14)
+	plain = append(plain, vc...)
+	plain = binary.BigEndian.AppendUint32(plain, selected)
+	plain = binary.BigEndian.AppendUint16(plain, 0) // len(PadD)
+	reply := make([]byte, len(plain))
+	enc.XORKeyStream(reply, plain)
+	if _, err := conn.Write(reply); err != nil {
+		return nil, skey, err
+	}
+
+	return newConn(conn, r, ia, selected, enc, dec), skey, nil
+}
+
+// ReadKey reads the initiator's public key.
+func ReadKey(r io.Reader) ([]byte, error) {
+	ya := make([]byte, keySize)
+	_, err := io.ReadFull(r, ya)
+	return ya, err
+}
@@ -0,0 +1,116 @@
+package mse
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	out, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	in := <-accepted
+	t.Cleanup(func() {
+		out.Close()
+		in.Close()
+	})
+	return out, in
+}
+
+type accepted struct {
+	conn *Conn
+	skey [20]byte
+	err  error
+}
+
+func TestHandshake(t *testing.T) {
+	var skey [20]byte
+	copy(skey[:], "0123456789abcdefghij")
+	other := [20]byte{1}
+
+	cases := map[string]struct {
+		local, remote Mode
+		want          uint32
+		fail          bool
+	}{
+		"both prefer":         {local: ModePrefer, remote: ModePrefer, want: CryptoRC4},
+		"require and prefer":  {local: ModeRequire, remote: ModePrefer, want: CryptoRC4},
+		"prefer and require":  {local: ModePrefer, remote: ModeRequire, want: CryptoRC4},
+		"require and require": {local: ModeRequire, remote: ModeRequire, want: CryptoRC4},
+	}
+
+	for name, c := range cases {
+		out, in := tcpPair(t)
+
+		done := make(chan accepted)
+		go func() {
+			r := bufio.NewReader(in)
+			ya, err := ReadKey(r)
+			if err != nil {
+				done <- accepted{err: err}
+				return
+			}
+			conn, key, err := Accept(in, r, ya, [][20]byte{other, skey}, c.remote)
+			done <- accepted{conn, key, err}
+		}()
+
+		initiated, err := Initiate(out, skey, c.local)
+		require.NoError(t, err, name)
+		res := <-done
+		require.NoError(t, res.err, name)
+		assert.Equal(t, skey, res.skey, name)
+		assert.Equal(t, c.want, initiated.Selected, name)
+		assert.Equal(t, c.want, res.conn.Selected, name)
+
+		go initiated.Write([]byte("\x13BitTorrent protocol"))
+		buf := make([]byte, 20)
+		_, err = io.ReadFull(res.conn, buf)
+		require.NoError(t, err, name)
+		assert.Equal(t, "\x13BitTorrent protocol", string(buf), name)
+
+		go res.conn.Write([]byte("reply"))
+		buf = make([]byte, 5)
+		_, err = io.ReadFull(initiated, buf)
+		require.NoError(t, err, name)
+		assert.Equal(t, "reply", string(buf), name)
+	}
+}
+
+func TestUnknownSKey(t *testing.T) {
+	out, in := tcpPair(t)
+
+	done := make(chan error)
+	go func() {
+		r := bufio.NewReader(in)
+		ya, _ := ReadKey(r)
+		_, _, err := Accept(in, r, ya, [][20]byte{{9}}, ModePrefer)
+		in.Close()
+		done <- err
+	}()
+
+	_, err := Initiate(out, [20]byte{1}, ModePrefer)
+	assert.Error(t, err)
+	assert.ErrorIs(t, <-done, ErrUnknownSKey)
+}
+
+func TestSelect(t *testing.T) {
+	got, err := ModePrefer.Select(CryptoPlaintext)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(CryptoPlaintext), got)
+
+	_, err = ModeRequire.Select(CryptoPlaintext)
+	assert.ErrorIs(t, err, ErrNoCommonCrypto)
+}
@@ -0,0 +1,199 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/data/mask"
+	"github.com/Sabir222/torrent-at-home/engine"
+)
+
+const (
+	// maxMapRows and maxTrackerRows bound their sections, the peer table
+	// gets the rest of the screen.
+	maxMapRows     = 6
+	maxTrackerRows = 4
+)
+
+// snapshot is everything a frame shows, taken at once.
+type snapshot struct {
+	name       string
+	stats      engine.Stats
+	peers      []engine.PeerInfo
+	trackers   []engine.TrackerStatus
+	have       mask.Mask
+	pieces     int
+	sequential bool
+	paused     bool
+	logs       []string
+}
+
+// render lays a frame out as lines of at most width runes, filling no
+// more than height lines.
+func render(s snapshot, width, height int) []string {
+	var lines []string
+	add := func(format string, args ...any) {
+		lines = append(lines, clip(fmt.Sprintf(format, args...), width))
+	}
+
+	var modes []string
+	if s.paused {
+		modes = append(modes, "[paused]")
+	}
+	if s.sequential {
+		modes = append(modes, "[sequential]")
+	}
+	add(" %s  %s", s.name, strings.Join(modes, " "))
+	st := s.stats
+	fraction := 1.0
+	if st.Wanted > 0 {
+		fraction = float64(st.Pieces) / float64(st.Wanted)
+	}
+	add(" %s %5.1f%%  %d/%d pieces", bar(fraction, width-30), fraction*100, st.Pieces, st.Wanted)
+	add(" ↓ %s/s  downloaded %s  left %s  ETA %s  wasted %s",
+		FormatBytes(st.DownRate), FormatBytes(st.Downloaded), FormatBytes(st.Left), formatETA(st.ETA), FormatBytes(st.Wasted))
+	add(" %d peer(s): %d unchoked us, %d seed(s), %d leecher(s)", st.Peers, st.Unchoked, st.Seeds, st.Leechers)
+
+	add("")
+	add(" Pieces")
+	for _, row := range pieceMap(s.have, s.pieces, width-2, maxMapRows) {
+		add(" %s", row)
+	}
+
+	trackerRows := min(len(s.trackers), maxTrackerRows)
+	footer := 2 + len(s.logs)
+	if trackerRows > 0 {
+		footer += 2 + trackerRows
+	}
+	peerRows := max(height-len(lines)-footer-3, 1)
+
+	add("")
+	add(" %-22s %-12s %-5s %12s %12s", "PEER", "CLIENT", "FLAGS", "RATE", "DOWNLOADED")
+	peers := append([]engine.PeerInfo(nil), s.peers...)
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].DownRate != peers[j].DownRate {
+			return peers[i].DownRate > peers[j].DownRate
+		}
+		return peers[i].Addr < peers[j].Addr
+	})
+	for i, p := range peers {
+		if i == peerRows {
+			add(" … %d more", len(peers)-i)
+			break
+		}
+		add(" %-22s %-12s %-5s %10s/s %12s", p.Addr, clip(p.Client, 12), flags(p), FormatBytes(p.DownRate), FormatBytes(p.Downloaded))
+	}
+
+	if trackerRows > 0 {
+		add("")
+		add(" %-40s %6s %8s  %s", "TRACKER", "PEERS", "LATENCY", "STATUS")
+		for _, tr := range s.trackers[:trackerRows] {
+			status := "ok"
+			if tr.Err != "" {
+				status = tr.Err
+			}
+			add(" %-40s %6d %8s  %s", clip(tr.URL, 40), tr.Peers, tr.Latency.Round(time.Millisecond), status)
+		}
+	}
+
+	add("")
+	for _, line := range s.logs {
+		add(" %s", line)
+	}
+	add(" p pause/resume · s sequential · q quit    flags: C choking us, S seed, U uTP")
+
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return lines
+}
+
+// bar draws a progress bar width cells wide.
+func bar(fraction float64, width int) string {
+	width = max(width, 10)
+	full := int(fraction * float64(width))
+	return "[" + strings.Repeat("█", full) + strings.Repeat("░", width-full) + "]"
+}
+
+// pieceMap draws the bitfield in rows of width cells, each standing for
+// an equal run of pieces: full when all are verified, shaded when some
+// are and light when none are.
+func pieceMap(have mask.Mask, pieces, width, rows int) []string {
+	if pieces == 0 || width <= 0 {
+		return nil
+	}
+	cells := min(pieces, width*rows)
+	var b strings.Builder
+	var out []string
+	for cell := 0; cell < cells; cell++ {
+		first, last := cell*pieces/cells, (cell+1)*pieces/cells
+		done := 0
+		for i := first; i < last; i++ {
+			if have.Check(i) {
+				done++
+			}
+		}
+		switch {
+		case done == last-first:
+			b.WriteString("█")
+		case done > 0:
+			b.WriteString("▓")
+		default:
+			b.WriteString("░")
+		}
+		if (cell+1)%width == 0 || cell == cells-1 {
+			out = append(out, b.String())
+			b.Reset()
+		}
+	}
+	return out
+}
+
+func flags(p engine.PeerInfo) string {
+	var f string
+	if p.Choked {
+		f += "C"
+	}
+	if p.Seed {
+		f += "S"
+	}
+	if p.Network == "utp" {
+		f += "U"
+	}
+	return f
+}
+
+// clip cuts s to at most width runes.
+func clip(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return string(r[:width])
+}
+
+// FormatBytes renders a size or a rate in binary units, as 1.5 KiB.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, prefix := float64(n)/unit, "KMGTPE"
+	for value >= unit && len(prefix) > 1 {
+		value /= unit
+		prefix = prefix[1:]
+	}
+	return fmt.Sprintf("%.1f %ciB", value, prefix[0])
+}
+
+func formatETA(d time.Duration) string {
+	if d < 0 {
+		return "unknown"
+	}
+	return d.Round(time.Second).String()
+}
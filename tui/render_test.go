@@ -0,0 +1,93 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Sabir222/torrent-at-home/data/mask"
+	"github.com/Sabir222/torrent-at-home/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPieceMap(t *testing.T) {
+	have := mask.New(8)
+	for _, i := range []int{0, 1, 2, 5} {
+		have.Mark(i)
+	}
+	// One cell per piece, wrapped at the width.
+	assert.Equal(t, []string{"███░░", "█░░"}, pieceMap(have, 8, 5, 2))
+	// Two pieces per cell.
+	assert.Equal(t, []string{"█▓▓░"}, pieceMap(have, 8, 4, 1))
+	assert.Nil(t, pieceMap(nil, 0, 4, 1))
+}
+
+func TestRender(t *testing.T) {
+	have := mask.New(4)
+	have.Mark(0)
+	s := snapshot{
+		name:   "debian.iso",
+		stats:  engine.Stats{Pieces: 1, Wanted: 4, DownRate: 2048, Left: 3 << 20, ETA: 90 * time.Second, Peers: 3},
+		pieces: 4,
+		have:   have,
+		paused: true,
+		peers: []engine.PeerInfo{
+			{Addr: "10.0.0.1:6881", Client: "qB4650", Seed: true, DownRate: 1024},
+			{Addr: "10.0.0.2:6881", Network: "utp", Choked: true},
+			{Addr: "10.0.0.3:6881", DownRate: 4096},
+		},
+		trackers: []engine.TrackerStatus{{URL: "udp://tracker.example:1337", Err: "timeout"}},
+		logs:     []string{"[peer] ✓ connected"},
+	}
+
+	lines := render(s, 100, 40)
+	out := strings.Join(lines, "\n")
+	for _, line := range lines {
+		assert.LessOrEqual(t, utf8.RuneCountInString(line), 100)
+	}
+	assert.Contains(t, lines[0], "debian.iso  [paused]")
+	assert.Contains(t, lines[1], " 25.0%  1/4 pieces")
+	assert.Contains(t, lines[2], "↓ 2.0 KiB/s")
+	assert.Contains(t, lines[2], "ETA 1m30s")
+	assert.Contains(t, out, " █░░░\n")
+	assert.Contains(t, out, "udp://tracker.example:1337")
+	assert.Contains(t, out, "timeout")
+	assert.Contains(t, out, "[peer] ✓ connected")
+	// Fastest peers first, with their flags.
+	assert.Less(t, strings.Index(out, "10.0.0.3"), strings.Index(out, "10.0.0.1"))
+	assert.Regexp(t, `10\.0\.0\.1:6881 +qB4650 +S `, out)
+	assert.Regexp(t, `10\.0\.0\.2:6881 +CU `, out)
+
+	// A short screen cuts the peer table down and never overflows.
+	lines = render(s, 100, 17)
+	assert.Len(t, lines, 17)
+	out = strings.Join(lines, "\n")
+	assert.Contains(t, out, "10.0.0.3")
+	assert.NotContains(t, out, "10.0.0.1")
+	assert.Contains(t, out, "… 2 more")
+	assert.Contains(t, lines[16], "q quit")
+}
+
+func TestScreenLogs(t *testing.T) {
+	var s Screen
+	s.Write([]byte("one\ntwo\n"))
+	s.Write([]byte("three\n"))
+	s.Write([]byte("four\n"))
+	assert.Equal(t, []string{"two", "three", "four"}, s.logs)
+}
+
+func TestFormat(t *testing.T) {
+	cases := map[int64]string{
+		0:        "0 B",
+		1023:     "1023 B",
+		1536:     "1.5 KiB",
+		5 << 30:  "5.0 GiB",
+		-1 << 63: "-9223372036854775808 B",
+	}
+	for n, want := range cases {
+		assert.Equal(t, want, FormatBytes(n), n)
+	}
+	assert.Equal(t, "unknown", formatETA(-1))
+	assert.Equal(t, "2s", formatETA(1600*time.Millisecond))
+}
@@ -0,0 +1,52 @@
+package tui
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/engine"
+	"github.com/Sabir222/torrent-at-home/logging"
+)
+
+const (
+	reportInterval = 5 * time.Second
+	// topPeers is how many of the fastest peers each report lists.
+	topPeers = 3
+)
+
+// Report is the plain output used when there is no terminal: it logs the
+// session's progress, rate, ETA, peer counts and fastest peers every few
+// seconds until stop is closed.
+func Report(session *engine.Session, stop <-chan struct{}) {
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		st := session.Stats()
+		pct := 100.0
+		if st.Wanted > 0 {
+			pct = float64(st.Pieces) / float64(st.Wanted) * 100
+		}
+		logging.Infof("[stats] [%5.1f%%] %d/%d piece(s), %s/s, %s left, ETA %s, %d peer(s): %d unchoked us, %d seed(s), %d leecher(s), %s wasted\n",
+			pct, st.Pieces, st.Wanted, FormatBytes(st.DownRate), FormatBytes(st.Left), formatETA(st.ETA),
+			st.Peers, st.Unchoked, st.Seeds, st.Leechers, FormatBytes(st.Wasted))
+
+		peers := session.ConnectedPeers()
+		sort.Slice(peers, func(i, j int) bool { return peers[i].DownRate > peers[j].DownRate })
+		for _, p := range peers[:min(len(peers), topPeers)] {
+			if p.DownRate == 0 {
+				break
+			}
+			client := p.Client
+			if client == "" {
+				client = "?"
+			}
+			logging.Infof("[stats]   %-22s %-12s %s/s\n", p.Addr, client, FormatBytes(p.DownRate))
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package tui
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("terminal control is not supported on this platform")
+
+// IsTerminal reports false, so callers fall back to plain output.
+func IsTerminal(f *os.File) bool {
+	return false
+}
+
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errUnsupported
+}
+
+func size(f *os.File) (int, int, error) {
+	return 0, 0, errUnsupported
+}
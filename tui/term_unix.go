@@ -0,0 +1,57 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package tui
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+type winsize struct {
+	Row, Col, X, Y uint16
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// IsTerminal reports whether f is connected to a terminal.
+func IsTerminal(f *os.File) bool {
+	var t syscall.Termios
+	return ioctl(f, ioctlGetTermios, unsafe.Pointer(&t)) == nil
+}
+
+// makeRaw turns off line buffering, echo and signal keys so single key
+// presses can be read, and returns a func that restores the terminal.
+// Output processing stays on, so "\n" still starts a new line.
+func makeRaw(f *os.File) (func(), error) {
+	var old syscall.Termios
+	if err := ioctl(f, ioctlGetTermios, unsafe.Pointer(&old)); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.IXON | syscall.ICRNL
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(f, ioctlSetTermios, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return func() {
+		ioctl(f, ioctlSetTermios, unsafe.Pointer(&old))
+	}, nil
+}
+
+// size returns the terminal's width and height.
+func size(f *os.File) (int, int, error) {
+	var ws winsize
+	if err := ioctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
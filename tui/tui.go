@@ -0,0 +1,155 @@
+// Package tui draws a download full screen with plain ANSI escapes: a
+// progress bar, rates, a piece map and the peer and tracker tables, and
+// takes single key commands.
+package tui
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sabir222/torrent-at-home/engine"
+)
+
+const (
+	refreshInterval = 500 * time.Millisecond
+	// logLines is how many of the latest log lines the screen keeps.
+	logLines = 3
+
+	// Default size when the terminal does not report one.
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	home        = "\x1b[H"
+	clearLine   = "\x1b[K"
+	clearBelow  = "\x1b[J"
+)
+
+// Screen shows a session on the terminal. Keys: p pauses and resumes, s
+// toggles sequential mode, q or Ctrl-C quits.
+type Screen struct {
+	Session *engine.Session
+	Name    string
+	// Paused reports whether the download is paused and TogglePause
+	// flips it; p does nothing when they are nil.
+	Paused      func() bool
+	TogglePause func()
+	// Quit is called when q or Ctrl-C is pressed.
+	Quit func()
+
+	mu   sync.Mutex
+	logs []string
+}
+
+// Write keeps the last lines written, so log output can be shown on the
+// screen instead of scrolling over it.
+func (s *Screen) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		s.logs = append(s.logs, line)
+	}
+	if len(s.logs) > logLines {
+		s.logs = s.logs[len(s.logs)-logLines:]
+	}
+	return len(p), nil
+}
+
+// Run takes over the terminal on stdin and stdout and redraws it until
+// stop is closed. It fails without touching the screen when stdin is not
+// a terminal that can be put in raw mode.
+func (s *Screen) Run(stop <-chan struct{}) error {
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	out := bufio.NewWriter(os.Stdout)
+	out.WriteString(enterScreen)
+	defer func() {
+		out.WriteString(leaveScreen)
+		out.Flush()
+	}()
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil || n == 0 {
+				return
+			}
+			select {
+			case keys <- buf[0]:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		s.draw(out)
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		case key := <-keys:
+			s.handle(key)
+		}
+	}
+}
+
+func (s *Screen) handle(key byte) {
+	switch key {
+	case 'p', 'P':
+		if s.TogglePause != nil {
+			s.TogglePause()
+		}
+	case 's', 'S':
+		s.Session.SetSequential(!s.Session.Sequential())
+	case 'q', 'Q', 3: // 3 is Ctrl-C, which raw mode delivers as a key.
+		if s.Quit != nil {
+			s.Quit()
+		}
+	}
+}
+
+func (s *Screen) draw(out *bufio.Writer) {
+	width, height, err := size(os.Stdout)
+	if err != nil || width == 0 || height == 0 {
+		width, height = defaultWidth, defaultHeight
+	}
+	snap := snapshot{
+		name:       s.Name,
+		stats:      s.Session.Stats(),
+		peers:      s.Session.ConnectedPeers(),
+		trackers:   s.Session.Trackers,
+		have:       s.Session.Bitfield(),
+		pieces:     len(s.Session.PieceHashes),
+		sequential: s.Session.Sequential(),
+		paused:     s.Paused != nil && s.Paused(),
+	}
+	s.mu.Lock()
+	snap.logs = append([]string(nil), s.logs...)
+	s.mu.Unlock()
+
+	out.WriteString(home)
+	for i, line := range render(snap, width, height) {
+		// No newline after the last line, which would scroll a full
+		// screen.
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString(line + clearLine)
+	}
+	out.WriteString(clearBelow)
+	out.Flush()
+}